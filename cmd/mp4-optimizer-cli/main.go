@@ -0,0 +1,13 @@
+// Command mp4-optimizer-cli is the headless build of the command-line interface.
+// It does not link the Wails GUI, so it runs on servers and NAS boxes without a display stack.
+package main
+
+import (
+	"os"
+
+	"mp4-optimizer/internal/cli"
+)
+
+func main() {
+	os.Exit(cli.Run(os.Args[1:], os.Stdout, os.Stderr))
+}
@@ -2,7 +2,7 @@
 
 import { useEffect, useState, useCallback, useMemo } from "react";
 import { useDropzone } from "react-dropzone";
-import { FileItem, FileStatus, FileMetadata, ProgressEvent } from "../types";
+import { CodecInfo, ColorInfo, ContainerFamily, FileItem, FileStatus, ProgressEvent, QueueJob, ScanResult, TrackInfo, TrackType, VideoStats } from "../types";
 
 type UpdateResult = {
   available: boolean;
@@ -25,7 +25,7 @@ import { Badge } from "@/components/ui/badge";
 import { ScrollArea } from "@/components/ui/scroll-area";
 import { Progress } from "@/components/ui/progress";
 // import { cn } from "@/lib/utils"; // Not used currently
-import { Loader2, CheckCircle2, XCircle, FileVideo, Plus, Zap, Trash2, Play, X, FolderPlus, Moon, Sun, Download, RefreshCw } from "lucide-react";
+import { Loader2, CheckCircle2, XCircle, FileVideo, Plus, Zap, Trash2, Play, X, FolderPlus, Moon, Sun, Download, RefreshCw, Pause } from "lucide-react";
 
 // Mock Wails Runtime for Dev/Build
 const getWailsApp = () => {
@@ -51,6 +51,84 @@ const formatSize = (bytes: number) => {
   return parseFloat((bytes / Math.pow(k, i)).toFixed(2)) + ' ' + sizes[i];
 };
 
+// 容器类型的显示名称（按 ftyp 品牌识别，而非扩展名）
+const familyLabels: Record<ContainerFamily, string> = {
+  mp4: 'MP4',
+  quicktime: 'QuickTime',
+  m4a: 'M4A 音频',
+  m4v: 'M4V',
+  '3gpp': '3GP',
+  '3gpp2': '3G2',
+  unknown: '未知容器',
+};
+
+const trackTypeLabels: Record<TrackType, string> = {
+  video: '视频',
+  audio: '音频',
+  subtitle: '字幕',
+  timecode: '时间码',
+  hint: '提示',
+  data: '数据',
+};
+
+// 按类型统计轨道，如 "1 视频 · 2 音频"
+const summarizeTracks = (tracks: TrackInfo[]) => {
+  const counts = new Map<TrackType, number>();
+  tracks.forEach((t) => counts.set(t.type, (counts.get(t.type) || 0) + 1));
+  return Array.from(counts, ([type, n]) => `${n} ${trackTypeLabels[type]}`).join(' · ');
+};
+
+const formatBitrate = (bps: number) =>
+  bps >= 1e6 ? `${(bps / 1e6).toFixed(1)} Mbps` : `${Math.round(bps / 1e3)} kbps`;
+
+// 帧率与关键帧统计（用于悬停提示）
+const describeVideo = (v: VideoStats) => [
+  `平均 ${v.frameRate.toFixed(3)} fps，最高 ${v.maxFrameRate.toFixed(3)} fps${v.variableFrameRate ? "（可变帧率）" : ""}`,
+  `关键帧 ${v.keyframes} 个，GOP ${v.minGop}-${v.maxGop} 帧（平均 ${v.avgGop.toFixed(1)}，约 ${v.keyframeInterval.toFixed(2)} 秒）`,
+  v.bFrames ? "含 B 帧" : "无 B 帧",
+].join("\n");
+
+// 色彩与 HDR 信息（用于悬停提示）
+const describeColor = (c: ColorInfo) => {
+  const lines = [`${c.hdr ?? 'SDR'}（传输特性 ${c.transfer}）`];
+  if (c.dolbyVision) lines.push(`杜比视界 Profile ${c.dolbyVision.profile}.${c.dolbyVision.compatibility} Level ${c.dolbyVision.level}`);
+  if (c.source) {
+    lines.push(`色域 ${c.primaries ?? c.colorPrimaries}，矩阵 ${c.matrix ?? c.matrixCoefficients}，${c.fullRange ? '全范围' : '有限范围'}`);
+  }
+  if (c.iccProfile) lines.push('含 ICC 配置文件');
+  if (c.mastering) lines.push(`母版亮度 ${c.mastering.minLuminance.toFixed(4)}-${Math.round(c.mastering.maxLuminance)} cd/m²`);
+  if (c.maxCll || c.maxFall) lines.push(`MaxCLL ${c.maxCll ?? 0}，MaxFALL ${c.maxFall ?? 0}`);
+  return lines.join("\n");
+};
+
+// 档次、级别、位深与色度格式，如 "High@4.1 10-bit 4:2:0"
+const describeCodec = (c: CodecInfo) => [
+  c.profile && c.level ? `${c.profile}@${c.level}` : c.profile,
+  c.bitDepth ? `${c.bitDepth}-bit` : '',
+  c.chromaFormat,
+].filter(Boolean).join(' ');
+
+// 显示尺寸，以及与之不同的编码尺寸、像素宽高比和旋转
+const describeGeometry = (t: TrackInfo) => {
+  const parts = [`${t.displayWidth}x${t.displayHeight}`];
+  if (t.codedWidth && (t.codedWidth !== t.displayWidth || t.codedHeight !== t.displayHeight)) parts.push(`编码 ${t.codedWidth}x${t.codedHeight}`);
+  if (t.pixelAspectH && t.pixelAspectH !== t.pixelAspectV) parts.push(`PAR ${t.pixelAspectH}:${t.pixelAspectV}`);
+  if (t.rotation) parts.push(`旋转 ${t.rotation}°`);
+  if (t.flipH) parts.push('水平翻转');
+  if (t.flipV) parts.push('垂直翻转');
+  return parts.join(' ');
+};
+
+// 单条轨道的详细描述（用于悬停提示）
+const describeTrack = (t: TrackInfo) => {
+  const parts = [`#${t.id} ${trackTypeLabels[t.type]}`, t.codecString || t.codec, describeCodec(t), t.language];
+  if (t.type === 'audio' && t.channels) parts.push(`${t.channels} 声道 ${t.sampleRate ? Math.round(t.sampleRate) + ' Hz' : ''}`.trim());
+  if (t.type === 'video' && t.displayWidth) parts.push(describeGeometry(t));
+  if (t.bitrate > 0) parts.push(formatBitrate(t.bitrate));
+  if (!t.enabled) parts.push('已禁用');
+  return parts.filter(Boolean).join(' ');
+};
+
 const formatTime = (seconds: number) => {
   const h = Math.floor(seconds / 3600);
   const m = Math.floor((seconds % 3600) / 60);
@@ -62,15 +140,17 @@ export default function Home() {
   const [files, setFiles] = useState<FileItem[]>([]);
   const [isWailsReady, setIsWailsReady] = useState(false);
   const [playingFile, setPlayingFile] = useState<FileItem | null>(null);
+  const [previewUrl, setPreviewUrl] = useState<string>("");
   const [theme, setTheme] = useState<'light' | 'dark'>('light');
   const [appVersion, setAppVersion] = useState("0.0.0");
   const [updateInfo, setUpdateInfo] = useState<UpdateResult | null>(null);
   const [isUpdating, setIsUpdating] = useState(false);
   const [updateReady, setUpdateReady] = useState(false);
   const [wailsConnected, setWailsConnected] = useState(false);
-  const [isOptimizing, setIsOptimizing] = useState(false);
   const [showCloseConfirm, setShowCloseConfirm] = useState(false);
   const [isCleaningUp, setIsCleaningUp] = useState(false);
+  const [queuePaused, setQueuePaused] = useState(false);
+  const [queueWorkers, setQueueWorkers] = useState(1);
 
   useEffect(() => {
     // Check for updates on mount
@@ -176,7 +256,19 @@ export default function Home() {
           );
         });
 
-        // 3. Listen for close confirmation requests
+        // 3. Listen for scan results, streamed as each file completes
+        runtime.EventsOff("scan-result");
+        runtime.EventsOn("scan-result", (result: ScanResult) => {
+          applyScanResult(result);
+        });
+
+        // 4. Listen for job queue updates
+        runtime.EventsOff("queue-update");
+        runtime.EventsOn("queue-update", (job: QueueJob) => {
+          applyJob(job);
+        });
+
+        // 5. Listen for close confirmation requests
         runtime.EventsOff("request-close-confirm");
         runtime.EventsOn("request-close-confirm", () => {
           console.log("Event: request-close-confirm received");
@@ -194,6 +286,11 @@ export default function Home() {
           }, true); // useDropTarget=true for CSS-based visual feedback
         }
 
+        // Restore the queue, including a batch interrupted by the last exit
+        if (getWailsApp()) {
+          getWailsApp().GetQueue().then(restoreQueue);
+        }
+
         // Get Version
         if (getWailsApp()) {
           getWailsApp().GetAppVersion().then((v: string) => {
@@ -256,7 +353,10 @@ export default function Home() {
     if (!processedPaths || processedPaths.length === 0) {
       // 增强反馈：如果处理后为空（且原输入不为空），说明可能是文件夹内没有 MP4 或权限问题
       if (newPaths.length > 0) {
-        alert("未找到 MP4 文件，或者无法访问拖拽的文件夹。\n请检查 debug_log.txt 获取详细信息。");
+        // The log lives in the user cache directory, not next to the app
+        const logPath: string = (await getWailsApp()?.GetLogPath().catch(() => "")) || "";
+        alert("未找到支持的视频文件（MP4/MOV/M4V/M4A/3GP），或者无法访问拖拽的文件夹。\n" +
+          (logPath ? `请检查日志文件获取详细信息：${logPath}` : "请查看控制台输出获取详细信息。"));
       }
       return;
     }
@@ -279,86 +379,109 @@ export default function Home() {
     });
 
     // scan immediately
-    newItems.forEach(async (item) => {
-      // Check if it's already in the list to avoid double scanning (if setFiles filtered it out)
-      // BUT `newItems` contains everything.
-      // We should only scan what we actually ADDED.
-      // However, `setFiles` is async, so we can't easily know here.
-      // It's acceptable to re-scan or scan duplicate requests (debounce handled by status check usually)
-
-      // Better: filter newItems against CURRENT files state ref?
-      // For now, scanning everything passed is safe enough.
-      scanFile(item.path);
-      fetchMetadata(item.path);
-    });
+    // Files already in the list are scanned again, which is harmless.
+    scanFiles(newItems.map((item) => item.path));
   }, []); // Remove `files` dep to avoid loop, use functional update
 
-  const scanFile = async (path: string) => {
-    // Only update if not already scanning?
-    updateFileStatus(path, "scanning");
+  // Mirror a queue job's state on its file
+  const applyJob = (job: QueueJob) => {
+    setFiles((prev) =>
+      prev.map((f): FileItem => {
+        if (f.path !== job.path) return f;
+        switch (job.state) {
+          case 'queued':
+            return { ...f, status: 'queued', message: undefined, progress: undefined, progressMessage: undefined };
+          case 'running':
+            return { ...f, status: 'optimizing', progress: job.progress, progressMessage: job.message };
+          case 'done':
+            return { ...f, status: 'optimized', progress: undefined, progressMessage: undefined };
+          case 'failed':
+            return { ...f, status: 'error', message: job.error, progress: undefined, progressMessage: undefined };
+          case 'canceled':
+            return { ...f, status: 'unoptimized', progress: undefined, progressMessage: undefined };
+        }
+        return f;
+      })
+    );
+  };
+
+  const restoreQueue = async (queue: { jobs: QueueJob[] | null; paused: boolean; workers: number }) => {
+    setQueuePaused(queue.paused);
+    setQueueWorkers(queue.workers);
+    const pending = (queue.jobs || []).filter((j) => j.state === 'queued' || j.state === 'running');
+    if (pending.length === 0) return;
+
+    // Registers the files for preview; missing ones are dropped
+    const paths: string[] = await getWailsApp().ExpandPaths(pending.map((j) => j.path));
+    const items: FileItem[] = paths.map((path) => ({
+      id: path,
+      path,
+      name: path.split(/[/\\]/).pop() || path,
+      size: 0,
+      status: "queued" as FileStatus,
+    }));
+    setFiles((prev) => {
+      const existingPaths = new Set(prev.map((f) => f.path));
+      return [...prev, ...items.filter((f) => !existingPaths.has(f.path))];
+    });
+    pending.forEach(applyJob);
+    scanFiles(paths);
+  };
+
+  // Scan in the backend worker pool; results arrive as scan-result events
+  const scanFiles = async (paths: string[]) => {
+    if (paths.length === 0) return;
+    setFiles((prev) =>
+      prev.map((f) => (paths.includes(f.path) && f.status === "pending" ? { ...f, status: "scanning" } : f))
+    );
     const app = getWailsApp();
     if (!app) {
       // Mock for dev
       setTimeout(() => {
-        updateFileStatus(path, Math.random() > 0.5 ? "optimized" : "unoptimized");
+        paths.forEach((path) => updateFileStatus(path, Math.random() > 0.5 ? "optimized" : "unoptimized"));
       }, 500);
       return;
     }
 
     try {
-      // First validate file completeness
-      let isTruncated = false;
-      try {
-        const isValid = await app.ValidateFile(path);
-        isTruncated = !isValid;
-      } catch (validateErr) {
-        console.warn("File validation failed, continuing anyway:", validateErr);
-      }
-
-      // Update truncated status first
-      setFiles((prev) =>
-        prev.map((f) => (f.path === path ? { ...f, isTruncated } : f))
-      );
-
-      // Then check optimization status
-      const isOptimized = await app.CheckFile(path);
-      updateFileStatus(path, isOptimized ? "optimized" : "unoptimized");
-    } catch (e: any) {
-      updateFileStatus(path, "error", e.toString());
+      await app.ScanFiles(paths);
+    } catch (e) {
+      console.error("Failed to scan files", e);
     }
   };
 
-  const updateFileStatus = (path: string, status: FileStatus, message?: string) => {
+  const applyScanResult = (result: ScanResult) => {
     setFiles((prev) =>
-      prev.map((f) => (f.path === path ? { ...f, status, message } : f))
+      prev.map((f): FileItem => {
+        if (f.path !== result.path) return f;
+        const next: FileItem = { ...f, isTruncated: result.truncated };
+        if (result.metadata) {
+          next.metadata = result.metadata;
+          next.size = result.metadata.size;
+        }
+        // Queued files keep their status until the queue reports on them
+        if (f.status === "queued" || f.status === "optimizing") return next;
+
+        const st = result.structure;
+        if (result.error || !st) {
+          return { ...next, status: "error", message: result.error };
+        }
+        if (st.layout === "fragmented") {
+          const fr = st.fragments;
+          return { ...next, status: "fragmented", message: fr ? `${fr.count} 个分片，每片 ${fr.minDuration.toFixed(2)}-${fr.maxDuration.toFixed(2)} 秒` : undefined };
+        }
+        return { ...next, status: st.layout === "faststart" ? "optimized" : "unoptimized", message: undefined };
+      })
     );
   };
 
-  const updateFileMetadata = (path: string, metadata: FileMetadata) => {
+  const updateFileStatus = (path: string, status: FileStatus, message?: string) => {
     setFiles((prev) =>
-      prev.map((f) => (f.path === path ? { ...f, metadata, size: metadata.size } : f))
+      prev.map((f) => (f.path === path ? { ...f, status, message } : f))
     );
   };
 
-  const fetchMetadata = async (path: string) => {
-    const app = getWailsApp();
-    if (!app) return;
-    try {
-      const meta = await app.GetFileMetadata(path);
-      if (meta) {
-        updateFileMetadata(path, {
-          ...meta,
-          // Ensure modified is a string if it comes as string from JSON, or Date object?
-          // Go 'time.Time' usually marshals to RFC3339 string in JSON.
-          modified: meta.modified
-        });
-      }
-    } catch (e) {
-      console.error("Failed to get metadata", e);
-    }
-  }
-
-  const optimizeFile = async (path: string) => {
+  const optimizeFile = async (path: string, defragment = false) => {
     // FIX: Unload video if it's currently playing to prevent file locking on Windows
     if (playingFile && playingFile.path === path) {
       setPlayingFile(null);
@@ -387,7 +510,12 @@ export default function Home() {
     }
 
     try {
-      await app.OptimizeFile(path);
+      if (defragment) {
+        // 分片 MP4 合并为常规 fast-start MP4
+        await app.DefragmentFile(path);
+      } else {
+        await app.OptimizeFile(path);
+      }
       updateFileStatus(path, "optimized");
     } catch (e: any) {
       updateFileStatus(path, "error", e.toString());
@@ -414,6 +542,24 @@ export default function Home() {
     }
   };
 
+  const playFile = async (file: FileItem) => {
+    const app = getWailsApp();
+    if (!app) return;
+    try {
+      // The backend only serves files from the list, under an opaque token
+      setPreviewUrl(await app.PreviewURL(file.path));
+      setPlayingFile(file);
+    } catch (e) {
+      console.error("Failed to get preview URL", e);
+    }
+  };
+
+  const clearFiles = () => {
+    setPlayingFile(null);
+    setFiles([]);
+    getWailsApp()?.ClearFiles();
+  };
+
   const handleOpenFiles = async () => {
     const app = getWailsApp();
     if (app) {
@@ -430,22 +576,57 @@ export default function Home() {
     }
   };
 
+  // Count unoptimized files
+  const unoptimizedCount = useMemo(() => files.filter(f => f.status === 'unoptimized').length, [files]);
+  const queuedCount = useMemo(() => files.filter(f => f.status === 'queued').length, [files]);
+  // Queue-update events keep the statuses in step with the backend queue
+  const isBusy = queuedCount > 0 || files.some(f => f.status === 'optimizing');
+
   const handleOptimizeAll = async () => {
     const unoptimizedFiles = files.filter(f => f.status === 'unoptimized');
-    if (unoptimizedFiles.length === 0 || isOptimizing) return;
+    if (unoptimizedFiles.length === 0 || isBusy) return;
 
-    setIsOptimizing(true);
-    try {
+    const app = getWailsApp();
+    if (!app) {
       for (const file of unoptimizedFiles) {
         await optimizeFile(file.path);
       }
-    } finally {
-      setIsOptimizing(false);
+      return;
+    }
+
+    // Release the player so the file is not locked while it is rewritten
+    if (playingFile && unoptimizedFiles.some(f => f.path === playingFile.path)) {
+      setPlayingFile(null);
+    }
+    // The backend queue runs the batch; queue-update events drive the statuses
+    try {
+      const jobs: QueueJob[] = await app.EnqueueFiles(unoptimizedFiles.map(f => f.path), { outputDir: "", overwrite: "", defragment: false });
+      jobs.forEach(applyJob);
+    } catch (e: any) {
+      alert("加入队列失败：" + e.toString());
     }
   }
 
-  // Count unoptimized files
-  const unoptimizedCount = useMemo(() => files.filter(f => f.status === 'unoptimized').length, [files]);
+  const toggleQueuePaused = async () => {
+    const app = getWailsApp();
+    if (!app) return;
+    if (queuePaused) {
+      await app.ResumeQueue();
+    } else {
+      await app.PauseQueue();
+    }
+    setQueuePaused(!queuePaused);
+  };
+
+  const changeQueueWorkers = async (n: number) => {
+    setQueueWorkers(n);
+    await getWailsApp()?.SetQueueWorkers(n);
+  };
+
+  const cancelJob = async (path: string) => {
+    // Covers both queued jobs and files started with the per-file button
+    await getWailsApp()?.CancelOptimize(path);
+  };
 
   // Dropzone for web fallback (and visual overlay trigger)
   // We disable native drop handling effectively by not using its file objects if in Wails
@@ -598,7 +779,7 @@ export default function Home() {
           <Badge variant="outline" className="h-10 px-4 text-muted-foreground border-border bg-muted/50">
             共 {files.length} 个文件
           </Badge>
-          <Button variant="outline" onClick={() => setFiles([])} disabled={files.length === 0 || isOptimizing}>
+          <Button variant="outline" onClick={clearFiles} disabled={files.length === 0 || isBusy}>
             <Trash2 className="w-4 h-4 mr-2" />
             清空
           </Button>
@@ -606,19 +787,35 @@ export default function Home() {
           {/* Batch Optimization Button */}
           <Button
             onClick={handleOptimizeAll}
-            disabled={unoptimizedCount === 0 || isOptimizing}
-            className={unoptimizedCount > 0 && !isOptimizing ? "bg-emerald-600 hover:bg-emerald-500 text-white" : "bg-slate-800 text-slate-500"}
+            disabled={unoptimizedCount === 0 || isBusy}
+            className={unoptimizedCount > 0 && !isBusy ? "bg-emerald-600 hover:bg-emerald-500 text-white" : "bg-slate-800 text-slate-500"}
           >
             <Zap className="w-4 h-4 mr-2" />
             全部优化 ({unoptimizedCount})
           </Button>
 
-          <Button onClick={handleOpenFiles} size="lg" className="bg-blue-600 hover:bg-blue-500 text-white" disabled={isOptimizing}>
+          {/* Queue controls: pause/resume and files optimized at once */}
+          <Button variant="outline" onClick={toggleQueuePaused} title={queuePaused ? "继续处理队列" : "暂停队列（正在处理的文件会完成）"}>
+            {queuePaused ? <Play className="w-4 h-4 mr-2" /> : <Pause className="w-4 h-4 mr-2" />}
+            {queuePaused ? `继续${queuedCount > 0 ? ` (${queuedCount})` : ""}` : "暂停"}
+          </Button>
+          <select
+            value={queueWorkers}
+            onChange={(e) => changeQueueWorkers(Number(e.target.value))}
+            className="h-10 rounded-md border border-border bg-background px-2 text-sm text-foreground"
+            title="同时优化的文件数（机械硬盘建议 1，SSD 可调高）"
+          >
+            {[1, 2, 3, 4].map((n) => (
+              <option key={n} value={n}>并行 {n}</option>
+            ))}
+          </select>
+
+          <Button onClick={handleOpenFiles} size="lg" className="bg-blue-600 hover:bg-blue-500 text-white" disabled={isBusy}>
             <Plus className="w-4 h-4 mr-2" />
             添加文件
           </Button>
 
-          <Button onClick={handleOpenDirectory} size="lg" className="bg-blue-700 hover:bg-blue-600 text-white" disabled={isOptimizing}>
+          <Button onClick={handleOpenDirectory} size="lg" className="bg-blue-700 hover:bg-blue-600 text-white" disabled={isBusy}>
             <FolderPlus className="w-4 h-4 mr-2" />
             添加文件夹
           </Button>
@@ -643,7 +840,7 @@ export default function Home() {
                 <TableRow>
                   <TableCell colSpan={6} className="h-[400px] text-center text-muted-foreground">
                     <FileVideo className="w-16 h-16 mx-auto mb-4 opacity-20" />
-                    <p>拖拽 MP4 文件或文件夹到此处</p>
+                    <p>拖拽 MP4/MOV 等视频文件或文件夹到此处</p>
                     <p className="text-sm mt-2">或点击“添加文件”</p>
                     <p className="text-sm mt-2">或点击“添加文件夹”</p>
                   </TableCell>
@@ -683,8 +880,38 @@ export default function Home() {
                     <TableCell className="text-muted-foreground text-sm">
                       {file.metadata ? (
                         <div className="flex flex-col gap-1">
-                          <span className="text-xs font-mono bg-muted px-1 rounded">{file.metadata.width}x{file.metadata.height}</span>
-                          <span className="text-xs text-muted-foreground">{file.metadata.codec}</span>
+                          <span
+                            className="text-xs font-mono bg-muted px-1 rounded"
+                            title={file.metadata.codedWidth ? `编码尺寸 ${file.metadata.codedWidth}x${file.metadata.codedHeight}` : undefined}
+                          >
+                            {file.metadata.width}x{file.metadata.height}{file.metadata.rotation ? ` ↻${file.metadata.rotation}°` : ""}
+                          </span>
+                          <span className="text-xs text-muted-foreground" title={file.metadata.codecs || file.metadata.codecString}>
+                            {file.metadata.codec}{file.metadata.profile ? ` · ${describeCodec(file.metadata)}` : ""}
+                          </span>
+                          {file.metadata.color?.hdr && (
+                            <span className="text-[10px] font-medium text-amber-600 dark:text-amber-400" title={describeColor(file.metadata.color)}>
+                              {file.metadata.color.hdr}
+                            </span>
+                          )}
+                          {file.metadata.video && (
+                            <span className="text-[10px] text-muted-foreground" title={describeVideo(file.metadata.video)}>
+                              {file.metadata.video.frameRate.toFixed(2)} fps{file.metadata.video.variableFrameRate ? " (VFR)" : ""}
+                            </span>
+                          )}
+                          {file.metadata.bitrate > 0 && (
+                            <span className="text-[10px] text-muted-foreground">{formatBitrate(file.metadata.bitrate)}</span>
+                          )}
+                          {file.metadata.tracks && file.metadata.tracks.length > 0 && (
+                            <span className="text-[10px] text-muted-foreground" title={file.metadata.tracks.map(describeTrack).join("\n")}>
+                              {summarizeTracks(file.metadata.tracks)}
+                            </span>
+                          )}
+                          {file.metadata.family && file.metadata.family !== 'mp4' && (
+                            <span className="text-[10px] text-muted-foreground" title={[file.metadata.brand, ...(file.metadata.compatibleBrands || [])].filter(Boolean).join(", ")}>
+                              {familyLabels[file.metadata.family]}
+                            </span>
+                          )}
                         </div>
                       ) : (
                         <span className="text-xs opacity-50">-</span>
@@ -709,7 +936,7 @@ export default function Home() {
                           size="sm"
                           variant="ghost"
                           className="h-8 w-8 p-0 text-blue-400 hover:text-blue-300 hover:bg-blue-900/20"
-                          onClick={() => setPlayingFile(file)}
+                          onClick={() => playFile(file)}
                           title="播放视频"
                         >
                           <Play className="w-4 h-4" />
@@ -723,12 +950,42 @@ export default function Home() {
                               e.stopPropagation();
                               optimizeFile(file.path);
                             }}
-                            disabled={isOptimizing}
+                            disabled={isBusy}
                           >
                             <Zap className="w-3 h-3 mr-1" />
                             优化
                           </Button>
                         )}
+                        {(file.status === 'queued' || file.status === 'optimizing') && (
+                          <Button
+                            size="sm"
+                            variant="ghost"
+                            className="h-8 w-8 p-0 text-muted-foreground hover:text-red-400"
+                            onClick={(e) => {
+                              e.stopPropagation();
+                              cancelJob(file.path);
+                            }}
+                            title="取消"
+                          >
+                            <X className="w-4 h-4" />
+                          </Button>
+                        )}
+                        {file.status === 'fragmented' && (
+                          <Button
+                            size="sm"
+                            variant="secondary"
+                            className="bg-sky-500/10 text-sky-400 hover:bg-sky-500/20 border-sky-500/20 border"
+                            onClick={(e) => {
+                              e.stopPropagation();
+                              optimizeFile(file.path, true);
+                            }}
+                            disabled={isBusy}
+                            title="合并分片为常规 MP4，便于桌面播放器拖动进度"
+                          >
+                            <Zap className="w-3 h-3 mr-1" />
+                            转换
+                          </Button>
+                        )}
                       </div>
                     </TableCell>
                   </TableRow>
@@ -755,7 +1012,7 @@ export default function Home() {
                   controls
                   autoPlay
                   className="w-full h-full object-contain"
-                  src={`/video/${encodeURIComponent(playingFile.path)}`}
+                  src={previewUrl}
                 >
                   您的浏览器不支持 HTML5 视频播放。
                 </video>
@@ -835,8 +1092,10 @@ export default function Home() {
 function StatusBadge({ status, message }: { status: FileStatus, message?: string }) {
   if (status === 'pending') return <Badge variant="outline" className="text-muted-foreground">等待中</Badge>;
   if (status === 'scanning') return <Badge variant="secondary" className="bg-blue-500/10 text-blue-500 dark:text-blue-400"><Loader2 className="w-3 h-3 mr-1 animate-spin" /> 检测中</Badge>;
+  if (status === 'queued') return <Badge variant="outline" className="text-muted-foreground border-border">排队中</Badge>;
   if (status === 'optimizing') return <Badge variant="secondary" className="bg-amber-500/10 text-amber-500 dark:text-amber-400"><Loader2 className="w-3 h-3 mr-1 animate-spin" /> 优化中</Badge>;
   if (status === 'optimized') return <Badge variant="default" className="bg-emerald-500/10 text-emerald-600 dark:text-emerald-400 border-emerald-500/20 border"><CheckCircle2 className="w-3 h-3 mr-1" /> 已优化</Badge>;
+  if (status === 'fragmented') return <Badge variant="secondary" className="bg-sky-500/10 text-sky-600 dark:text-sky-400 border-sky-500/20 border" title={message}><CheckCircle2 className="w-3 h-3 mr-1" /> 分片 MP4</Badge>;
   if (status === 'unoptimized') return <Badge variant="destructive" className="bg-red-500/10 text-red-600 dark:text-red-400 border-red-500/20 border"><XCircle className="w-3 h-3 mr-1" /> 未优化</Badge>;
   if (status === 'error') return <Badge variant="destructive" title={message}>错误</Badge>;
   return null;
@@ -1,12 +1,136 @@
-export type FileStatus = 'pending' | 'scanning' | 'optimizing' | 'optimized' | 'unoptimized' | 'error';
+export type FileStatus = 'pending' | 'scanning' | 'queued' | 'optimizing' | 'optimized' | 'unoptimized' | 'fragmented' | 'error';
 
-export interface FileMetadata {
+// 解码器配置（avcC/hvcC/av1C/vpcC/esds）解析出的编码信息
+export interface CodecInfo {
+    codecString: string; // RFC 6381 编码字符串，如 'avc1.64001F'、'mp4a.40.2'
+    profile?: string; // 如 'High'、'Main 10'、'AAC LC'
+    level?: string; // 如 '4.1'；高 tier 附加 ' High'
+    bitDepth?: number;
+    chromaFormat?: string; // '4:2:0'、'4:2:2'、'4:4:4'、'4:0:0'
+}
+
+export interface FileMetadata extends CodecInfo {
     size: number;
     duration: number;
-    width: number;
+    width: number; // 主视频轨的显示尺寸（已应用像素宽高比与旋转）
     height: number;
-    codec: string;
+    codedWidth?: number; // 编码尺寸
+    codedHeight?: number;
+    rotation?: number; // 顺时针旋转角度：0、90、180、270
+    codec: string; // 主视频轨的样本条目类型；codecString 等字段同样来自主视频轨
+    codecs?: string; // 所有启用的音视频轨的编码字符串，逗号分隔（同 HLS CODECS）
     modified: string; // ISO string from Go time.Time
+    brand?: string; // ftyp 主品牌，如 "isom"、"qt"
+    compatibleBrands?: string[];
+    family: ContainerFamily; // 按 ftyp 品牌识别的真实容器类型
+    tracks: TrackInfo[] | null;
+    bitrate: number; // 整体平均码率 (bit/s)
+    video?: VideoStats; // 主视频轨的帧率与关键帧统计
+    color?: ColorInfo; // 主视频轨的色彩与 HDR 信息
+}
+
+export interface VideoStats {
+    frameRate: number; // 平均帧率
+    maxFrameRate: number;
+    variableFrameRate: boolean; // 可变帧率 (VFR)
+    bFrames: boolean;
+    keyframes: number;
+    minGop: number; // GOP 长度（帧）
+    maxGop: number;
+    avgGop: number;
+    keyframeInterval: number; // 平均关键帧间隔（秒）
+}
+
+// 色彩与 HDR 信息（colr/mdcv/clli、编码配置中的色彩字段、杜比视界 dvcC/dvvC）
+export interface ColorInfo {
+    source?: string; // 色彩参数来源：'nclx'、'nclc'、'vpcC'、'hvcC'、'av1C'
+    colorPrimaries: number; // ITU-T H.273 码值
+    transferCharacteristics: number;
+    matrixCoefficients: number;
+    primaries?: string; // 如 'BT.709'、'BT.2020'
+    matrix?: string;
+    transfer: 'SDR' | 'PQ' | 'HLG';
+    fullRange: boolean; // 全范围 (true) 或有限范围
+    iccProfile?: boolean;
+    mastering?: MasteringDisplay;
+    maxCll?: number; // cd/m²
+    maxFall?: number;
+    dolbyVision?: DolbyVisionConfig;
+    hdr?: 'HDR10' | 'HLG' | 'Dolby Vision'; // SDR 时为空
+}
+
+export interface MasteringDisplay {
+    red: number[]; // CIE 1931 xy
+    green: number[];
+    blue: number[];
+    whitePoint: number[];
+    maxLuminance: number; // cd/m²
+    minLuminance: number;
+}
+
+export interface DolbyVisionConfig {
+    profile: number;
+    level: number;
+    rpu: boolean;
+    el: boolean;
+    bl: boolean;
+    compatibility: number; // 基础层兼容性：1 HDR10、2 SDR、4 HLG
+}
+
+export type TrackType = 'video' | 'audio' | 'subtitle' | 'timecode' | 'hint' | 'data';
+
+export interface TrackInfo extends CodecInfo {
+    id: number;
+    type: TrackType;
+    handler: string; // hdlr 类型，如 'vide'、'soun'
+    codec: string;
+    language: string; // ISO 639-2/T，如 'eng'；未指定为 'und'
+    duration: number; // 秒
+    timescale: number;
+    sampleCount: number;
+    enabled: boolean;
+    default: boolean;
+    alternateGroup: number;
+    width?: number; // tkhd 尺寸（旋转前）
+    height?: number;
+    codedWidth?: number; // 样本条目中的编码尺寸
+    codedHeight?: number;
+    cleanWidth?: number; // clap 净孔径
+    cleanHeight?: number;
+    pixelAspectH?: number; // pasp 像素宽高比
+    pixelAspectV?: number;
+    rotation?: number; // 顺时针旋转角度
+    flipH?: boolean;
+    flipV?: boolean;
+    displayWidth?: number; // 播放器显示尺寸（含旋转）
+    displayHeight?: number;
+    channels?: number; // 音频声道数
+    sampleRate?: number; // 音频采样率 (Hz)
+    bitrate: number; // 平均码率 (bit/s)
+    video?: VideoStats;
+    color?: ColorInfo;
+}
+
+export type ContainerFamily = 'mp4' | 'quicktime' | 'm4a' | 'm4v' | '3gpp' | '3gpp2' | 'unknown';
+
+export type Layout = 'faststart' | 'needs_optimize' | 'fragmented';
+
+export interface FragmentStats {
+    count: number;
+    hasMvex: boolean;
+    hasSidx: boolean;
+    hasMfra: boolean;
+    hasStyp: boolean;
+    durations: number[];
+    minDuration: number;
+    maxDuration: number;
+    avgDuration: number;
+    totalDuration: number;
+}
+
+export interface FileStructure {
+    layout: Layout;
+    fragments?: FragmentStats;
 }
 
 export interface FileItem {
@@ -26,4 +150,27 @@ export interface ProgressEvent {
     path: string;
     progress: number;
     message: string;
+    verification?: 'passed' | 'failed'; // 内容校验结果（仅最终事件）
+}
+
+export type JobState = 'queued' | 'running' | 'done' | 'failed' | 'canceled';
+
+// 后端任务队列中的一个任务（queue-update 事件）
+export interface QueueJob {
+    id: string;
+    path: string;
+    state: JobState;
+    progress: number;
+    message?: string;
+    output?: string;
+    error?: string;
+}
+
+// ScanFiles 的单个结果（scan-result 事件）
+export interface ScanResult {
+    path: string;
+    structure?: FileStructure;
+    truncated: boolean;
+    metadata?: FileMetadata;
+    error?: string;
 }
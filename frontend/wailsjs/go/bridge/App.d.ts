@@ -2,19 +2,43 @@
 // This file is automatically generated. DO NOT EDIT
 import {updater} from '../models';
 import {analyzer} from '../models';
+import {bridge} from '../models';
+import {queue} from '../models';
+
+export function CancelAll():Promise<void>;
+
+export function CancelJob(arg1:string):Promise<boolean>;
+
+export function CancelOptimize(arg1:string):Promise<boolean>;
 
 export function CheckFile(arg1:string):Promise<boolean>;
 
+export function CheckFileStructure(arg1:string):Promise<analyzer.Structure>;
+
 export function CheckForUpdates(arg1:string):Promise<updater.CheckResult>;
 
+export function ClearFiles():Promise<void>;
+
+export function ClearFinishedJobs():Promise<void>;
+
+export function DefragmentFile(arg1:string):Promise<string>;
+
+export function EnqueueFiles(arg1:Array<string>,arg2:bridge.OptimizeOptions):Promise<Array<queue.Job>>;
+
 export function ExpandPaths(arg1:Array<string>):Promise<Array<string>>;
 
 export function ForceClose():Promise<void>;
 
 export function GetAppVersion():Promise<string>;
 
+export function GetExtensions():Promise<Array<string>>;
+
 export function GetFileMetadata(arg1:string):Promise<analyzer.Metadata>;
 
+export function GetLogPath():Promise<string>;
+
+export function GetQueue():Promise<bridge.QueueStatus>;
+
 export function InstallUpdate(arg1:string):Promise<void>;
 
 export function IsForceClosing():Promise<boolean>;
@@ -23,10 +47,32 @@ export function IsOptimizing():Promise<boolean>;
 
 export function OptimizeFile(arg1:string):Promise<void>;
 
+export function OptimizeFileTo(arg1:string,arg2:bridge.OptimizeOptions):Promise<string>;
+
+export function PauseQueue():Promise<void>;
+
+export function PreviewURL(arg1:string):Promise<string>;
+
 export function RequestClose():Promise<boolean>;
 
+export function RestoreBackup(arg1:string):Promise<void>;
+
+export function ResumeQueue():Promise<void>;
+
+export function RetryJob(arg1:string):Promise<boolean>;
+
+export function ScanFiles(arg1:Array<string>):Promise<void>;
+
 export function SelectDirectory():Promise<string>;
 
 export function SelectFiles():Promise<Array<string>>;
 
+export function SetExtensions(arg1:Array<string>):Promise<void>;
+
+export function SetQueueWorkers(arg1:number):Promise<void>;
+
+export function SetSafetyMode(arg1:string,arg2:number):Promise<void>;
+
+export function SetVerifyAfterOptimize(arg1:boolean):Promise<void>;
+
 export function ValidateFile(arg1:string):Promise<boolean>;
@@ -2,14 +2,46 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
 
+export function CancelAll() {
+  return window['go']['bridge']['App']['CancelAll']();
+}
+
+export function CancelJob(arg1) {
+  return window['go']['bridge']['App']['CancelJob'](arg1);
+}
+
+export function CancelOptimize(arg1) {
+  return window['go']['bridge']['App']['CancelOptimize'](arg1);
+}
+
 export function CheckFile(arg1) {
   return window['go']['bridge']['App']['CheckFile'](arg1);
 }
 
+export function CheckFileStructure(arg1) {
+  return window['go']['bridge']['App']['CheckFileStructure'](arg1);
+}
+
 export function CheckForUpdates(arg1) {
   return window['go']['bridge']['App']['CheckForUpdates'](arg1);
 }
 
+export function ClearFiles() {
+  return window['go']['bridge']['App']['ClearFiles']();
+}
+
+export function ClearFinishedJobs() {
+  return window['go']['bridge']['App']['ClearFinishedJobs']();
+}
+
+export function DefragmentFile(arg1) {
+  return window['go']['bridge']['App']['DefragmentFile'](arg1);
+}
+
+export function EnqueueFiles(arg1, arg2) {
+  return window['go']['bridge']['App']['EnqueueFiles'](arg1, arg2);
+}
+
 export function ExpandPaths(arg1) {
   return window['go']['bridge']['App']['ExpandPaths'](arg1);
 }
@@ -22,10 +54,22 @@ export function GetAppVersion() {
   return window['go']['bridge']['App']['GetAppVersion']();
 }
 
+export function GetExtensions() {
+  return window['go']['bridge']['App']['GetExtensions']();
+}
+
 export function GetFileMetadata(arg1) {
   return window['go']['bridge']['App']['GetFileMetadata'](arg1);
 }
 
+export function GetLogPath() {
+  return window['go']['bridge']['App']['GetLogPath']();
+}
+
+export function GetQueue() {
+  return window['go']['bridge']['App']['GetQueue']();
+}
+
 export function InstallUpdate(arg1) {
   return window['go']['bridge']['App']['InstallUpdate'](arg1);
 }
@@ -42,10 +86,38 @@ export function OptimizeFile(arg1) {
   return window['go']['bridge']['App']['OptimizeFile'](arg1);
 }
 
+export function OptimizeFileTo(arg1, arg2) {
+  return window['go']['bridge']['App']['OptimizeFileTo'](arg1, arg2);
+}
+
+export function PauseQueue() {
+  return window['go']['bridge']['App']['PauseQueue']();
+}
+
+export function PreviewURL(arg1) {
+  return window['go']['bridge']['App']['PreviewURL'](arg1);
+}
+
 export function RequestClose() {
   return window['go']['bridge']['App']['RequestClose']();
 }
 
+export function RestoreBackup(arg1) {
+  return window['go']['bridge']['App']['RestoreBackup'](arg1);
+}
+
+export function ResumeQueue() {
+  return window['go']['bridge']['App']['ResumeQueue']();
+}
+
+export function RetryJob(arg1) {
+  return window['go']['bridge']['App']['RetryJob'](arg1);
+}
+
+export function ScanFiles(arg1) {
+  return window['go']['bridge']['App']['ScanFiles'](arg1);
+}
+
 export function SelectDirectory() {
   return window['go']['bridge']['App']['SelectDirectory']();
 }
@@ -54,6 +126,22 @@ export function SelectFiles() {
   return window['go']['bridge']['App']['SelectFiles']();
 }
 
+export function SetExtensions(arg1) {
+  return window['go']['bridge']['App']['SetExtensions'](arg1);
+}
+
+export function SetQueueWorkers(arg1) {
+  return window['go']['bridge']['App']['SetQueueWorkers'](arg1);
+}
+
+export function SetSafetyMode(arg1, arg2) {
+  return window['go']['bridge']['App']['SetSafetyMode'](arg1, arg2);
+}
+
+export function SetVerifyAfterOptimize(arg1) {
+  return window['go']['bridge']['App']['SetVerifyAfterOptimize'](arg1);
+}
+
 export function ValidateFile(arg1) {
   return window['go']['bridge']['App']['ValidateFile'](arg1);
 }
@@ -1,13 +1,191 @@
 export namespace analyzer {
 	
+	export class DolbyVisionConfig {
+	    profile: number;
+	    level: number;
+	    rpu: boolean;
+	    el: boolean;
+	    bl: boolean;
+	    compatibility: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new DolbyVisionConfig(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.profile = source["profile"];
+	        this.level = source["level"];
+	        this.rpu = source["rpu"];
+	        this.el = source["el"];
+	        this.bl = source["bl"];
+	        this.compatibility = source["compatibility"];
+	    }
+	}
+	
+	export class MasteringDisplay {
+	    red: number[];
+	    green: number[];
+	    blue: number[];
+	    whitePoint: number[];
+	    maxLuminance: number;
+	    minLuminance: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new MasteringDisplay(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.red = source["red"];
+	        this.green = source["green"];
+	        this.blue = source["blue"];
+	        this.whitePoint = source["whitePoint"];
+	        this.maxLuminance = source["maxLuminance"];
+	        this.minLuminance = source["minLuminance"];
+	    }
+	}
+	
+	export class ColorInfo {
+	    source?: string;
+	    colorPrimaries: number;
+	    transferCharacteristics: number;
+	    matrixCoefficients: number;
+	    primaries?: string;
+	    matrix?: string;
+	    transfer: string;
+	    fullRange: boolean;
+	    iccProfile?: boolean;
+	    mastering?: MasteringDisplay;
+	    maxCll?: number;
+	    maxFall?: number;
+	    dolbyVision?: DolbyVisionConfig;
+	    hdr?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new ColorInfo(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.source = source["source"];
+	        this.colorPrimaries = source["colorPrimaries"];
+	        this.transferCharacteristics = source["transferCharacteristics"];
+	        this.matrixCoefficients = source["matrixCoefficients"];
+	        this.primaries = source["primaries"];
+	        this.matrix = source["matrix"];
+	        this.transfer = source["transfer"];
+	        this.fullRange = source["fullRange"];
+	        this.iccProfile = source["iccProfile"];
+	        this.mastering = this.convertValues(source["mastering"], MasteringDisplay);
+	        this.maxCll = source["maxCll"];
+	        this.maxFall = source["maxFall"];
+	        this.dolbyVision = this.convertValues(source["dolbyVision"], DolbyVisionConfig);
+	        this.hdr = source["hdr"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	
+	export class VideoStats {
+	    frameRate: number;
+	    maxFrameRate: number;
+	    variableFrameRate: boolean;
+	    bFrames: boolean;
+	    keyframes: number;
+	    minGop: number;
+	    maxGop: number;
+	    avgGop: number;
+	    keyframeInterval: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new VideoStats(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.frameRate = source["frameRate"];
+	        this.maxFrameRate = source["maxFrameRate"];
+	        this.variableFrameRate = source["variableFrameRate"];
+	        this.bFrames = source["bFrames"];
+	        this.keyframes = source["keyframes"];
+	        this.minGop = source["minGop"];
+	        this.maxGop = source["maxGop"];
+	        this.avgGop = source["avgGop"];
+	        this.keyframeInterval = source["keyframeInterval"];
+	    }
+	}
+	
+	export class FragmentStats {
+	    count: number;
+	    hasMvex: boolean;
+	    hasSidx: boolean;
+	    hasMfra: boolean;
+	    hasStyp: boolean;
+	    durations: number[];
+	    minDuration: number;
+	    maxDuration: number;
+	    avgDuration: number;
+	    totalDuration: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new FragmentStats(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.count = source["count"];
+	        this.hasMvex = source["hasMvex"];
+	        this.hasSidx = source["hasSidx"];
+	        this.hasMfra = source["hasMfra"];
+	        this.hasStyp = source["hasStyp"];
+	        this.durations = source["durations"];
+	        this.minDuration = source["minDuration"];
+	        this.maxDuration = source["maxDuration"];
+	        this.avgDuration = source["avgDuration"];
+	        this.totalDuration = source["totalDuration"];
+	    }
+	}
 	export class Metadata {
 	    size: number;
 	    duration: number;
 	    width: number;
 	    height: number;
 	    codec: string;
+	    codedWidth?: number;
+	    codedHeight?: number;
+	    rotation?: number;
+	    codecString: string;
+	    profile?: string;
+	    level?: string;
+	    bitDepth?: number;
+	    chromaFormat?: string;
+	    codecs?: string;
 	    // Go type: time
 	    modified: any;
+	    brand?: string;
+	    compatibleBrands?: string[];
+	    family: string;
+	    tracks: TrackInfo[];
+	    bitrate: number;
+	    video?: VideoStats;
+	    color?: ColorInfo;
 	
 	    static createFrom(source: any = {}) {
 	        return new Metadata(source);
@@ -20,7 +198,280 @@ export namespace analyzer {
 	        this.width = source["width"];
 	        this.height = source["height"];
 	        this.codec = source["codec"];
+	        this.codedWidth = source["codedWidth"];
+	        this.codedHeight = source["codedHeight"];
+	        this.rotation = source["rotation"];
+	        this.codecString = source["codecString"];
+	        this.profile = source["profile"];
+	        this.level = source["level"];
+	        this.bitDepth = source["bitDepth"];
+	        this.chromaFormat = source["chromaFormat"];
+	        this.codecs = source["codecs"];
 	        this.modified = this.convertValues(source["modified"], null);
+	        this.brand = source["brand"];
+	        this.compatibleBrands = source["compatibleBrands"];
+	        this.family = source["family"];
+	        this.tracks = this.convertValues(source["tracks"], TrackInfo);
+	        this.bitrate = source["bitrate"];
+	        this.video = this.convertValues(source["video"], VideoStats);
+	        this.color = this.convertValues(source["color"], ColorInfo);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+
+	export class TrackInfo {
+	    id: number;
+	    type: string;
+	    handler: string;
+	    codec: string;
+	    codecString: string;
+	    profile?: string;
+	    level?: string;
+	    bitDepth?: number;
+	    chromaFormat?: string;
+	    language: string;
+	    duration: number;
+	    timescale: number;
+	    sampleCount: number;
+	    enabled: boolean;
+	    default: boolean;
+	    alternateGroup: number;
+	    width?: number;
+	    height?: number;
+	    codedWidth?: number;
+	    codedHeight?: number;
+	    cleanWidth?: number;
+	    cleanHeight?: number;
+	    pixelAspectH?: number;
+	    pixelAspectV?: number;
+	    rotation?: number;
+	    flipH?: boolean;
+	    flipV?: boolean;
+	    displayWidth?: number;
+	    displayHeight?: number;
+	    channels?: number;
+	    sampleRate?: number;
+	    bitrate: number;
+	    video?: VideoStats;
+	    color?: ColorInfo;
+	
+	    static createFrom(source: any = {}) {
+	        return new TrackInfo(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.type = source["type"];
+	        this.handler = source["handler"];
+	        this.codec = source["codec"];
+	        this.codecString = source["codecString"];
+	        this.profile = source["profile"];
+	        this.level = source["level"];
+	        this.bitDepth = source["bitDepth"];
+	        this.chromaFormat = source["chromaFormat"];
+	        this.language = source["language"];
+	        this.duration = source["duration"];
+	        this.timescale = source["timescale"];
+	        this.sampleCount = source["sampleCount"];
+	        this.enabled = source["enabled"];
+	        this.default = source["default"];
+	        this.alternateGroup = source["alternateGroup"];
+	        this.width = source["width"];
+	        this.height = source["height"];
+	        this.codedWidth = source["codedWidth"];
+	        this.codedHeight = source["codedHeight"];
+	        this.cleanWidth = source["cleanWidth"];
+	        this.cleanHeight = source["cleanHeight"];
+	        this.pixelAspectH = source["pixelAspectH"];
+	        this.pixelAspectV = source["pixelAspectV"];
+	        this.rotation = source["rotation"];
+	        this.flipH = source["flipH"];
+	        this.flipV = source["flipV"];
+	        this.displayWidth = source["displayWidth"];
+	        this.displayHeight = source["displayHeight"];
+	        this.channels = source["channels"];
+	        this.sampleRate = source["sampleRate"];
+	        this.bitrate = source["bitrate"];
+	        this.video = this.convertValues(source["video"], VideoStats);
+	        this.color = this.convertValues(source["color"], ColorInfo);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	export class Structure {
+	    layout: string;
+	    fragments?: FragmentStats;
+	
+	    static createFrom(source: any = {}) {
+	        return new Structure(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.layout = source["layout"];
+	        this.fragments = this.convertValues(source["fragments"], FragmentStats);
+	    }
+
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+
+}
+
+export namespace bridge {
+	
+	export class OptimizeOptions {
+	    outputDir: string;
+	    overwrite: string;
+	    defragment: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new OptimizeOptions(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.outputDir = source["outputDir"];
+	        this.overwrite = source["overwrite"];
+	        this.defragment = source["defragment"];
+	    }
+	}
+	export class QueueStatus {
+	    jobs: queue.Job[];
+	    paused: boolean;
+	    workers: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new QueueStatus(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.jobs = this.convertValues(source["jobs"], queue.Job);
+	        this.paused = source["paused"];
+	        this.workers = source["workers"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+
+}
+
+export namespace queue {
+	
+	export class Options {
+	    outputDir?: string;
+	    overwrite?: string;
+	    defragment?: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new Options(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.outputDir = source["outputDir"];
+	        this.overwrite = source["overwrite"];
+	        this.defragment = source["defragment"];
+	    }
+	}
+	export class Job {
+	    id: string;
+	    path: string;
+	    options: Options;
+	    state: string;
+	    progress: number;
+	    message?: string;
+	    output?: string;
+	    error?: string;
+	    // Go type: time
+	    added: any;
+	    // Go type: time
+	    started?: any;
+	    // Go type: time
+	    finished?: any;
+	
+	    static createFrom(source: any = {}) {
+	        return new Job(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.path = source["path"];
+	        this.options = this.convertValues(source["options"], Options);
+	        this.state = source["state"];
+	        this.progress = source["progress"];
+	        this.message = source["message"];
+	        this.output = source["output"];
+	        this.error = source["error"];
+	        this.added = this.convertValues(source["added"], null);
+	        this.started = this.convertValues(source["started"], null);
+	        this.finished = this.convertValues(source["finished"], null);
 	    }
 	
 		convertValues(a: any, classs: any, asMap: boolean = false): any {
@@ -0,0 +1,110 @@
+package analyzer
+
+import (
+	"io"
+	"strings"
+
+	"mp4-optimizer/pkg/atomic"
+)
+
+// Family is the container type identified by a file's ftyp brands. All of them
+// share the moov/mdat layout, so they are optimized the same way.
+type Family string
+
+const (
+	// FamilyMP4 is an ISO base media / MP4 file (isom, mp41, mp42, avc1, dash, cmfc, ...)
+	FamilyMP4 Family = "mp4"
+	// FamilyQuickTime is a QuickTime movie: the 'qt  ' brand, or no ftyp at all
+	// as written by older QuickTime versions and many cameras
+	FamilyQuickTime Family = "quicktime"
+	// FamilyM4A is Apple's MP4 audio (M4A, audiobook M4B, protected M4P)
+	FamilyM4A Family = "m4a"
+	// FamilyM4V is Apple's MP4 video (M4V, M4VH, M4VP)
+	FamilyM4V Family = "m4v"
+	// Family3GPP is a 3GPP file (3gp4, 3gp5, 3gp6, 3ge6, ...)
+	Family3GPP Family = "3gpp"
+	// Family3GPP2 is a 3GPP2 file (3g2a, 3g2b, ...)
+	Family3GPP2 Family = "3gpp2"
+	// FamilyUnknown means no known brand was found
+	FamilyUnknown Family = "unknown"
+)
+
+// brandFamilies maps exact brands, with trailing spaces trimmed.
+var brandFamilies = map[string]Family{
+	"qt":   FamilyQuickTime,
+	"M4A":  FamilyM4A,
+	"M4B":  FamilyM4A,
+	"M4P":  FamilyM4A,
+	"M4V":  FamilyM4V,
+	"M4VH": FamilyM4V,
+	"M4VP": FamilyM4V,
+	"mp41": FamilyMP4,
+	"mp42": FamilyMP4,
+	"mp71": FamilyMP4,
+	"avc1": FamilyMP4,
+	"dash": FamilyMP4,
+	"msdh": FamilyMP4,
+	"msix": FamilyMP4,
+	"mmp4": FamilyMP4,
+	"MSNV": FamilyMP4,
+	"XAVC": FamilyMP4,
+	"f4v":  FamilyMP4,
+}
+
+// brandFamily classifies one brand, or returns "" if it is not known.
+func brandFamily(brand string) Family {
+	if f, ok := brandFamilies[brand]; ok {
+		return f
+	}
+	switch {
+	case strings.HasPrefix(brand, "3g2"):
+		return Family3GPP2
+	case strings.HasPrefix(brand, "3g"):
+		return Family3GPP
+	case strings.HasPrefix(brand, "iso"), strings.HasPrefix(brand, "cmf"):
+		return FamilyMP4
+	}
+	return ""
+}
+
+// BrandFamily identifies the container from the major brand, falling back to
+// the compatible brands in order when the major brand is not known.
+func BrandFamily(major string, compatible []string) Family {
+	if f := brandFamily(major); f != "" {
+		return f
+	}
+	for _, b := range compatible {
+		if f := brandFamily(b); f != "" {
+			return f
+		}
+	}
+	return FamilyUnknown
+}
+
+// readBrands reads the ftyp of the parsed top-level boxes into meta. Files
+// without an ftyp but with a movie are classic QuickTime.
+func readBrands(r io.ReadSeeker, boxes []*atomic.Box, meta *Metadata) {
+	ftyp := atomic.FindBox(boxes, "ftyp")
+	if ftyp == nil {
+		meta.Family = FamilyUnknown
+		if atomic.FindBox(boxes, "moov") != nil || atomic.FindBox(boxes, "mdat") != nil {
+			meta.Family = FamilyQuickTime
+		}
+		return
+	}
+	data, err := ftyp.ReadBody(r)
+	if err != nil || len(data) < 4 {
+		meta.Family = FamilyUnknown
+		return
+	}
+
+	// major_brand(4) + minor_version(4) + compatible_brands(4 each)
+	meta.Brand = strings.TrimRight(string(data[:4]), " ")
+	meta.CompatibleBrands = nil
+	for i := 8; i+4 <= len(data); i += 4 {
+		if b := strings.TrimRight(string(data[i:i+4]), " \x00"); b != "" {
+			meta.CompatibleBrands = append(meta.CompatibleBrands, b)
+		}
+	}
+	meta.Family = BrandFamily(meta.Brand, meta.CompatibleBrands)
+}
@@ -0,0 +1,49 @@
+package analyzer
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestBrandFamily(t *testing.T) {
+	moov := box("moov", box("mvhd", u32s(0, 0, 0, 1000, 0)))
+	ftyp := func(major string, compatible ...string) []byte {
+		return box("ftyp", []byte(major), u32s(0x200), []byte(strings.Join(compatible, "")))
+	}
+
+	cases := []struct {
+		name       string
+		head       []byte
+		brand      string
+		compatible []string
+		want       Family
+	}{
+		{"clip.mp4", ftyp("isom", "isom", "iso2", "avc1", "mp41"), "isom", []string{"isom", "iso2", "avc1", "mp41"}, FamilyMP4},
+		{"camera.mov", ftyp("qt  ", "qt  "), "qt", []string{"qt"}, FamilyQuickTime},
+		{"old.mov", box("wide"), "", nil, FamilyQuickTime},
+		{"song.m4a", ftyp("M4A ", "M4A ", "mp42", "isom"), "M4A", []string{"M4A", "mp42", "isom"}, FamilyM4A},
+		{"phone.3gp", ftyp("3gp4", "isom", "3gp4"), "3gp4", []string{"isom", "3gp4"}, Family3GPP},
+		{"phone.3g2", ftyp("3g2a", "3g2a"), "3g2a", []string{"3g2a"}, Family3GPP2},
+		// An unknown major brand falls back to the compatible brands
+		{"odd.mp4", ftyp("abcd", "abcd", "mp42"), "abcd", []string{"abcd", "mp42"}, FamilyMP4},
+		{"other.mp4", ftyp("abcd"), "abcd", nil, FamilyUnknown},
+	}
+	dir := t.TempDir()
+	for _, c := range cases {
+		path := filepath.Join(dir, c.name)
+		if err := os.WriteFile(path, bytes.Join([][]byte{c.head, moov, box("mdat")}, nil), 0644); err != nil {
+			t.Fatal(err)
+		}
+		meta, err := GetMetadata(path)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if meta.Brand != c.brand || !slices.Equal(meta.CompatibleBrands, c.compatible) || meta.Family != c.want {
+			t.Errorf("%s: expected %q %v %s, got %q %v %s", c.name, c.brand, c.compatible, c.want, meta.Brand, meta.CompatibleBrands, meta.Family)
+		}
+	}
+}
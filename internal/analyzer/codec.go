@@ -0,0 +1,386 @@
+package analyzer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+	"strconv"
+	"strings"
+
+	"mp4-optimizer/pkg/atomic"
+)
+
+// CodecInfo describes a sample entry and its decoder configuration.
+type CodecInfo struct {
+	// CodecString is the RFC 6381 codecs parameter used by HLS, DASH and
+	// canPlayType, e.g. "avc1.64001F", "hvc1.1.6.L93.B0", "mp4a.40.2" or
+	// "av01.0.08M.08". Without a known configuration it is the sample entry type.
+	CodecString string `json:"codecString"`
+	// Profile is human readable, e.g. "High", "Main 10", "AAC LC"
+	Profile string `json:"profile,omitempty"`
+	// Level is e.g. "3.1", with " High" appended for the HEVC and AV1 high tier
+	Level string `json:"level,omitempty"`
+	// BitDepth is the luma bit depth
+	BitDepth int `json:"bitDepth,omitempty"`
+	// ChromaFormat is "4:2:0", "4:2:2", "4:4:4" or "4:0:0" (monochrome)
+	ChromaFormat string `json:"chromaFormat,omitempty"`
+}
+
+// chromaFormats maps chroma_format_idc of H.264 and H.265.
+var chromaFormats = []string{"4:0:0", "4:2:0", "4:2:2", "4:4:4"}
+
+var avcProfiles = map[byte]string{
+	44: "CAVLC 4:4:4 Intra", 66: "Baseline", 77: "Main", 88: "Extended",
+	100: "High", 110: "High 10", 122: "High 4:2:2", 244: "High 4:4:4 Predictive",
+	118: "Multiview High", 128: "Stereo High",
+}
+
+var hevcProfiles = map[byte]string{
+	1: "Main", 2: "Main 10", 3: "Main Still Picture", 4: "Range Extensions",
+	5: "High Throughput", 9: "Screen Content Coding",
+}
+
+var av1Profiles = []string{"Main", "High", "Professional"}
+
+var aacProfiles = map[int]string{
+	1: "AAC Main", 2: "AAC LC", 3: "AAC SSR", 4: "AAC LTP", 5: "HE-AAC",
+	23: "AAC LD", 29: "HE-AAC v2", 39: "AAC ELD", 42: "xHE-AAC",
+}
+
+// plainCodecs are sample entries whose codecs parameter is just their type,
+// possibly in a different case.
+var plainCodecs = map[string]string{
+	"Opus": "opus",
+	"fLaC": "flac",
+}
+
+var dolbyVisionEntries = map[string]bool{
+	"dvh1": true, "dvhe": true, "dva1": true, "dvav": true, "dav1": true,
+}
+
+// ReadCodec parses the decoder configuration of a sample entry (a child of
+// stsd). Encrypted entries (encv, enca) are described by their original format.
+func ReadCodec(r io.ReadSeeker, entry *atomic.Box) CodecInfo {
+	typ := entry.Type
+	if frma := entry.Find("sinf", "frma"); frma != nil {
+		if data, err := frma.ReadBody(r); err == nil && len(data) >= 4 {
+			typ = string(data[:4])
+		}
+	}
+	info := CodecInfo{CodecString: typ}
+	if s, ok := plainCodecs[typ]; ok {
+		info.CodecString = s
+	}
+
+	config := func(name string) []byte {
+		b := entry.Child(name)
+		if b == nil {
+			// QuickTime nests audio configuration in a 'wave' box
+			b = entry.Find("wave", name)
+		}
+		if b == nil {
+			return nil
+		}
+		data, err := b.ReadBody(r)
+		if err != nil {
+			return nil
+		}
+		return data
+	}
+
+	if data := config("avcC"); data != nil {
+		prefix := typ
+		if !strings.HasPrefix(prefix, "avc") {
+			prefix = "avc1"
+		}
+		parseAVCConfig(data, prefix, &info)
+	} else if data := config("hvcC"); data != nil {
+		prefix := typ
+		if prefix != "hev1" {
+			prefix = "hvc1"
+		}
+		parseHEVCConfig(data, prefix, &info)
+	} else if data := config("av1C"); data != nil {
+		parseAV1Config(data, &info)
+	} else if data := config("vpcC"); data != nil {
+		parseVPConfig(data, typ, &info)
+	} else if data := config("esds"); data != nil {
+		parseESDescriptor(data, typ, &info)
+	}
+
+	// Dolby Vision sample entries are identified by the profile and level of
+	// their configuration, the base layer codec by its own entry
+	if dolbyVisionEntries[typ] {
+		for _, name := range []string{"dvcC", "dvvC", "dvwC"} {
+			if data := config(name); len(data) >= 5 {
+				dv := parseDolbyVisionConfig(data)
+				info.CodecString = fmt.Sprintf("%s.%02d.%02d", typ, dv.Profile, dv.Level)
+				break
+			}
+		}
+	}
+	return info
+}
+
+// parseAVCConfig reads an AVCDecoderConfigurationRecord (ISO/IEC 14496-15 5.3.3).
+func parseAVCConfig(data []byte, prefix string, info *CodecInfo) {
+	// configurationVersion(1) + AVCProfileIndication(1) + profile_compatibility(1) + AVCLevelIndication(1)
+	if len(data) < 4 {
+		return
+	}
+	profile, compat, level := data[1], data[2], data[3]
+	info.CodecString = fmt.Sprintf("%s.%02X%02X%02X", prefix, profile, compat, level)
+	info.Profile = avcProfiles[profile]
+	if profile == 66 && compat&0x40 != 0 {
+		info.Profile = "Constrained Baseline"
+	}
+	if level == 11 && compat&0x10 != 0 && (profile == 66 || profile == 77 || profile == 88) {
+		info.Level = "1b"
+	} else {
+		info.Level = formatLevel(float64(level) / 10)
+	}
+
+	// Only High profiles carry chroma format and bit depth, after the parameter sets
+	info.ChromaFormat, info.BitDepth = "4:2:0", 8
+	switch profile {
+	case 66, 77, 88:
+		return
+	}
+	info.ChromaFormat, info.BitDepth = "", 0
+	if profile == 100 {
+		info.ChromaFormat, info.BitDepth = "4:2:0", 8
+	}
+	if len(data) < 6 {
+		return
+	}
+	pos := 5
+	numSPS := int(data[pos] & 0x1F)
+	pos++
+	for range numSPS {
+		if pos+2 > len(data) {
+			return
+		}
+		pos += 2 + int(binary.BigEndian.Uint16(data[pos:]))
+	}
+	if pos >= len(data) {
+		return
+	}
+	numPPS := int(data[pos])
+	pos++
+	for range numPPS {
+		if pos+2 > len(data) {
+			return
+		}
+		pos += 2 + int(binary.BigEndian.Uint16(data[pos:]))
+	}
+	// reserved(6)+chroma_format(2), reserved(5)+bit_depth_luma_minus8(3), ...
+	if pos+2 <= len(data) {
+		info.ChromaFormat = chromaFormats[data[pos]&0x3]
+		info.BitDepth = int(data[pos+1]&0x7) + 8
+	}
+}
+
+// parseHEVCConfig reads an HEVCDecoderConfigurationRecord (ISO/IEC 14496-15 8.3.3)
+// and formats the codecs parameter as in annex E.3.
+func parseHEVCConfig(data []byte, prefix string, info *CodecInfo) {
+	// configurationVersion(1) + profile_space(2)/tier(1)/profile_idc(5) + compatibility(4)
+	// + constraint_indicator(6) + level_idc(1) + min_spatial_segmentation(2) + parallelismType(1)
+	// + chroma_format_idc(1) + bit_depth_luma_minus8(1)
+	if len(data) < 13 {
+		return
+	}
+	space := data[1] >> 6
+	highTier := data[1]&0x20 != 0
+	profile := data[1] & 0x1F
+	compat := binary.BigEndian.Uint32(data[2:6])
+	constraints := data[6:12]
+	level := data[12]
+
+	var b strings.Builder
+	b.WriteString(prefix + ".")
+	if space > 0 {
+		b.WriteByte('A' + space - 1)
+	}
+	fmt.Fprintf(&b, "%d.%X.", profile, bits.Reverse32(compat))
+	if highTier {
+		b.WriteByte('H')
+	} else {
+		b.WriteByte('L')
+	}
+	b.WriteString(strconv.Itoa(int(level)))
+	// Trailing zero constraint bytes are omitted
+	n := len(constraints)
+	for n > 0 && constraints[n-1] == 0 {
+		n--
+	}
+	for _, c := range constraints[:n] {
+		fmt.Fprintf(&b, ".%X", c)
+	}
+	info.CodecString = b.String()
+
+	info.Profile = hevcProfiles[profile]
+	info.Level = formatLevel(float64(level) / 30)
+	if highTier {
+		info.Level += " High"
+	}
+	if len(data) >= 18 {
+		info.ChromaFormat = chromaFormats[data[16]&0x3]
+		info.BitDepth = int(data[17]&0x7) + 8
+	}
+}
+
+// parseAV1Config reads an AV1CodecConfigurationRecord (AV1 in ISOBMFF 2.3).
+func parseAV1Config(data []byte, info *CodecInfo) {
+	// marker(1)+version(7), seq_profile(3)+seq_level_idx_0(5),
+	// seq_tier_0(1)+high_bitdepth(1)+twelve_bit(1)+monochrome(1)+subsampling_x(1)+subsampling_y(1)+position(2)
+	if len(data) < 3 {
+		return
+	}
+	profile := int(data[1] >> 5)
+	levelIdx := int(data[1] & 0x1F)
+	highTier := data[2]&0x80 != 0
+	depth := 8
+	if data[2]&0x40 != 0 {
+		depth = 10
+		if profile == 2 && data[2]&0x20 != 0 {
+			depth = 12
+		}
+	}
+	tier := "M"
+	if highTier {
+		tier = "H"
+	}
+	info.CodecString = fmt.Sprintf("av01.%d.%02d%s.%02d", profile, levelIdx, tier, depth)
+	if profile < len(av1Profiles) {
+		info.Profile = av1Profiles[profile]
+	}
+	if levelIdx < 31 {
+		info.Level = fmt.Sprintf("%d.%d", 2+levelIdx>>2, levelIdx&3)
+		if highTier {
+			info.Level += " High"
+		}
+	}
+	info.BitDepth = depth
+
+	mono, x, y := data[2]&0x10 != 0, data[2]&0x08 != 0, data[2]&0x04 != 0
+	switch {
+	case mono:
+		info.ChromaFormat = "4:0:0"
+	case x && y:
+		info.ChromaFormat = "4:2:0"
+	case x:
+		info.ChromaFormat = "4:2:2"
+	default:
+		info.ChromaFormat = "4:4:4"
+	}
+}
+
+// parseVPConfig reads a VPCodecConfigurationRecord (VP codec ISOBMFF binding 2.2).
+func parseVPConfig(data []byte, typ string, info *CodecInfo) {
+	// version/flags(4) + profile(1) + level(1) + bitDepth(4)+chromaSubsampling(3)+fullRange(1)
+	if len(data) < 7 {
+		return
+	}
+	profile, level := data[4], data[5]
+	depth := int(data[6] >> 4)
+	subsampling := data[6] >> 1 & 0x7
+	info.CodecString = fmt.Sprintf("%s.%02d.%02d.%02d", typ, profile, level, depth)
+	info.Profile = fmt.Sprintf("Profile %d", profile)
+	info.Level = formatLevel(float64(level) / 10)
+	info.BitDepth = depth
+	switch subsampling {
+	case 0, 1:
+		info.ChromaFormat = "4:2:0"
+	case 2:
+		info.ChromaFormat = "4:2:2"
+	case 3:
+		info.ChromaFormat = "4:4:4"
+	}
+}
+
+// parseESDescriptor reads the ES_Descriptor of an esds box (ISO/IEC 14496-1 7.2.6)
+// for the object type and, for AAC, the audio object type.
+func parseESDescriptor(data []byte, typ string, info *CodecInfo) {
+	if len(data) < 4 {
+		return
+	}
+	// FullBox header
+	es, ok := findDescriptor(data[4:], 0x03)
+	if !ok || len(es) < 3 {
+		return
+	}
+	// ES_ID(2) + flags(1), then the optional fields the flags announce
+	flags := es[2]
+	pos := 3
+	if flags&0x80 != 0 {
+		pos += 2 // dependsOn_ES_ID
+	}
+	if flags&0x40 != 0 {
+		if pos >= len(es) {
+			return
+		}
+		pos += 1 + int(es[pos]) // URL
+	}
+	if flags&0x20 != 0 {
+		pos += 2 // OCR_ES_Id
+	}
+	if pos >= len(es) {
+		return
+	}
+	dc, ok := findDescriptor(es[pos:], 0x04)
+	if !ok || len(dc) < 13 {
+		return
+	}
+	// objectTypeIndication(1) + streamType(1) + bufferSize(3) + maxBitrate(4) + avgBitrate(4)
+	oti := dc[0]
+	info.CodecString = fmt.Sprintf("%s.%02X", typ, oti)
+	dsi, _ := findDescriptor(dc[13:], 0x05)
+
+	switch {
+	case oti == 0x40 && len(dsi) >= 1:
+		// AudioSpecificConfig: audioObjectType(5), escaped to 32+6 bits when 31
+		aot := int(dsi[0] >> 3)
+		if aot == 31 && len(dsi) >= 2 {
+			aot = 32 + int(dsi[0]&0x7)<<3 | int(dsi[1]>>5)
+		}
+		info.CodecString = fmt.Sprintf("%s.40.%d", typ, aot)
+		info.Profile = aacProfiles[aot]
+	case oti == 0x20 && len(dsi) >= 5 && dsi[0] == 0 && dsi[1] == 0 && dsi[2] == 1 && dsi[3] == 0xB0:
+		// MPEG-4 Visual: profile_and_level_indication after the VOS start code
+		info.CodecString = fmt.Sprintf("%s.20.%d", typ, dsi[4])
+	case oti == 0x69 || oti == 0x6B:
+		info.Profile = "MP3"
+	}
+}
+
+// findDescriptor returns the payload of the first descriptor with tag in data,
+// skipping descriptors with other tags.
+func findDescriptor(data []byte, tag byte) ([]byte, bool) {
+	for len(data) >= 2 {
+		t := data[0]
+		// Size is coded in up to 4 bytes of 7 bits each
+		size, pos := 0, 1
+		for ; pos < len(data) && pos <= 4; pos++ {
+			size = size<<7 | int(data[pos]&0x7F)
+			if data[pos]&0x80 == 0 {
+				break
+			}
+		}
+		pos++
+		if pos > len(data) {
+			return nil, false
+		}
+		end := min(pos+size, len(data))
+		if t == tag {
+			return data[pos:end], true
+		}
+		data = data[end:]
+	}
+	return nil, false
+}
+
+// formatLevel prints a level without trailing zeros, e.g. 3.1 or 4.
+func formatLevel(level float64) string {
+	return strconv.FormatFloat(level, 'f', -1, 64)
+}
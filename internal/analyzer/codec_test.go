@@ -0,0 +1,85 @@
+package analyzer
+
+import (
+	"bytes"
+	"testing"
+
+	"mp4-optimizer/pkg/atomic"
+)
+
+func TestReadCodec(t *testing.T) {
+	visual := func(typ string, children ...[]byte) []byte {
+		return box(typ, append([][]byte{make([]byte, 78)}, children...)...)
+	}
+	// QuickTime version 1 sound descriptions add 16 bytes
+	audio := func(typ string, version byte, children ...[]byte) []byte {
+		prefix := make([]byte, 28)
+		prefix[9] = version
+		if version == 1 {
+			prefix = append(prefix, make([]byte, 16)...)
+		}
+		return box(typ, append([][]byte{prefix}, children...)...)
+	}
+	// One SPS of 2 bytes and one PPS of 1 byte, then the High profile extension
+	avcC := func(profile, compat, level byte, ext ...byte) []byte {
+		body := []byte{1, profile, compat, level, 0xFF, 0xE1, 0, 2, 0x67, 0x64, 1, 0, 1, 0x68}
+		return box("avcC", append(body, ext...))
+	}
+	hvcC := func(b1 byte, compat uint32, constraint, level, chroma, depth byte) []byte {
+		body := append([]byte{1, b1}, u32s(compat)...)
+		body = append(body, constraint, 0, 0, 0, 0, 0, level, 0xF0, 0, 0xFC, 0xFC|chroma, 0xF8|depth, 0xF8, 0, 0, 0x0F)
+		return box("hvcC", body)
+	}
+	// ES_Descriptor > DecoderConfigDescriptor > DecoderSpecificInfo, with the
+	// ES_Descriptor size in the 4-byte form
+	esds := func(asc ...byte) []byte {
+		dsi := append([]byte{0x05, byte(len(asc))}, asc...)
+		dc := append([]byte{0x04, byte(13 + len(dsi)), 0x40, 0x15}, make([]byte, 11)...)
+		dc = append(dc, dsi...)
+		es := append([]byte{0x03, 0x80, 0x80, 0x80, byte(3 + len(dc)), 0, 1, 0}, dc...)
+		return box("esds", append(make([]byte, 4), es...))
+	}
+
+	tests := []struct {
+		name  string
+		entry []byte
+		want  CodecInfo
+	}{
+		{"avc high", visual("avc1", avcC(0x64, 0x00, 0x1F, 0xFD, 0xF8, 0)),
+			CodecInfo{"avc1.64001F", "High", "3.1", 8, "4:2:0"}},
+		{"avc high 4:2:2 10-bit", visual("avc3", avcC(0x7A, 0x00, 0x28, 0xFE, 0xFA, 0)),
+			CodecInfo{"avc3.7A0028", "High 4:2:2", "4", 10, "4:2:2"}},
+		{"avc constrained baseline", visual("avc1", avcC(0x42, 0xC0, 0x1E)),
+			CodecInfo{"avc1.42C01E", "Constrained Baseline", "3", 8, "4:2:0"}},
+		{"hevc main", visual("hvc1", hvcC(0x01, 0x60000000, 0xB0, 93, 1, 0)),
+			CodecInfo{"hvc1.1.6.L93.B0", "Main", "3.1", 8, "4:2:0"}},
+		{"hevc main 10 high tier", visual("hev1", hvcC(0x22, 0x20000000, 0x90, 153, 1, 2)),
+			CodecInfo{"hev1.2.4.H153.90", "Main 10", "5.1 High", 10, "4:2:0"}},
+		{"av1", visual("av01", box("av1C", []byte{0x81, 0x08, 0x0C, 0})),
+			CodecInfo{"av01.0.08M.08", "Main", "4.0", 8, "4:2:0"}},
+		{"av1 10-bit", visual("av01", box("av1C", []byte{0x81, 0x0D, 0x4C, 0})),
+			CodecInfo{"av01.0.13M.10", "Main", "5.1", 10, "4:2:0"}},
+		{"vp9", visual("vp09", box("vpcC", []byte{1, 0, 0, 0, 0, 41, 0x82, 1, 1, 1, 0, 0})),
+			CodecInfo{"vp09.00.41.08", "Profile 0", "4.1", 8, "4:2:0"}},
+		{"aac lc", audio("mp4a", 0, esds(0x12, 0x10)),
+			CodecInfo{CodecString: "mp4a.40.2", Profile: "AAC LC"}},
+		{"quicktime he-aac", audio("mp4a", 1, box("wave", box("frma", []byte("mp4a")), esds(0x2B, 0x8A, 0x08, 0x00))),
+			CodecInfo{CodecString: "mp4a.40.5", Profile: "HE-AAC"}},
+		{"encrypted avc", visual("encv", avcC(0x4D, 0x40, 0x29), box("sinf", box("frma", []byte("avc1")))),
+			CodecInfo{"avc1.4D4029", "Main", "4.1", 8, "4:2:0"}},
+		{"opus", audio("Opus", 0), CodecInfo{CodecString: "opus"}},
+		{"unknown", visual("apch"), CodecInfo{CodecString: "apch"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bytes.NewReader(box("stsd", u32s(0, 1), tt.entry))
+			boxes, err := atomic.ParseTree(r)
+			if err != nil || len(boxes) != 1 || len(boxes[0].Children) != 1 {
+				t.Fatalf("Failed to parse the sample entry: %v", err)
+			}
+			if got := ReadCodec(r, boxes[0].Children[0]); got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
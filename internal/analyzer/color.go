@@ -0,0 +1,714 @@
+package analyzer
+
+import (
+	"encoding/binary"
+	"io"
+
+	"mp4-optimizer/pkg/atomic"
+)
+
+// Transfer categories
+const (
+	TransferSDR = "SDR"
+	TransferPQ  = "PQ"  // SMPTE ST 2084
+	TransferHLG = "HLG" // ARIB STD-B67
+)
+
+// HDR formats
+const (
+	HDR10       = "HDR10"
+	HDRHLG      = "HLG"
+	DolbyVision = "Dolby Vision"
+)
+
+// ColorInfo is the color description of a visual sample entry. The code points
+// are those of ITU-T H.273, shared by colr, vpcC and the codec bitstreams.
+type ColorInfo struct {
+	// Source names where the code points were found: "nclx", "nclc", "vpcC",
+	// "hvcC" (SPS VUI) or "av1C" (sequence header)
+	Source                  string `json:"source,omitempty"`
+	ColorPrimaries          int    `json:"colorPrimaries"`
+	TransferCharacteristics int    `json:"transferCharacteristics"`
+	MatrixCoefficients      int    `json:"matrixCoefficients"`
+	// Primaries and Matrix are readable names, e.g. "BT.2020", "BT.709"
+	Primaries string `json:"primaries,omitempty"`
+	Matrix    string `json:"matrix,omitempty"`
+	// Transfer is PQ, HLG or SDR; every other transfer function counts as SDR
+	Transfer  string `json:"transfer"`
+	FullRange bool   `json:"fullRange"`
+	// ICCProfile is true when colr carries an ICC profile
+	ICCProfile bool `json:"iccProfile,omitempty"`
+	// Mastering comes from mdcv, SmDm or a mastering display SEI message
+	Mastering *MasteringDisplay `json:"mastering,omitempty"`
+	// MaxCLL and MaxFALL come from clli, CoLL or a content light level SEI
+	// message, in cd/m²
+	MaxCLL  int `json:"maxCll,omitempty"`
+	MaxFALL int `json:"maxFall,omitempty"`
+	// DolbyVision comes from dvcC, dvvC or dvwC
+	DolbyVision *DolbyVisionConfig `json:"dolbyVision,omitempty"`
+	// HDR is "Dolby Vision", "HDR10" (PQ) or "HLG"; empty for SDR
+	HDR string `json:"hdr,omitempty"`
+}
+
+// MasteringDisplay is the color volume of the mastering display (SMPTE ST 2086).
+// Chromaticities are CIE 1931 xy coordinates, luminances in cd/m².
+type MasteringDisplay struct {
+	Red          [2]float64 `json:"red"`
+	Green        [2]float64 `json:"green"`
+	Blue         [2]float64 `json:"blue"`
+	WhitePoint   [2]float64 `json:"whitePoint"`
+	MaxLuminance float64    `json:"maxLuminance"`
+	MinLuminance float64    `json:"minLuminance"`
+}
+
+// DolbyVisionConfig is the DOVIDecoderConfigurationRecord.
+type DolbyVisionConfig struct {
+	Profile int  `json:"profile"`
+	Level   int  `json:"level"`
+	RPU     bool `json:"rpu"` // reference processing unit present
+	EL      bool `json:"el"`  // enhancement layer present
+	BL      bool `json:"bl"`  // base layer present
+	// Compatibility is dv_bl_signal_compatibility_id: 1 HDR10, 2 SDR, 4 HLG
+	Compatibility int `json:"compatibility"`
+}
+
+var colorPrimaries = map[int]string{
+	1: "BT.709", 4: "BT.470M", 5: "BT.601 PAL", 6: "BT.601 NTSC", 7: "SMPTE 240M",
+	8: "Film", 9: "BT.2020", 10: "XYZ", 11: "DCI-P3", 12: "Display P3", 22: "EBU 3213",
+}
+
+var matrixCoefficients = map[int]string{
+	0: "Identity", 1: "BT.709", 4: "FCC", 5: "BT.601", 6: "BT.601", 7: "SMPTE 240M",
+	8: "YCgCo", 9: "BT.2020 NCL", 10: "BT.2020 CL", 11: "SMPTE 2085",
+	12: "Chroma NCL", 13: "Chroma CL", 14: "ICtCp",
+}
+
+// readColor collects the color description of a visual sample entry. colr
+// wins over vpcC, which wins over the SPS or sequence header inside hvcC and
+// av1C. It returns nil when the entry describes no color at all.
+func readColor(r io.ReadSeeker, entry *atomic.Box) *ColorInfo {
+	body := func(name string) []byte {
+		b := entry.Child(name)
+		if b == nil {
+			return nil
+		}
+		data, err := b.ReadBody(r)
+		if err != nil {
+			return nil
+		}
+		return data
+	}
+	c := &ColorInfo{}
+	found := false
+	setCodes := func(source string, primaries, transfer, matrix int, fullRange bool) {
+		c.Source = source
+		c.ColorPrimaries, c.TransferCharacteristics, c.MatrixCoefficients = primaries, transfer, matrix
+		c.FullRange = fullRange
+		found = true
+	}
+
+	// colr may appear more than once, e.g. nclx next to an ICC profile
+	for _, colr := range entry.ChildrenOfType("colr") {
+		data, err := colr.ReadBody(r)
+		if err != nil || len(data) < 4 {
+			continue
+		}
+		switch string(data[:4]) {
+		case "nclx":
+			// primaries(2) + transfer(2) + matrix(2) + full_range_flag(1)+reserved(7)
+			if len(data) >= 11 && c.Source != "nclx" {
+				setCodes("nclx", int(binary.BigEndian.Uint16(data[4:])), int(binary.BigEndian.Uint16(data[6:])),
+					int(binary.BigEndian.Uint16(data[8:])), data[10]&0x80 != 0)
+			}
+		case "nclc":
+			// QuickTime: the same code points without a range flag
+			if len(data) >= 10 && c.Source == "" {
+				setCodes("nclc", int(binary.BigEndian.Uint16(data[4:])), int(binary.BigEndian.Uint16(data[6:])),
+					int(binary.BigEndian.Uint16(data[8:])), false)
+			}
+		case "rICC", "prof":
+			c.ICCProfile = true
+			found = true
+		}
+	}
+
+	if c.Source == "" {
+		// version/flags(4) + profile(1) + level(1) + bitDepth(4)+chromaSubsampling(3)+fullRange(1)
+		// + colourPrimaries(1) + transferCharacteristics(1) + matrixCoefficients(1)
+		if data := body("vpcC"); len(data) >= 10 {
+			setCodes("vpcC", int(data[7]), int(data[8]), int(data[9]), data[6]&0x1 != 0)
+		}
+	}
+	if data := body("hvcC"); data != nil {
+		vui, sei := parseHEVCArrays(data)
+		if c.Source == "" && vui != nil {
+			setCodes("hvcC", vui.primaries, vui.transfer, vui.matrix, vui.fullRange)
+		}
+		if sei.mastering != nil {
+			c.Mastering = sei.mastering
+			found = true
+		}
+		if sei.maxCLL > 0 || sei.maxFALL > 0 {
+			c.MaxCLL, c.MaxFALL = sei.maxCLL, sei.maxFALL
+			found = true
+		}
+	}
+	if data := body("av1C"); c.Source == "" && len(data) > 4 {
+		if cc := parseAV1SequenceColor(data[4:]); cc != nil {
+			setCodes("av1C", cc.primaries, cc.transfer, cc.matrix, cc.fullRange)
+		}
+	}
+
+	// mdcv and clli are plain boxes; SmDm and CoLL are the FullBox variants of
+	// the VP codec binding with different fixed point scales
+	if data := body("mdcv"); len(data) >= 24 {
+		c.Mastering = parseMasteringDisplay(data)
+		found = true
+	} else if data := body("SmDm"); len(data) >= 28 {
+		c.Mastering = parseSmDm(data[4:])
+		found = true
+	}
+	if data := body("clli"); len(data) >= 4 {
+		c.MaxCLL, c.MaxFALL = int(binary.BigEndian.Uint16(data)), int(binary.BigEndian.Uint16(data[2:]))
+		found = true
+	} else if data := body("CoLL"); len(data) >= 8 {
+		c.MaxCLL, c.MaxFALL = int(binary.BigEndian.Uint16(data[4:])), int(binary.BigEndian.Uint16(data[6:]))
+		found = true
+	}
+	for _, name := range []string{"dvcC", "dvvC", "dvwC"} {
+		if data := body(name); len(data) >= 5 {
+			c.DolbyVision = parseDolbyVisionConfig(data)
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil
+	}
+
+	c.Primaries = colorPrimaries[c.ColorPrimaries]
+	c.Matrix = matrixCoefficients[c.MatrixCoefficients]
+	switch c.TransferCharacteristics {
+	case 16:
+		c.Transfer, c.HDR = TransferPQ, HDR10
+	case 18:
+		c.Transfer, c.HDR = TransferHLG, HDRHLG
+	default:
+		c.Transfer = TransferSDR
+	}
+	if dv := c.DolbyVision; dv != nil {
+		c.HDR = DolbyVision
+		if c.Source == "" {
+			// Without other signaling the base layer compatibility tells the transfer
+			switch dv.Compatibility {
+			case 0, 1, 6:
+				c.Transfer = TransferPQ
+			case 4:
+				c.Transfer = TransferHLG
+			}
+		}
+	}
+	return c
+}
+
+// parseMasteringDisplay reads the mdcv box or the mastering display colour
+// volume SEI message, which share their layout: the green, blue and red
+// primaries and the white point in 0.00002 units, then the maximum and minimum
+// luminance in 0.0001 cd/m².
+func parseMasteringDisplay(data []byte) *MasteringDisplay {
+	xy := func(off int) [2]float64 {
+		return [2]float64{
+			float64(binary.BigEndian.Uint16(data[off:])) * 0.00002,
+			float64(binary.BigEndian.Uint16(data[off+2:])) * 0.00002,
+		}
+	}
+	return &MasteringDisplay{
+		Green:        xy(0),
+		Blue:         xy(4),
+		Red:          xy(8),
+		WhitePoint:   xy(12),
+		MaxLuminance: float64(binary.BigEndian.Uint32(data[16:])) * 0.0001,
+		MinLuminance: float64(binary.BigEndian.Uint32(data[20:])) * 0.0001,
+	}
+}
+
+// parseSmDm reads the SmDm body after its FullBox header: the red, green and
+// blue primaries and the white point in 0.16 fixed point, the maximum luminance
+// in 24.8 and the minimum in 18.14.
+func parseSmDm(data []byte) *MasteringDisplay {
+	xy := func(off int) [2]float64 {
+		return [2]float64{
+			float64(binary.BigEndian.Uint16(data[off:])) / (1 << 16),
+			float64(binary.BigEndian.Uint16(data[off+2:])) / (1 << 16),
+		}
+	}
+	return &MasteringDisplay{
+		Red:          xy(0),
+		Green:        xy(4),
+		Blue:         xy(8),
+		WhitePoint:   xy(12),
+		MaxLuminance: float64(binary.BigEndian.Uint32(data[16:])) / (1 << 8),
+		MinLuminance: float64(binary.BigEndian.Uint32(data[20:])) / (1 << 14),
+	}
+}
+
+// parseDolbyVisionConfig reads a DOVIDecoderConfigurationRecord:
+// dv_version_major(8) + dv_version_minor(8) + dv_profile(7) + dv_level(6)
+// + rpu/el/bl_present_flag(1 each) + dv_bl_signal_compatibility_id(4).
+func parseDolbyVisionConfig(data []byte) *DolbyVisionConfig {
+	return &DolbyVisionConfig{
+		Profile:       int(data[2] >> 1),
+		Level:         int(data[2]&0x1)<<5 | int(data[3]>>3),
+		RPU:           data[3]&0x4 != 0,
+		EL:            data[3]&0x2 != 0,
+		BL:            data[3]&0x1 != 0,
+		Compatibility: int(data[4] >> 4),
+	}
+}
+
+// bitstreamColor is the color description of a codec bitstream.
+type bitstreamColor struct {
+	primaries, transfer, matrix int
+	fullRange                   bool
+}
+
+// hevcSEI holds the HDR metadata of prefix SEI messages stored in hvcC.
+type hevcSEI struct {
+	mastering       *MasteringDisplay
+	maxCLL, maxFALL int
+}
+
+// parseHEVCArrays walks the parameter set arrays of an hvcC for the video
+// signal type of the SPS VUI and for HDR SEI messages.
+func parseHEVCArrays(data []byte) (vui *bitstreamColor, sei hevcSEI) {
+	// 22 bytes of configuration, then numOfArrays
+	if len(data) < 23 {
+		return nil, sei
+	}
+	pos := 23
+	for range int(data[22]) {
+		if pos+3 > len(data) {
+			break
+		}
+		nalType := data[pos] & 0x3F
+		count := int(binary.BigEndian.Uint16(data[pos+1:]))
+		pos += 3
+		for range count {
+			if pos+2 > len(data) {
+				return vui, sei
+			}
+			size := int(binary.BigEndian.Uint16(data[pos:]))
+			pos += 2
+			if pos+size > len(data) {
+				return vui, sei
+			}
+			// Skip the 2-byte NAL unit header
+			if nal := data[pos : pos+size]; len(nal) > 2 {
+				switch nalType {
+				case 33: // SPS
+					if vui == nil {
+						vui = parseHEVCSPSColor(unescapeRBSP(nal[2:]))
+					}
+				case 39: // prefix SEI
+					parseHEVCSEI(unescapeRBSP(nal[2:]), &sei)
+				}
+			}
+			pos += size
+		}
+	}
+	return vui, sei
+}
+
+// unescapeRBSP removes the emulation prevention bytes (00 00 03) of a NAL unit.
+func unescapeRBSP(nal []byte) []byte {
+	out := make([]byte, 0, len(nal))
+	zeros := 0
+	for _, b := range nal {
+		if zeros >= 2 && b == 3 {
+			zeros = 0
+			continue
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+// parseHEVCSEI reads the mastering display colour volume (137) and content
+// light level (144) messages of a prefix SEI RBSP.
+func parseHEVCSEI(rbsp []byte, sei *hevcSEI) {
+	pos := 0
+	// The last byte holds rbsp_trailing_bits
+	for pos < len(rbsp)-1 {
+		typ, size := 0, 0
+		for pos < len(rbsp) && rbsp[pos] == 0xFF {
+			typ += 255
+			pos++
+		}
+		if pos >= len(rbsp) {
+			return
+		}
+		typ += int(rbsp[pos])
+		pos++
+		for pos < len(rbsp) && rbsp[pos] == 0xFF {
+			size += 255
+			pos++
+		}
+		if pos >= len(rbsp) {
+			return
+		}
+		size += int(rbsp[pos])
+		pos++
+		if pos+size > len(rbsp) {
+			return
+		}
+		payload := rbsp[pos : pos+size]
+		switch {
+		case typ == 137 && len(payload) >= 24:
+			sei.mastering = parseMasteringDisplay(payload)
+		case typ == 144 && len(payload) >= 4:
+			sei.maxCLL = int(binary.BigEndian.Uint16(payload))
+			sei.maxFALL = int(binary.BigEndian.Uint16(payload[2:]))
+		}
+		pos += size
+	}
+}
+
+// parseHEVCSPSColor reads an HEVC SPS RBSP (H.265 7.3.2.2) up to the
+// video_signal_type of its VUI. It returns nil when the SPS has no colour
+// description or cannot be read.
+func parseHEVCSPSColor(rbsp []byte) *bitstreamColor {
+	br := &bitReader{data: rbsp}
+	br.skip(4) // sps_video_parameter_set_id
+	maxSubLayers := int(br.u(3))
+	br.skip(1) // sps_temporal_id_nesting_flag
+
+	// profile_tier_level(1, sps_max_sub_layers_minus1)
+	br.skip(88 + 8)
+	profilePresent := make([]bool, maxSubLayers)
+	levelPresent := make([]bool, maxSubLayers)
+	for i := range maxSubLayers {
+		profilePresent[i] = br.u(1) == 1
+		levelPresent[i] = br.u(1) == 1
+	}
+	if maxSubLayers > 0 {
+		br.skip(2 * (8 - maxSubLayers))
+	}
+	for i := range maxSubLayers {
+		if profilePresent[i] {
+			br.skip(88)
+		}
+		if levelPresent[i] {
+			br.skip(8)
+		}
+	}
+
+	br.ue() // sps_seq_parameter_set_id
+	if br.ue() == 3 {
+		br.skip(1) // separate_colour_plane_flag
+	}
+	br.ue() // pic_width_in_luma_samples
+	br.ue() // pic_height_in_luma_samples
+	if br.u(1) == 1 {
+		// conformance window offsets
+		br.ue()
+		br.ue()
+		br.ue()
+		br.ue()
+	}
+	br.ue() // bit_depth_luma_minus8
+	br.ue() // bit_depth_chroma_minus8
+	pocBits := int(br.ue()) + 4
+	first := maxSubLayers
+	if br.u(1) == 1 { // sps_sub_layer_ordering_info_present_flag
+		first = 0
+	}
+	for i := first; i <= maxSubLayers; i++ {
+		br.ue()
+		br.ue()
+		br.ue()
+	}
+	for range 6 {
+		// log2 coding/transform block sizes and transform hierarchy depths
+		br.ue()
+	}
+	if br.u(1) == 1 && br.u(1) == 1 { // scaling_list_enabled, sps_scaling_list_data_present
+		skipScalingListData(br)
+	}
+	br.skip(2)        // amp_enabled_flag, sample_adaptive_offset_enabled_flag
+	if br.u(1) == 1 { // pcm_enabled_flag
+		br.skip(8)
+		br.ue()
+		br.ue()
+		br.skip(1)
+	}
+
+	numSets := int(br.ue())
+	if numSets > 64 {
+		return nil
+	}
+	deltaPocs := make([]int, numSets)
+	for i := range numSets {
+		deltaPocs[i] = skipShortTermRefPicSet(br, i, deltaPocs)
+	}
+	if br.u(1) == 1 { // long_term_ref_pics_present_flag
+		n := int(br.ue())
+		if n > 32 {
+			return nil
+		}
+		for range n {
+			br.skip(pocBits + 1)
+		}
+	}
+	br.skip(2) // sps_temporal_mvp_enabled_flag, strong_intra_smoothing_enabled_flag
+
+	if br.u(1) == 0 { // vui_parameters_present_flag
+		return nil
+	}
+	if br.u(1) == 1 { // aspect_ratio_info_present_flag
+		if br.u(8) == 255 { // EXTENDED_SAR
+			br.skip(32)
+		}
+	}
+	if br.u(1) == 1 { // overscan_info_present_flag
+		br.skip(1)
+	}
+	if br.u(1) == 0 { // video_signal_type_present_flag
+		return nil
+	}
+	br.skip(3) // video_format
+	c := &bitstreamColor{fullRange: br.u(1) == 1}
+	if br.u(1) == 0 { // colour_description_present_flag
+		return nil
+	}
+	c.primaries, c.transfer, c.matrix = int(br.u(8)), int(br.u(8)), int(br.u(8))
+	if br.err {
+		return nil
+	}
+	return c
+}
+
+// skipScalingListData skips scaling_list_data() (H.265 7.3.4).
+func skipScalingListData(br *bitReader) {
+	for sizeID := range 4 {
+		step := 1
+		if sizeID == 3 {
+			step = 3
+		}
+		for matrixID := 0; matrixID < 6; matrixID += step {
+			if br.u(1) == 0 { // scaling_list_pred_mode_flag
+				br.ue() // scaling_list_pred_matrix_id_delta
+				continue
+			}
+			coefs := min(64, 1<<(4+sizeID<<1))
+			if sizeID > 1 {
+				br.ue() // scaling_list_dc_coef_minus8, se(v)
+			}
+			for range coefs {
+				br.ue() // scaling_list_delta_coef, se(v)
+			}
+		}
+	}
+}
+
+// skipShortTermRefPicSet skips st_ref_pic_set(idx) (H.265 7.3.7) and returns
+// its number of delta POCs, which later sets predicted from it need.
+func skipShortTermRefPicSet(br *bitReader, idx int, deltaPocs []int) int {
+	if idx > 0 && br.u(1) == 1 { // inter_ref_pic_set_prediction_flag
+		br.skip(1) // delta_rps_sign
+		br.ue()    // abs_delta_rps_minus1
+		n := 0
+		for range deltaPocs[idx-1] + 1 {
+			used := br.u(1) == 1
+			if used || br.u(1) == 1 { // use_delta_flag
+				n++
+			}
+		}
+		return n
+	}
+	negative, positive := int(br.ue()), int(br.ue())
+	if negative > 16 || positive > 16 {
+		br.err = true
+		return 0
+	}
+	for range negative + positive {
+		br.ue()    // delta_poc_minus1
+		br.skip(1) // used_by_curr_pic_flag
+	}
+	return negative + positive
+}
+
+// parseAV1SequenceColor finds the sequence header OBU among the configOBUs of
+// an av1C and reads its color_config (AV1 5.5).
+func parseAV1SequenceColor(obus []byte) *bitstreamColor {
+	for len(obus) > 0 {
+		// forbidden(1) + obu_type(4) + extension_flag(1) + has_size_field(1) + reserved(1)
+		header := obus[0]
+		typ := header >> 3 & 0xF
+		pos := 1
+		if header&0x4 != 0 {
+			pos++
+		}
+		size := len(obus) - pos
+		if header&0x2 != 0 {
+			v, n := readLEB128(obus[min(pos, len(obus)):])
+			if n == 0 {
+				return nil
+			}
+			pos += n
+			size = int(v)
+		}
+		if pos > len(obus) || size < 0 || pos+size > len(obus) {
+			return nil
+		}
+		if typ == 1 { // OBU_SEQUENCE_HEADER
+			return parseAV1SequenceHeader(obus[pos : pos+size])
+		}
+		obus = obus[pos+size:]
+	}
+	return nil
+}
+
+// readLEB128 decodes an unsigned LEB128 value and returns the bytes it used,
+// or 0 when it is malformed.
+func readLEB128(data []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < 8 && i < len(data); i++ {
+		v |= uint64(data[i]&0x7F) << (7 * i)
+		if data[i]&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// parseAV1SequenceHeader reads sequence_header_obu() up to color_config.
+func parseAV1SequenceHeader(data []byte) *bitstreamColor {
+	br := &bitReader{data: data}
+	profile := br.u(3)
+	br.skip(1) // still_picture
+	reduced := br.u(1) == 1
+	if reduced {
+		br.skip(5) // seq_level_idx[0]
+	} else {
+		decoderModel := false
+		bufferDelayBits := 0
+		if br.u(1) == 1 { // timing_info_present_flag
+			br.skip(64)       // num_units_in_display_tick, time_scale
+			if br.u(1) == 1 { // equal_picture_interval
+				br.ue() // num_ticks_per_picture_minus_1, uvlc()
+			}
+			if br.u(1) == 1 { // decoder_model_info_present_flag
+				decoderModel = true
+				bufferDelayBits = int(br.u(5)) + 1
+				br.skip(32 + 5 + 5)
+			}
+		}
+		displayDelay := br.u(1) == 1
+		for range br.u(5) + 1 { // operating_points_cnt_minus_1
+			br.skip(12) // operating_point_idc
+			if br.u(5) > 7 {
+				br.skip(1) // seq_tier
+			}
+			if decoderModel && br.u(1) == 1 {
+				br.skip(2*bufferDelayBits + 1)
+			}
+			if displayDelay && br.u(1) == 1 {
+				br.skip(4)
+			}
+		}
+	}
+	widthBits, heightBits := int(br.u(4))+1, int(br.u(4))+1
+	br.skip(widthBits + heightBits)
+	if !reduced && br.u(1) == 1 { // frame_id_numbers_present_flag
+		br.skip(4 + 3)
+	}
+	br.skip(3) // use_128x128_superblock, enable_filter_intra, enable_intra_edge_filter
+	if !reduced {
+		br.skip(4) // interintra_compound, masked_compound, warped_motion, dual_filter
+		orderHint := br.u(1) == 1
+		if orderHint {
+			br.skip(2) // enable_jnt_comp, enable_ref_frame_mvs
+		}
+		forceScreenContent := uint32(2)
+		if br.u(1) == 0 { // seq_choose_screen_content_tools
+			forceScreenContent = br.u(1)
+		}
+		if forceScreenContent > 0 && br.u(1) == 0 { // seq_choose_integer_mv
+			br.skip(1) // seq_force_integer_mv
+		}
+		if orderHint {
+			br.skip(3) // order_hint_bits_minus_1
+		}
+	}
+	br.skip(3) // enable_superres, enable_cdef, enable_restoration
+
+	// color_config()
+	if br.u(1) == 1 && profile == 2 { // high_bitdepth
+		br.skip(1) // twelve_bit
+	}
+	mono := false
+	if profile != 1 {
+		mono = br.u(1) == 1
+	}
+	if br.u(1) == 0 { // color_description_present_flag
+		return nil
+	}
+	c := &bitstreamColor{primaries: int(br.u(8)), transfer: int(br.u(8)), matrix: int(br.u(8))}
+	if !mono && c.primaries == 1 && c.transfer == 13 && c.matrix == 0 {
+		// sRGB is always full range
+		c.fullRange = true
+	} else {
+		c.fullRange = br.u(1) == 1
+	}
+	if br.err {
+		return nil
+	}
+	return c
+}
+
+// bitReader reads big-endian bit fields. Reading past the end sets err and
+// yields zeros.
+type bitReader struct {
+	data []byte
+	pos  int // in bits
+	err  bool
+}
+
+func (b *bitReader) u(n int) uint32 {
+	var v uint32
+	for range n {
+		if b.pos >= len(b.data)*8 {
+			b.err = true
+			return 0
+		}
+		v = v<<1 | uint32(b.data[b.pos/8]>>(7-b.pos%8)&1)
+		b.pos++
+	}
+	return v
+}
+
+func (b *bitReader) skip(n int) {
+	b.pos += n
+	if b.pos > len(b.data)*8 {
+		b.err = true
+	}
+}
+
+// ue reads an Exp-Golomb code, which also covers se(v) and AV1 uvlc() when
+// only skipping.
+func (b *bitReader) ue() uint32 {
+	zeros := 0
+	for b.u(1) == 0 {
+		if b.err || zeros == 31 {
+			b.err = true
+			return 0
+		}
+		zeros++
+	}
+	return 1<<zeros - 1 + b.u(zeros)
+}
@@ -0,0 +1,232 @@
+package analyzer
+
+import (
+	"bytes"
+	"math"
+	"math/bits"
+	"testing"
+
+	"mp4-optimizer/pkg/atomic"
+)
+
+// bitWriter builds bitstream headers for the tests.
+type bitWriter struct {
+	data []byte
+	n    int
+}
+
+func (w *bitWriter) u(n int, v uint32) {
+	for i := n - 1; i >= 0; i-- {
+		if w.n%8 == 0 {
+			w.data = append(w.data, 0)
+		}
+		w.data[len(w.data)-1] |= byte(v>>i&1) << (7 - w.n%8)
+		w.n++
+	}
+}
+
+func (w *bitWriter) ue(v uint32) {
+	n := bits.Len32(v + 1)
+	w.u(n-1, 0)
+	w.u(n, v+1)
+}
+
+// hevcSPS writes an SPS with two short-term reference picture sets, the
+// second predicted from the first, and a VUI with the given colour description.
+func hevcSPS(primaries, transfer, matrix uint32) []byte {
+	w := &bitWriter{}
+	w.u(16, 0x4201) // NAL unit header, type 33
+	w.u(4, 0)       // sps_video_parameter_set_id
+	w.u(3, 0)       // sps_max_sub_layers_minus1
+	w.u(1, 1)
+	w.u(8, 0x01) // general_profile_space, tier, profile_idc
+	w.u(32, 0x60000000)
+	w.u(16, 0xB000)
+	w.u(32, 0)
+	w.u(8, 93) // general_level_idc
+	w.ue(0)    // sps_seq_parameter_set_id
+	w.ue(1)    // chroma_format_idc
+	w.ue(1920) // pic_width_in_luma_samples
+	w.ue(1080) // pic_height_in_luma_samples
+	w.u(1, 0)  // conformance_window_flag
+	w.ue(2)    // bit_depth_luma_minus8
+	w.ue(2)    // bit_depth_chroma_minus8
+	w.ue(4)    // log2_max_pic_order_cnt_lsb_minus4
+	w.u(1, 1)  // sps_sub_layer_ordering_info_present_flag
+	w.ue(4)
+	w.ue(2)
+	w.ue(0)
+	for _, v := range []uint32{0, 3, 0, 3, 1, 1} {
+		w.ue(v)
+	}
+	w.u(1, 0) // scaling_list_enabled_flag
+	w.u(2, 3) // amp, sao
+	w.u(1, 0) // pcm_enabled_flag
+	w.ue(2)   // num_short_term_ref_pic_sets
+	w.ue(1)   // set 0: num_negative_pics
+	w.ue(0)   // num_positive_pics
+	w.ue(0)   // delta_poc_s0_minus1
+	w.u(1, 1) // used_by_curr_pic_s0_flag
+	w.u(1, 1) // set 1: inter_ref_pic_set_prediction_flag
+	w.u(1, 0) // delta_rps_sign
+	w.ue(0)   // abs_delta_rps_minus1
+	w.u(2, 3) // used_by_curr_pic_flag for both delta POCs
+	w.u(1, 0) // long_term_ref_pics_present_flag
+	w.u(2, 3) // temporal mvp, strong intra smoothing
+	w.u(1, 1) // vui_parameters_present_flag
+	w.u(1, 1) // aspect_ratio_info_present_flag
+	w.u(8, 1)
+	w.u(1, 0) // overscan_info_present_flag
+	w.u(1, 1) // video_signal_type_present_flag
+	w.u(3, 5)
+	w.u(1, 0) // video_full_range_flag
+	w.u(1, 1) // colour_description_present_flag
+	w.u(8, primaries)
+	w.u(8, transfer)
+	w.u(8, matrix)
+	w.u(1, 1) // rbsp_stop_one_bit
+	return w.data
+}
+
+// av1SequenceHeader writes a sequence header OBU with a colour description.
+func av1SequenceHeader(primaries, transfer, matrix uint32, fullRange bool) []byte {
+	w := &bitWriter{}
+	w.u(3, 0) // seq_profile
+	w.u(2, 0) // still_picture, reduced_still_picture_header
+	w.u(1, 0) // timing_info_present_flag
+	w.u(1, 0) // initial_display_delay_present_flag
+	w.u(5, 0) // operating_points_cnt_minus_1
+	w.u(12, 0)
+	w.u(5, 8) // seq_level_idx
+	w.u(1, 0) // seq_tier
+	w.u(4, 10)
+	w.u(4, 10)
+	w.u(11, 1919)
+	w.u(11, 1079)
+	w.u(1, 0) // frame_id_numbers_present_flag
+	w.u(3, 4)
+	w.u(4, 0)
+	w.u(1, 1) // enable_order_hint
+	w.u(2, 0)
+	w.u(1, 1) // seq_choose_screen_content_tools
+	w.u(1, 1) // seq_choose_integer_mv
+	w.u(3, 6) // order_hint_bits_minus_1
+	w.u(3, 0)
+	w.u(1, 0) // high_bitdepth
+	w.u(1, 0) // mono_chrome
+	w.u(1, 1) // color_description_present_flag
+	w.u(8, primaries)
+	w.u(8, transfer)
+	w.u(8, matrix)
+	if fullRange {
+		w.u(1, 1)
+	} else {
+		w.u(1, 0)
+	}
+	w.u(1, 1) // trailing bits
+	return append([]byte{0x0A, byte(len(w.data))}, w.data...)
+}
+
+func TestReadColor(t *testing.T) {
+	visual := func(typ string, children ...[]byte) []byte {
+		return box(typ, append([][]byte{make([]byte, 78)}, children...)...)
+	}
+	nclx := func(primaries, transfer, matrix uint16, fullRange bool) []byte {
+		body := append([]byte("nclx"), 0, byte(primaries), 0, byte(transfer), 0, byte(matrix), 0)
+		if fullRange {
+			body[10] = 0x80
+		}
+		return box("colr", body)
+	}
+	// BT.2020 primaries and a D65 white point at 1000 and 0.005 cd/m²
+	mdcv := box("mdcv",
+		[]byte{0x21, 0x34, 0x9B, 0xAA, 0x19, 0x96, 0x08, 0xFC, 0x8A, 0x48, 0x39, 0x08, 0x3D, 0x13, 0x40, 0x42},
+		u32s(10000000, 50))
+	// hvcC configuration up to numOfArrays, then the SPS and a prefix SEI
+	// carrying a content light level message
+	hvcC := func(sps []byte) []byte {
+		sei := []byte{0x4E, 0x01, 144, 4, 0x03, 0xE8, 0x01, 0x90, 0x80}
+		body := append(make([]byte, 22), 2, 0x80|33, 0, 1, byte(len(sps)>>8), byte(len(sps)))
+		body = append(body, sps...)
+		body = append(body, 39, 0, 1, 0, byte(len(sei)))
+		return box("hvcC", append(body, sei...))
+	}
+
+	tests := []struct {
+		name  string
+		entry []byte
+		check func(t *testing.T, c *ColorInfo)
+	}{
+		{"hdr10 from colr, mdcv and clli", visual("hvc1", nclx(9, 16, 9, false), mdcv, box("clli", []byte{0x03, 0xE8, 0x01, 0x90})),
+			func(t *testing.T, c *ColorInfo) {
+				if c.Source != "nclx" || c.Transfer != TransferPQ || c.HDR != HDR10 || c.Primaries != "BT.2020" || c.Matrix != "BT.2020 NCL" || c.FullRange {
+					t.Errorf("Expected limited range BT.2020 PQ, got %+v", c)
+				}
+				m := c.Mastering
+				if m == nil || math.Abs(m.MaxLuminance-1000) > 1e-9 || math.Abs(m.MinLuminance-0.005) > 1e-9 ||
+					math.Abs(m.Red[0]-0.708) > 1e-9 || math.Abs(m.WhitePoint[1]-0.329) > 1e-9 {
+					t.Errorf("Unexpected mastering display %+v", m)
+				}
+				if c.MaxCLL != 1000 || c.MaxFALL != 400 {
+					t.Errorf("Expected MaxCLL 1000 and MaxFALL 400, got %d and %d", c.MaxCLL, c.MaxFALL)
+				}
+			}},
+		{"hlg from the hevc sps", visual("hvc1", hvcC(hevcSPS(9, 18, 9))),
+			func(t *testing.T, c *ColorInfo) {
+				if c.Source != "hvcC" || c.Transfer != TransferHLG || c.HDR != HDRHLG || c.ColorPrimaries != 9 || c.MatrixCoefficients != 9 {
+					t.Errorf("Expected BT.2020 HLG from the VUI, got %+v", c)
+				}
+				if c.MaxCLL != 1000 || c.MaxFALL != 400 {
+					t.Errorf("Expected the content light level SEI, got %d and %d", c.MaxCLL, c.MaxFALL)
+				}
+			}},
+		{"sdr from the av1 sequence header", visual("av01", box("av1C", append([]byte{0x81, 0x08, 0x0C, 0}, av1SequenceHeader(1, 1, 1, true)...))),
+			func(t *testing.T, c *ColorInfo) {
+				if c.Source != "av1C" || c.Transfer != TransferSDR || c.HDR != "" || c.Primaries != "BT.709" || !c.FullRange {
+					t.Errorf("Expected full range BT.709 SDR, got %+v", c)
+				}
+			}},
+		{"pq from vpcC", visual("vp09", box("vpcC", []byte{1, 0, 0, 0, 2, 41, 0xA3, 9, 16, 9, 0, 0})),
+			func(t *testing.T, c *ColorInfo) {
+				if c.Source != "vpcC" || c.Transfer != TransferPQ || !c.FullRange {
+					t.Errorf("Expected full range PQ, got %+v", c)
+				}
+			}},
+		{"dolby vision profile 5", visual("dvh1", hvcC(nil), box("dvcC", []byte{1, 0, 5 << 1, 6<<3 | 0x5, 0})),
+			func(t *testing.T, c *ColorInfo) {
+				dv := c.DolbyVision
+				if c.HDR != DolbyVision || c.Transfer != TransferPQ || dv == nil || dv.Profile != 5 || dv.Level != 6 || !dv.RPU || dv.EL || !dv.BL {
+					t.Errorf("Expected Dolby Vision profile 5 level 6, got %+v %+v", c, dv)
+				}
+			}},
+		{"quicktime nclc with an icc profile", visual("apch", box("colr", []byte("prof")), box("colr", append([]byte("nclc"), 0, 1, 0, 1, 0, 1))),
+			func(t *testing.T, c *ColorInfo) {
+				if c.Source != "nclc" || c.Transfer != TransferSDR || !c.ICCProfile || c.Primaries != "BT.709" {
+					t.Errorf("Expected BT.709 with an ICC profile, got %+v", c)
+				}
+			}},
+		{"no color", visual("avc1"),
+			func(t *testing.T, c *ColorInfo) {
+				if c != nil {
+					t.Errorf("Expected no color description, got %+v", c)
+				}
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bytes.NewReader(box("stsd", u32s(0, 1), tt.entry))
+			boxes, err := atomic.ParseTree(r)
+			if err != nil || len(boxes) != 1 || len(boxes[0].Children) != 1 {
+				t.Fatalf("Failed to parse the sample entry: %v", err)
+			}
+			tt.check(t, readColor(r, boxes[0].Children[0]))
+		})
+	}
+
+	// The Dolby Vision entry is named by its own profile and level
+	r := bytes.NewReader(box("stsd", u32s(0, 1), visual("dvh1", box("dvcC", []byte{1, 0, 8 << 1, 9<<3 | 0x5, 0x10}))))
+	boxes, _ := atomic.ParseTree(r)
+	if got := ReadCodec(r, boxes[0].Children[0]).CodecString; got != "dvh1.08.09" {
+		t.Errorf("Expected dvh1.08.09, got %s", got)
+	}
+}
@@ -0,0 +1,226 @@
+package analyzer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+
+	"mp4-optimizer/pkg/atomic"
+)
+
+// Layout classifies how a file arranges its metadata and media.
+type Layout string
+
+const (
+	// LayoutFastStart means moov precedes the media data
+	LayoutFastStart Layout = "faststart"
+	// LayoutNeedsOptimize means moov follows the media data
+	LayoutNeedsOptimize Layout = "needs_optimize"
+	// LayoutFragmented means the media is carried in moof/mdat fragments (fMP4/CMAF).
+	// Such files stream progressively already and are not rewritten by the optimizer.
+	LayoutFragmented Layout = "fragmented"
+)
+
+// Structure describes the top-level layout of a file.
+type Structure struct {
+	Layout    Layout         `json:"layout"`
+	Fragments *FragmentStats `json:"fragments,omitempty"`
+}
+
+// FragmentStats summarizes a fragmented file.
+type FragmentStats struct {
+	Count   int  `json:"count"`
+	HasMvex bool `json:"hasMvex"`
+	HasSidx bool `json:"hasSidx"`
+	HasMfra bool `json:"hasMfra"`
+	HasStyp bool `json:"hasStyp"`
+	// Durations in seconds, measured on the reference track (the first video track if any)
+	Durations   []float64 `json:"durations"`
+	MinDuration float64   `json:"minDuration"`
+	MaxDuration float64   `json:"maxDuration"`
+	AvgDuration float64   `json:"avgDuration"`
+	// TotalDuration covers every fragment, or the mehd duration when no samples were found
+	TotalDuration float64 `json:"totalDuration"`
+}
+
+// CheckStructure classifies the file at path as fast-start, needing optimization or fragmented.
+// For fragmented files it also reports the fragment count and durations.
+func CheckStructure(path string) (*Structure, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open file: %w", err)
+	}
+	defer f.Close()
+
+	boxes, err := atomic.ParseTree(f)
+	if err != nil {
+		return nil, fmt.Errorf("parse atoms: %w", err)
+	}
+	return structureOf(f, boxes)
+}
+
+// structureOf classifies the parsed top-level boxes of f.
+func structureOf(f *os.File, boxes []*atomic.Box) (*Structure, error) {
+	layout, moov, err := layoutOf(boxes)
+	if err != nil {
+		return nil, err
+	}
+	if layout != LayoutFragmented {
+		return &Structure{Layout: layout}, nil
+	}
+
+	stats, err := fragmentStats(f, boxes, moov)
+	if err != nil {
+		return nil, err
+	}
+	return &Structure{Layout: LayoutFragmented, Fragments: stats}, nil
+}
+
+// layoutOf classifies parsed top-level boxes and returns the first moov. A
+// moof anywhere or an mvex in moov makes the file fragmented; otherwise moov
+// must precede the first mdat.
+func layoutOf(boxes []*atomic.Box) (Layout, *atomic.Box, error) {
+	moovIndex, mdatIndex := -1, -1
+	fragmented := false
+	for i, b := range boxes {
+		switch b.Type {
+		case "moov":
+			if moovIndex == -1 {
+				moovIndex = i
+			}
+		case "mdat":
+			if mdatIndex == -1 {
+				mdatIndex = i
+			}
+		case "moof":
+			fragmented = true
+		}
+	}
+	if moovIndex == -1 {
+		return "", nil, fmt.Errorf("no moov atom found")
+	}
+	moov := boxes[moovIndex]
+	switch {
+	case fragmented || moov.Child("mvex") != nil:
+		return LayoutFragmented, moov, nil
+	case mdatIndex == -1 || moovIndex < mdatIndex:
+		return LayoutFastStart, moov, nil
+	}
+	return LayoutNeedsOptimize, moov, nil
+}
+
+func fragmentStats(f *os.File, boxes []*atomic.Box, moov *atomic.Box) (*FragmentStats, error) {
+	stats := &FragmentStats{HasMvex: moov.Child("mvex") != nil}
+	for _, b := range boxes {
+		switch b.Type {
+		case "moof":
+			stats.Count++
+		case "sidx":
+			stats.HasSidx = true
+		case "mfra":
+			stats.HasMfra = true
+		case "styp":
+			stats.HasStyp = true
+		}
+	}
+
+	timescales, refTrack, err := trackTimescales(f, moov)
+	if err != nil {
+		return nil, err
+	}
+	defaults, err := atomic.ReadTrackDefaults(f, moov)
+	if err != nil {
+		return nil, fmt.Errorf("read trex: %w", err)
+	}
+	fragments, err := atomic.ReadFragments(f, boxes, defaults)
+	if err != nil {
+		return nil, fmt.Errorf("read fragments: %w", err)
+	}
+
+	for _, frag := range fragments {
+		var d float64
+		for _, tf := range frag.Tracks {
+			ts := timescales[tf.TrackID]
+			if ts == 0 {
+				continue
+			}
+			secs := float64(tf.Duration()) / float64(ts)
+			if tf.TrackID == refTrack {
+				// The reference track decides whenever the fragment carries it
+				d = secs
+				break
+			}
+			d = max(d, secs)
+		}
+		stats.Durations = append(stats.Durations, d)
+		stats.TotalDuration += d
+		if len(stats.Durations) == 1 || d < stats.MinDuration {
+			stats.MinDuration = d
+		}
+		stats.MaxDuration = max(stats.MaxDuration, d)
+	}
+	if n := len(stats.Durations); n > 0 {
+		stats.AvgDuration = stats.TotalDuration / float64(n)
+	}
+	if stats.TotalDuration == 0 {
+		stats.TotalDuration = fragmentDuration(f, moov)
+	}
+	return stats, nil
+}
+
+// trackTimescales maps track IDs to their mdhd timescale and picks the reference
+// track: the first video track, or the first track if there is no video.
+func trackTimescales(f *os.File, moov *atomic.Box) (map[uint32]uint32, uint32, error) {
+	timescales := make(map[uint32]uint32)
+	var ref uint32
+	refIsVideo := false
+	for _, trak := range moov.ChildrenOfType("trak") {
+		t, err := atomic.ReadTrack(f, trak)
+		if err != nil {
+			return nil, 0, fmt.Errorf("read track: %w", err)
+		}
+		timescales[t.ID] = t.Timescale
+		if ref == 0 || (!refIsVideo && t.Handler == "vide") {
+			ref = t.ID
+			refIsVideo = t.Handler == "vide"
+		}
+	}
+	return timescales, ref, nil
+}
+
+// fragmentDuration returns the mehd fragment duration in seconds, or 0 if absent.
+func fragmentDuration(f *os.File, moov *atomic.Box) float64 {
+	mehd := moov.Find("mvex", "mehd")
+	mvhd := moov.Child("mvhd")
+	if mehd == nil || mvhd == nil {
+		return 0
+	}
+	data, err := mehd.ReadBody(f)
+	if err != nil || len(data) < 8 {
+		return 0
+	}
+	var duration uint64
+	if data[0] == 1 && len(data) >= 12 {
+		duration = binary.BigEndian.Uint64(data[4:12])
+	} else {
+		duration = uint64(binary.BigEndian.Uint32(data[4:8]))
+	}
+
+	hdr, err := mvhd.ReadBody(f)
+	if err != nil || len(hdr) < 20 {
+		return 0
+	}
+	var timescale uint32
+	if hdr[0] == 1 {
+		if len(hdr) < 24 {
+			return 0
+		}
+		timescale = binary.BigEndian.Uint32(hdr[20:24])
+	} else {
+		timescale = binary.BigEndian.Uint32(hdr[12:16])
+	}
+	if timescale == 0 {
+		return 0
+	}
+	return float64(duration) / float64(timescale)
+}
@@ -0,0 +1,92 @@
+package analyzer
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+
+	"mp4-optimizer/pkg/atomic"
+)
+
+// readMatrix decodes the tkhd transformation matrix {a b u, c d v, x y w}
+// into a clockwise display rotation of 0, 90, 180 or 270 degrees and a flip.
+// A mirrored matrix is a horizontal flip followed by the rotation; a mirror
+// rotated by 180 degrees is reported as a vertical flip instead. Matrices that
+// are all zero, as some muxers write, count as the identity.
+func readMatrix(m []byte) (rotation int, flipH, flipV bool) {
+	// a, b, c and d are 16.16 fixed point
+	fixed := func(i int) float64 {
+		return float64(int32(binary.BigEndian.Uint32(m[i*4:]))) / 65536
+	}
+	a, b, c, d := fixed(0), fixed(1), fixed(3), fixed(4)
+	det := a*d - b*c
+	if det == 0 {
+		return 0, false, false
+	}
+	// A point (x, y) is displayed at (x*a + y*c, x*b + y*d)
+	var angle float64
+	if det > 0 {
+		angle = math.Atan2(b, a)
+	} else {
+		flipH = true
+		angle = math.Atan2(-b, -a)
+	}
+	rotation = (int(math.Round(angle*180/math.Pi/90))*90 + 360) % 360
+	if flipH && rotation == 180 {
+		return 0, false, true
+	}
+	return rotation, flipH, false
+}
+
+// readVisualEntry reads the coded size of a visual sample entry and its pasp
+// and clap children into info.
+func readVisualEntry(r io.ReadSeeker, entry *atomic.Box, info *TrackInfo) {
+	// reserved(6) + data_reference_index(2) + pre_defined(2) + reserved(2)
+	// + pre_defined(12) + width(2) + height(2)
+	if data, err := entry.ReadBody(r); err == nil && len(data) >= 28 {
+		info.CodedWidth = int(binary.BigEndian.Uint16(data[24:]))
+		info.CodedHeight = int(binary.BigEndian.Uint16(data[26:]))
+	}
+	if pasp := entry.Child("pasp"); pasp != nil {
+		// hSpacing(4) + vSpacing(4)
+		if data, err := pasp.ReadBody(r); err == nil && len(data) >= 8 {
+			h, v := binary.BigEndian.Uint32(data), binary.BigEndian.Uint32(data[4:])
+			if h > 0 && v > 0 {
+				info.PixelAspectH, info.PixelAspectV = int(h), int(v)
+			}
+		}
+	}
+	if clap := entry.Child("clap"); clap != nil {
+		// cleanApertureWidthN/D(4+4) + cleanApertureHeightN/D(4+4) + horizOffN/D + vertOffN/D
+		if data, err := clap.ReadBody(r); err == nil && len(data) >= 16 {
+			ratio := func(i int) int {
+				n, d := binary.BigEndian.Uint32(data[i:]), binary.BigEndian.Uint32(data[i+4:])
+				if d == 0 {
+					return 0
+				}
+				return int(math.Round(float64(n) / float64(d)))
+			}
+			info.CleanWidth, info.CleanHeight = ratio(0), ratio(8)
+		}
+	}
+}
+
+// setDisplaySize derives the size a player shows. The tkhd size already
+// accounts for the clean aperture and pixel aspect ratio; without one it is
+// computed from the coded size. Rotations by 90 and 270 degrees swap the axes.
+func setDisplaySize(info *TrackInfo) {
+	w, h := info.Width, info.Height
+	if w == 0 || h == 0 {
+		w, h = info.CodedWidth, info.CodedHeight
+		if info.CleanWidth > 0 && info.CleanHeight > 0 {
+			w, h = info.CleanWidth, info.CleanHeight
+		}
+		if info.PixelAspectH > 0 && info.PixelAspectV > 0 {
+			w = int(math.Round(float64(w) * float64(info.PixelAspectH) / float64(info.PixelAspectV)))
+		}
+	}
+	if info.Rotation == 90 || info.Rotation == 270 {
+		w, h = h, w
+	}
+	info.DisplayWidth, info.DisplayHeight = w, h
+}
@@ -0,0 +1,97 @@
+package analyzer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// matrix encodes a, b, c and d of a tkhd matrix, with u, v and w at their
+// identity values.
+func matrix(a, b, c, d int32) []byte {
+	m := make([]byte, 36)
+	for i, v := range []int32{a << 16, b << 16, 0, c << 16, d << 16, 0, 0, 0, 1 << 30} {
+		binary.BigEndian.PutUint32(m[i*4:], uint32(v))
+	}
+	return m
+}
+
+func TestReadMatrix(t *testing.T) {
+	tests := []struct {
+		name         string
+		m            []byte
+		rotation     int
+		flipH, flipV bool
+	}{
+		{"identity", matrix(1, 0, 0, 1), 0, false, false},
+		{"90", matrix(0, 1, -1, 0), 90, false, false},
+		{"180", matrix(-1, 0, 0, -1), 180, false, false},
+		{"270", matrix(0, -1, 1, 0), 270, false, false},
+		{"horizontal flip", matrix(-1, 0, 0, 1), 0, true, false},
+		{"vertical flip", matrix(1, 0, 0, -1), 0, false, true},
+		{"flip and 90", matrix(0, -1, -1, 0), 90, true, false},
+		{"zero", make([]byte, 36), 0, false, false},
+	}
+	for _, tt := range tests {
+		rotation, flipH, flipV := readMatrix(tt.m)
+		if rotation != tt.rotation || flipH != tt.flipH || flipV != tt.flipV {
+			t.Errorf("%s: got %d %v %v, want %d %v %v", tt.name, rotation, flipH, flipV, tt.rotation, tt.flipH, tt.flipV)
+		}
+	}
+}
+
+func TestDisplaySize(t *testing.T) {
+	// geometryTrak builds an enabled video trak with a version 0 tkhd and one
+	// sample entry of the given coded size.
+	geometryTrak := func(id uint32, m []byte, width, height uint32, codedW, codedH uint16, children ...[]byte) []byte {
+		tkhd := make([]byte, 84)
+		binary.BigEndian.PutUint32(tkhd[0:4], 3)
+		binary.BigEndian.PutUint32(tkhd[12:16], id)
+		copy(tkhd[40:76], m)
+		binary.BigEndian.PutUint32(tkhd[76:80], width<<16)
+		binary.BigEndian.PutUint32(tkhd[80:84], height<<16)
+		hdlr := make([]byte, 24)
+		copy(hdlr[8:12], "vide")
+
+		prefix := make([]byte, 78)
+		binary.BigEndian.PutUint16(prefix[24:26], codedW)
+		binary.BigEndian.PutUint16(prefix[26:28], codedH)
+		entry := box("avc1", append([][]byte{prefix}, children...)...)
+		stbl := box("stbl", box("stsd", u32s(0, 1), entry))
+		return box("trak", box("tkhd", tkhd),
+			box("mdia", box("mdhd", u32s(0, 0, 0, 1000, 1000)), box("hdlr", hdlr), box("minf", stbl)))
+	}
+	write := func(name string, trak []byte) *Metadata {
+		moov := box("moov", box("mvhd", u32s(0, 0, 0, 1000, 1000)), trak)
+		path := filepath.Join(t.TempDir(), name)
+		if err := os.WriteFile(path, bytes.Join([][]byte{box("ftyp", []byte("qt  ")), moov, box("mdat")}, nil), 0644); err != nil {
+			t.Fatal(err)
+		}
+		meta, err := GetMetadata(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return meta
+	}
+
+	// A phone recording in portrait: landscape frames rotated by 90 degrees
+	meta := write("portrait.mov", geometryTrak(1, matrix(0, 1, -1, 0), 1920, 1080, 1920, 1080))
+	if meta.Width != 1080 || meta.Height != 1920 || meta.CodedWidth != 1920 || meta.CodedHeight != 1080 || meta.Rotation != 90 {
+		t.Errorf("Expected a 1080x1920 display of 1920x1080 frames rotated by 90, got %dx%d coded %dx%d rotated %d",
+			meta.Width, meta.Height, meta.CodedWidth, meta.CodedHeight, meta.Rotation)
+	}
+
+	// Anamorphic PAL without a tkhd size: 704x576 clean aperture at 16:15
+	clap := box("clap", u32s(704, 1, 576, 1, 0, 1, 0, 1))
+	pasp := box("pasp", u32s(16, 15))
+	meta = write("anamorphic.mov", geometryTrak(1, matrix(1, 0, 0, 1), 0, 0, 720, 576, clap, pasp))
+	tr := meta.Tracks[0]
+	if tr.CleanWidth != 704 || tr.CleanHeight != 576 || tr.PixelAspectH != 16 || tr.PixelAspectV != 15 {
+		t.Errorf("Expected the clap and pasp values, got %+v", tr)
+	}
+	if meta.Width != 751 || meta.Height != 576 || meta.Rotation != 0 {
+		t.Errorf("Expected a 751x576 display, got %dx%d rotated %d", meta.Width, meta.Height, meta.Rotation)
+	}
+}
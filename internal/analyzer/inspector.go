@@ -8,6 +8,8 @@ import (
 )
 
 // CheckFastStart returns true if the MP4 file at path has 'moov' atom before 'mdat' atom.
+// Fragmented files stream progressively by design and also report true; use
+// CheckStructure to tell them apart.
 // It also returns an error if the structure is invalid or atoms are missing.
 func CheckFastStart(path string) (bool, error) {
 	f, err := os.Open(path)
@@ -16,35 +18,16 @@ func CheckFastStart(path string) (bool, error) {
 	}
 	defer f.Close()
 
-	atoms, err := atomic.FindAtoms(f)
+	boxes, err := atomic.ParseTree(f)
 	if err != nil {
 		return false, fmt.Errorf("parse atoms: %w", err)
 	}
-
-	var moovIndex, mdatIndex = -1, -1
-
-	for i, a := range atoms {
-		if a.Type == "moov" {
-			moovIndex = i
-		}
-		if a.Type == "mdat" {
-			mdatIndex = i
-		}
-	}
-
-	if moovIndex == -1 {
-		return false, fmt.Errorf("no moov atom found")
-	}
-	if mdatIndex == -1 {
-		// If no mdat, technically it might be valid metadata-only or fragmented,
-		// but usually we expect mdat.
-		// However, if moov exists and no mdat, it's fast-start compatible (just metadata).
-		// Let's assume true if moov is present.
-		// But in typical video files, we want moov < mdat.
-		return true, nil
+	// Same classification as CheckStructure, without the fragment statistics
+	layout, _, err := layoutOf(boxes)
+	if err != nil {
+		return false, err
 	}
-
-	return moovIndex < mdatIndex, nil
+	return layout != LayoutNeedsOptimize, nil
 }
 
 // ValidateFile checks if the MP4 file at path is complete and not truncated.
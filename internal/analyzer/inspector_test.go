@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -58,3 +59,109 @@ func TestCheckFastStart(t *testing.T) {
 		t.Errorf("Expected slow start, got true")
 	}
 }
+
+// box builds a compact box with the given body.
+func box(typ string, body ...[]byte) []byte {
+	payload := bytes.Join(body, nil)
+	return append(makeAtom(typ, uint32(8+len(payload))), payload...)
+}
+
+func u32s(vals ...uint32) []byte {
+	buf := make([]byte, 4*len(vals))
+	for i, v := range vals {
+		binary.BigEndian.PutUint32(buf[i*4:], v)
+	}
+	return buf
+}
+
+// fragmentedFile builds [ftyp][moov+mvex][moof][mdat][moof][mdat] with one
+// video track at timescale 1000 and fragments of 2s and 1.5s.
+func fragmentedFile() []byte {
+	tkhd := make([]byte, 84)
+	binary.BigEndian.PutUint32(tkhd[12:16], 1)
+	hdlr := make([]byte, 24)
+	copy(hdlr[8:12], "vide")
+	stbl := box("stbl",
+		box("stsd", u32s(0, 0)),
+		box("stts", u32s(0, 0)),
+		box("stsc", u32s(0, 0)),
+		box("stsz", u32s(0, 0, 0)),
+		box("stco", u32s(0, 0)),
+	)
+	trak := box("trak", box("tkhd", tkhd),
+		box("mdia", box("mdhd", u32s(0, 0, 0, 1000, 0, 0)), box("hdlr", hdlr), box("minf", stbl)))
+	moov := box("moov",
+		box("mvhd", u32s(0, 0, 0, 1000, 0)),
+		trak,
+		box("mvex", box("trex", u32s(0, 1, 1, 500, 4, 0))),
+	)
+
+	fragment := func(seq, count uint32) []byte {
+		moof := box("moof", box("mfhd", u32s(0, seq)), box("traf",
+			box("tfhd", u32s(0x020000, 1)),
+			box("trun", u32s(0, count)),
+		))
+		return append(moof, box("mdat", make([]byte, 4*count))...)
+	}
+
+	var buf bytes.Buffer
+	buf.Write(box("ftyp", []byte("iso6"), u32s(0)))
+	buf.Write(moov)
+	buf.Write(fragment(1, 4))
+	buf.Write(fragment(2, 3))
+	return buf.Bytes()
+}
+
+func TestCheckStructure(t *testing.T) {
+	dir := t.TempDir()
+
+	cases := []struct {
+		name string
+		data []byte
+		want Layout
+	}{
+		{"fast", bytes.Join([][]byte{box("ftyp", []byte("isom")), box("moov"), box("mdat")}, nil), LayoutFastStart},
+		{"slow", bytes.Join([][]byte{box("ftyp", []byte("isom")), box("mdat"), box("moov")}, nil), LayoutNeedsOptimize},
+		{"fragmented", fragmentedFile(), LayoutFragmented},
+		// An mvex alone marks the file as fragmented, wherever moov is
+		{"mvex", bytes.Join([][]byte{box("ftyp", []byte("iso6")), box("mdat"), box("moov", box("mvex"))}, nil), LayoutFragmented},
+	}
+	for _, c := range cases {
+		path := filepath.Join(dir, c.name+".mp4")
+		if err := os.WriteFile(path, c.data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		st, err := CheckStructure(path)
+		if err != nil {
+			t.Fatalf("%s: CheckStructure failed: %v", c.name, err)
+		}
+		if st.Layout != c.want {
+			t.Errorf("%s: expected layout %s, got %s", c.name, c.want, st.Layout)
+		}
+		// CheckFastStart agrees: only files that need optimizing report false
+		if fast, err := CheckFastStart(path); err != nil || fast != (c.want != LayoutNeedsOptimize) {
+			t.Errorf("%s: CheckFastStart returned %v, %v", c.name, fast, err)
+		}
+		if (st.Fragments != nil) != (c.want == LayoutFragmented) {
+			t.Errorf("%s: unexpected fragment stats %+v", c.name, st.Fragments)
+		}
+	}
+
+	st, _ := CheckStructure(filepath.Join(dir, "fragmented.mp4"))
+	fr := st.Fragments
+	if fr.Count != 2 || !fr.HasMvex || fr.HasSidx || fr.HasMfra {
+		t.Errorf("Unexpected fragment stats %+v", fr)
+	}
+	if len(fr.Durations) != 2 || fr.Durations[0] != 2 || fr.Durations[1] != 1.5 {
+		t.Errorf("Expected durations [2 1.5], got %v", fr.Durations)
+	}
+	if fr.MinDuration != 1.5 || fr.MaxDuration != 2 || fr.AvgDuration != 1.75 || fr.TotalDuration != 3.5 {
+		t.Errorf("Unexpected duration summary %+v", fr)
+	}
+
+	// The plain check treats fragmented files as streamable
+	fast, err := CheckFastStart(filepath.Join(dir, "fragmented.mp4"))
+	if err != nil || !fast {
+		t.Errorf("Expected CheckFastStart to report true for a fragmented file, got %v, %v", fast, err)
+	}
+}
@@ -0,0 +1,97 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+
+	"mp4-optimizer/pkg/atomic"
+)
+
+// ScanResult is everything the file list shows about one file, gathered with
+// a single open.
+type ScanResult struct {
+	Path      string     `json:"path"`
+	Structure *Structure `json:"structure,omitempty"`
+	// Truncated reports a file whose last box runs past the end of the file
+	Truncated bool      `json:"truncated"`
+	Metadata  *Metadata `json:"metadata,omitempty"`
+	// Error is set when the layout could not be determined. Metadata may
+	// still be present for damaged files.
+	Error string `json:"error,omitempty"`
+}
+
+// ScanFile checks the layout, completeness and metadata of the file at path.
+func ScanFile(path string) ScanResult {
+	result := ScanResult{Path: path}
+	f, err := os.Open(path)
+	if err != nil {
+		result.Error = fmt.Sprintf("open file: %v", err)
+		return result
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		result.Error = fmt.Sprintf("stat file: %v", err)
+		return result
+	}
+
+	// A file that cannot be validated is reported by the layout check below
+	if complete, err := atomic.ValidateFile(f); err == nil {
+		result.Truncated = !complete
+	}
+
+	boxes, parseErr := atomic.ParseTree(f)
+	result.Metadata = metadataOf(f, info, boxes)
+	if parseErr != nil {
+		result.Error = fmt.Sprintf("parse atoms: %v", parseErr)
+		return result
+	}
+	st, err := structureOf(f, boxes)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Structure = st
+	return result
+}
+
+// ScanFiles scans paths with up to workers files at a time and calls fn with
+// each result as it completes, so results arrive out of order. fn is called
+// from the worker goroutines. Zero workers means one per CPU. ScanFiles
+// returns when every file is scanned or ctx is canceled.
+func ScanFiles(ctx context.Context, paths []string, workers int, fn func(ScanResult)) error {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	workers = min(workers, len(paths))
+
+	work := make(chan string)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range work {
+				fn(ScanFile(path))
+			}
+		}()
+	}
+
+	var err error
+feed:
+	for _, path := range paths {
+		select {
+		case work <- path:
+		case <-ctx.Done():
+			err = ctx.Err()
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+	return err
+}
@@ -0,0 +1,67 @@
+package analyzer
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestScanFiles(t *testing.T) {
+	dir := t.TempDir()
+	mvhd := box("mvhd", u32s(0, 0, 0, 1000, 2500))
+	files := map[string][]byte{
+		"fast.mp4": bytes.Join([][]byte{box("ftyp", []byte("isom")), box("moov", mvhd), box("mdat")}, nil),
+		"slow.mp4": bytes.Join([][]byte{box("ftyp", []byte("isom")), box("mdat"), box("moov", mvhd)}, nil),
+		// The mdat claims 64 bytes but only 8 follow
+		"cut.mp4":        bytes.Join([][]byte{box("ftyp", []byte("isom")), box("moov", mvhd), makeAtom("mdat", 72), make([]byte, 8)}, nil),
+		"fragmented.mp4": fragmentedFile(),
+	}
+	var paths []string
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	paths = append(paths, filepath.Join(dir, "missing.mp4"))
+
+	var mu sync.Mutex
+	results := make(map[string]ScanResult)
+	err := ScanFiles(context.Background(), paths, 2, func(r ScanResult) {
+		mu.Lock()
+		results[filepath.Base(r.Path)] = r
+		mu.Unlock()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(paths) {
+		t.Fatalf("Expected %d results, got %d", len(paths), len(results))
+	}
+
+	for name, want := range map[string]Layout{"fast.mp4": LayoutFastStart, "slow.mp4": LayoutNeedsOptimize, "fragmented.mp4": LayoutFragmented} {
+		r := results[name]
+		if r.Error != "" || r.Structure == nil || r.Structure.Layout != want {
+			t.Errorf("%s: expected layout %s, got %+v", name, want, r)
+		}
+		if r.Truncated {
+			t.Errorf("%s: unexpectedly reported as truncated", name)
+		}
+		if r.Metadata == nil || r.Metadata.Size != int64(len(files[name])) {
+			t.Errorf("%s: expected metadata, got %+v", name, r.Metadata)
+		}
+	}
+	if d := results["fast.mp4"].Metadata.Duration; d != 2.5 {
+		t.Errorf("Expected a duration of 2.5s, got %v", d)
+	}
+	if r := results["cut.mp4"]; !r.Truncated || r.Metadata == nil {
+		t.Errorf("Expected cut.mp4 to be truncated with metadata, got %+v", r)
+	}
+	if r := results["missing.mp4"]; r.Error == "" || r.Metadata != nil {
+		t.Errorf("Expected an error for a missing file, got %+v", r)
+	}
+}
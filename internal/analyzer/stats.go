@@ -0,0 +1,126 @@
+package analyzer
+
+import (
+	"io"
+
+	"mp4-optimizer/pkg/atomic"
+)
+
+// VideoStats are the frame timing and keyframe statistics of a video track,
+// computed from stts, stss and ctts.
+type VideoStats struct {
+	// FrameRate is the average: samples over the summed sample durations
+	FrameRate float64 `json:"frameRate"`
+	// MaxFrameRate follows from the shortest sample duration
+	MaxFrameRate float64 `json:"maxFrameRate"`
+	// VariableFrameRate is true when sample durations differ by more than one
+	// timescale tick. The last sample is ignored; muxers often shorten it.
+	VariableFrameRate bool `json:"variableFrameRate"`
+	// BFrames is true when frames are presented in a different order than they
+	// are decoded (ctts offsets reorder them)
+	BFrames bool `json:"bFrames"`
+	// Keyframes is the number of sync samples. A track without stss has only
+	// sync samples (intra-only).
+	Keyframes int `json:"keyframes"`
+	// GOP lengths in frames between consecutive keyframes. The trailing,
+	// usually shorter, group only counts when there is a single keyframe.
+	MinGOP int     `json:"minGop"`
+	MaxGOP int     `json:"maxGop"`
+	AvgGOP float64 `json:"avgGop"`
+	// KeyframeInterval is the average time between keyframes, in seconds
+	KeyframeInterval float64 `json:"keyframeInterval"`
+}
+
+// readTrackStats adds the bitrate, the media byte count and, for video, the
+// frame statistics to info. Tracks whose sample tables cannot be resolved, or
+// that carry their samples in fragments, are left as they are.
+func readTrackStats(r io.ReadSeeker, trak *atomic.Box, info *TrackInfo) (size int64) {
+	t, err := atomic.ReadTrack(r, trak)
+	if err != nil || len(t.Samples) == 0 || t.Timescale == 0 {
+		return 0
+	}
+
+	var ticks uint64
+	for _, s := range t.Samples {
+		size += int64(s.Size)
+		ticks += uint64(s.Duration)
+	}
+	seconds := float64(ticks) / float64(t.Timescale)
+	if seconds == 0 {
+		seconds = info.Duration
+	}
+	if seconds > 0 {
+		info.Bitrate = float64(size) * 8 / seconds
+	}
+
+	if info.Type == TrackVideo && seconds > 0 {
+		info.Video = videoStats(t, seconds)
+	}
+	return size
+}
+
+// videoStats computes the frame and GOP statistics of a video track that
+// spans seconds.
+func videoStats(t *atomic.Track, seconds float64) *VideoStats {
+	samples := t.Samples
+	v := &VideoStats{FrameRate: float64(len(samples)) / seconds}
+
+	// Frame durations, ignoring the last sample
+	timed := samples
+	if len(timed) > 1 {
+		timed = timed[:len(timed)-1]
+	}
+	var minDur, maxDur uint32
+	for _, s := range timed {
+		if s.Duration == 0 {
+			continue
+		}
+		if minDur == 0 || s.Duration < minDur {
+			minDur = s.Duration
+		}
+		maxDur = max(maxDur, s.Duration)
+	}
+	if minDur > 0 {
+		v.MaxFrameRate = float64(t.Timescale) / float64(minDur)
+		v.VariableFrameRate = maxDur-minDur > 1
+	}
+
+	// Presentation order differs from decode order when frames are reordered
+	if t.HasCompositionOffsets {
+		for i := 1; i < len(samples); i++ {
+			if samples[i].PresentationTime() < samples[i-1].PresentationTime() {
+				v.BFrames = true
+				break
+			}
+		}
+	}
+
+	var keyframes []int
+	for i, s := range samples {
+		if s.Sync {
+			keyframes = append(keyframes, i)
+		}
+	}
+	v.Keyframes = len(keyframes)
+	switch len(keyframes) {
+	case 0:
+	case 1:
+		v.MinGOP, v.MaxGOP, v.AvgGOP = len(samples), len(samples), float64(len(samples))
+		v.KeyframeInterval = seconds
+	default:
+		total := 0
+		for i := 1; i < len(keyframes); i++ {
+			n := keyframes[i] - keyframes[i-1]
+			if v.MinGOP == 0 || n < v.MinGOP {
+				v.MinGOP = n
+			}
+			v.MaxGOP = max(v.MaxGOP, n)
+			total += n
+		}
+		gops := len(keyframes) - 1
+		v.AvgGOP = float64(total) / float64(gops)
+		first, last := samples[keyframes[0]], samples[keyframes[gops]]
+		v.KeyframeInterval = float64(last.DecodeTime-first.DecodeTime) / float64(t.Timescale) / float64(gops)
+	}
+	return v
+}
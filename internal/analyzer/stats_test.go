@@ -0,0 +1,106 @@
+package analyzer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// mediaTrak builds an enabled trak whose sample tables all live in one chunk.
+func mediaTrak(id uint32, handler string, timescale uint32, tables ...[]byte) []byte {
+	tkhd := make([]byte, 84)
+	binary.BigEndian.PutUint32(tkhd[0:4], 1)
+	binary.BigEndian.PutUint32(tkhd[12:16], id)
+	if handler == "vide" {
+		binary.BigEndian.PutUint32(tkhd[76:80], 640<<16)
+		binary.BigEndian.PutUint32(tkhd[80:84], 360<<16)
+	}
+	hdlr := make([]byte, 24)
+	copy(hdlr[8:12], handler)
+	stbl := box("stbl", append([][]byte{box("stsd", u32s(0, 0)), box("stco", u32s(0, 1, 0))}, tables...)...)
+	return box("trak", box("tkhd", tkhd),
+		box("mdia", box("mdhd", u32s(0, 0, 0, timescale, 0, 0)), box("hdlr", hdlr), box("minf", stbl)))
+}
+
+func TestVideoStats(t *testing.T) {
+	near := func(a, b float64) bool { return math.Abs(a-b) < 1e-6 }
+
+	// 30 fps at timescale 3000 with I P B B ordering and a keyframe every 4 frames
+	video := mediaTrak(1, "vide", 3000,
+		box("stts", u32s(0, 1, 10, 100)),
+		box("ctts", u32s(0, 3, 1, 100, 1, 300, 8, 0)),
+		box("stss", u32s(0, 3, 1, 5, 9)),
+		box("stsc", u32s(0, 1, 1, 10, 1)),
+		box("stsz", u32s(0, 1000, 10)),
+	)
+	// 1024-sample AAC frames at 48 kHz
+	audio := mediaTrak(2, "soun", 48000,
+		box("stts", u32s(0, 1, 5, 1024)),
+		box("stsc", u32s(0, 1, 1, 5, 1)),
+		box("stsz", u32s(0, 200, 5)),
+	)
+	moov := box("moov", box("mvhd", u32s(0, 0, 0, 1000, 400)), video, audio)
+	path := filepath.Join(t.TempDir(), "stats.mp4")
+	// The mdat holds the bytes the constant sample sizes describe
+	mdat := box("mdat", make([]byte, 10*1000+5*200))
+	if err := os.WriteFile(path, bytes.Join([][]byte{box("ftyp", []byte("isom")), moov, mdat}, nil), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := GetMetadata(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := meta.Video
+	if v == nil {
+		t.Fatal("Expected video statistics")
+	}
+	if !near(v.FrameRate, 30) || !near(v.MaxFrameRate, 30) || v.VariableFrameRate {
+		t.Errorf("Expected constant 30 fps, got %+v", v)
+	}
+	if !v.BFrames {
+		t.Errorf("Expected reordered frames to be reported as B-frames")
+	}
+	if v.Keyframes != 3 || v.MinGOP != 4 || v.MaxGOP != 4 || !near(v.AvgGOP, 4) || !near(v.KeyframeInterval, 400.0/3000) {
+		t.Errorf("Unexpected keyframe statistics %+v", v)
+	}
+
+	if video := meta.Tracks[0]; !near(video.Bitrate, 10*1000*8/(1000.0/3000)) {
+		t.Errorf("Expected a video bitrate of 240 kbit/s, got %v", video.Bitrate)
+	}
+	if audio := meta.Tracks[1]; !near(audio.Bitrate, 5*200*8/(5*1024.0/48000)) || audio.Video != nil {
+		t.Errorf("Expected an audio bitrate of 75 kbit/s without video statistics, got %+v", audio)
+	}
+	if !near(meta.Bitrate, 11000*8/0.4) {
+		t.Errorf("Expected an overall bitrate of 220 kbit/s, got %v", meta.Bitrate)
+	}
+}
+
+func TestVariableFrameRate(t *testing.T) {
+	// Durations of 100 and 50 ticks, no stss (every frame is a keyframe), no ctts
+	video := mediaTrak(1, "vide", 3000,
+		box("stts", u32s(0, 2, 4, 100, 4, 50)),
+		box("stsc", u32s(0, 1, 1, 8, 1)),
+		box("stsz", u32s(0, 10, 8)),
+	)
+	moov := box("moov", box("mvhd", u32s(0, 0, 0, 1000, 200)), video)
+	path := filepath.Join(t.TempDir(), "vfr.mp4")
+	if err := os.WriteFile(path, bytes.Join([][]byte{box("ftyp", []byte("isom")), moov, box("mdat")}, nil), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := GetMetadata(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := meta.Video
+	if v == nil || !v.VariableFrameRate || v.BFrames || v.MaxFrameRate != 60 {
+		t.Fatalf("Expected variable frame rate up to 60 fps without B-frames, got %+v", v)
+	}
+	if v.Keyframes != 8 || v.MinGOP != 1 || v.MaxGOP != 1 {
+		t.Errorf("Expected an intra-only track, got %+v", v)
+	}
+}
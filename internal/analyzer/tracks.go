@@ -0,0 +1,282 @@
+package analyzer
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+
+	"mp4-optimizer/pkg/atomic"
+)
+
+// Track types, derived from the hdlr handler type
+const (
+	TrackVideo    = "video"
+	TrackAudio    = "audio"
+	TrackSubtitle = "subtitle"
+	TrackTimecode = "timecode"
+	TrackHint     = "hint"
+	TrackData     = "data" // timed metadata and anything else
+)
+
+// TrackInfo describes one trak of the movie.
+type TrackInfo struct {
+	ID uint32 `json:"id"`
+	// Type is video, audio, subtitle, timecode, hint or data
+	Type string `json:"type"`
+	// Handler is the hdlr handler type, e.g. "vide", "soun", "sbtl", "tmcd"
+	Handler string `json:"handler"`
+	// Codec is the sample entry type, e.g. "avc1", "mp4a", "tx3g"
+	Codec string `json:"codec"`
+	// CodecInfo is parsed from the decoder configuration of the sample entry
+	CodecInfo
+	// Language is the ISO 639-2/T code from mdhd ("und" when unspecified), or
+	// the BCP 47 tag from elng when present
+	Language    string  `json:"language"`
+	Duration    float64 `json:"duration"` // in seconds
+	Timescale   uint32  `json:"timescale"`
+	SampleCount uint32  `json:"sampleCount"`
+	// Enabled is the tkhd track_enabled flag
+	Enabled bool `json:"enabled"`
+	// Default is true for the enabled track a player picks from its alternate
+	// group: the first enabled one, or every enabled track outside a group
+	Default        bool   `json:"default"`
+	AlternateGroup uint16 `json:"alternateGroup"`
+	// Width and Height are the tkhd presentation size of visual tracks, before
+	// the transformation matrix is applied
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+	// CodedWidth and CodedHeight are the sample entry size of the decoded frames
+	CodedWidth  int `json:"codedWidth,omitempty"`
+	CodedHeight int `json:"codedHeight,omitempty"`
+	// CleanWidth and CleanHeight are the clap clean aperture
+	CleanWidth  int `json:"cleanWidth,omitempty"`
+	CleanHeight int `json:"cleanHeight,omitempty"`
+	// PixelAspectH and PixelAspectV are the pasp pixel aspect ratio, e.g. 4:3
+	PixelAspectH int `json:"pixelAspectH,omitempty"`
+	PixelAspectV int `json:"pixelAspectV,omitempty"`
+	// Rotation is the clockwise display rotation of the tkhd matrix in degrees
+	// (0, 90, 180 or 270); FlipH and FlipV mirror the picture before it
+	Rotation int  `json:"rotation,omitempty"`
+	FlipH    bool `json:"flipH,omitempty"`
+	FlipV    bool `json:"flipV,omitempty"`
+	// DisplayWidth and DisplayHeight are the size a player shows, rotation included
+	DisplayWidth  int `json:"displayWidth,omitempty"`
+	DisplayHeight int `json:"displayHeight,omitempty"`
+	// Channels and SampleRate come from the audio sample entry
+	Channels   int     `json:"channels,omitempty"`
+	SampleRate float64 `json:"sampleRate,omitempty"`
+	// Bitrate is the average in bits per second, from the stsz total over the
+	// summed sample durations
+	Bitrate float64 `json:"bitrate"`
+	// Video holds the frame statistics of video tracks
+	Video *VideoStats `json:"video,omitempty"`
+	// Color is the color and HDR description of video tracks that signal one
+	Color *ColorInfo `json:"color,omitempty"`
+}
+
+// trackTypes maps hdlr handler types to track types.
+var trackTypes = map[string]string{
+	"vide": TrackVideo,
+	"soun": TrackAudio,
+	"text": TrackSubtitle,
+	"sbtl": TrackSubtitle,
+	"subt": TrackSubtitle,
+	"clcp": TrackSubtitle,
+	"tmcd": TrackTimecode,
+	"hint": TrackHint,
+}
+
+// macLanguages maps the classic QuickTime language codes stored in mdhd
+// (values below 0x400) to ISO 639-2/T.
+var macLanguages = map[uint16]string{
+	0: "eng", 1: "fra", 2: "deu", 3: "ita", 4: "nld", 5: "swe", 6: "spa",
+	7: "dan", 8: "por", 9: "nor", 10: "heb", 11: "jpn", 12: "ara", 13: "fin",
+	14: "ell", 15: "isl", 16: "mlt", 17: "tur", 18: "hrv", 19: "zho", 23: "kor",
+	33: "zho",
+}
+
+// readTrackInfo reads what tkhd, mdhd, hdlr, elng and the sample tables say
+// about a trak. Missing or short boxes leave their fields zero.
+func readTrackInfo(r io.ReadSeeker, trak *atomic.Box) TrackInfo {
+	info := TrackInfo{Type: TrackData, Language: "und"}
+
+	if tkhd := trak.Child("tkhd"); tkhd != nil {
+		if data, err := tkhd.ReadBody(r); err == nil && len(data) > 0 {
+			// Ver(1)+Flags(3) + Create(4/8) + Mod(4/8) + TrackID(4) + Reserved(4) + Duration(4/8)
+			// + Reserved(8) + Layer(2) + Alt(2) + Vol(2) + Reserved(2) + Matrix(36) + Width(4) + Height(4)
+			idOffset, groupOffset, matrixOffset, dimOffset := 12, 34, 40, 76
+			if data[0] == 1 {
+				idOffset, groupOffset, matrixOffset, dimOffset = 20, 46, 52, 88
+			}
+			if len(data) >= 4 {
+				info.Enabled = data[3]&0x1 != 0
+			}
+			if len(data) >= idOffset+4 {
+				info.ID = binary.BigEndian.Uint32(data[idOffset:])
+			}
+			if len(data) >= groupOffset+2 {
+				info.AlternateGroup = binary.BigEndian.Uint16(data[groupOffset:])
+			}
+			if len(data) >= matrixOffset+36 {
+				info.Rotation, info.FlipH, info.FlipV = readMatrix(data[matrixOffset : matrixOffset+36])
+			}
+			if len(data) >= dimOffset+8 {
+				// Fixed point 16.16 values
+				info.Width = int(binary.BigEndian.Uint32(data[dimOffset:]) >> 16)
+				info.Height = int(binary.BigEndian.Uint32(data[dimOffset+4:]) >> 16)
+			}
+		}
+	}
+
+	mdia := trak.Child("mdia")
+	if mdia == nil {
+		return info
+	}
+	if mdhd := mdia.Child("mdhd"); mdhd != nil {
+		if data, err := mdhd.ReadBody(r); err == nil && len(data) > 0 {
+			var duration uint64
+			langOffset := 20
+			if data[0] == 1 && len(data) >= 32 {
+				info.Timescale = binary.BigEndian.Uint32(data[20:24])
+				duration = binary.BigEndian.Uint64(data[24:32])
+				langOffset = 32
+			} else if len(data) >= 20 {
+				info.Timescale = binary.BigEndian.Uint32(data[12:16])
+				duration = uint64(binary.BigEndian.Uint32(data[16:20]))
+			}
+			// All ones means the duration is unknown
+			unknown := duration == math.MaxUint64 || (data[0] == 0 && duration == math.MaxUint32)
+			if info.Timescale != 0 && !unknown {
+				info.Duration = float64(duration) / float64(info.Timescale)
+			}
+			if len(data) >= langOffset+2 {
+				info.Language = decodeLanguage(binary.BigEndian.Uint16(data[langOffset:]))
+			}
+		}
+	}
+	if elng := mdia.Child("elng"); elng != nil {
+		// FullBox header + null-terminated BCP 47 tag
+		if data, err := elng.ReadBody(r); err == nil && len(data) > 4 {
+			if tag := cString(data[4:]); tag != "" {
+				info.Language = tag
+			}
+		}
+	}
+	if hdlr := mdia.Child("hdlr"); hdlr != nil {
+		if data, err := hdlr.ReadBody(r); err == nil && len(data) >= 12 {
+			info.Handler = string(data[8:12])
+		}
+	}
+	if t, ok := trackTypes[info.Handler]; ok {
+		info.Type = t
+	}
+	if info.Type != TrackVideo {
+		// Audio and text tracks often carry a zero or nominal size and matrix
+		info.Width, info.Height = 0, 0
+		info.Rotation, info.FlipH, info.FlipV = 0, false, false
+	}
+
+	stbl := mdia.Find("minf", "stbl")
+	if stbl == nil {
+		if info.Type == TrackVideo {
+			setDisplaySize(&info)
+		}
+		return info
+	}
+	if stsd := stbl.Child("stsd"); stsd != nil && len(stsd.Children) > 0 {
+		entry := stsd.Children[0]
+		info.Codec = entry.Type
+		info.CodecInfo = ReadCodec(r, entry)
+		switch info.Type {
+		case TrackAudio:
+			info.Channels, info.SampleRate = readAudioEntry(r, entry)
+		case TrackVideo:
+			info.Color = readColor(r, entry)
+			readVisualEntry(r, entry, &info)
+		}
+	}
+	if info.Type == TrackVideo {
+		setDisplaySize(&info)
+	}
+	for _, typ := range []string{"stsz", "stz2"} {
+		if b := stbl.Child(typ); b != nil {
+			// Ver/Flags(4) + sample_size or field_size(4) + sample_count(4)
+			if data, err := b.ReadBody(r); err == nil && len(data) >= 12 {
+				info.SampleCount = binary.BigEndian.Uint32(data[8:12])
+			}
+			break
+		}
+	}
+	return info
+}
+
+// readAudioEntry returns the channel count and sample rate of an audio
+// sample entry. QuickTime version 2 sound descriptions keep both elsewhere.
+func readAudioEntry(r io.ReadSeeker, entry *atomic.Box) (int, float64) {
+	data, err := entry.ReadBody(r)
+	if err != nil || len(data) < 28 {
+		return 0, 0
+	}
+	// reserved(6) + data_reference_index(2) + version(2) + revision(2) + vendor(4)
+	// + channelcount(2) + samplesize(2) + pre_defined(2) + reserved(2) + samplerate(4, 16.16)
+	if binary.BigEndian.Uint16(data[8:10]) == 2 && len(data) >= 44 {
+		// sizeOfStructOnly(4) + audioSampleRate(float64) + numAudioChannels(4)
+		rate := math.Float64frombits(binary.BigEndian.Uint64(data[32:40]))
+		return int(binary.BigEndian.Uint32(data[40:44])), rate
+	}
+	channels := int(binary.BigEndian.Uint16(data[16:18]))
+	rate := float64(binary.BigEndian.Uint32(data[24:28])) / 65536
+	return channels, rate
+}
+
+// decodeLanguage decodes the packed ISO 639-2/T code of mdhd: three 5-bit
+// letters offset from 0x60. Smaller values are classic QuickTime codes.
+func decodeLanguage(v uint16) string {
+	if v < 0x400 {
+		if lang, ok := macLanguages[v]; ok {
+			return lang
+		}
+		return "und"
+	}
+	b := []byte{
+		byte(v>>10&0x1F) + 0x60,
+		byte(v>>5&0x1F) + 0x60,
+		byte(v&0x1F) + 0x60,
+	}
+	for _, c := range b {
+		if c < 'a' || c > 'z' {
+			return "und"
+		}
+	}
+	return string(b)
+}
+
+// cString returns data up to the first NUL byte.
+func cString(data []byte) string {
+	for i, c := range data {
+		if c == 0 {
+			return string(data[:i])
+		}
+	}
+	return string(data)
+}
+
+// markDefaultTracks sets Default on the first enabled track of each
+// alternate group, and on every enabled track outside a group.
+func markDefaultTracks(tracks []TrackInfo) {
+	seen := make(map[uint16]bool)
+	for i := range tracks {
+		t := &tracks[i]
+		if !t.Enabled {
+			continue
+		}
+		if t.AlternateGroup == 0 {
+			t.Default = true
+			continue
+		}
+		if !seen[t.AlternateGroup] {
+			seen[t.AlternateGroup] = true
+			t.Default = true
+		}
+	}
+}
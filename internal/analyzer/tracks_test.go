@@ -0,0 +1,82 @@
+package analyzer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testTrak builds a trak with a version 0 tkhd, an mdhd at timescale 1000 and
+// one sample entry.
+func testTrak(id, flags uint32, group uint16, width, height uint32, handler, lang string, entry []byte, samples uint32) []byte {
+	tkhd := make([]byte, 84)
+	binary.BigEndian.PutUint32(tkhd[0:4], flags)
+	binary.BigEndian.PutUint32(tkhd[12:16], id)
+	binary.BigEndian.PutUint16(tkhd[34:36], group)
+	binary.BigEndian.PutUint32(tkhd[76:80], width<<16)
+	binary.BigEndian.PutUint32(tkhd[80:84], height<<16)
+
+	packed := uint16(lang[0]-0x60)<<10 | uint16(lang[1]-0x60)<<5 | uint16(lang[2]-0x60)
+	mdhd := append(u32s(0, 0, 0, 1000, 4000), byte(packed>>8), byte(packed), 0, 0)
+	hdlr := make([]byte, 24)
+	copy(hdlr[8:12], handler)
+
+	stbl := box("stbl",
+		box("stsd", u32s(0, 1), entry),
+		box("stsz", u32s(0, 0, samples)),
+	)
+	return box("trak", box("tkhd", tkhd), box("mdia", box("mdhd", mdhd), box("hdlr", hdlr), box("minf", stbl)))
+}
+
+func TestTracks(t *testing.T) {
+	// reserved(6) + data_reference_index(2) + reserved(8) + channels(2) + samplesize(2) + reserved(4) + rate(4)
+	audioEntry := func(typ string, channels uint16) []byte {
+		body := make([]byte, 28)
+		binary.BigEndian.PutUint16(body[16:18], channels)
+		binary.BigEndian.PutUint32(body[24:28], 48000<<16)
+		return box(typ, body)
+	}
+	moov := box("moov",
+		box("mvhd", u32s(0, 0, 0, 1000, 4000)),
+		// A disabled thumbnail track ahead of the real video
+		testTrak(1, 0, 0, 160, 90, "vide", "und", box("jpeg", make([]byte, 78)), 1),
+		testTrak(2, 3, 0, 1920, 1080, "vide", "und", box("avc1", make([]byte, 78)), 100),
+		testTrak(3, 3, 1, 0, 0, "soun", "eng", audioEntry("mp4a", 2), 188),
+		testTrak(4, 3, 1, 0, 0, "soun", "deu", audioEntry("ac-3", 6), 125),
+		testTrak(5, 0, 2, 0, 0, "sbtl", "fra", box("tx3g", make([]byte, 38)), 12),
+		testTrak(6, 3, 0, 0, 0, "tmcd", "und", box("tmcd", make([]byte, 26)), 1),
+	)
+	path := filepath.Join(t.TempDir(), "tracks.mov")
+	if err := os.WriteFile(path, bytes.Join([][]byte{box("ftyp", []byte("qt  ")), moov, box("mdat")}, nil), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := GetMetadata(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Width != 1920 || meta.Height != 1080 || meta.Codec != "avc1" {
+		t.Errorf("Expected the enabled 1920x1080 avc1 track to describe the file, got %dx%d %s", meta.Width, meta.Height, meta.Codec)
+	}
+
+	want := []TrackInfo{
+		{ID: 1, Type: TrackVideo, Handler: "vide", Codec: "jpeg", Language: "und", SampleCount: 1, Width: 160, Height: 90, DisplayWidth: 160, DisplayHeight: 90},
+		{ID: 2, Type: TrackVideo, Handler: "vide", Codec: "avc1", Language: "und", SampleCount: 100, Enabled: true, Default: true, Width: 1920, Height: 1080, DisplayWidth: 1920, DisplayHeight: 1080},
+		{ID: 3, Type: TrackAudio, Handler: "soun", Codec: "mp4a", Language: "eng", SampleCount: 188, Enabled: true, Default: true, AlternateGroup: 1, Channels: 2, SampleRate: 48000},
+		{ID: 4, Type: TrackAudio, Handler: "soun", Codec: "ac-3", Language: "deu", SampleCount: 125, Enabled: true, AlternateGroup: 1, Channels: 6, SampleRate: 48000},
+		{ID: 5, Type: TrackSubtitle, Handler: "sbtl", Codec: "tx3g", Language: "fra", SampleCount: 12, AlternateGroup: 2},
+		{ID: 6, Type: TrackTimecode, Handler: "tmcd", Codec: "tmcd", Language: "und", SampleCount: 1, Enabled: true, Default: true},
+	}
+	if len(meta.Tracks) != len(want) {
+		t.Fatalf("Expected %d tracks, got %d: %+v", len(want), len(meta.Tracks), meta.Tracks)
+	}
+	for i, w := range want {
+		// No decoder configuration: the codec string is the sample entry type
+		w.Duration, w.Timescale, w.CodecString = 4, 1000, w.Codec
+		if meta.Tracks[i] != w {
+			t.Errorf("Track %d:\n got  %+v\n want %+v", i, meta.Tracks[i], w)
+		}
+	}
+}
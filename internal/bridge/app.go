@@ -2,11 +2,14 @@ package bridge
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	"mp4-optimizer/internal/analyzer"
+	"mp4-optimizer/internal/discovery"
 	"mp4-optimizer/internal/optimizer"
+	"mp4-optimizer/internal/queue"
 	"mp4-optimizer/internal/updater"
 
 	"os"
@@ -22,6 +25,18 @@ type ProgressEvent struct {
 	Path     string  `json:"path"`
 	Progress float64 `json:"progress"`
 	Message  string  `json:"message"`
+	// Verification is "passed" or "failed" on the final event when content verification ran
+	Verification string `json:"verification,omitempty"`
+}
+
+// OptimizeOptions selects where OptimizeFileTo writes the fast-start file
+type OptimizeOptions struct {
+	// OutputDir receives a copy with the same name; empty means optimize in place
+	OutputDir string `json:"outputDir"`
+	// Overwrite decides what to do when the copy already exists: "fail", "replace" or "rename"
+	Overwrite string `json:"overwrite"`
+	// Defragment merges fragmented (fMP4) input into a progressive fast-start file
+	Defragment bool `json:"defragment"`
 }
 
 // App struct
@@ -34,14 +49,31 @@ type App struct {
 	forceClose       bool
 	visitedFolders   map[string]bool
 	visitedFoldersMu sync.Mutex
+	safetyMode       optimizer.SafetyMode
+	keepBackupDays   int
+	verifyOptimized  bool
+	safetyMu         sync.Mutex
+	cancels          map[string]context.CancelFunc
+	cancelsMu        sync.Mutex
+	previews         *previewRegistry
+	queue            *queue.Queue
+	extensions       []string
+	extensionsMu     sync.Mutex
 }
 
 // NewApp creates a new App application struct
 func NewApp(version string) *App {
-	return &App{
-		version:        version,
-		visitedFolders: make(map[string]bool),
+	a := &App{
+		version:         version,
+		visitedFolders:  make(map[string]bool),
+		cancels:         make(map[string]context.CancelFunc),
+		previews:        newPreviewRegistry(),
+		safetyMode:      optimizer.SafetyTemp,
+		verifyOptimized: true,
+		extensions:      discovery.DefaultExtensions,
 	}
+	a.queue = a.newQueue()
+	return a
 }
 
 // startOptimizing increments the optimizing count
@@ -61,15 +93,9 @@ func (a *App) stopOptimizing() {
 }
 
 // isOurTempFile checks if a file is our temporary file
-// Our temp file pattern is: {name}_tmp_{random}.mp4
-func isOurTempFile(path string) bool {
-	name := filepath.Base(path)
-	if !strings.HasSuffix(name, ".mp4") {
-		return false
-	}
-	nameWithoutExt := strings.TrimSuffix(name, ".mp4")
-	parts := strings.Split(nameWithoutExt, "_tmp_")
-	return len(parts) == 2
+// Our temp file pattern is: {name}_tmp_{random}{ext}, for the extensions in use
+func (a *App) isOurTempFile(path string) bool {
+	return discovery.IsTempFile(path, a.GetExtensions())
 }
 
 // cleanupTempFilesInDir removes our temporary files from the given directory
@@ -88,7 +114,7 @@ func (a *App) cleanupTempFilesInDir(dir string) {
 			continue
 		}
 		fullPath := filepath.Join(dir, entry.Name())
-		if isOurTempFile(fullPath) {
+		if a.isOurTempFile(fullPath) {
 			logToFile(fmt.Sprintf("[Cleanup] Removing temp file: %s", fullPath))
 			if err := os.Remove(fullPath); err != nil {
 				logToFile(fmt.Sprintf("[Cleanup] Failed to remove %s: %v", fullPath, err))
@@ -140,6 +166,15 @@ func (a *App) Startup(ctx context.Context) {
 
 	// 调用平台特定的拖拽设置 (Windows 需要延迟注册)
 	a.setupFileDrop(ctx)
+
+	// Resume a batch that was interrupted by the last exit
+	a.queue.Start(ctx)
+}
+
+// Shutdown is called when the app is closing. Running queued jobs are
+// interrupted and stay queued, so they run again after the next start.
+func (a *App) Shutdown(ctx context.Context) {
+	a.queue.Close()
 }
 
 // CheckFile checks if the MP4 file is fast-start optimized.
@@ -148,38 +183,199 @@ func (a *App) CheckFile(path string) (bool, error) {
 	return analyzer.CheckFastStart(path)
 }
 
+// CheckFileStructure classifies the file as fast-start, needing optimization or
+// fragmented (fMP4/CMAF), with fragment statistics for the latter.
+func (a *App) CheckFileStructure(path string) (*analyzer.Structure, error) {
+	return analyzer.CheckStructure(path)
+}
+
 // ValidateFile checks if the MP4 file is complete and not truncated.
 // Returns true if the file appears to be complete, false if truncated.
 func (a *App) ValidateFile(path string) (bool, error) {
 	return analyzer.ValidateFile(path)
 }
 
+// ScanFiles checks the layout, completeness and metadata of each file, opening
+// it once, with a worker per CPU. Each result is emitted as a scan-result event
+// as soon as it is ready; the call returns when all files are scanned.
+func (a *App) ScanFiles(paths []string) error {
+	return analyzer.ScanFiles(a.ctx, paths, 0, func(result analyzer.ScanResult) {
+		runtime.EventsEmit(a.ctx, "scan-result", result)
+	})
+}
+
 // OptimizeFile performs the fast-start optimization on the file.
 func (a *App) OptimizeFile(path string) error {
+	_, err := a.OptimizeFileTo(path, OptimizeOptions{})
+	return err
+}
+
+// DefragmentFile converts a fragmented MP4 in place into a progressive fast-start MP4.
+func (a *App) DefragmentFile(path string) (string, error) {
+	return a.OptimizeFileTo(path, OptimizeOptions{Defragment: true})
+}
+
+// OptimizeFileTo performs the fast-start optimization, either in place or as a copy
+// in opts.OutputDir leaving the original untouched. It returns the path written.
+func (a *App) OptimizeFileTo(path string, opts OptimizeOptions) (string, error) {
 	a.startOptimizing()
 	defer a.stopOptimizing()
 
+	ctx, cancel := context.WithCancel(context.Background())
+	a.registerCancel(path, cancel)
+	defer a.unregisterCancel(path)
+
+	return a.runOptimize(ctx, path, opts, nil)
+}
+
+// runOptimize optimizes path with the current safety settings, reporting on the
+// optimize-progress stream and, if set, to progress. It is shared by direct
+// calls and the job queue.
+func (a *App) runOptimize(ctx context.Context, path string, opts OptimizeOptions, progress func(float64, string)) (string, error) {
 	// Track the folder of this file
 	parentDir := filepath.Dir(path)
 	a.trackFolder(parentDir)
+	if opts.OutputDir != "" {
+		// Temp files are created next to the output
+		a.trackFolder(opts.OutputDir)
+	}
 
-	callback := func(progress float64, message string) {
+	callback := func(p float64, message string) {
 		event := ProgressEvent{
 			Path:     path,
-			Progress: progress,
+			Progress: p,
 			Message:  message,
 		}
 		runtime.EventsEmit(a.ctx, "optimize-progress", event)
+		if progress != nil {
+			progress(p, message)
+		}
+	}
+
+	a.safetyMu.Lock()
+	safety, keepDays, verify := a.safetyMode, a.keepBackupDays, a.verifyOptimized
+	a.safetyMu.Unlock()
+
+	run := optimizer.OptimizeWithOptions
+	if opts.Defragment {
+		if st, err := analyzer.CheckStructure(path); err == nil && st.Layout == analyzer.LayoutFragmented {
+			run = optimizer.Defragment
+		}
 	}
+	outPath, err := run(ctx, optimizer.Options{
+		InputPath:      path,
+		OutputDir:      opts.OutputDir,
+		Overwrite:      optimizer.OverwritePolicy(opts.Overwrite),
+		Safety:         safety,
+		KeepBackupDays: keepDays,
+		Verify:         verify,
+		Progress:       callback,
+	})
 
-	return optimizer.Optimize(path, callback)
+	if verify {
+		// Report the verification outcome of this file on the progress stream
+		event := ProgressEvent{Path: path, Progress: 100, Message: "校验通过", Verification: "passed"}
+		if errors.Is(err, optimizer.ErrVerifyFailed) {
+			event = ProgressEvent{Path: path, Progress: 100, Message: err.Error(), Verification: "failed"}
+		}
+		if err == nil || event.Verification == "failed" {
+			logToFile(fmt.Sprintf("[Verify] %s: %s", path, event.Message))
+			runtime.EventsEmit(a.ctx, "optimize-progress", event)
+		}
+	}
+	var canceled *optimizer.CanceledError
+	if errors.As(err, &canceled) {
+		logToFile(fmt.Sprintf("[Cancel] Optimization canceled: %s", path))
+		runtime.EventsEmit(a.ctx, "optimize-progress", ProgressEvent{Path: path, Progress: 0, Message: "已取消"})
+	}
+	return outPath, err
+}
+
+// registerCancel remembers the cancel function of a running optimization
+func (a *App) registerCancel(path string, cancel context.CancelFunc) {
+	a.cancelsMu.Lock()
+	a.cancels[path] = cancel
+	a.cancelsMu.Unlock()
+}
+
+// unregisterCancel releases the context of a finished optimization
+func (a *App) unregisterCancel(path string) {
+	a.cancelsMu.Lock()
+	if cancel, ok := a.cancels[path]; ok {
+		cancel()
+		delete(a.cancels, path)
+	}
+	a.cancelsMu.Unlock()
+}
+
+// CancelOptimize stops the optimization of path, whether it was started
+// directly or runs from the queue, and drops it from the queue if it has not
+// started yet. Returns false if the file is not being optimized.
+func (a *App) CancelOptimize(path string) bool {
+	a.cancelsMu.Lock()
+	cancel, ok := a.cancels[path]
+	if ok {
+		cancel()
+	}
+	a.cancelsMu.Unlock()
+	return a.queue.CancelPath(path) || ok
+}
+
+// CancelAll stops every running optimization and cancels the queued jobs
+func (a *App) CancelAll() {
+	a.cancelRunning()
+	a.queue.CancelAll()
+}
+
+// cancelRunning stops the optimizations started outside the queue. Queued
+// jobs are left alone so that they resume on the next start.
+func (a *App) cancelRunning() {
+	a.cancelsMu.Lock()
+	defer a.cancelsMu.Unlock()
+	for _, cancel := range a.cancels {
+		cancel()
+	}
+}
+
+// SetVerifyAfterOptimize enables or disables sample-level content verification
+// of optimized files. It is enabled by default.
+func (a *App) SetVerifyAfterOptimize(enabled bool) {
+	a.safetyMu.Lock()
+	a.verifyOptimized = enabled
+	a.safetyMu.Unlock()
+}
+
+// SetSafetyMode selects how in-place optimization protects originals:
+// "temp" (temp file + rename) or "backup" ({name}.bak, verify, auto-restore).
+// keepBackupDays retains verified backups for that many days; 0 deletes them immediately.
+func (a *App) SetSafetyMode(mode string, keepBackupDays int) error {
+	switch optimizer.SafetyMode(mode) {
+	case optimizer.SafetyTemp, optimizer.SafetyBackup:
+	default:
+		return fmt.Errorf("unknown safety mode: %s", mode)
+	}
+	if keepBackupDays < 0 {
+		keepBackupDays = 0
+	}
+
+	a.safetyMu.Lock()
+	a.safetyMode = optimizer.SafetyMode(mode)
+	a.keepBackupDays = keepBackupDays
+	a.safetyMu.Unlock()
+	return nil
+}
+
+// RestoreBackup rolls the file back to its {name}.bak backup.
+func (a *App) RestoreBackup(path string) error {
+	logToFile(fmt.Sprintf("[Backup] Restoring %s", path))
+	return optimizer.RestoreBackup(path)
 }
 
 // IsOptimizing returns whether there's an optimization in progress
 func (a *App) IsOptimizing() bool {
 	a.optimizingMu.Lock()
 	defer a.optimizingMu.Unlock()
-	return a.optimizingCount > 0
+	return a.optimizingCount > 0 || a.queue.Active() > 0
 }
 
 // RequestClose requests the app to close, will prompt user if optimizing
@@ -203,6 +399,9 @@ func (a *App) IsForceClosing() bool {
 func (a *App) ForceClose() {
 	logToFile("[ForceClose] User requested force close - cleaning up temp files first...")
 	a.forceClose = true
+	// Closing the queue first interrupts its jobs so they resume on the next start
+	a.queue.Close()
+	a.cancelRunning()
 	a.cleanupAllVisitedFolders()
 	a.shouldClose = true
 	runtime.Quit(a.ctx)
@@ -213,17 +412,48 @@ func (a *App) GetFileMetadata(path string) (*analyzer.Metadata, error) {
 	return analyzer.GetMetadata(path)
 }
 
-// SelectFiles opens a file dialog to select multiple MP4 files.
+// SetExtensions sets the file extensions picked up from folders and offered in
+// the file dialog, e.g. ["mp4", ".mov"]. An empty list restores the defaults.
+func (a *App) SetExtensions(exts []string) error {
+	parsed := discovery.ParseExtensions(strings.Join(exts, ","))
+	if len(parsed) == 0 {
+		parsed = discovery.DefaultExtensions
+	}
+	for _, ext := range parsed {
+		if strings.ContainsAny(ext, `*?;/\ `) {
+			return fmt.Errorf("invalid extension %q", ext)
+		}
+	}
+	a.extensionsMu.Lock()
+	a.extensions = parsed
+	a.extensionsMu.Unlock()
+	return nil
+}
+
+// GetExtensions returns the file extensions currently picked up
+func (a *App) GetExtensions() []string {
+	a.extensionsMu.Lock()
+	defer a.extensionsMu.Unlock()
+	return a.extensions
+}
+
+// SelectFiles opens a file dialog to select multiple media files.
 func (a *App) SelectFiles() ([]string, error) {
+	exts := a.GetExtensions()
+	patterns := make([]string, len(exts))
+	for i, ext := range exts {
+		patterns[i] = "*" + ext
+	}
 	selection, err := runtime.OpenMultipleFilesDialog(a.ctx, runtime.OpenDialogOptions{
-		Title: "Select MP4 Files",
+		Title: "Select Video Files",
 		Filters: []runtime.FileFilter{
-			{DisplayName: "MP4 Video", Pattern: "*.mp4"},
+			{DisplayName: "MP4 / MOV Video (" + strings.Join(patterns, ", ") + ")", Pattern: strings.Join(patterns, ";")},
 		},
 	})
 	if err != nil {
 		return nil, fmt.Errorf("dialog error: %w", err)
 	}
+	a.registerPreviews(selection)
 	return selection, nil
 }
 
@@ -238,82 +468,24 @@ func (a *App) SelectDirectory() (string, error) {
 	return selection, nil
 }
 
-// ExpandPaths takes a list of paths and returns a flat list of all MP4 files found.
+// ExpandPaths takes a list of paths and returns a flat list of all media files found.
 // It handles directories recursively.
 func (a *App) ExpandPaths(paths []string) ([]string, error) {
-	var result []string
-	uniquePaths := make(map[string]bool)
-	foldersToTrack := make(map[string]bool)
-
 	logToFile(fmt.Sprintf("[ExpandPaths] Start processing %d paths: %v", len(paths), paths))
 
-	for _, p := range paths {
-		cleanPath := filepath.Clean(p)
-		info, err := os.Stat(cleanPath)
-		if err != nil {
-			logToFile(fmt.Sprintf("[ExpandPaths] Error accessing path '%s': %v", cleanPath, err))
-			continue // Skip invalid paths
-		}
-
-		if info.IsDir() {
-			logToFile(fmt.Sprintf("[ExpandPaths] processing directory: %s", cleanPath))
-			// Track this folder
-			absDir, _ := filepath.Abs(cleanPath)
-			foldersToTrack[absDir] = true
-
-			// Walk directory
-			err := filepath.WalkDir(cleanPath, func(path string, d os.DirEntry, err error) error {
-				if err != nil {
-					logToFile(fmt.Sprintf("[ExpandPaths] Walk error at %s: %v", path, err))
-					return nil // Skip errors accessing files
-				}
-				if d.IsDir() {
-					absSubDir, _ := filepath.Abs(path)
-					foldersToTrack[absSubDir] = true
-				} else {
-					if isMP4(path) && !isOurTempFile(path) {
-						absPath, err := filepath.Abs(path)
-						if err == nil {
-							if !uniquePaths[absPath] {
-								uniquePaths[absPath] = true
-								result = append(result, absPath)
-							}
-						}
-					}
-				}
-				return nil
-			})
-			if err != nil {
-				logToFile(fmt.Sprintf("[ExpandPaths] Error walking dir %s: %v", cleanPath, err))
-				fmt.Printf("Error walking dir %s: %v\n", cleanPath, err)
-			}
-		} else {
-			// It's a file - track its parent folder
-			parentDir := filepath.Dir(cleanPath)
-			absParentDir, _ := filepath.Abs(parentDir)
-			foldersToTrack[absParentDir] = true
-
-			if isMP4(cleanPath) && !isOurTempFile(cleanPath) {
-				absPath, err := filepath.Abs(cleanPath)
-				if err == nil {
-					if !uniquePaths[absPath] {
-						uniquePaths[absPath] = true
-						result = append(result, absPath)
-					}
-				}
-			} else {
-				logToFile(fmt.Sprintf("[ExpandPaths] Skipped non-MP4 or temp file: %s", cleanPath))
-			}
-		}
-	}
+	result := discovery.Expand(paths, a.GetExtensions(), func(msg string) {
+		logToFile("[ExpandPaths] " + msg)
+	})
 
 	// Cleanup all tracked folders
-	for folder := range foldersToTrack {
+	for _, folder := range result.Folders {
 		a.trackFolder(folder)
 	}
 
-	logToFile(fmt.Sprintf("[ExpandPaths] Finished. Found %d MP4 files.", len(result)))
-	return result, nil
+	a.registerPreviews(result.Files)
+
+	logToFile(fmt.Sprintf("[ExpandPaths] Finished. Found %d media files.", len(result.Files)))
+	return result.Files, nil
 }
 
 // GetAppVersion returns the current application version
@@ -321,6 +493,12 @@ func (a *App) GetAppVersion() string {
 	return a.version
 }
 
+// GetLogPath returns where the debug log is written, or "" when it goes to
+// the console
+func (a *App) GetLogPath() string {
+	return logPath
+}
+
 // CheckForUpdates checks for updates from the given URL
 func (a *App) CheckForUpdates(url string) (*updater.CheckResult, error) {
 	return updater.CheckUpdate(a.version, url)
@@ -331,13 +509,25 @@ func (a *App) InstallUpdate(url string) error {
 	return updater.ApplyUpdate(url)
 }
 
-func isMP4(path string) bool {
-	ext := strings.ToLower(filepath.Ext(path))
-	return ext == ".mp4"
+// logPath is the debug log. It lives in the user cache directory rather than
+// the working directory; empty logs to the console. Tests point it elsewhere.
+var logPath = defaultLogPath()
+
+func defaultLogPath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "mp4-optimizer", "debug_log.txt")
 }
 
 func logToFile(msg string) {
-	f, err := os.OpenFile("debug_log.txt", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if logPath == "" {
+		fmt.Println(msg)
+		return
+	}
+	os.MkdirAll(filepath.Dir(logPath), 0755)
+	f, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		fmt.Println(msg) // fallback to console
 		return
@@ -0,0 +1,19 @@
+package bridge
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// Keep the debug log of the tests out of the user cache and the source tree
+	dir, err := os.MkdirTemp("", "mp4-optimizer-test")
+	if err != nil {
+		panic(err)
+	}
+	logPath = filepath.Join(dir, "debug_log.txt")
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
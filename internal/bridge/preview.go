@@ -0,0 +1,205 @@
+package bridge
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"mp4-optimizer/internal/optimizer"
+)
+
+// previewPrefix is the URL path under which registered files are served.
+const previewPrefix = "/video/"
+
+// previewTypes are the media extensions the preview handler serves, with
+// their Content-Type.
+var previewTypes = map[string]string{
+	".mp4": "video/mp4",
+	".m4v": "video/mp4",
+	".mov": "video/quicktime",
+	".m4a": "audio/mp4",
+	".m4b": "audio/mp4",
+	".3gp": "video/3gpp",
+	".3g2": "video/3gpp2",
+}
+
+// previewRegistry maps opaque tokens to the files the user added, so the
+// preview handler never serves a path taken from the URL.
+type previewRegistry struct {
+	mu      sync.Mutex
+	byToken map[string]string
+	byPath  map[string]string
+	// views caches the fast-start layout of each file, as parsing moov on
+	// every Range request of a seeking player is expensive
+	views map[string]previewView
+}
+
+// previewView is a cached view, valid while the file keeps its size and
+// modification time.
+type previewView struct {
+	size    int64
+	modTime time.Time
+	view    *optimizer.FastStartView
+}
+
+func newPreviewRegistry() *previewRegistry {
+	return &previewRegistry{
+		byToken: make(map[string]string),
+		byPath:  make(map[string]string),
+		views:   make(map[string]previewView),
+	}
+}
+
+// register adds a media file and returns its token. Registering the same file
+// again returns the same token.
+func (p *previewRegistry) register(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	if previewTypes[strings.ToLower(filepath.Ext(abs))] == "" {
+		return "", fmt.Errorf("not a media file: %s", path)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if token, ok := p.byPath[abs]; ok {
+		return token, nil
+	}
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+	p.byToken[token] = abs
+	p.byPath[abs] = token
+	return token, nil
+}
+
+// lookup returns the path of a token.
+func (p *previewRegistry) lookup(token string) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	path, ok := p.byToken[token]
+	return path, ok
+}
+
+// token returns the token of a registered path.
+func (p *previewRegistry) token(path string) (string, bool) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	token, ok := p.byPath[abs]
+	return token, ok
+}
+
+// view returns a view of f at path, reusing the cached layout while the file
+// is unchanged and rebuilding it otherwise.
+func (p *previewRegistry) view(path string, f *os.File, info os.FileInfo) (*optimizer.FastStartView, error) {
+	p.mu.Lock()
+	cached, ok := p.views[path]
+	p.mu.Unlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.view.WithSource(f), nil
+	}
+
+	view, err := optimizer.NewFastStartView(f, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// Files removed from the list meanwhile are not cached again
+	if _, ok := p.byPath[path]; ok {
+		p.views[path] = previewView{size: info.Size(), modTime: info.ModTime(), view: view}
+	}
+	return view, nil
+}
+
+// clear invalidates every token.
+func (p *previewRegistry) clear() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	clear(p.byToken)
+	clear(p.byPath)
+	clear(p.views)
+}
+
+// registerPreviews makes the files available to the preview handler.
+// Files with other extensions are skipped.
+func (a *App) registerPreviews(paths []string) {
+	for _, path := range paths {
+		if _, err := a.previews.register(path); err != nil {
+			logToFile(fmt.Sprintf("[Preview] Not registering %s: %v", path, err))
+		}
+	}
+}
+
+// PreviewURL returns the URL under which the player can load a file that was
+// added through ExpandPaths or SelectFiles.
+func (a *App) PreviewURL(path string) (string, error) {
+	token, ok := a.previews.token(path)
+	if !ok {
+		return "", fmt.Errorf("file is not in the list: %s", path)
+	}
+	return previewPrefix + token, nil
+}
+
+// ClearFiles forgets every added file; their preview URLs stop working.
+func (a *App) ClearFiles() {
+	a.previews.clear()
+}
+
+// NewPreviewHandler serves the files registered with app under /video/<token>.
+// Unknown tokens, vanished files and anything that is not a regular media file
+// get a 404. Files are presented through a virtual fast-start view so the
+// player can seek immediately; nothing is written to disk. The layout of each
+// view is cached until the file changes.
+func NewPreviewHandler(app *App) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.URL.Path, previewPrefix)
+		if !ok || token == "" || strings.ContainsAny(token, "/\\.") {
+			http.NotFound(w, r)
+			return
+		}
+		path, ok := app.previews.lookup(token)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		contentType := previewTypes[strings.ToLower(filepath.Ext(path))]
+		if contentType == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			http.NotFound(w, r)
+			return
+		}
+		view, err := app.previews.view(path, f, info)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		http.ServeContent(w, r, info.Name(), info.ModTime(), view)
+	})
+}
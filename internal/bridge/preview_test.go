@@ -0,0 +1,107 @@
+package bridge
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"mp4-optimizer/internal/optimizer"
+)
+
+func TestPreviewHandler(t *testing.T) {
+	dir := t.TempDir()
+	video := filepath.Join(dir, "clip.mov")
+	other := filepath.Join(dir, "notes.txt")
+	for _, p := range []string{video, other} {
+		if err := os.WriteFile(p, []byte("not really a movie"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	app := NewApp("test")
+	app.registerPreviews([]string{video, other})
+	handler := NewPreviewHandler(app)
+	get := func(url string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		return rec
+	}
+
+	url, err := app.PreviewURL(video)
+	if err != nil {
+		t.Fatalf("PreviewURL failed: %v", err)
+	}
+	rec := get(url)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "video/quicktime" {
+		t.Fatalf("Expected 200 video/quicktime, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	// Raw paths, traversal and files that were never registered are refused
+	if _, err := app.PreviewURL(other); err == nil {
+		t.Errorf("Expected non-media files not to be registered")
+	}
+	for _, u := range []string{"/video/" + video, "/video/../../etc/passwd", "/video/deadbeef"} {
+		if rec := get(u); rec.Code != http.StatusNotFound {
+			t.Errorf("%s: expected 404, got %d", u, rec.Code)
+		}
+	}
+
+	// Clearing the list invalidates the tokens
+	app.ClearFiles()
+	if rec := get(url); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 after ClearFiles, got %d", rec.Code)
+	}
+}
+
+func TestPreviewViewCache(t *testing.T) {
+	video := filepath.Join(t.TempDir(), "clip.mp4")
+	if err := os.WriteFile(video, []byte("first version"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	app := NewApp("test")
+	app.registerPreviews([]string{video})
+	handler := NewPreviewHandler(app)
+	url, err := app.PreviewURL(video)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func() string {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		return rec.Body.String()
+	}
+	cached := func() *optimizer.FastStartView {
+		app.previews.mu.Lock()
+		defer app.previews.mu.Unlock()
+		return app.previews.views[video].view
+	}
+
+	if body := get(); body != "first version" {
+		t.Fatalf("Unexpected body %q", body)
+	}
+	first := cached()
+	if first == nil {
+		t.Fatal("Expected the view to be cached")
+	}
+	if get(); cached() != first {
+		t.Error("Expected an unchanged file to reuse the cached view")
+	}
+
+	// A rewritten file is parsed again
+	if err := os.WriteFile(video, []byte("second, longer version"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if body := get(); body != "second, longer version" {
+		t.Errorf("Expected the new content after a change, got %q", body)
+	}
+	if cached() == first {
+		t.Error("Expected the cached view to be replaced")
+	}
+
+	app.ClearFiles()
+	if cached() != nil {
+		t.Error("Expected ClearFiles to drop the cached views")
+	}
+}
@@ -0,0 +1,115 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+
+	"mp4-optimizer/internal/analyzer"
+	"mp4-optimizer/internal/queue"
+)
+
+// QueueStatus is the state of the job queue as shown by the frontend
+type QueueStatus struct {
+	Jobs    []queue.Job `json:"jobs"`
+	Paused  bool        `json:"paused"`
+	Workers int         `json:"workers"`
+}
+
+// queueStatePath is where the queue is persisted between runs. Empty disables
+// persistence when there is no user config directory.
+func queueStatePath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		logToFile(fmt.Sprintf("[Queue] No config directory, the queue will not persist: %v", err))
+		return ""
+	}
+	return filepath.Join(dir, "mp4-optimizer", "queue.json")
+}
+
+// newQueue creates the job queue backed by runOptimize. A corrupt state file
+// is logged and replaced by an empty queue rather than blocking startup.
+func (a *App) newQueue() *queue.Queue {
+	cfg := queue.Config{
+		StatePath: queueStatePath(),
+		Run: func(ctx context.Context, job queue.Job, progress func(float64, string)) (string, error) {
+			// Files that became fast-start since they were queued, e.g. by an
+			// earlier run of a restored batch, are finished without a rewrite
+			if st, err := analyzer.CheckStructure(job.Path); err == nil {
+				if st.Layout == analyzer.LayoutFastStart || (st.Layout == analyzer.LayoutFragmented && !job.Options.Defragment) {
+					logToFile(fmt.Sprintf("[Queue] Already optimized, skipped: %s", job.Path))
+					progress(100, "已是快速启动格式，已跳过")
+					return job.Path, nil
+				}
+			}
+			return a.runOptimize(ctx, job.Path, OptimizeOptions{
+				OutputDir:  job.Options.OutputDir,
+				Overwrite:  job.Options.Overwrite,
+				Defragment: job.Options.Defragment,
+			}, progress)
+		},
+		OnChange: func(job queue.Job) {
+			if a.ctx != nil {
+				runtime.EventsEmit(a.ctx, "queue-update", job)
+			}
+		},
+		Log: logToFile,
+	}
+	q, err := queue.New(cfg)
+	if err != nil {
+		logToFile(fmt.Sprintf("[Queue] Failed to restore the queue: %v", err))
+		cfg.StatePath = ""
+		q, _ = queue.New(cfg)
+	}
+	return q
+}
+
+// EnqueueFiles adds paths to the job queue. Files already waiting or running
+// are skipped. Progress is reported on optimize-progress and job state
+// changes on queue-update.
+func (a *App) EnqueueFiles(paths []string, opts OptimizeOptions) ([]queue.Job, error) {
+	return a.queue.Enqueue(paths, queue.Options{
+		OutputDir:  opts.OutputDir,
+		Overwrite:  opts.Overwrite,
+		Defragment: opts.Defragment,
+	})
+}
+
+// GetQueue returns the jobs, including a batch restored from the last run
+func (a *App) GetQueue() QueueStatus {
+	return QueueStatus{Jobs: a.queue.Jobs(), Paused: a.queue.Paused(), Workers: a.queue.Workers()}
+}
+
+// PauseQueue stops starting new jobs; running ones finish
+func (a *App) PauseQueue() {
+	a.queue.Pause()
+}
+
+// ResumeQueue starts queued jobs again
+func (a *App) ResumeQueue() {
+	a.queue.Resume()
+}
+
+// SetQueueWorkers sets how many files are optimized at once
+func (a *App) SetQueueWorkers(n int) {
+	a.queue.SetWorkers(n)
+}
+
+// CancelJob cancels a queued or running job. It reports false for unknown or
+// finished jobs.
+func (a *App) CancelJob(id string) bool {
+	return a.queue.Cancel(id)
+}
+
+// RetryJob queues a failed or canceled job again
+func (a *App) RetryJob(id string) bool {
+	return a.queue.Retry(id)
+}
+
+// ClearFinishedJobs forgets finished jobs
+func (a *App) ClearFinishedJobs() {
+	a.queue.RemoveFinished()
+}
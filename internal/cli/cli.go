@@ -0,0 +1,842 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"mp4-optimizer/internal/analyzer"
+	"mp4-optimizer/internal/discovery"
+	"mp4-optimizer/internal/optimizer"
+	"mp4-optimizer/internal/packager"
+	"mp4-optimizer/internal/server"
+	"mp4-optimizer/internal/watcher"
+)
+
+// Exit codes meaningful for scripts
+const (
+	// ExitOK means every file is fine
+	ExitOK = 0
+	// ExitAttention means at least one file needs optimizing, is truncated or failed to optimize
+	ExitAttention = 1
+	// ExitError means invalid usage or a file could not be read
+	ExitError = 2
+)
+
+type command struct {
+	summary string
+	run     func(ctx context.Context, env *env, args []string) int
+}
+
+var commands = map[string]command{
+	"check":    {"report whether files are fast-start (exit 1 if any needs optimizing)", runCheck},
+	"info":     {"print metadata (resolution, codec, duration, size)", runInfo},
+	"validate": {"report truncated files (exit 1 if any is incomplete)", runValidate},
+	"optimize": {"move moov to the front of files that need it (exit 1 if any fails)", runOptimize},
+	"watch":    {"watch folders and optimize new MP4 files once they are completely written", runWatch},
+	"fragment": {"remux progressive MP4 into fragmented MP4 (fMP4/CMAF) without re-encoding", runFragment},
+	"hls":      {"package MP4 into fMP4 HLS segments and playlists without re-encoding", runHLS},
+	"serve":    {"run a local HTTP API (JSON + Server-Sent Events) for check, info, validate and optimize", runServe},
+	"dash":     {"package MP4 into DASH on-demand files (one per track, with sidx) and an MPD", runDASH},
+}
+
+// env carries the output streams of one invocation.
+type env struct {
+	stdout io.Writer
+	stderr io.Writer
+	json   bool
+	exts   string
+	// badArgs is set when a path argument could not be read. The other
+	// files are still processed, but the command exits with ExitError.
+	badArgs bool
+}
+
+// IsCommand reports whether name is a CLI subcommand, which switches the
+// binary into headless mode instead of starting the GUI.
+func IsCommand(name string) bool {
+	_, ok := commands[name]
+	return ok || name == "help" || name == "-h" || name == "--help"
+}
+
+// Run executes the subcommand in args[0] and returns the process exit code.
+func Run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		usage(stderr)
+		if len(args) == 0 {
+			return ExitError
+		}
+		return ExitOK
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n\n", args[0])
+		usage(stderr)
+		return ExitError
+	}
+
+	// Ctrl-C cancels a running optimization and removes its temp file
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	e := &env{stdout: stdout, stderr: stderr}
+	code := cmd.run(ctx, e, args[1:])
+	if e.badArgs {
+		return ExitError
+	}
+	return code
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: mp4-optimizer <command> [flags] <files or folders>...")
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Folders are scanned recursively for %s files;\n", strings.Join(discovery.DefaultExtensions, " "))
+	fmt.Fprintln(w, "use -ext to choose other extensions, e.g. -ext mp4,f4v.")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-9s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run 'mp4-optimizer <command> -h' for the flags of a command.")
+}
+
+// newFlagSet creates the flag set shared by every command.
+func newFlagSet(e *env, name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(e.stderr)
+	fs.BoolVar(&e.json, "json", false, "print results as JSON")
+	fs.StringVar(&e.exts, "ext", strings.Join(discovery.DefaultExtensions, ","), "comma-separated file extensions to process")
+	fs.Usage = func() {
+		fmt.Fprintf(e.stderr, "Usage: mp4-optimizer %s [flags] <files or folders>...\n", name)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// expand parses flags and resolves the positional arguments to MP4 files.
+func expand(e *env, fs *flag.FlagSet, args []string) ([]string, int) {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, ExitOK
+		}
+		return nil, ExitError
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return nil, ExitError
+	}
+	for _, p := range fs.Args() {
+		// Opening rather than stat'ing also catches unreadable files and folders
+		f, err := os.Open(p)
+		if err != nil {
+			fmt.Fprintln(e.stderr, err)
+			e.badArgs = true
+			continue
+		}
+		f.Close()
+	}
+	result := discovery.Expand(fs.Args(), discovery.ParseExtensions(e.exts), nil)
+	if len(result.Files) == 0 {
+		fmt.Fprintln(e.stderr, "no media files found")
+		return nil, ExitError
+	}
+	return result.Files, -1
+}
+
+// fileResult is one line of output. Fields that do not apply are omitted from JSON.
+type fileResult struct {
+	Path      string              `json:"path"`
+	FastStart *bool               `json:"fastStart,omitempty"`
+	Structure *analyzer.Structure `json:"structure,omitempty"`
+	Complete  *bool               `json:"complete,omitempty"`
+	Metadata  *analyzer.Metadata  `json:"metadata,omitempty"`
+	Status    string              `json:"status,omitempty"`
+	Output    string              `json:"output,omitempty"`
+	Error     string              `json:"error,omitempty"`
+}
+
+func (e *env) report(results []fileResult, human func(r fileResult) string) {
+	if e.json {
+		enc := json.NewEncoder(e.stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(results)
+		return
+	}
+	for _, r := range results {
+		if r.Error != "" {
+			fmt.Fprintf(e.stdout, "ERROR     %s: %s\n", r.Path, r.Error)
+			continue
+		}
+		fmt.Fprintln(e.stdout, human(r))
+	}
+}
+
+func runCheck(ctx context.Context, e *env, args []string) int {
+	fs := newFlagSet(e, "check")
+	files, code := expand(e, fs, args)
+	if code >= 0 {
+		return code
+	}
+
+	exit := ExitOK
+	var results []fileResult
+	for _, path := range files {
+		r := fileResult{Path: path}
+		st, err := analyzer.CheckStructure(path)
+		if err != nil {
+			r.Error = err.Error()
+			exit = ExitError
+		} else {
+			fast := st.Layout != analyzer.LayoutNeedsOptimize
+			r.FastStart, r.Structure = &fast, st
+			if !fast && exit == ExitOK {
+				exit = ExitAttention
+			}
+		}
+		results = append(results, r)
+	}
+
+	e.report(results, func(r fileResult) string {
+		switch r.Structure.Layout {
+		case analyzer.LayoutFragmented:
+			fr := r.Structure.Fragments
+			return fmt.Sprintf("FRAGMENTED %s (%d fragments, %.2f-%.2fs)", r.Path, fr.Count, fr.MinDuration, fr.MaxDuration)
+		case analyzer.LayoutNeedsOptimize:
+			return "OPTIMIZE  " + r.Path
+		}
+		return "OK        " + r.Path
+	})
+	return exit
+}
+
+func runInfo(ctx context.Context, e *env, args []string) int {
+	fs := newFlagSet(e, "info")
+	files, code := expand(e, fs, args)
+	if code >= 0 {
+		return code
+	}
+
+	exit := ExitOK
+	var results []fileResult
+	for _, path := range files {
+		r := fileResult{Path: path}
+		meta, err := analyzer.GetMetadata(path)
+		if err != nil {
+			r.Error = err.Error()
+			exit = ExitError
+		} else {
+			r.Metadata = meta
+		}
+		results = append(results, r)
+	}
+
+	e.report(results, func(r fileResult) string {
+		m := r.Metadata
+		var b strings.Builder
+		fmt.Fprintf(&b, "%s\n  %dx%d  %s  %.2fs  %d bytes  %s  %.0f kbit/s",
+			r.Path, m.Width, m.Height, m.Codec, m.Duration, m.Size, m.Modified.Format("2006-01-02 15:04:05"), m.Bitrate/1000)
+		if v := m.Video; v != nil {
+			fmt.Fprintf(&b, "\n  %.3f fps (max %.3f)", v.FrameRate, v.MaxFrameRate)
+			if v.VariableFrameRate {
+				b.WriteString("  VFR")
+			}
+			if v.BFrames {
+				b.WriteString("  B-frames")
+			}
+			fmt.Fprintf(&b, "  %d keyframes, GOP %d-%d (avg %.1f, %.2fs)", v.Keyframes, v.MinGOP, v.MaxGOP, v.AvgGOP, v.KeyframeInterval)
+		}
+		if m.Color != nil {
+			b.WriteString("\n  " + colorLine(m.Color))
+		}
+		if m.Codecs != "" {
+			fmt.Fprintf(&b, "\n  codecs=%q", m.Codecs)
+		}
+		for _, t := range m.Tracks {
+			b.WriteString("\n    " + trackLine(t))
+		}
+		return b.String()
+	})
+	return exit
+}
+
+// trackLine summarizes a track for the text output of info.
+func trackLine(t analyzer.TrackInfo) string {
+	codec := t.CodecString
+	if codec == "" {
+		codec = t.Codec
+	}
+	line := fmt.Sprintf("#%d %-8s %-4s %s  %.2fs  %d samples  %.0f kbit/s", t.ID, t.Type, codec, t.Language, t.Duration, t.SampleCount, t.Bitrate/1000)
+	if details := codecDetails(t.CodecInfo); details != "" {
+		line += "  " + details
+	}
+	switch t.Type {
+	case analyzer.TrackVideo:
+		line += "  " + geometryLine(t)
+	case analyzer.TrackAudio:
+		line += fmt.Sprintf("  %dch  %gHz", t.Channels, t.SampleRate)
+	}
+	switch {
+	case !t.Enabled:
+		line += "  (disabled)"
+	case t.Default && t.AlternateGroup != 0:
+		line += "  (default)"
+	}
+	return line
+}
+
+// geometryLine formats the display size of a video track followed by the coded
+// size, clean aperture, pixel aspect ratio and rotation where they differ.
+func geometryLine(t analyzer.TrackInfo) string {
+	line := fmt.Sprintf("%dx%d", t.DisplayWidth, t.DisplayHeight)
+	if t.CodedWidth > 0 && (t.CodedWidth != t.DisplayWidth || t.CodedHeight != t.DisplayHeight) {
+		line += fmt.Sprintf(" (coded %dx%d)", t.CodedWidth, t.CodedHeight)
+	}
+	if t.CleanWidth > 0 && (t.CleanWidth != t.CodedWidth || t.CleanHeight != t.CodedHeight) {
+		line += fmt.Sprintf("  clap %dx%d", t.CleanWidth, t.CleanHeight)
+	}
+	if t.PixelAspectH > 0 && t.PixelAspectH != t.PixelAspectV {
+		line += fmt.Sprintf("  PAR %d:%d", t.PixelAspectH, t.PixelAspectV)
+	}
+	if t.Rotation != 0 {
+		line += fmt.Sprintf("  rotated %d°", t.Rotation)
+	}
+	switch {
+	case t.FlipH:
+		line += "  flipped horizontally"
+	case t.FlipV:
+		line += "  flipped vertically"
+	}
+	return line
+}
+
+// codecDetails formats profile, level, bit depth and chroma format, e.g.
+// "High@3.1 8-bit 4:2:0".
+func codecDetails(c analyzer.CodecInfo) string {
+	var parts []string
+	switch {
+	case c.Profile != "" && c.Level != "":
+		parts = append(parts, c.Profile+"@"+c.Level)
+	case c.Profile != "":
+		parts = append(parts, c.Profile)
+	}
+	if c.BitDepth > 0 {
+		parts = append(parts, fmt.Sprintf("%d-bit", c.BitDepth))
+	}
+	if c.ChromaFormat != "" {
+		parts = append(parts, c.ChromaFormat)
+	}
+	return strings.Join(parts, " ")
+}
+
+// colorLine summarizes the color and HDR description of a video track.
+func colorLine(c *analyzer.ColorInfo) string {
+	var parts []string
+	if c.HDR != "" {
+		parts = append(parts, c.HDR)
+	}
+	if dv := c.DolbyVision; dv != nil {
+		parts = append(parts, fmt.Sprintf("profile %d.%d level %d", dv.Profile, dv.Compatibility, dv.Level))
+	}
+	parts = append(parts, c.Transfer)
+	if c.Source != "" {
+		primaries, matrix := c.Primaries, c.Matrix
+		if primaries == "" {
+			primaries = fmt.Sprint(c.ColorPrimaries)
+		}
+		if matrix == "" {
+			matrix = fmt.Sprint(c.MatrixCoefficients)
+		}
+		rng := "limited"
+		if c.FullRange {
+			rng = "full"
+		}
+		parts = append(parts, fmt.Sprintf("primaries %s  matrix %s  %s range", primaries, matrix, rng))
+	}
+	if c.ICCProfile {
+		parts = append(parts, "ICC profile")
+	}
+	if m := c.Mastering; m != nil {
+		parts = append(parts, fmt.Sprintf("mastering %.4f-%.0f cd/m²", m.MinLuminance, m.MaxLuminance))
+	}
+	if c.MaxCLL > 0 || c.MaxFALL > 0 {
+		parts = append(parts, fmt.Sprintf("MaxCLL %d  MaxFALL %d", c.MaxCLL, c.MaxFALL))
+	}
+	return strings.Join(parts, "  ")
+}
+
+func runValidate(ctx context.Context, e *env, args []string) int {
+	fs := newFlagSet(e, "validate")
+	files, code := expand(e, fs, args)
+	if code >= 0 {
+		return code
+	}
+
+	exit := ExitOK
+	var results []fileResult
+	for _, path := range files {
+		r := fileResult{Path: path}
+		complete, err := analyzer.ValidateFile(path)
+		if err != nil {
+			r.Error = err.Error()
+			exit = ExitError
+		} else {
+			r.Complete = &complete
+			if !complete && exit == ExitOK {
+				exit = ExitAttention
+			}
+		}
+		results = append(results, r)
+	}
+
+	e.report(results, func(r fileResult) string {
+		if *r.Complete {
+			return "COMPLETE  " + r.Path
+		}
+		return "TRUNCATED " + r.Path
+	})
+	return exit
+}
+
+func runOptimize(ctx context.Context, e *env, args []string) int {
+	fs := newFlagSet(e, "optimize")
+	outputDir := fs.String("o", "", "write fast-start copies to this folder instead of replacing the originals")
+	overwrite := fs.String("overwrite", string(optimizer.OverwriteFail), "existing copies in -o: fail, replace or rename")
+	safety := fs.String("safety", string(optimizer.SafetyTemp), "in-place safety mode: temp or backup")
+	keepDays := fs.Int("keep-days", 0, "keep verified .bak backups for this many days (with -safety backup)")
+	verify := fs.Bool("verify", true, "verify sample by sample that media bytes are identical")
+	force := fs.Bool("force", false, "rewrite files that are already fast-start")
+	defrag := fs.Bool("defrag", false, "merge fragmented MP4 (fMP4/CMAF) into progressive fast-start files")
+	files, code := expand(e, fs, args)
+	if code >= 0 {
+		return code
+	}
+	switch optimizer.OverwritePolicy(*overwrite) {
+	case optimizer.OverwriteFail, optimizer.OverwriteReplace, optimizer.OverwriteRename:
+	default:
+		fmt.Fprintf(e.stderr, "invalid -overwrite %q\n", *overwrite)
+		return ExitError
+	}
+	switch optimizer.SafetyMode(*safety) {
+	case optimizer.SafetyTemp, optimizer.SafetyBackup:
+	default:
+		fmt.Fprintf(e.stderr, "invalid -safety %q\n", *safety)
+		return ExitError
+	}
+
+	exit := ExitOK
+	var results []fileResult
+	for _, path := range files {
+		r := fileResult{Path: path}
+		results = append(results, r)
+		res := &results[len(results)-1]
+
+		fragmented := false
+		if *defrag {
+			st, err := analyzer.CheckStructure(path)
+			if err != nil {
+				res.Error = err.Error()
+				exit = ExitError
+				continue
+			}
+			fragmented = st.Layout == analyzer.LayoutFragmented
+		}
+		if !*force && !fragmented {
+			fast, err := analyzer.CheckFastStart(path)
+			if err != nil {
+				res.Error = err.Error()
+				exit = ExitError
+				continue
+			}
+			if fast {
+				res.Status = "skipped"
+				continue
+			}
+		}
+		complete, err := analyzer.ValidateFile(path)
+		if err == nil && !complete {
+			// Never rewrite a file that is still being written or was cut off
+			res.Status = "truncated"
+			if exit == ExitOK {
+				exit = ExitAttention
+			}
+			continue
+		}
+
+		run := optimizer.OptimizeWithOptions
+		if fragmented {
+			run = optimizer.Defragment
+		}
+		out, err := run(ctx, optimizer.Options{
+			InputPath:      path,
+			OutputDir:      *outputDir,
+			Overwrite:      optimizer.OverwritePolicy(*overwrite),
+			Safety:         optimizer.SafetyMode(*safety),
+			KeepBackupDays: *keepDays,
+			Verify:         *verify,
+		})
+		if err != nil {
+			res.Status = "failed"
+			res.Error = err.Error()
+			if exit == ExitOK {
+				exit = ExitAttention
+			}
+			var canceled *optimizer.CanceledError
+			if errors.As(err, &canceled) {
+				break
+			}
+			continue
+		}
+		res.Status = "optimized"
+		res.Output = out
+	}
+
+	e.report(results, func(r fileResult) string {
+		switch r.Status {
+		case "optimized":
+			if r.Output != r.Path {
+				return fmt.Sprintf("OPTIMIZED %s -> %s", r.Path, r.Output)
+			}
+			return "OPTIMIZED " + r.Path
+		case "skipped":
+			return "SKIPPED   " + r.Path + " (already fast-start)"
+		default:
+			return "TRUNCATED " + r.Path + " (not touched)"
+		}
+	})
+	return exit
+}
+
+func runFragment(ctx context.Context, e *env, args []string) int {
+	fs := newFlagSet(e, "fragment")
+	outputDir := fs.String("o", "", "write fragmented copies to this folder instead of replacing the originals")
+	overwrite := fs.String("overwrite", string(optimizer.OverwriteFail), "existing copies in -o: fail, replace or rename")
+	duration := fs.Duration("duration", optimizer.DefaultFragmentDuration, "target fragment duration; fragments start at key frames")
+	sidx := fs.Bool("sidx", false, "add a segment index (sidx)")
+	mfra := fs.Bool("mfra", false, "append a random access index (mfra)")
+	verify := fs.Bool("verify", true, "verify sample by sample that media bytes are identical")
+	files, code := expand(e, fs, args)
+	if code >= 0 {
+		return code
+	}
+	switch optimizer.OverwritePolicy(*overwrite) {
+	case optimizer.OverwriteFail, optimizer.OverwriteReplace, optimizer.OverwriteRename:
+	default:
+		fmt.Fprintf(e.stderr, "invalid -overwrite %q\n", *overwrite)
+		return ExitError
+	}
+
+	exit := ExitOK
+	var results []fileResult
+	for _, path := range files {
+		r := fileResult{Path: path}
+		results = append(results, r)
+		res := &results[len(results)-1]
+
+		complete, err := analyzer.ValidateFile(path)
+		if err == nil && !complete {
+			res.Status = "truncated"
+			if exit == ExitOK {
+				exit = ExitAttention
+			}
+			continue
+		}
+
+		out, err := optimizer.Fragment(ctx, optimizer.FragmentOptions{
+			Options: optimizer.Options{
+				InputPath: path,
+				OutputDir: *outputDir,
+				Overwrite: optimizer.OverwritePolicy(*overwrite),
+				Verify:    *verify,
+			},
+			FragmentDuration: *duration,
+			Sidx:             *sidx,
+			Mfra:             *mfra,
+		})
+		if errors.Is(err, optimizer.ErrFragmented) {
+			res.Status = "skipped"
+			continue
+		}
+		if err != nil {
+			res.Status = "failed"
+			res.Error = err.Error()
+			if exit == ExitOK {
+				exit = ExitAttention
+			}
+			var canceled *optimizer.CanceledError
+			if errors.As(err, &canceled) {
+				break
+			}
+			continue
+		}
+		res.Status = "fragmented"
+		res.Output = out
+	}
+
+	e.report(results, func(r fileResult) string {
+		switch r.Status {
+		case "fragmented":
+			if r.Output != r.Path {
+				return fmt.Sprintf("FRAGMENTED %s -> %s", r.Path, r.Output)
+			}
+			return "FRAGMENTED " + r.Path
+		case "skipped":
+			return "SKIPPED   " + r.Path + " (already fragmented)"
+		default:
+			return "TRUNCATED " + r.Path + " (not touched)"
+		}
+	})
+	return exit
+}
+
+func runHLS(ctx context.Context, e *env, args []string) int {
+	fs := newFlagSet(e, "hls")
+	outputDir := fs.String("o", "", "create the packages in this folder instead of next to the originals")
+	overwrite := fs.Bool("overwrite", false, "replace existing packages")
+	duration := fs.Duration("duration", packager.DefaultSegmentDuration, "target segment duration; segments start at key frames")
+	single := fs.Bool("single-file", false, "write one media file addressed with byte ranges instead of one file per segment")
+	files, code := expand(e, fs, args)
+	if code >= 0 {
+		return code
+	}
+
+	exit := ExitOK
+	var results []fileResult
+	for _, path := range files {
+		r := fileResult{Path: path}
+		results = append(results, r)
+		res := &results[len(results)-1]
+
+		complete, err := analyzer.ValidateFile(path)
+		if err == nil && !complete {
+			res.Status = "truncated"
+			if exit == ExitOK {
+				exit = ExitAttention
+			}
+			continue
+		}
+
+		opts := packager.HLSOptions{
+			InputPath:       path,
+			SegmentDuration: *duration,
+			SingleFile:      *single,
+			Overwrite:       *overwrite,
+		}
+		if *outputDir != "" {
+			base := filepath.Base(path)
+			opts.OutputDir = filepath.Join(*outputDir, base[:len(base)-len(filepath.Ext(base))]+"_hls")
+		}
+		out, err := packager.PackageHLS(ctx, opts)
+		if errors.Is(err, optimizer.ErrFragmented) {
+			res.Status = "skipped"
+			continue
+		}
+		if err != nil {
+			res.Status = "failed"
+			res.Error = err.Error()
+			if exit == ExitOK {
+				exit = ExitAttention
+			}
+			if errors.Is(err, context.Canceled) {
+				break
+			}
+			continue
+		}
+		res.Status = "packaged"
+		res.Output = filepath.Join(out.OutputDir, out.MasterPlaylist)
+	}
+
+	e.report(results, func(r fileResult) string {
+		switch r.Status {
+		case "packaged":
+			return fmt.Sprintf("PACKAGED  %s -> %s", r.Path, r.Output)
+		case "skipped":
+			return "SKIPPED   " + r.Path + " (already fragmented)"
+		default:
+			return "TRUNCATED " + r.Path + " (not touched)"
+		}
+	})
+	return exit
+}
+
+func runDASH(ctx context.Context, e *env, args []string) int {
+	fs := newFlagSet(e, "dash")
+	outputDir := fs.String("o", "", "create the packages in this folder instead of next to the originals")
+	overwrite := fs.Bool("overwrite", false, "replace existing packages")
+	duration := fs.Duration("duration", packager.DefaultSegmentDuration, "target subsegment duration; subsegments start at key frames")
+	files, code := expand(e, fs, args)
+	if code >= 0 {
+		return code
+	}
+
+	exit := ExitOK
+	var results []fileResult
+	for _, path := range files {
+		r := fileResult{Path: path}
+		results = append(results, r)
+		res := &results[len(results)-1]
+
+		complete, err := analyzer.ValidateFile(path)
+		if err == nil && !complete {
+			res.Status = "truncated"
+			if exit == ExitOK {
+				exit = ExitAttention
+			}
+			continue
+		}
+
+		opts := packager.DASHOptions{
+			InputPath:       path,
+			SegmentDuration: *duration,
+			Overwrite:       *overwrite,
+		}
+		if *outputDir != "" {
+			base := filepath.Base(path)
+			opts.OutputDir = filepath.Join(*outputDir, base[:len(base)-len(filepath.Ext(base))]+"_dash")
+		}
+		out, err := packager.PackageDASH(ctx, opts)
+		if errors.Is(err, optimizer.ErrFragmented) {
+			res.Status = "skipped"
+			continue
+		}
+		if err != nil {
+			res.Status = "failed"
+			res.Error = err.Error()
+			if exit == ExitOK {
+				exit = ExitAttention
+			}
+			if errors.Is(err, context.Canceled) {
+				break
+			}
+			continue
+		}
+		res.Status = "packaged"
+		res.Output = filepath.Join(out.OutputDir, out.Manifest)
+	}
+
+	e.report(results, func(r fileResult) string {
+		switch r.Status {
+		case "packaged":
+			return fmt.Sprintf("PACKAGED  %s -> %s", r.Path, r.Output)
+		case "skipped":
+			return "SKIPPED   " + r.Path + " (already fragmented)"
+		default:
+			return "TRUNCATED " + r.Path + " (not touched)"
+		}
+	})
+	return exit
+}
+
+func runWatch(ctx context.Context, e *env, args []string) int {
+	fs := newFlagSet(e, "watch")
+	fs.Usage = func() {
+		fmt.Fprintln(e.stderr, "Usage: mp4-optimizer watch [flags] <folders>...")
+		fs.PrintDefaults()
+	}
+	interval := fs.Duration("interval", watcher.DefaultInterval, "time between folder scans")
+	stable := fs.Duration("stable", watcher.DefaultStableFor, "how long a file must stay unchanged before it is processed")
+	existing := fs.Bool("existing", false, "also process files already present at startup")
+	outputDir := fs.String("o", "", "write fast-start copies to this folder instead of replacing the originals")
+	overwrite := fs.String("overwrite", string(optimizer.OverwriteRename), "existing copies in -o: fail, replace or rename")
+	safety := fs.String("safety", string(optimizer.SafetyTemp), "in-place safety mode: temp or backup")
+	keepDays := fs.Int("keep-days", 0, "keep verified .bak backups for this many days (with -safety backup)")
+	verify := fs.Bool("verify", true, "verify sample by sample that media bytes are identical")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return ExitOK
+		}
+		return ExitError
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return ExitError
+	}
+
+	w, err := watcher.New(watcher.Config{
+		Dirs:       fs.Args(),
+		Interval:   *interval,
+		StableFor:  *stable,
+		Existing:   *existing,
+		Extensions: discovery.ParseExtensions(e.exts),
+		Optimize: optimizer.Options{
+			OutputDir:      *outputDir,
+			Overwrite:      optimizer.OverwritePolicy(*overwrite),
+			Safety:         optimizer.SafetyMode(*safety),
+			KeepBackupDays: *keepDays,
+			Verify:         *verify,
+		},
+		OnEvent: func(ev watcher.Event) {
+			if e.json {
+				// One JSON object per line so the stream can be tailed
+				json.NewEncoder(e.stdout).Encode(ev)
+			}
+		},
+		Log: log.New(e.stderr, "", log.LstdFlags),
+	})
+	if err != nil {
+		fmt.Fprintln(e.stderr, err)
+		return ExitError
+	}
+
+	if err := w.Run(ctx); err != nil {
+		fmt.Fprintln(e.stderr, err)
+		return ExitError
+	}
+	return ExitOK
+}
+
+func runServe(ctx context.Context, e *env, args []string) int {
+	fs := newFlagSet(e, "serve")
+	fs.Usage = func() {
+		fmt.Fprintln(e.stderr, "Usage: mp4-optimizer serve [flags]")
+		fs.PrintDefaults()
+	}
+	addr := fs.String("addr", server.DefaultAddr, "listen address; keep it on localhost unless the network is trusted")
+	token := fs.String("token", os.Getenv("MP4_OPTIMIZER_TOKEN"), "token clients must send (default $MP4_OPTIMIZER_TOKEN, or a random one)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return ExitOK
+		}
+		return ExitError
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return ExitError
+	}
+
+	logger := log.New(e.stderr, "", log.LstdFlags)
+	srv, err := server.New(server.Config{Addr: *addr, Token: *token, Log: logger})
+	if err != nil {
+		fmt.Fprintln(e.stderr, err)
+		return ExitError
+	}
+	if host, _, err := net.SplitHostPort(srv.Addr()); err == nil {
+		if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			logger.Printf("Warning: listening on %s makes the API reachable from other machines", srv.Addr())
+		}
+	}
+	if *token == "" {
+		logger.Printf("API token: %s", srv.Token())
+	}
+
+	if err := srv.ListenAndServe(ctx); err != nil {
+		fmt.Fprintln(e.stderr, err)
+		return ExitError
+	}
+	return ExitOK
+}
@@ -0,0 +1,223 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func box(typ string, body ...[]byte) []byte {
+	payload := bytes.Join(body, nil)
+	buf := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(8+len(payload)))
+	copy(buf[4:8], typ)
+	return append(buf, payload...)
+}
+
+func u32s(vals ...uint32) []byte {
+	buf := make([]byte, 4*len(vals))
+	for i, v := range vals {
+		binary.BigEndian.PutUint32(buf[i*4:], v)
+	}
+	return buf
+}
+
+// slowStartFile builds [ftyp][mdat][moov] with one video track of two 16 byte chunks.
+func slowStartFile() []byte {
+	ftyp := box("ftyp", []byte("isom"), u32s(0x200), []byte("isom"))
+	mdatStart := uint32(len(ftyp) + 8)
+	mdat := box("mdat", bytes.Repeat([]byte{0xAA}, 16), bytes.Repeat([]byte{0xBB}, 16))
+
+	tkhd := make([]byte, 84)
+	binary.BigEndian.PutUint32(tkhd[12:16], 1)
+	hdlr := make([]byte, 24)
+	copy(hdlr[8:12], "vide")
+	stbl := box("stbl",
+		box("stsd", u32s(0, 0)),
+		box("stts", u32s(0, 1, 2, 40)),
+		box("stsc", u32s(0, 1, 1, 1, 1)),
+		box("stsz", u32s(0, 0, 2, 16, 16)),
+		box("stco", u32s(0, 2, mdatStart, mdatStart+16)),
+	)
+	trak := box("trak", box("tkhd", tkhd),
+		box("mdia", box("mdhd", u32s(0, 0, 0, 1000, 80, 0)), box("hdlr", hdlr), box("minf", stbl)))
+	moov := box("moov", box("mvhd", make([]byte, 100)), trak)
+	return bytes.Join([][]byte{ftyp, mdat, moov}, nil)
+}
+
+// writeFile writes data to name in dir and returns its absolute path.
+func writeFile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// run executes the CLI and returns the exit code with both output streams.
+func run(args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := Run(args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+// runJSON executes the CLI with -json and decodes its results.
+func runJSON(t *testing.T, cmd string, args ...string) (int, []map[string]any) {
+	t.Helper()
+	code, stdout, stderr := run(append([]string{cmd, "-json"}, args...)...)
+	var results []map[string]any
+	if err := json.Unmarshal([]byte(stdout), &results); err != nil {
+		t.Fatalf("%s printed invalid JSON: %v\nstdout: %s\nstderr: %s", cmd, err, stdout, stderr)
+	}
+	return code, results
+}
+
+func TestRunUsage(t *testing.T) {
+	tests := []struct {
+		args []string
+		want int
+	}{
+		{nil, ExitError},
+		{[]string{"help"}, ExitOK},
+		{[]string{"-h"}, ExitOK},
+		{[]string{"nope"}, ExitError},
+		{[]string{"check"}, ExitError},
+		{[]string{"check", "-h"}, ExitOK},
+		{[]string{"check", "-bogus", "x.mp4"}, ExitError},
+	}
+	for _, tt := range tests {
+		if code, _, _ := run(tt.args...); code != tt.want {
+			t.Errorf("Run(%q) = %d, want %d", tt.args, code, tt.want)
+		}
+	}
+
+	// The usage lists the default extensions and how to change them
+	if _, _, stderr := run("help"); !strings.Contains(stderr, ".mp4 .m4v .mov") || !strings.Contains(stderr, "-ext") {
+		t.Errorf("Expected the usage to mention the extensions and -ext:\n%s", stderr)
+	}
+
+	// A folder without media is a usage error, not a clean run
+	dir := t.TempDir()
+	writeFile(t, dir, "notes.txt", []byte("hello"))
+	if code, _, stderr := run("check", dir); code != ExitError || !strings.Contains(stderr, "no media files found") {
+		t.Errorf("Expected exit %d with no media files, got %d: %s", ExitError, code, stderr)
+	}
+}
+
+func TestCheckJSON(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "slow.mp4", slowStartFile())
+
+	code, results := runJSON(t, "check", dir)
+	if code != ExitAttention {
+		t.Errorf("Expected exit %d for a file that needs optimizing, got %d", ExitAttention, code)
+	}
+	if len(results) != 1 {
+		t.Fatalf("Expected 1 result, got %d", len(results))
+	}
+	r := results[0]
+	if r["path"] != path || r["fastStart"] != false {
+		t.Errorf("Expected %s to need optimizing, got %v", path, r)
+	}
+	if _, ok := r["structure"].(map[string]any); !ok {
+		t.Errorf("Expected a structure object, got %v", r["structure"])
+	}
+	// Fields of other commands are omitted
+	for _, key := range []string{"complete", "metadata", "status", "output", "error"} {
+		if _, ok := r[key]; ok {
+			t.Errorf("Unexpected %q in check output: %v", key, r)
+		}
+	}
+}
+
+func TestOptimizeSkipAndForce(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "slow.mp4", slowStartFile())
+
+	code, results := runJSON(t, "optimize", path)
+	if code != ExitOK || results[0]["status"] != "optimized" || results[0]["output"] != path {
+		t.Fatalf("Expected the file to be optimized in place, got exit %d: %v", code, results)
+	}
+	optimized, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := runJSON(t, "check", path); code != ExitOK {
+		t.Errorf("Expected check to pass after optimize, got exit %d", code)
+	}
+
+	code, results = runJSON(t, "optimize", path)
+	if code != ExitOK || results[0]["status"] != "skipped" {
+		t.Errorf("Expected a fast-start file to be skipped, got exit %d: %v", code, results)
+	}
+
+	// -force rewrites it anyway, and the result must still be the same valid file
+	code, results = runJSON(t, "optimize", "-force", path)
+	if code != ExitOK || results[0]["status"] != "optimized" {
+		t.Fatalf("Expected -force to rewrite the file, got exit %d: %v", code, results)
+	}
+	forced, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(forced, optimized) {
+		t.Error("Forcing an already fast-start file changed its contents")
+	}
+}
+
+func TestOptimizeTruncated(t *testing.T) {
+	dir := t.TempDir()
+	data := slowStartFile()
+	path := writeFile(t, dir, "cut.mp4", data[:len(data)-20])
+
+	code, results := runJSON(t, "optimize", path)
+	if code != ExitAttention || results[0]["status"] != "truncated" {
+		t.Errorf("Expected a truncated file to be left alone with exit %d, got %d: %v", ExitAttention, code, results)
+	}
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(after, data[:len(data)-20]) {
+		t.Error("Truncated file was modified")
+	}
+
+	code, results = runJSON(t, "validate", path)
+	if code != ExitAttention || results[0]["complete"] != false {
+		t.Errorf("Expected validate to report the file as incomplete, got exit %d: %v", code, results)
+	}
+}
+
+func TestExtensionFlag(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "slow.mp4", slowStartFile())
+	custom := writeFile(t, dir, "slow.video", slowStartFile())
+
+	code, results := runJSON(t, "check", "-ext", "video", dir)
+	if code != ExitAttention || len(results) != 1 || results[0]["path"] != custom {
+		t.Errorf("Expected only %s to be checked, got exit %d: %v", custom, code, results)
+	}
+}
+
+func TestMissingArgument(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "slow.mp4", slowStartFile())
+	missing := filepath.Join(dir, "missing.mp4")
+
+	// The readable file is still checked, but the run fails
+	code, results := runJSON(t, "check", path, missing)
+	if code != ExitError {
+		t.Errorf("Expected exit %d with a missing argument, got %d", ExitError, code)
+	}
+	if len(results) != 1 || results[0]["path"] != path {
+		t.Errorf("Expected %s to be checked, got %v", path, results)
+	}
+	if _, _, stderr := run("check", path, missing); !strings.Contains(stderr, "missing.mp4") {
+		t.Errorf("Expected the missing path to be reported:\n%s", stderr)
+	}
+}
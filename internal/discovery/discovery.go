@@ -0,0 +1,141 @@
+package discovery
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// DefaultExtensions are the ISO base media (MP4 family) and QuickTime file
+// extensions picked up when no other set is configured. They all share the
+// moov/mdat layout the optimizer fixes.
+var DefaultExtensions = []string{".mp4", ".m4v", ".mov", ".m4a", ".m4b", ".3gp", ".3g2"}
+
+// Logger receives diagnostic messages while expanding paths. It may be nil.
+type Logger func(msg string)
+
+// Result is the outcome of expanding a list of paths.
+type Result struct {
+	// Files are absolute, de-duplicated media paths in discovery order
+	Files []string
+	// Folders are every directory that was visited, including parents of plain files
+	Folders []string
+}
+
+// ParseExtensions parses a comma-separated list such as "mp4, .MOV" into
+// lower-case extensions with a leading dot. Empty entries are ignored.
+func ParseExtensions(list string) []string {
+	var exts []string
+	for _, e := range strings.Split(list, ",") {
+		e = strings.ToLower(strings.TrimSpace(e))
+		if e == "" || e == "." {
+			continue
+		}
+		if !strings.HasPrefix(e, ".") {
+			e = "." + e
+		}
+		if !slices.Contains(exts, e) {
+			exts = append(exts, e)
+		}
+	}
+	return exts
+}
+
+// HasExtension reports whether path has one of exts, ignoring case.
+// Nil exts means DefaultExtensions.
+func HasExtension(path string, exts []string) bool {
+	if exts == nil {
+		exts = DefaultExtensions
+	}
+	return slices.Contains(exts, strings.ToLower(filepath.Ext(path)))
+}
+
+// IsTempFile checks if a file is a temporary file written by the optimizer.
+// os.CreateTemp expands the pattern {name}_tmp_*{ext} with a decimal number,
+// so only names of that exact form with one of exts (nil means
+// DefaultExtensions) match; "clip_tmp_final.mov" does not.
+func IsTempFile(path string, exts []string) bool {
+	name := filepath.Base(path)
+	if !HasExtension(name, exts) {
+		return false
+	}
+	stem := strings.TrimSuffix(name, filepath.Ext(name))
+	i := strings.LastIndex(stem, "_tmp_")
+	if i <= 0 {
+		return false
+	}
+	random := stem[i+len("_tmp_"):]
+	return random != "" && strings.Trim(random, "0123456789") == ""
+}
+
+// Expand takes a list of files and directories and returns every media file
+// with one of exts (nil means DefaultExtensions). Directories are walked
+// recursively; temp files and invalid paths are skipped.
+func Expand(paths []string, exts []string, logf Logger) Result {
+	if logf == nil {
+		logf = func(string) {}
+	}
+
+	var result Result
+	uniquePaths := make(map[string]bool)
+	folders := make(map[string]bool)
+
+	addFolder := func(dir string) {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			abs = dir
+		}
+		if !folders[abs] {
+			folders[abs] = true
+			result.Folders = append(result.Folders, abs)
+		}
+	}
+	addFile := func(path string) {
+		abs, err := filepath.Abs(path)
+		if err == nil && !uniquePaths[abs] {
+			uniquePaths[abs] = true
+			result.Files = append(result.Files, abs)
+		}
+	}
+
+	for _, p := range paths {
+		cleanPath := filepath.Clean(p)
+		info, err := os.Stat(cleanPath)
+		if err != nil {
+			logf(fmt.Sprintf("Error accessing path '%s': %v", cleanPath, err))
+			continue // Skip invalid paths
+		}
+
+		if !info.IsDir() {
+			// It's a file - track its parent folder
+			addFolder(filepath.Dir(cleanPath))
+			if HasExtension(cleanPath, exts) && !IsTempFile(cleanPath, exts) {
+				addFile(cleanPath)
+			} else {
+				logf(fmt.Sprintf("Skipped unsupported or temp file: %s", cleanPath))
+			}
+			continue
+		}
+
+		logf(fmt.Sprintf("processing directory: %s", cleanPath))
+		err = filepath.WalkDir(cleanPath, func(path string, d os.DirEntry, err error) error {
+			if err != nil {
+				logf(fmt.Sprintf("Walk error at %s: %v", path, err))
+				return nil // Skip errors accessing files
+			}
+			if d.IsDir() {
+				addFolder(path)
+			} else if HasExtension(path, exts) && !IsTempFile(path, exts) {
+				addFile(path)
+			}
+			return nil
+		})
+		if err != nil {
+			logf(fmt.Sprintf("Error walking dir %s: %v", cleanPath, err))
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,87 @@
+package discovery
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestParseExtensions(t *testing.T) {
+	tests := []struct {
+		list string
+		want []string
+	}{
+		{"mp4, .MOV", []string{".mp4", ".mov"}},
+		{".m4v,,m4v, M4V", []string{".m4v"}},
+		{" , .", nil},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		if got := ParseExtensions(tt.list); !slices.Equal(got, tt.want) {
+			t.Errorf("ParseExtensions(%q) = %q, want %q", tt.list, got, tt.want)
+		}
+	}
+}
+
+func TestIsTempFile(t *testing.T) {
+	tests := []struct {
+		path string
+		exts []string
+		want bool
+	}{
+		{"clip_tmp_123456.mp4", nil, true},
+		{"/videos/clip_tmp_123456.MOV", nil, true},
+		{"my_tmp_clip_tmp_42.m4a", nil, true},
+		{"clip.mp4", nil, false},
+		{"clip_tmp_final.mov", nil, false},
+		{"clip_tmp_.mp4", nil, false},
+		{"_tmp_123.mp4", nil, false},
+		{"clip_tmp_123456.txt", nil, false},
+		{"clip_tmp_123456", nil, false},
+		// Temp files of extensions added by the user, and only those in use
+		{"clip_tmp_123456.mkv", []string{".mkv"}, true},
+		{"clip_tmp_123456.mp4", []string{".mkv"}, false},
+	}
+	for _, tt := range tests {
+		if got := IsTempFile(tt.path, tt.exts); got != tt.want {
+			t.Errorf("IsTempFile(%q, %q) = %v, want %v", tt.path, tt.exts, got, tt.want)
+		}
+	}
+}
+
+func TestExpand(t *testing.T) {
+	root := t.TempDir()
+	sub := filepath.Join(root, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.mp4", "b.MOV", "notes.txt", "a_tmp_42.mp4", "sub/c.m4a", "sub/d.mkv", "x_tmp_final.mp4"} {
+		if err := os.WriteFile(filepath.Join(root, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var logged []string
+	logf := func(msg string) { logged = append(logged, msg) }
+	// The folder, a file inside it again, a plain file and a path that does not exist
+	result := Expand([]string{root, filepath.Join(root, "a.mp4"), filepath.Join(root, "notes.txt"), filepath.Join(root, "missing.mp4")}, nil, logf)
+
+	// a_tmp_42.mp4 is an optimizer temp file, x_tmp_final.mp4 a user file
+	want := []string{filepath.Join(root, "a.mp4"), filepath.Join(root, "b.MOV"), filepath.Join(sub, "c.m4a"), filepath.Join(root, "x_tmp_final.mp4")}
+	if !slices.Equal(result.Files, want) {
+		t.Errorf("Files = %q, want %q", result.Files, want)
+	}
+	if !slices.Equal(result.Folders, []string{root, sub}) {
+		t.Errorf("Folders = %q, want %q", result.Folders, []string{root, sub})
+	}
+	if len(logged) == 0 {
+		t.Error("Expected the skipped and missing paths to be logged")
+	}
+
+	// An explicit extension list replaces the defaults
+	result = Expand([]string{root}, ParseExtensions("mkv"), nil)
+	if want := []string{filepath.Join(sub, "d.mkv")}; !slices.Equal(result.Files, want) {
+		t.Errorf("Files with mkv = %q, want %q", result.Files, want)
+	}
+}
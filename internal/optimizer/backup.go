@@ -0,0 +1,231 @@
+package optimizer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// SafetyMode selects how an in-place optimization protects the original file.
+type SafetyMode string
+
+const (
+	// SafetyTemp writes a temp file and atomically renames it over the original. This is the default.
+	SafetyTemp SafetyMode = "temp"
+	// SafetyBackup renames the original to {name}.bak, verifies the new file against it
+	// and restores the backup automatically when verification fails.
+	SafetyBackup SafetyMode = "backup"
+)
+
+// backupExt is appended to the original file name.
+const backupExt = ".bak"
+
+// ErrNoBackup is returned by RestoreBackup when no backup exists for the file.
+var ErrNoBackup = errors.New("no backup found")
+
+// BackupPath returns the backup file name for path.
+func BackupPath(path string) string {
+	return path + backupExt
+}
+
+// backupManifest lists the backups the optimizer made in a folder. Only those
+// are ever pruned, so .bak files the user made by hand are never deleted.
+const backupManifest = ".mp4-optimizer-backups.json"
+
+// manifestMu serializes manifest updates of concurrent optimizations.
+var manifestMu sync.Mutex
+
+// backupRecord is a manifest entry, keyed by the backup's file name.
+type backupRecord struct {
+	// Size tells a recorded backup apart from a file that later took its name
+	Size    int64     `json:"size"`
+	Created time.Time `json:"created"`
+}
+
+func readManifest(dir string) (map[string]backupRecord, error) {
+	records := make(map[string]backupRecord)
+	data, err := os.ReadFile(filepath.Join(dir, backupManifest))
+	if os.IsNotExist(err) {
+		return records, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("invalid backup manifest: %w", err)
+	}
+	return records, nil
+}
+
+// writeManifest saves records, removing the manifest once it is empty.
+func writeManifest(dir string, records map[string]backupRecord) error {
+	path := filepath.Join(dir, backupManifest)
+	if len(records) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// recordBackup adds bak to the manifest of its folder.
+func recordBackup(bak string, size int64, created time.Time) error {
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+	dir := filepath.Dir(bak)
+	records, err := readManifest(dir)
+	if err != nil {
+		return err
+	}
+	records[filepath.Base(bak)] = backupRecord{Size: size, Created: created}
+	return writeManifest(dir, records)
+}
+
+// forgetBackup removes bak from the manifest of its folder.
+func forgetBackup(bak string) {
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+	dir := filepath.Dir(bak)
+	records, err := readManifest(dir)
+	if err != nil {
+		return
+	}
+	if _, ok := records[filepath.Base(bak)]; ok {
+		delete(records, filepath.Base(bak))
+		writeManifest(dir, records)
+	}
+}
+
+// IsBackupFile checks if path is a backup the optimizer made and recorded,
+// and has not been replaced since.
+func IsBackupFile(path string) bool {
+	manifestMu.Lock()
+	records, err := readManifest(filepath.Dir(path))
+	manifestMu.Unlock()
+	if err != nil {
+		return false
+	}
+	rec, ok := records[filepath.Base(path)]
+	if !ok {
+		return false
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular() && info.Size() == rec.Size
+}
+
+// RestoreBackup replaces path with its {name}.bak backup.
+func RestoreBackup(path string) error {
+	bak := BackupPath(path)
+	if _, err := os.Stat(bak); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%w for %s", ErrNoBackup, path)
+		}
+		return err
+	}
+	if err := os.Rename(bak, path); err != nil {
+		return fmt.Errorf("failed to restore backup: %w", err)
+	}
+	forgetBackup(bak)
+	return nil
+}
+
+// PruneBackups removes the recorded optimizer backups in dir that are older
+// than keepDays. Other .bak files are left alone. It returns the number of
+// removed files.
+func PruneBackups(dir string, keepDays int) (int, error) {
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+	records, err := readManifest(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -keepDays)
+	removed := 0
+	for name, rec := range records {
+		path := filepath.Join(dir, name)
+		info, err := os.Lstat(path)
+		if err != nil || !info.Mode().IsRegular() || info.Size() != rec.Size {
+			// Gone, restored or replaced by another file: no longer ours
+			delete(records, name)
+			continue
+		}
+		if rec.Created.After(cutoff) {
+			continue
+		}
+		if err := os.Remove(path); err == nil {
+			delete(records, name)
+			removed++
+		}
+	}
+	return removed, writeManifest(dir, records)
+}
+
+// replaceWithBackup moves path aside to its backup and tmpPath into place,
+// then verifies the result with verifyRewrite. On any failure the original is restored.
+func replaceWithBackup(path, tmpPath string, keepDays int, verifyRewrite func(orig, rewritten string) error, reportProgress func(float64, string)) error {
+	bak := BackupPath(path)
+	if _, err := os.Lstat(bak); err == nil {
+		return fmt.Errorf("backup already exists: %s", bak)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	// 1. Backup the original
+	if err := os.Rename(path, bak); err != nil {
+		return fmt.Errorf("failed to create backup: %w", err)
+	}
+	// A rename keeps the original mtime; stamp the backup so retention counts from now
+	now := time.Now()
+	os.Chtimes(bak, now, now)
+
+	restore := func() {
+		os.Remove(path)
+		if err := os.Rename(bak, path); err == nil {
+			os.Chtimes(path, now, info.ModTime())
+			forgetBackup(bak)
+		}
+	}
+	if keepDays > 0 {
+		// Only recorded backups are pruned later
+		if err := recordBackup(bak, info.Size(), now); err != nil {
+			restore()
+			return fmt.Errorf("failed to record backup: %w", err)
+		}
+	}
+
+	// 2. Put the new file in place
+	if err := os.Rename(tmpPath, path); err != nil {
+		restore()
+		return fmt.Errorf("failed to replace file: %w", err)
+	}
+
+	// 3. Verify against the backup
+	reportProgress(97, "校验文件...")
+	if err := verifyRewrite(bak, path); err != nil {
+		restore()
+		return fmt.Errorf("restored original: %w", err)
+	}
+
+	// 4. Drop the backup, or keep it for the retention period
+	if keepDays <= 0 {
+		if err := os.Remove(bak); err != nil {
+			return fmt.Errorf("failed to remove backup: %w", err)
+		}
+		return nil
+	}
+	PruneBackups(filepath.Dir(path), keepDays)
+	return nil
+}
@@ -0,0 +1,49 @@
+package optimizer
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// copyChunkSize bounds how much is copied between cancellation checks.
+const copyChunkSize = 4 << 20
+
+// CanceledError is returned when an optimization is stopped through its context.
+// It unwraps to the context error, so errors.Is(err, context.Canceled) holds.
+type CanceledError struct {
+	Path string
+	Err  error
+}
+
+func (e *CanceledError) Error() string {
+	return fmt.Sprintf("optimization of %s canceled: %v", e.Path, e.Err)
+}
+
+func (e *CanceledError) Unwrap() error {
+	return e.Err
+}
+
+// checkCanceled returns a *CanceledError once ctx is done.
+func checkCanceled(ctx context.Context, path string) error {
+	if err := ctx.Err(); err != nil {
+		return &CanceledError{Path: path, Err: err}
+	}
+	return nil
+}
+
+// copyContext copies n bytes from src to dst, checking ctx between chunks.
+func copyContext(ctx context.Context, path string, dst io.Writer, src io.Reader, n int64) error {
+	for n > 0 {
+		if err := checkCanceled(ctx, path); err != nil {
+			return err
+		}
+		chunk := min(n, copyChunkSize)
+		written, err := io.CopyN(dst, src, chunk)
+		n -= written
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,359 @@
+package optimizer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+
+	"mp4-optimizer/pkg/atomic"
+)
+
+// ErrNotFragmented is returned by Defragment for files without movie fragments.
+var ErrNotFragmented = errors.New("not a fragmented MP4")
+
+// fragmentOnlyBoxes are top-level boxes that only make sense next to movie fragments.
+var fragmentOnlyBoxes = map[string]bool{
+	"moof": true, "mdat": true, "sidx": true, "ssix": true,
+	"mfra": true, "styp": true, "emsg": true, "prft": true,
+}
+
+// rebuiltTables are the stbl children replaced by the merged sample tables.
+// Per-sample side tables are dropped because their counts no longer match.
+var rebuiltTables = []string{"stts", "ctts", "stss", "stsz", "stz2", "stsc", "stco", "co64", "sdtp", "sbgp", "subs"}
+
+// Defragment merges the fragments of an fMP4/CMAF file into a progressive
+// fast-start MP4: one moov with complete sample tables followed by a single
+// mdat. Samples keep their interleaving from the source file. Output handling,
+// safety mode and verification behave as in OptimizeWithOptions.
+func Defragment(ctx context.Context, opts Options) (string, error) {
+	progressFn := opts.Progress
+	reportProgress := func(p float64, msg string) {
+		if progressFn != nil {
+			progressFn(p, msg)
+		}
+	}
+
+	path := opts.InputPath
+	outPath, err := resolveOutputPath(opts)
+	if err != nil {
+		return "", err
+	}
+
+	reportProgress(0, "开始处理...")
+
+	// 1. Open original file for reading
+	in, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	reportProgress(10, "解析文件结构...")
+
+	// 2. Parse the box tree and check that there is something to merge
+	boxes, err := atomic.ParseTree(in)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse atoms: %w", err)
+	}
+	moovBox := atomic.FindBox(boxes, "moov")
+	ftypBox := atomic.FindBox(boxes, "ftyp")
+	if moovBox == nil {
+		return "", fmt.Errorf("no moov atom found")
+	}
+	if atomic.FindBox(boxes, "moof") == nil && moovBox.Child("mvex") == nil {
+		return "", ErrNotFragmented
+	}
+	for _, b := range boxes {
+		if b.Type == "moof" && (len(b.FindAll("senc")) > 0 || len(b.FindAll("saiz")) > 0) {
+			return "", fmt.Errorf("encrypted fragments are not supported")
+		}
+	}
+
+	reportProgress(20, "读取分片...")
+
+	// 3. Resolve every sample, from moov and from all fragments
+	if err := moovBox.Load(in); err != nil {
+		return "", err
+	}
+	tracks, err := readMovieTracks(in, boxes, moovBox)
+	if err != nil {
+		return "", fmt.Errorf("failed to read fragments: %w", err)
+	}
+
+	reportProgress(30, "重建索引...")
+
+	// 4. Build the progressive moov with sample tables pointing into the new mdat
+	var ftypSize int64
+	if ftypBox != nil {
+		ftypSize = ftypBox.Size
+	}
+	order := interleave(tracks)
+	dataSize, err := buildProgressiveMoov(moovBox, tracks, order, ftypSize)
+	if err != nil {
+		return "", fmt.Errorf("failed to build sample tables: %w", err)
+	}
+	moovBuf, err := moovBox.Bytes()
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize moov: %w", err)
+	}
+
+	reportProgress(40, "创建临时文件...")
+
+	// 5. Create temporary file next to the output
+	dir := filepath.Dir(outPath)
+	ext := filepath.Ext(outPath)
+	base := filepath.Base(outPath)
+	nameWithoutExt := base[:len(base)-len(ext)]
+
+	tmpFile, err := os.CreateTemp(dir, nameWithoutExt+"_tmp_*"+ext)
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := tmpFile.Name()
+
+	success := false
+	defer func() {
+		tmpFile.Close()
+		if !success {
+			os.Remove(tmpPath)
+		}
+	}()
+
+	// 6. Write ftyp, moov and the mdat header
+	if ftypBox != nil {
+		if _, err := in.Seek(ftypBox.Offset, io.SeekStart); err != nil {
+			return "", err
+		}
+		if err := copyContext(ctx, path, tmpFile, in, ftypBox.Size); err != nil {
+			return "", err
+		}
+	}
+	if _, err := tmpFile.Write(moovBuf); err != nil {
+		return "", err
+	}
+	if _, err := atomic.WriteHeader(tmpFile, "mdat", atomic.HeaderSizeFor(dataSize)+dataSize); err != nil {
+		return "", err
+	}
+
+	reportProgress(45, "写入视频数据...")
+
+	// 7. Copy the samples, coalescing runs that are contiguous in the source
+	var written int64
+	for i := 0; i < len(order); {
+		start := tracks[order[i].track].Samples[order[i].index]
+		runEnd := start.Offset + int64(start.Size)
+		j := i + 1
+		for j < len(order) {
+			s := tracks[order[j].track].Samples[order[j].index]
+			if s.Offset != runEnd {
+				break
+			}
+			runEnd += int64(s.Size)
+			j++
+		}
+
+		if _, err := in.Seek(start.Offset, io.SeekStart); err != nil {
+			return "", err
+		}
+		if err := copyContext(ctx, path, tmpFile, in, runEnd-start.Offset); err != nil {
+			return "", err
+		}
+		written += runEnd - start.Offset
+		if dataSize > 0 {
+			reportProgress(45+float64(written)/float64(dataSize)*40, "写入视频数据...")
+		}
+		i = j
+	}
+
+	// 8. Keep remaining top-level boxes (udta, meta, uuid, ...) after the media
+	for _, b := range boxes {
+		if b.Type == "ftyp" || b.Type == "moov" || fragmentOnlyBoxes[b.Type] {
+			continue
+		}
+		if _, err := in.Seek(b.Offset, io.SeekStart); err != nil {
+			return "", err
+		}
+		if err := copyContext(ctx, path, tmpFile, in, b.Size); err != nil {
+			return "", err
+		}
+	}
+
+	if err := tmpFile.Sync(); err != nil {
+		return "", err
+	}
+	tmpFile.Close()
+	in.Close()
+
+	// 9. Verify and move into place
+	if err := commitOutput(ctx, opts, outPath, tmpPath, sampleVerifier(ctx), reportProgress); err != nil {
+		return "", err
+	}
+	success = true
+	reportProgress(100, "完成！")
+	return outPath, nil
+}
+
+// sampleVerifier checks a rewritten file sample by sample. Remuxed layouts
+// differ completely, so the backup workflow cannot compare box by box.
+func sampleVerifier(ctx context.Context) func(orig, rewritten string) error {
+	return func(orig, rewritten string) error {
+		_, err := VerifySamples(ctx, orig, rewritten)
+		return err
+	}
+}
+
+// sampleRef points at one sample of one track.
+type sampleRef struct {
+	track, index int
+}
+
+// interleave lists every sample in source file order while keeping each track
+// in decode order, so the new mdat preserves the interleaving of the fragments.
+func interleave(tracks []*atomic.Track) []sampleRef {
+	total := 0
+	for _, t := range tracks {
+		total += len(t.Samples)
+	}
+	order := make([]sampleRef, 0, total)
+	next := make([]int, len(tracks))
+	for len(order) < total {
+		best := -1
+		for i, t := range tracks {
+			if next[i] >= len(t.Samples) {
+				continue
+			}
+			if best == -1 || t.Samples[next[i]].Offset < tracks[best].Samples[next[best]].Offset {
+				best = i
+			}
+		}
+		order = append(order, sampleRef{track: best, index: next[best]})
+		next[best]++
+	}
+	return order
+}
+
+// buildProgressiveMoov turns the fragmented moov into a progressive one whose
+// chunk offsets address a single mdat that follows it, holding the samples in
+// order. It returns the size of the mdat payload.
+func buildProgressiveMoov(moov *atomic.Box, tracks []*atomic.Track, order []sampleRef, ftypSize int64) (int64, error) {
+	if mvex := moov.Child("mvex"); mvex != nil {
+		moov.RemoveChild(mvex)
+	}
+
+	// Chunks with offsets relative to the start of the mdat payload. A chunk
+	// ends wherever another track's sample or a new sample description intervenes.
+	chunks := make([][]atomic.Chunk, len(tracks))
+	var dataSize int64
+	prevTrack := -1
+	for _, ref := range order {
+		t := tracks[ref.track]
+		s := t.Samples[ref.index]
+		c := chunks[ref.track]
+		if ref.track != prevTrack || len(c) == 0 || t.Samples[ref.index-1].DescriptionIndex != s.DescriptionIndex {
+			c = append(c, atomic.Chunk{Offset: dataSize})
+		}
+		c[len(c)-1].Samples++
+		chunks[ref.track] = c
+		dataSize += int64(s.Size)
+		prevTrack = ref.track
+	}
+
+	stbls := make([]*atomic.Box, len(tracks))
+	for i, t := range tracks {
+		stbls[i] = t.Trak.Find("mdia", "minf", "stbl")
+		if stbls[i] == nil {
+			return 0, fmt.Errorf("track %d has no stbl", t.ID)
+		}
+		for _, typ := range rebuiltTables {
+			for _, b := range stbls[i].ChildrenOfType(typ) {
+				stbls[i].RemoveChild(b)
+			}
+		}
+	}
+	setDurations(moov, tracks)
+
+	// The chunk offsets depend on the moov size, which depends on whether
+	// they need co64
+	large := false
+	dataStart := ftypSize + moovSizeWith(moov, tracks, chunks, stbls, false) + atomic.HeaderSizeFor(dataSize)
+	if dataStart+dataSize > math.MaxUint32 {
+		large = true
+		dataStart = ftypSize + moovSizeWith(moov, tracks, chunks, stbls, true) + atomic.HeaderSizeFor(dataSize)
+	}
+	for i, t := range tracks {
+		abs := make([]atomic.Chunk, len(chunks[i]))
+		for j, c := range chunks[i] {
+			abs[j] = atomic.Chunk{Offset: dataStart + c.Offset, Samples: c.Samples}
+		}
+		insertTables(stbls[i], atomic.BuildSampleTables(t.Samples, abs, large))
+	}
+	moov.ComputeSize()
+	return dataSize, nil
+}
+
+// moovSizeWith returns the size moov will have once the sample tables are built.
+func moovSizeWith(moov *atomic.Box, tracks []*atomic.Track, chunks [][]atomic.Chunk, stbls []*atomic.Box, large bool) int64 {
+	var tables [][]*atomic.Box
+	for i, t := range tracks {
+		built := atomic.BuildSampleTables(t.Samples, chunks[i], large)
+		insertTables(stbls[i], built)
+		tables = append(tables, built)
+	}
+	size := moov.ComputeSize()
+	for i := range tables {
+		for _, b := range tables[i] {
+			stbls[i].RemoveChild(b)
+		}
+	}
+	return size
+}
+
+// insertTables places the sample tables right after stsd.
+func insertTables(stbl *atomic.Box, tables []*atomic.Box) {
+	pos := 0
+	for i, c := range stbl.Children {
+		if c.Type == "stsd" {
+			pos = i + 1
+			break
+		}
+	}
+	for i, b := range tables {
+		stbl.InsertChild(pos+i, b)
+	}
+}
+
+// setDurations stores the merged media durations in mdhd, and in tkhd and
+// mvhd when the movie timescale is known.
+func setDurations(moov *atomic.Box, tracks []*atomic.Track) {
+	var movieTimescale uint32
+	mvhd := moov.Child("mvhd")
+	if mvhd != nil {
+		movieTimescale = mvhd.Timescale()
+	}
+
+	var movieDuration uint64
+	for _, t := range tracks {
+		var media uint64
+		for _, s := range t.Samples {
+			media += uint64(s.Duration)
+		}
+		if mdhd := t.Trak.Find("mdia", "mdhd"); mdhd != nil {
+			mdhd.SetDuration(media)
+		}
+		if t.Timescale == 0 || movieTimescale == 0 {
+			continue
+		}
+		scaled := media * uint64(movieTimescale) / uint64(t.Timescale)
+		if tkhd := t.Trak.Child("tkhd"); tkhd != nil {
+			tkhd.SetDuration(scaled)
+		}
+		movieDuration = max(movieDuration, scaled)
+	}
+	if movieTimescale != 0 {
+		mvhd.SetDuration(movieDuration)
+	}
+}
@@ -0,0 +1,179 @@
+package optimizer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"mp4-optimizer/pkg/atomic"
+)
+
+// DefaultFragmentDuration is the target fragment length when none is given.
+const DefaultFragmentDuration = 2 * time.Second
+
+// FragmentOptions configures Fragment. The embedded Options select the output
+// and safety mode exactly as for OptimizeWithOptions.
+type FragmentOptions struct {
+	Options
+	// FragmentDuration is the target length of each fragment. Fragments start
+	// at sync samples, so they may run longer.
+	FragmentDuration time.Duration
+	// Sidx adds a segment index after the init segment
+	Sidx bool
+	// Mfra appends a movie fragment random access index
+	Mfra bool
+}
+
+// Fragment remuxes a progressive MP4 into a fragmented (fMP4/CMAF) file: an init
+// segment (ftyp and a moov with mvex/trex) followed by moof/mdat fragments cut
+// at sync samples. The media is copied as is, nothing is re-encoded.
+func Fragment(ctx context.Context, opts FragmentOptions) (string, error) {
+	progressFn := opts.Progress
+	reportProgress := func(p float64, msg string) {
+		if progressFn != nil {
+			progressFn(p, msg)
+		}
+	}
+	if opts.FragmentDuration <= 0 {
+		opts.FragmentDuration = DefaultFragmentDuration
+	}
+
+	path := opts.InputPath
+	outPath, err := resolveOutputPath(opts.Options)
+	if err != nil {
+		return "", err
+	}
+
+	reportProgress(0, "开始处理...")
+
+	// 1. Open original file for reading
+	in, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	reportProgress(10, "解析文件结构...")
+
+	// 2. Parse the box tree
+	boxes, err := atomic.ParseTree(in)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse atoms: %w", err)
+	}
+	moovBox := atomic.FindBox(boxes, "moov")
+	if moovBox == nil {
+		return "", fmt.Errorf("no moov atom found")
+	}
+	if atomic.FindBox(boxes, "moof") != nil || moovBox.Child("mvex") != nil {
+		return "", ErrFragmented
+	}
+
+	reportProgress(20, "规划分片...")
+
+	// 3. Plan the fragments and build the init segment
+	plan, err := atomic.PlanFragments(in, boxes, opts.FragmentDuration)
+	if err != nil {
+		return "", fmt.Errorf("failed to plan fragments: %w", err)
+	}
+	tracks, fragments := plan.Tracks, plan.Fragments
+
+	var sidxBuf []byte
+	if opts.Sidx && len(fragments) > 0 {
+		sidx, err := plan.Sidx(0)
+		if err != nil {
+			return "", err
+		}
+		if sidxBuf, err = sidx.Bytes(); err != nil {
+			return "", err
+		}
+	}
+
+	reportProgress(30, "创建临时文件...")
+
+	// 4. Create temporary file next to the output
+	dir := filepath.Dir(outPath)
+	ext := filepath.Ext(outPath)
+	base := filepath.Base(outPath)
+	nameWithoutExt := base[:len(base)-len(ext)]
+
+	tmpFile, err := os.CreateTemp(dir, nameWithoutExt+"_tmp_*"+ext)
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := tmpFile.Name()
+
+	success := false
+	defer func() {
+		tmpFile.Close()
+		if !success {
+			os.Remove(tmpPath)
+		}
+	}()
+
+	// 5. Write the init segment and the optional segment index
+	offset := int64(0)
+	for _, buf := range [][]byte{plan.Init, sidxBuf} {
+		n, err := tmpFile.Write(buf)
+		offset += int64(n)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	reportProgress(35, "写入分片...")
+
+	// 6. Write the fragments, remembering where each moof starts for mfra
+	ref := atomic.ReferenceTrack(tracks)
+	randomAccess := make(map[uint32][]atomic.RandomAccessEntry)
+	for i, f := range fragments {
+		if err := checkCanceled(ctx, path); err != nil {
+			return "", err
+		}
+		// Each run is written as one traf, in order
+		for j, r := range f.Runs {
+			if r.Samples[0].Sync {
+				randomAccess[r.TrackID] = append(randomAccess[r.TrackID],
+					atomic.RandomAccessEntry{Time: r.Samples[0].DecodeTime, MoofOffset: offset, TrafNumber: uint16(j + 1)})
+			}
+		}
+		n, err := f.Write(tmpFile, in)
+		offset += n
+		if err != nil {
+			return "", err
+		}
+		reportProgress(35+float64(i+1)/float64(len(fragments))*50, fmt.Sprintf("写入分片... %d/%d", i+1, len(fragments)))
+	}
+
+	// 7. Append the random access index
+	if opts.Mfra && len(tracks) > 0 {
+		ids := []uint32{tracks[ref].ID}
+		for i, t := range tracks {
+			if i != ref {
+				ids = append(ids, t.ID)
+			}
+		}
+		mfra, err := atomic.BuildMfra(randomAccess, ids).Bytes()
+		if err != nil {
+			return "", err
+		}
+		if _, err := tmpFile.Write(mfra); err != nil {
+			return "", err
+		}
+	}
+
+	if err := tmpFile.Sync(); err != nil {
+		return "", err
+	}
+	tmpFile.Close()
+	in.Close()
+
+	// 8. Verify and move into place
+	if err := commitOutput(ctx, opts.Options, outPath, tmpPath, sampleVerifier(ctx), reportProgress); err != nil {
+		return "", err
+	}
+	success = true
+	reportProgress(100, "完成！")
+	return outPath, nil
+}
@@ -0,0 +1,690 @@
+package optimizer
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"mp4-optimizer/pkg/atomic"
+)
+
+func box(typ string, body ...[]byte) []byte {
+	payload := bytes.Join(body, nil)
+	buf := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(8+len(payload)))
+	copy(buf[4:8], typ)
+	return append(buf, payload...)
+}
+
+func u32s(vals ...uint32) []byte {
+	buf := make([]byte, 4*len(vals))
+	for i, v := range vals {
+		binary.BigEndian.PutUint32(buf[i*4:], v)
+	}
+	return buf
+}
+
+func stcoBox(offsets ...uint32) []byte {
+	return box("stco", u32s(0, uint32(len(offsets))), u32s(offsets...))
+}
+
+func moovWithStco(stco []byte) []byte {
+	return box("moov", box("mvhd", make([]byte, 100)), trakBox(1, stco, []uint32{16, 16}, 1))
+}
+
+// trakBox builds a video track whose chunks each hold perChunk samples of the given sizes.
+func trakBox(id uint32, stco []byte, sizes []uint32, perChunk uint32) []byte {
+	tkhd := make([]byte, 84)
+	binary.BigEndian.PutUint32(tkhd[12:16], id)
+	mdhd := u32s(0, 0, 0, 1000, uint32(len(sizes))*40, 0)
+	hdlr := make([]byte, 24)
+	copy(hdlr[8:12], "vide")
+	stbl := box("stbl",
+		box("stsd", u32s(0, 0)),
+		box("stts", u32s(0, 1, uint32(len(sizes)), 40)),
+		box("stsc", u32s(0, 1, 1, perChunk, 1)),
+		box("stsz", u32s(0, 0, uint32(len(sizes))), u32s(sizes...)),
+		stco,
+	)
+	return box("trak", box("tkhd", tkhd), box("mdia", box("mdhd", mdhd), box("hdlr", hdlr), box("minf", stbl)))
+}
+
+// slowStartFile builds [ftyp][mdat][moov] where each chunk holds a distinct byte pattern.
+func slowStartFile() []byte {
+	ftyp := box("ftyp", []byte("isom"), u32s(0x200), []byte("isom"))
+	chunks := [][]byte{bytes.Repeat([]byte{0xAA}, 16), bytes.Repeat([]byte{0xBB}, 16)}
+	mdatStart := uint32(len(ftyp) + 8)
+	mdat := box("mdat", chunks...)
+	moov := moovWithStco(stcoBox(mdatStart, mdatStart+16))
+	return bytes.Join([][]byte{ftyp, mdat, moov}, nil)
+}
+
+func TestOptimize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "slow.mp4")
+	if err := os.WriteFile(path, slowStartFile(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Optimize(context.Background(), path); err != nil {
+		t.Fatalf("Optimize failed: %v", err)
+	}
+
+	out, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	boxes, err := atomic.ParseTree(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("ParseTree failed: %v", err)
+	}
+	if len(boxes) != 3 || boxes[1].Type != "moov" || boxes[2].Type != "mdat" {
+		t.Fatalf("Expected [ftyp][moov][mdat], got %d boxes", len(boxes))
+	}
+
+	stco := boxes[1].FindAll("stco")[0]
+	body := out[stco.BodyOffset():stco.End()]
+	for i, want := range []byte{0xAA, 0xBB} {
+		off := binary.BigEndian.Uint32(body[8+i*4:])
+		if out[off] != want {
+			t.Errorf("Chunk %d points at 0x%02x, want 0x%02x", i, out[off], want)
+		}
+	}
+}
+
+func TestOptimizeAlreadyOptimized(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "slow.mp4")
+	if err := os.WriteFile(path, slowStartFile(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Optimize(context.Background(), path); err != nil {
+		t.Fatalf("Optimize failed: %v", err)
+	}
+	optimized, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Media that already follows moov must stay where it is, with or without verification
+	for _, verify := range []bool{true, false} {
+		if _, err := OptimizeWithOptions(context.Background(), Options{InputPath: path, Verify: verify}); err != nil {
+			t.Fatalf("Re-optimizing with verify=%v failed: %v", verify, err)
+		}
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, optimized) {
+			t.Errorf("Re-optimizing with verify=%v changed the fast-start file", verify)
+		}
+	}
+	if _, err := VerifySamples(context.Background(), path, path); err != nil {
+		t.Errorf("Re-optimized file does not resolve its samples: %v", err)
+	}
+}
+
+func TestRelocateMoovUpgradesToCo64(t *testing.T) {
+	// The first chunk is close enough to 4 GiB that moving moov in front overflows stco
+	near := uint32(math.MaxUint32 - 64)
+	moovBuf := moovWithStco(stcoBox(100, near))
+
+	boxes, err := atomic.ParseTree(bytes.NewReader(moovBuf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	moov := boxes[0]
+	if err := moov.Load(bytes.NewReader(moovBuf)); err != nil {
+		t.Fatal(err)
+	}
+	// moov sits at the end of the file, behind all of its media
+	moov.Offset = math.MaxUint32
+
+	displacement, err := RelocateMoov(moov, nil)
+	if err != nil {
+		t.Fatalf("RelocateMoov failed: %v", err)
+	}
+
+	out, err := moov.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if displacement != int64(len(out)) {
+		t.Errorf("Expected displacement %d to equal the new moov size %d", displacement, len(out))
+	}
+	if displacement != int64(len(moovBuf))+8 {
+		t.Errorf("Expected moov to grow by 8 bytes for two co64 entries, got %d -> %d", len(moovBuf), displacement)
+	}
+
+	reparsed, err := atomic.ParseTree(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	co64 := reparsed[0].FindAll("co64")
+	if len(co64) != 1 || len(reparsed[0].FindAll("stco")) != 0 {
+		t.Fatalf("Expected stco to be upgraded to co64")
+	}
+	body := out[co64[0].BodyOffset():co64[0].End()]
+	if got := binary.BigEndian.Uint64(body[8:16]); got != 100+uint64(displacement) {
+		t.Errorf("First entry = %d, want %d", got, 100+displacement)
+	}
+	if got := binary.BigEndian.Uint64(body[16:24]); got != uint64(near)+uint64(displacement) {
+		t.Errorf("Second entry = %d, want %d", got, uint64(near)+uint64(displacement))
+	}
+}
+
+func TestOptimizeWithOptionsCopy(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "slow.mp4")
+	original := slowStartFile()
+	if err := os.WriteFile(in, original, 0644); err != nil {
+		t.Fatal(err)
+	}
+	outDir := filepath.Join(dir, "out")
+
+	out, err := OptimizeWithOptions(context.Background(), Options{InputPath: in, OutputDir: outDir})
+	if err != nil {
+		t.Fatalf("OptimizeWithOptions failed: %v", err)
+	}
+	if out != filepath.Join(outDir, "slow.mp4") {
+		t.Errorf("Unexpected output path %s", out)
+	}
+	if got, _ := os.ReadFile(in); !bytes.Equal(got, original) {
+		t.Errorf("Expected the input to be left untouched")
+	}
+
+	if _, err := OptimizeWithOptions(context.Background(), Options{InputPath: in, OutputDir: outDir}); !errors.Is(err, ErrOutputExists) {
+		t.Errorf("Expected ErrOutputExists, got %v", err)
+	}
+
+	renamed, err := OptimizeWithOptions(context.Background(), Options{InputPath: in, OutputDir: outDir, Overwrite: OverwriteRename})
+	if err != nil {
+		t.Fatalf("OptimizeWithOptions with rename failed: %v", err)
+	}
+	if renamed != filepath.Join(outDir, "slow (1).mp4") {
+		t.Errorf("Unexpected renamed output %s", renamed)
+	}
+}
+
+func TestOptimizeRejectsFragmented(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "frag.mp4")
+	// [ftyp][mdat][moov+mvex][moof]: moov trails the media, but moving it would not help
+	data := bytes.Join([][]byte{
+		box("ftyp", []byte("iso6"), u32s(0)),
+		box("mdat", make([]byte, 8)),
+		box("moov", box("mvex", box("trex", u32s(0, 1, 1, 0, 0, 0)))),
+		box("moof", box("mfhd", u32s(0, 1))),
+	}, nil)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Optimize(context.Background(), path); !errors.Is(err, ErrFragmented) {
+		t.Errorf("Expected ErrFragmented, got %v", err)
+	}
+	if got, _ := os.ReadFile(path); !bytes.Equal(got, data) {
+		t.Errorf("Expected the file to be left untouched")
+	}
+}
+
+func TestOptimizeBackupMode(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "slow.mp4")
+	original := slowStartFile()
+	if err := os.WriteFile(path, original, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OptimizeWithOptions(context.Background(), Options{InputPath: path, Safety: SafetyBackup, KeepBackupDays: 7}); err != nil {
+		t.Fatalf("OptimizeWithOptions failed: %v", err)
+	}
+	if got, _ := os.ReadFile(BackupPath(path)); !bytes.Equal(got, original) {
+		t.Fatalf("Expected the backup to hold the original bytes")
+	}
+	if err := VerifyRewrite(BackupPath(path), path); err != nil {
+		t.Errorf("VerifyRewrite failed on a good rewrite: %v", err)
+	}
+
+	if err := RestoreBackup(path); err != nil {
+		t.Fatalf("RestoreBackup failed: %v", err)
+	}
+	if got, _ := os.ReadFile(path); !bytes.Equal(got, original) {
+		t.Errorf("Expected the original to be restored")
+	}
+	if err := RestoreBackup(path); !errors.Is(err, ErrNoBackup) {
+		t.Errorf("Expected ErrNoBackup, got %v", err)
+	}
+
+	// Without retention the verified backup is removed
+	if _, err := OptimizeWithOptions(context.Background(), Options{InputPath: path, Safety: SafetyBackup}); err != nil {
+		t.Fatalf("OptimizeWithOptions failed: %v", err)
+	}
+	if _, err := os.Stat(BackupPath(path)); !os.IsNotExist(err) {
+		t.Errorf("Expected the backup to be deleted after verification")
+	}
+}
+
+func TestPruneBackupsOnlyRemovesRecordedBackups(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "slow.mp4")
+	if err := os.WriteFile(path, slowStartFile(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A backup the user made by hand, long before
+	userFile := filepath.Join(dir, "clip.mp4")
+	for _, p := range []string{userFile, BackupPath(userFile)} {
+		if err := os.WriteFile(p, []byte("mine"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().AddDate(0, 0, -30)
+	os.Chtimes(BackupPath(userFile), old, old)
+
+	if _, err := OptimizeWithOptions(context.Background(), Options{InputPath: path, Safety: SafetyBackup, KeepBackupDays: 7}); err != nil {
+		t.Fatalf("OptimizeWithOptions failed: %v", err)
+	}
+	if !IsBackupFile(BackupPath(path)) || IsBackupFile(BackupPath(userFile)) {
+		t.Fatalf("Expected only the optimizer's backup to be recognized")
+	}
+
+	// Zero days expires every recorded backup
+	removed, err := PruneBackups(dir, 0)
+	if err != nil || removed != 1 {
+		t.Fatalf("Expected 1 backup to be pruned, got %d %v", removed, err)
+	}
+	if _, err := os.Stat(BackupPath(path)); !os.IsNotExist(err) {
+		t.Errorf("Expected the optimizer's backup to be pruned")
+	}
+	if _, err := os.Stat(BackupPath(userFile)); err != nil {
+		t.Errorf("Expected the user's backup to survive: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, backupManifest)); !os.IsNotExist(err) {
+		t.Errorf("Expected the empty manifest to be removed")
+	}
+}
+
+func TestVerifyRewriteDetectsCorruption(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "slow.mp4")
+	if err := os.WriteFile(in, slowStartFile(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := OptimizeWithOptions(context.Background(), Options{InputPath: in, OutputDir: filepath.Join(dir, "out")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := os.ReadFile(out)
+	data[len(data)-1] ^= 0xFF
+	os.WriteFile(out, data, 0644)
+
+	if err := VerifyRewrite(in, out); !errors.Is(err, ErrVerifyFailed) {
+		t.Errorf("Expected ErrVerifyFailed, got %v", err)
+	}
+}
+
+func TestVerifySamples(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "slow.mp4")
+	if err := os.WriteFile(in, slowStartFile(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := OptimizeWithOptions(context.Background(), Options{InputPath: in, OutputDir: filepath.Join(dir, "out"), Verify: true})
+	if err != nil {
+		t.Fatalf("OptimizeWithOptions with Verify failed: %v", err)
+	}
+
+	digests, err := VerifySamples(context.Background(), in, out)
+	if err != nil {
+		t.Fatalf("VerifySamples failed: %v", err)
+	}
+	if len(digests) != 1 || digests[0].TrackID != 1 || digests[0].Samples != 2 {
+		t.Errorf("Unexpected digests %+v", digests)
+	}
+
+	// Corrupt the second sample, the last bytes of mdat
+	data, _ := os.ReadFile(out)
+	data[len(data)-1] ^= 0xFF
+	os.WriteFile(out, data, 0644)
+
+	_, err = VerifySamples(context.Background(), in, out)
+	var mismatch *SampleMismatchError
+	if !errors.As(err, &mismatch) || !errors.Is(err, ErrVerifyFailed) {
+		t.Fatalf("Expected SampleMismatchError, got %v", err)
+	}
+	if mismatch.TrackID != 1 || mismatch.Sample != 2 {
+		t.Errorf("Expected mismatch at track 1 sample 2, got track %d sample %d", mismatch.TrackID, mismatch.Sample)
+	}
+}
+
+func TestVerifySamplesRejectsOversizedSamples(t *testing.T) {
+	dir := t.TempDir()
+	data := slowStartFile()
+	// Claim a 2 GiB first sample in both files
+	stsz := bytes.Index(data, []byte("stsz"))
+	binary.BigEndian.PutUint32(data[stsz+16:], 0x7FFFFFFF)
+	in := filepath.Join(dir, "in.mp4")
+	out := filepath.Join(dir, "out.mp4")
+	for _, path := range []string{in, out} {
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := VerifySamples(context.Background(), in, out); err == nil || !strings.Contains(err.Error(), "outside the file") {
+		t.Errorf("Expected the oversized sample to be rejected, got %v", err)
+	}
+}
+
+func TestOptimizeCanceled(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "slow.mp4")
+	original := slowStartFile()
+	if err := os.WriteFile(path, original, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := Optimize(ctx, path)
+	var canceled *CanceledError
+	if !errors.As(err, &canceled) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected CanceledError, got %v", err)
+	}
+	if got, _ := os.ReadFile(path); !bytes.Equal(got, original) {
+		t.Errorf("Expected the original to be untouched")
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("Expected the temp file to be removed, found %d entries", len(entries))
+	}
+}
+
+// fragmentedFile builds [ftyp][moov+mvex][moof][mdat][moof][mdat] with two tracks.
+// Each fragment carries two samples of track 1 followed by one sample of track 2.
+func fragmentedFile() []byte {
+	ftyp := box("ftyp", []byte("iso6"), u32s(0), []byte("iso6"))
+	mvhd := append(u32s(0, 0, 0, 1000, 0), make([]byte, 80)...)
+	moov := box("moov", box("mvhd", mvhd),
+		trakBox(1, stcoBox(), nil, 1),
+		trakBox(2, stcoBox(), nil, 1),
+		// Track 1 samples are non-sync unless flagged otherwise
+		box("mvex", box("trex", u32s(0, 1, 1, 40, 0, 0x10000)), box("trex", u32s(0, 2, 1, 20, 0, 0))),
+	)
+
+	parts := [][]byte{ftyp, moov}
+	for seq := uint32(1); seq <= 2; seq++ {
+		moof := func(dataOffset uint32) []byte {
+			return box("moof", box("mfhd", u32s(0, seq)),
+				// default-base-is-moof; data offset, sync first sample and sizes 3 and 4
+				box("traf", box("tfhd", u32s(0x020000, 1)), box("trun", u32s(0x205, 2, dataOffset, 0, 3, 4))),
+				box("traf", box("tfhd", u32s(0x020000, 2)), box("trun", u32s(0x201, 1, dataOffset+7, 5))),
+			)
+		}
+		size := uint32(len(moof(0)))
+		b := byte(seq << 4)
+		mdat := box("mdat", bytes.Repeat([]byte{b | 1}, 3), bytes.Repeat([]byte{b | 2}, 4), bytes.Repeat([]byte{b | 3}, 5))
+		parts = append(parts, moof(size+8), mdat)
+	}
+	return bytes.Join(parts, nil)
+}
+
+func TestDefragment(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "frag.mp4")
+	if err := os.WriteFile(in, fragmentedFile(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "progressive.mp4")
+
+	if _, err := Defragment(context.Background(), Options{InputPath: in, OutputPath: out, Verify: true}); err != nil {
+		t.Fatalf("Defragment failed: %v", err)
+	}
+
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	boxes, err := atomic.ParseTree(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for _, b := range boxes {
+		types = append(types, b.Type)
+	}
+	if len(types) != 3 || types[0] != "ftyp" || types[1] != "moov" || types[2] != "mdat" {
+		t.Fatalf("Expected [ftyp moov mdat], got %v", types)
+	}
+	if boxes[1].Child("mvex") != nil {
+		t.Errorf("Expected mvex to be removed")
+	}
+
+	// The mdat keeps the interleaving of the fragments
+	want := bytes.Join([][]byte{
+		bytes.Repeat([]byte{0x11}, 3), bytes.Repeat([]byte{0x12}, 4), bytes.Repeat([]byte{0x13}, 5),
+		bytes.Repeat([]byte{0x21}, 3), bytes.Repeat([]byte{0x22}, 4), bytes.Repeat([]byte{0x23}, 5),
+	}, nil)
+	data, _ := os.ReadFile(out)
+	if got := data[boxes[2].BodyOffset():boxes[2].End()]; !bytes.Equal(got, want) {
+		t.Errorf("Unexpected mdat payload %x", got)
+	}
+
+	tracks, err := atomic.ReadTracks(f, boxes[1])
+	if err != nil {
+		t.Fatalf("ReadTracks failed: %v", err)
+	}
+	if len(tracks) != 2 || len(tracks[0].Samples) != 4 || len(tracks[1].Samples) != 2 {
+		t.Fatalf("Unexpected tracks %+v", tracks)
+	}
+	for i, s := range tracks[0].Samples {
+		if s.Sync != (i%2 == 0) || s.DecodeTime != uint64(i*40) {
+			t.Errorf("Track 1 sample %d = %+v", i+1, s)
+		}
+	}
+	if tracks[0].Duration != 160 || tracks[1].Duration != 40 {
+		t.Errorf("Expected media durations 160 and 40, got %d and %d", tracks[0].Duration, tracks[1].Duration)
+	}
+
+	// Backup mode cannot compare boxes one to one and must verify by sample instead
+	if _, err := Defragment(context.Background(), Options{InputPath: in, Safety: SafetyBackup}); err != nil {
+		t.Fatalf("Defragment in place failed: %v", err)
+	}
+	if _, err := os.Stat(BackupPath(in)); !os.IsNotExist(err) {
+		t.Errorf("Expected the verified backup to be removed")
+	}
+
+	slow := filepath.Join(dir, "slow.mp4")
+	os.WriteFile(slow, slowStartFile(), 0644)
+	if _, err := Defragment(context.Background(), Options{InputPath: slow}); !errors.Is(err, ErrNotFragmented) {
+		t.Errorf("Expected ErrNotFragmented, got %v", err)
+	}
+}
+
+// progressiveFile builds [ftyp][moov][mdat] with a video track of 6 one-second
+// samples (sync at 1 and 4) and an audio track of 4 samples of 1.5s, interleaved
+// in four chunks.
+func progressiveFile() []byte {
+	trak := func(id uint32, handler string, stbl []byte) []byte {
+		tkhd := make([]byte, 84)
+		binary.BigEndian.PutUint32(tkhd[12:16], id)
+		hdlr := make([]byte, 24)
+		copy(hdlr[8:12], handler)
+		return box("trak", box("tkhd", tkhd), box("mdia",
+			box("mdhd", u32s(0, 0, 0, 1000, 6000, 0)), box("hdlr", hdlr), box("minf", stbl)))
+	}
+	ftyp := box("ftyp", []byte("isom"), u32s(0x200), []byte("isom"))
+	build := func(mdatStart uint32) []byte {
+		video := box("stbl",
+			box("stsd", u32s(0, 0)),
+			box("stts", u32s(0, 1, 6, 1000)),
+			box("stss", u32s(0, 2, 1, 4)),
+			box("stsc", u32s(0, 1, 1, 3, 1)),
+			box("stsz", u32s(0, 10, 6)),
+			stcoBox(mdatStart, mdatStart+40),
+		)
+		audio := box("stbl",
+			box("stsd", u32s(0, 0)),
+			box("stts", u32s(0, 1, 4, 1500)),
+			box("stsc", u32s(0, 1, 1, 2, 1)),
+			box("stsz", u32s(0, 5, 4)),
+			stcoBox(mdatStart+30, mdatStart+70),
+		)
+		mvhd := append(u32s(0, 0, 0, 1000, 6000), make([]byte, 80)...)
+		return box("moov", box("mvhd", mvhd), trak(1, "vide", video), trak(2, "soun", audio))
+	}
+	moovSize := uint32(len(build(0)))
+	var payload []byte
+	for chunk, n := range []int{30, 10, 30, 10} {
+		payload = append(payload, bytes.Repeat([]byte{byte(chunk + 1)}, n)...)
+	}
+	return bytes.Join([][]byte{ftyp, build(uint32(len(ftyp)) + moovSize + 8), box("mdat", payload)}, nil)
+}
+
+func TestFragmentRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "progressive.mp4")
+	if err := os.WriteFile(in, progressiveFile(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	frag := filepath.Join(dir, "frag.mp4")
+
+	_, err := Fragment(context.Background(), FragmentOptions{
+		Options:          Options{InputPath: in, OutputPath: frag, Verify: true},
+		FragmentDuration: 2 * time.Second,
+		Sidx:             true,
+		Mfra:             true,
+	})
+	if err != nil {
+		t.Fatalf("Fragment failed: %v", err)
+	}
+
+	f, err := os.Open(frag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	boxes, err := atomic.ParseTree(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for _, b := range boxes {
+		types = append(types, b.Type)
+	}
+	want := []string{"ftyp", "moov", "sidx", "moof", "mdat", "moof", "mdat", "mfra"}
+	if strings.Join(types, " ") != strings.Join(want, " ") {
+		t.Fatalf("Expected %v, got %v", want, types)
+	}
+	if boxes[1].Find("mvex", "trex") == nil {
+		t.Errorf("Expected mvex/trex in the init segment")
+	}
+
+	// Fragments are cut at the second sync sample, with audio following the video times
+	fragments, err := atomic.ReadFragments(f, boxes, nil)
+	if err != nil {
+		t.Fatalf("ReadFragments failed: %v", err)
+	}
+	for i, fr := range fragments {
+		if len(fr.Tracks) != 2 || len(fr.Tracks[0].Samples) != 3 || len(fr.Tracks[1].Samples) != 2 {
+			t.Fatalf("Fragment %d: unexpected track fragments", i+1)
+		}
+		if !fr.Tracks[0].Samples[0].Sync || fr.Tracks[0].Samples[1].Sync {
+			t.Errorf("Fragment %d: unexpected sync flags", i+1)
+		}
+	}
+	if fragments[1].Tracks[0].BaseDecodeTime != 3000 || fragments[1].Tracks[1].BaseDecodeTime != 3000 {
+		t.Errorf("Expected the second fragment to start at 3s")
+	}
+
+	// Video (track 1) is the first traf of each moof and audio (track 2) the second
+	for _, tfra := range boxes[7].ChildrenOfType("tfra") {
+		body, err := tfra.ReadBody(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, count := binary.BigEndian.Uint32(body[4:8]), binary.BigEndian.Uint32(body[12:16])
+		for i := range count {
+			// Time(8) MoofOffset(8) TrafNumber(1) TrunNumber(1) SampleNumber(1)
+			if traf := body[16+i*19+16]; uint32(traf) != id {
+				t.Errorf("Track %d: tfra entry %d points at traf %d", id, i+1, traf)
+			}
+		}
+	}
+
+	// Merging the fragments back must restore the same media
+	back := filepath.Join(dir, "back.mp4")
+	if _, err := Defragment(context.Background(), Options{InputPath: frag, OutputPath: back}); err != nil {
+		t.Fatalf("Defragment failed: %v", err)
+	}
+	if _, err := VerifySamples(context.Background(), in, back); err != nil {
+		t.Errorf("Round trip changed the media: %v", err)
+	}
+
+	if _, err := Fragment(context.Background(), FragmentOptions{Options: Options{InputPath: frag, OutputDir: filepath.Join(dir, "again")}}); !errors.Is(err, ErrFragmented) {
+		t.Errorf("Expected ErrFragmented, got %v", err)
+	}
+}
+
+func TestFastStartView(t *testing.T) {
+	dir := t.TempDir()
+	src := slowStartFile()
+	in := filepath.Join(dir, "slow.mp4")
+	if err := os.WriteFile(in, src, 0644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "fast.mp4")
+	if _, err := OptimizeWithOptions(context.Background(), Options{InputPath: in, OutputPath: out}); err != nil {
+		t.Fatalf("OptimizeWithOptions failed: %v", err)
+	}
+	want, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	view, err := NewFastStartView(bytes.NewReader(src), int64(len(src)))
+	if err != nil {
+		t.Fatalf("NewFastStartView failed: %v", err)
+	}
+	if !view.Rewritten() || view.Size() != int64(len(want)) {
+		t.Fatalf("Expected a rewritten view of %d bytes, got %d", len(want), view.Size())
+	}
+	got, err := io.ReadAll(view)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("View differs from the optimized file")
+	}
+
+	// Ranges that straddle the in-memory moov and the source
+	for _, off := range []int64{0, 20, int64(len(want)) - 40} {
+		if _, err := view.Seek(off, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 30)
+		if _, err := io.ReadFull(view, buf); err != nil {
+			t.Fatalf("Read at %d failed: %v", off, err)
+		}
+		if !bytes.Equal(buf, want[off:off+30]) {
+			t.Errorf("Read at %d differs", off)
+		}
+	}
+
+	// Already fast-start files are presented as they are
+	view, err = NewFastStartView(bytes.NewReader(want), int64(len(want)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(view); view.Rewritten() || !bytes.Equal(got, want) {
+		t.Errorf("Expected a fast-start file to be presented unchanged")
+	}
+}
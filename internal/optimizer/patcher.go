@@ -1,61 +1,41 @@
 package optimizer
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
+	"math"
+
+	"mp4-optimizer/pkg/atomic"
 )
 
 // PatchMoov updates the chunk offsets in the moov atom by the given displacement.
-// It searches for 'stco' and 'co64' boxes and adjusts their values.
+// It walks the box tree of moov and adjusts every 'stco' and 'co64' box in place.
+// In-place patching cannot grow boxes, so an overflowing 'stco' is an error here;
+// use RelocateMoov to upgrade such tables to 'co64'.
 func PatchMoov(moov []byte, displacement int64) error {
-	// We scan the moov byte slice for "stco" and "co64"
-	// This is a heuristic scan, but safe enough if we validate box sizes.
-
-	// A strictly correct parser would traverse the tree.
-	// Given we have the whole moov, we can just search for the 4-byte tags.
-	// But we must ensure 4-byte alignment relative to the box structure or at least check valid sizes.
-
-	// Quick implementation: scan every byte?
-	// To be safer and faster: parse the tree.
-	// But implementing a full tree parser is heavy.
-	// Let's do a linear scan but verify box structure.
-
 	if displacement == 0 {
 		return nil
 	}
 
-	for i := 0; i < len(moov)-8; i++ {
-		// Look for boxes
-		// Size (4), Type (4)
-		// We only care about stco and co64
+	// Offsets in the parsed tree are relative to the start of the moov buffer
+	boxes, err := atomic.ParseTree(bytes.NewReader(moov))
+	if err != nil {
+		return fmt.Errorf("parse moov: %w", err)
+	}
+	root := atomic.FindBox(boxes, "moov")
+	if root == nil {
+		return fmt.Errorf("buffer does not start with a moov box")
+	}
 
-		// Check for stco
-		if string(moov[i+4:i+8]) == "stco" {
-			size := binary.BigEndian.Uint32(moov[i : i+4])
-			// Verify size fits in buffer
-			if int(size) > len(moov)-i {
-				continue // False positive or truncated
-			}
-			if err := patchStco(moov[i:i+int(size)], displacement); err != nil {
-				return err
-			}
-			// Skip this box
-			i += int(size) - 1
-			continue
+	for _, box := range root.FindAll("stco") {
+		if err := patchStco(moov[box.Offset:box.End()], displacement); err != nil {
+			return err
 		}
-
-		// Check for co64
-		if string(moov[i+4:i+8]) == "co64" {
-			size := binary.BigEndian.Uint32(moov[i : i+4])
-			// Verify size fits in buffer
-			if int(size) > len(moov)-i {
-				continue
-			}
-			if err := patchCo64(moov[i:i+int(size)], displacement); err != nil {
-				return err
-			}
-			i += int(size) - 1
-			continue
+	}
+	for _, box := range root.FindAll("co64") {
+		if err := patchCo64(moov[box.Offset:box.End()], displacement); err != nil {
+			return err
 		}
 	}
 	return nil
@@ -106,3 +86,131 @@ func patchCo64(box []byte, displacement int64) error {
 	}
 	return nil
 }
+
+// chunkOffsetTable is a parsed 'stco' or 'co64' box.
+type chunkOffsetTable struct {
+	box     *atomic.Box
+	header  []byte // version(1) + flags(3)
+	offsets []uint64
+}
+
+// RelocateMoov patches the chunk offsets of a loaded moov tree for the layout
+// OptimizeWithOptions writes: ftyp, moov, then every other top-level box in its
+// original order. ftyp may be nil. moov.Offset and moov.Size must still describe
+// the original position, so each offset can be shifted by how far the data it
+// points into moves: media that preceded moov moves by the size of the new moov,
+// media that already followed it only by the change in size. An 'stco' whose
+// offsets would no longer fit in 32 bits is upgraded to 'co64'. Since that grows
+// moov and therefore the shift, the upgrade is repeated until it converges.
+// It returns the new size of moov.
+func RelocateMoov(moov, ftyp *atomic.Box) (int64, error) {
+	var tables []*chunkOffsetTable
+	for _, typ := range []string{"stco", "co64"} {
+		for _, box := range moov.FindAll(typ) {
+			t, err := readChunkOffsets(box)
+			if err != nil {
+				return 0, err
+			}
+			tables = append(tables, t)
+		}
+	}
+
+	// The shift of an offset is the new moov size plus a constant that only
+	// depends on where the offset lies relative to the original ftyp and moov
+	oldEnd, oldSize := moov.End(), moov.Size
+	base := func(off uint64) int64 {
+		var b int64
+		if ftyp != nil && off < uint64(ftyp.Offset) {
+			// ftyp is written first, ahead of data that used to precede it
+			b += ftyp.Size
+		}
+		if off >= uint64(oldEnd) {
+			// Data behind moov already sat past the old moov
+			b -= oldSize
+		}
+		return b
+	}
+	relocate := func(off uint64, size int64) uint64 {
+		return uint64(int64(off) + base(off) + size)
+	}
+
+	size := moov.ComputeSize()
+	for {
+		upgraded := false
+		for _, t := range tables {
+			if t.box.Type != "stco" {
+				continue
+			}
+			for _, off := range t.offsets {
+				if relocate(off, size) > math.MaxUint32 {
+					t.box.Type = "co64"
+					t.box.SetData(encodeChunkOffsets(t, nil))
+					upgraded = true
+					break
+				}
+			}
+		}
+		if !upgraded {
+			break
+		}
+		// Upgraded tables are larger, which pushes the media further out
+		size = moov.ComputeSize()
+	}
+
+	for _, t := range tables {
+		t.box.SetData(encodeChunkOffsets(t, func(off uint64) uint64 { return relocate(off, size) }))
+	}
+	return size, nil
+}
+
+func readChunkOffsets(box *atomic.Box) (*chunkOffsetTable, error) {
+	// Body: Version(1) Flags(3) Count(4) Entries(4 or 8 each)
+	data := box.Data
+	if len(data) < 8 {
+		return nil, fmt.Errorf("%s box too small", box.Type)
+	}
+	count := int(binary.BigEndian.Uint32(data[4:8]))
+	entrySize := 4
+	if box.Type == "co64" {
+		entrySize = 8
+	}
+	if len(data) < 8+count*entrySize {
+		return nil, fmt.Errorf("%s box truncated", box.Type)
+	}
+
+	t := &chunkOffsetTable{box: box, header: data[0:4], offsets: make([]uint64, count)}
+	for i := range t.offsets {
+		pos := 8 + i*entrySize
+		if entrySize == 8 {
+			t.offsets[i] = binary.BigEndian.Uint64(data[pos : pos+8])
+		} else {
+			t.offsets[i] = uint64(binary.BigEndian.Uint32(data[pos : pos+4]))
+		}
+	}
+	return t, nil
+}
+
+// encodeChunkOffsets serializes the table with every offset passed through
+// relocate, or unchanged when relocate is nil.
+func encodeChunkOffsets(t *chunkOffsetTable, relocate func(uint64) uint64) []byte {
+	entrySize := 4
+	if t.box.Type == "co64" {
+		entrySize = 8
+	}
+	data := make([]byte, 8+len(t.offsets)*entrySize)
+	copy(data[0:4], t.header)
+	binary.BigEndian.PutUint32(data[4:8], uint32(len(t.offsets)))
+	for i, off := range t.offsets {
+		pos := 8 + i*entrySize
+		val := off
+		if relocate != nil {
+			val = relocate(off)
+		}
+		if entrySize == 8 {
+			binary.BigEndian.PutUint64(data[pos:pos+8], val)
+		} else {
+			binary.BigEndian.PutUint32(data[pos:pos+4], uint32(val))
+		}
+	}
+	return data
+}
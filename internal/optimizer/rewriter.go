@@ -1,10 +1,13 @@
 package optimizer
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"mp4-optimizer/pkg/atomic"
 )
@@ -12,87 +15,128 @@ import (
 // ProgressCallback is a function type for progress updates
 type ProgressCallback func(progress float64, message string)
 
-// Optimize rearranges the MP4 atoms to move 'moov' to the front.
-func Optimize(path string, callback ...ProgressCallback) error {
-	var progressFn ProgressCallback
-	if len(callback) > 0 && callback[0] != nil {
-		progressFn = callback[0]
+// OverwritePolicy controls what happens when the output file of a copy already exists.
+type OverwritePolicy string
+
+const (
+	// OverwriteFail aborts with ErrOutputExists. This is the default.
+	OverwriteFail OverwritePolicy = "fail"
+	// OverwriteReplace replaces the existing file.
+	OverwriteReplace OverwritePolicy = "replace"
+	// OverwriteRename picks a free name such as "clip (1).mp4".
+	OverwriteRename OverwritePolicy = "rename"
+)
+
+// ErrOutputExists is returned when the output file exists and the policy is OverwriteFail.
+var ErrOutputExists = errors.New("output file already exists")
+
+// ErrFragmented is returned for fragmented MP4 files. Their media is addressed
+// relative to each moof, so moving moov would not make them stream any better.
+var ErrFragmented = errors.New("fragmented MP4 is not supported")
+
+// Options configures an optimization run.
+// With neither OutputPath nor OutputDir set, the input file is replaced in place.
+type Options struct {
+	InputPath string
+	// OutputPath is the exact file to write. It takes precedence over OutputDir.
+	OutputPath string
+	// OutputDir receives a file with the same name as the input.
+	OutputDir string
+	Overwrite OverwritePolicy
+	// Safety selects temp-file replacement or the .bak backup-and-verify workflow
+	Safety SafetyMode
+	// KeepBackupDays retains verified backups for this many days; 0 deletes them right away
+	KeepBackupDays int
+	// Verify checks sample by sample that the new file resolves to identical media bytes
+	Verify   bool
+	Progress ProgressCallback
+}
+
+// Optimize rearranges the MP4 atoms to move 'moov' to the front, replacing the file in place.
+// Cancelling ctx stops the copy, removes the temp file and returns a *CanceledError.
+func Optimize(ctx context.Context, path string, callback ...ProgressCallback) error {
+	opts := Options{InputPath: path}
+	if len(callback) > 0 {
+		opts.Progress = callback[0]
 	}
+	_, err := OptimizeWithOptions(ctx, opts)
+	return err
+}
 
+// OptimizeWithOptions rearranges the MP4 atoms to move 'moov' to the front and writes
+// the result to the configured output. The input is only modified for in-place runs.
+// It returns the path of the written file.
+func OptimizeWithOptions(ctx context.Context, opts Options) (string, error) {
+	progressFn := opts.Progress
 	reportProgress := func(p float64, msg string) {
 		if progressFn != nil {
 			progressFn(p, msg)
 		}
 	}
 
+	path := opts.InputPath
+	outPath, err := resolveOutputPath(opts)
+	if err != nil {
+		return "", err
+	}
+
 	reportProgress(0, "开始处理...")
 
-	// 1. Open original file for reading
+	// 1. Open original file for reading (read-only, the source may live on an archive volume)
 	in, err := os.Open(path)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer in.Close()
 
 	reportProgress(10, "解析文件结构...")
 
-	// 2. Parse atoms to find moov and its size
-	atoms, err := atomic.FindAtoms(in)
+	// 2. Parse the box tree to find moov and its size
+	boxes, err := atomic.ParseTree(in)
 	if err != nil {
-		return fmt.Errorf("failed to parse atoms: %w", err)
+		return "", fmt.Errorf("failed to parse atoms: %w", err)
 	}
 
-	var moovAtom atomic.Atom
-	var ftypAtom atomic.Atom
-	foundMoov := false
-	foundFtyp := false
-
-	for _, a := range atoms {
-		if a.Type == "moov" {
-			moovAtom = a
-			foundMoov = true
-		}
-		if a.Type == "ftyp" {
-			ftypAtom = a
-			foundFtyp = true
-		}
+	moovBox := atomic.FindBox(boxes, "moov")
+	ftypBox := atomic.FindBox(boxes, "ftyp")
+	if moovBox == nil {
+		return "", fmt.Errorf("no moov atom found")
 	}
-
-	if !foundMoov {
-		return fmt.Errorf("no moov atom found")
+	if atomic.FindBox(boxes, "moof") != nil || moovBox.Child("mvex") != nil {
+		return "", ErrFragmented
 	}
 
 	reportProgress(20, "读取元数据...")
 
 	// 3. Read the whole moov into memory
-	if _, err := in.Seek(moovAtom.Offset, io.SeekStart); err != nil {
-		return err
-	}
-	moovBuf := make([]byte, moovAtom.Size)
-	if _, err := io.ReadFull(in, moovBuf); err != nil {
-		return err
+	if err := moovBox.Load(in); err != nil {
+		return "", err
 	}
 
 	reportProgress(30, "处理元数据...")
 
-	displacement := moovAtom.Size
-
-	// 4. Parse/Patch moov
-	if err := PatchMoov(moovBuf, displacement); err != nil {
-		return fmt.Errorf("failed to patch moov: %w", err)
+	// 4. Patch chunk offsets, upgrading stco to co64 where the move overflows 32 bits.
+	// Media before the old moov position shifts by the size of the new moov, media
+	// that already followed it (a fast-start input) only by the change in size.
+	if _, err := RelocateMoov(moovBox, ftypBox); err != nil {
+		return "", fmt.Errorf("failed to patch moov: %w", err)
+	}
+	moovBuf, err := moovBox.Bytes()
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize moov: %w", err)
 	}
 
 	reportProgress(40, "创建临时文件...")
 
-	// 5. Create temporary file in the same directory
-	dir := filepath.Dir(path)
-	ext := filepath.Ext(path)
-	base := filepath.Base(path)
+	// 5. Create temporary file next to the output so the final rename stays on one volume
+	dir := filepath.Dir(outPath)
+	ext := filepath.Ext(outPath)
+	base := filepath.Base(outPath)
 	nameWithoutExt := base[:len(base)-len(ext)]
 
 	tmpFile, err := os.CreateTemp(dir, nameWithoutExt+"_tmp_*"+ext)
 	if err != nil {
-		return fmt.Errorf("failed to create temp file: %w", err)
+		return "", fmt.Errorf("failed to create temp file: %w", err)
 	}
 	tmpPath := tmpFile.Name()
 
@@ -108,12 +152,12 @@ func Optimize(path string, callback ...ProgressCallback) error {
 
 	// 6. Writing to temp file
 	// 1. Write ftyp
-	if foundFtyp {
-		if _, err := in.Seek(ftypAtom.Offset, io.SeekStart); err != nil {
-			return err
+	if ftypBox != nil {
+		if _, err := in.Seek(ftypBox.Offset, io.SeekStart); err != nil {
+			return "", err
 		}
-		if _, err := io.CopyN(tmpFile, in, ftypAtom.Size); err != nil {
-			return err
+		if err := copyContext(ctx, path, tmpFile, in, ftypBox.Size); err != nil {
+			return "", err
 		}
 	}
 
@@ -121,33 +165,26 @@ func Optimize(path string, callback ...ProgressCallback) error {
 
 	// 2. Write patched moov
 	if _, err := tmpFile.Write(moovBuf); err != nil {
-		return err
+		return "", err
 	}
 
 	reportProgress(70, "写入视频数据...")
 
 	// 3. Write others (mdat, etc), skipping ftyp and moov
-	totalAtoms := len(atoms)
+	totalAtoms := len(boxes)
 	processedAtoms := 0
-	for _, a := range atoms {
+	for _, a := range boxes {
 		if a.Type == "ftyp" || a.Type == "moov" {
 			continue
 		}
 
 		if _, err := in.Seek(a.Offset, io.SeekStart); err != nil {
-			return err
+			return "", err
 		}
 
-		// Use CopyN or Copy depending on size
-		if a.Size > 0 {
-			if _, err := io.CopyN(tmpFile, in, a.Size); err != nil {
-				return err
-			}
-		} else {
-			// Size 0 means "until EOF"
-			if _, err := io.Copy(tmpFile, in); err != nil {
-				return err
-			}
+		// Size 0 ("until EOF") is already resolved to the real extent by the parser
+		if err := copyContext(ctx, path, tmpFile, in, a.Size); err != nil {
+			return "", err
 		}
 
 		processedAtoms++
@@ -157,21 +194,116 @@ func Optimize(path string, callback ...ProgressCallback) error {
 
 	// 7. Sync and close temp file
 	if err := tmpFile.Sync(); err != nil {
-		return err
+		return "", err
 	}
 	tmpFile.Close()
 
 	// 8. Close input file BEFORE rename (Windows locks open files, preventing rename)
 	in.Close()
 
+	// 9. Verify and atomically move the temp file into place
+	if err := commitOutput(ctx, opts, outPath, tmpPath, VerifyRewrite, reportProgress); err != nil {
+		return "", err
+	}
+	success = true
+	reportProgress(100, "完成！")
+	return outPath, nil
+}
+
+// commitOutput verifies a finished temp file and moves it into place according
+// to opts. verifyRewrite checks the written file against its source whenever the
+// backup workflow asks for it.
+func commitOutput(ctx context.Context, opts Options, outPath, tmpPath string, verifyRewrite func(orig, rewritten string) error, reportProgress func(float64, string)) error {
+	path := opts.InputPath
+	if opts.Verify {
+		// The original is still in place, so a mismatch leaves nothing to undo
+		reportProgress(90, "校验媒体数据...")
+		if _, err := VerifySamples(ctx, path, tmpPath); err != nil {
+			return err
+		}
+	}
+
+	// Last chance to back out before the original is touched
+	if err := checkCanceled(ctx, path); err != nil {
+		return err
+	}
+
 	reportProgress(95, "完成...")
 
-	// 9. Atomically replace original file with temp file
-	if err := os.Rename(tmpPath, path); err != nil {
+	// Atomically move the temp file into place
+	if outPath == path && opts.Safety == SafetyBackup {
+		return replaceWithBackup(path, tmpPath, opts.KeepBackupDays, verifyRewrite, reportProgress)
+	}
+	if outPath != path && opts.Overwrite != OverwriteReplace {
+		// The output may have appeared while we were writing
+		if _, err := os.Lstat(outPath); err == nil {
+			return fmt.Errorf("%w: %s", ErrOutputExists, outPath)
+		}
+	}
+	if err := os.Rename(tmpPath, outPath); err != nil {
 		return fmt.Errorf("failed to replace file: %w", err)
 	}
 
-	success = true
-	reportProgress(100, "完成！")
+	if outPath != path && opts.Safety == SafetyBackup {
+		// The original is untouched, so it serves as the reference
+		reportProgress(97, "校验文件...")
+		if err := verifyRewrite(path, outPath); err != nil {
+			os.Remove(outPath)
+			return err
+		}
+	}
 	return nil
 }
+
+// resolveOutputPath determines the file to write for the given options.
+func resolveOutputPath(opts Options) (string, error) {
+	if opts.InputPath == "" {
+		return "", fmt.Errorf("no input file given")
+	}
+	in, err := filepath.Abs(opts.InputPath)
+	if err != nil {
+		return "", err
+	}
+
+	out := opts.OutputPath
+	if out == "" && opts.OutputDir != "" {
+		if err := os.MkdirAll(opts.OutputDir, 0755); err != nil {
+			return "", fmt.Errorf("failed to create output directory: %w", err)
+		}
+		out = filepath.Join(opts.OutputDir, filepath.Base(in))
+	}
+	if out == "" {
+		return opts.InputPath, nil
+	}
+	out, err = filepath.Abs(out)
+	if err != nil {
+		return "", err
+	}
+	if out == in {
+		// Writing onto the input is an in-place run
+		return opts.InputPath, nil
+	}
+
+	if _, err := os.Stat(out); err == nil {
+		switch opts.Overwrite {
+		case OverwriteReplace:
+		case OverwriteRename:
+			return uniquePath(out), nil
+		default:
+			return "", fmt.Errorf("%w: %s", ErrOutputExists, out)
+		}
+	}
+	return out, nil
+}
+
+// uniquePath returns the first "name (n).ext" variant of path that does not exist.
+func uniquePath(path string) string {
+	ext := filepath.Ext(path)
+	stem := strings.TrimSuffix(path, ext)
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s (%d)%s", stem, i, ext)
+		if _, err := os.Stat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package optimizer
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"mp4-optimizer/pkg/atomic"
+)
+
+// ErrVerifyFailed is returned when an optimized file does not match its source.
+var ErrVerifyFailed = errors.New("verification failed")
+
+// VerifyRewrite checks that optimized carries exactly the media of original.
+// Structurally, both files must have the same top-level boxes apart from the
+// position of moov, and the same chunk offset tables per track. For content,
+// every non-moov box must hash identically and every chunk offset must point
+// at the same relative position inside the same box.
+func VerifyRewrite(originalPath, optimizedPath string) error {
+	orig, err := os.Open(originalPath)
+	if err != nil {
+		return err
+	}
+	defer orig.Close()
+
+	opt, err := os.Open(optimizedPath)
+	if err != nil {
+		return err
+	}
+	defer opt.Close()
+
+	origBoxes, err := atomic.ParseTree(orig)
+	if err != nil {
+		return fmt.Errorf("parse original: %w", err)
+	}
+	optBoxes, err := atomic.ParseTree(opt)
+	if err != nil {
+		return fmt.Errorf("%w: parse optimized: %v", ErrVerifyFailed, err)
+	}
+
+	origMedia := withoutType(origBoxes, "moov")
+	optMedia := withoutType(optBoxes, "moov")
+	if len(origMedia) != len(optMedia) {
+		return fmt.Errorf("%w: expected %d top-level boxes besides moov, found %d", ErrVerifyFailed, len(origMedia), len(optMedia))
+	}
+	for i := range origMedia {
+		a, b := origMedia[i], optMedia[i]
+		if a.Type != b.Type || a.BodySize() != b.BodySize() {
+			return fmt.Errorf("%w: box %d is '%s' (%d bytes), expected '%s' (%d bytes)",
+				ErrVerifyFailed, i, b.Type, b.BodySize(), a.Type, a.BodySize())
+		}
+	}
+
+	origMoov := atomic.FindBox(origBoxes, "moov")
+	optMoov := atomic.FindBox(optBoxes, "moov")
+	if origMoov == nil || optMoov == nil {
+		return fmt.Errorf("%w: moov missing", ErrVerifyFailed)
+	}
+
+	origOffsets, err := trackChunkOffsets(orig, origMoov)
+	if err != nil {
+		return fmt.Errorf("read original chunk offsets: %w", err)
+	}
+	optOffsets, err := trackChunkOffsets(opt, optMoov)
+	if err != nil {
+		return fmt.Errorf("%w: read chunk offsets: %v", ErrVerifyFailed, err)
+	}
+	if len(origOffsets) != len(optOffsets) {
+		return fmt.Errorf("%w: expected %d tracks, found %d", ErrVerifyFailed, len(origOffsets), len(optOffsets))
+	}
+	for t := range origOffsets {
+		if len(origOffsets[t]) != len(optOffsets[t]) {
+			return fmt.Errorf("%w: track %d has %d chunks, expected %d", ErrVerifyFailed, t+1, len(optOffsets[t]), len(origOffsets[t]))
+		}
+		for c := range origOffsets[t] {
+			ai, ad := locate(origMedia, origOffsets[t][c])
+			bi, bd := locate(optMedia, optOffsets[t][c])
+			if ai != bi || ad != bd {
+				return fmt.Errorf("%w: track %d chunk %d points to a different position", ErrVerifyFailed, t+1, c+1)
+			}
+		}
+	}
+
+	for i := range origMedia {
+		a, err := hashBox(orig, origMedia[i])
+		if err != nil {
+			return fmt.Errorf("hash original: %w", err)
+		}
+		b, err := hashBox(opt, optMedia[i])
+		if err != nil {
+			return fmt.Errorf("%w: hash '%s': %v", ErrVerifyFailed, optMedia[i].Type, err)
+		}
+		if !bytes.Equal(a, b) {
+			return fmt.Errorf("%w: content of '%s' box %d differs", ErrVerifyFailed, optMedia[i].Type, i)
+		}
+	}
+
+	return nil
+}
+
+func withoutType(boxes []*atomic.Box, typ string) []*atomic.Box {
+	var result []*atomic.Box
+	for _, b := range boxes {
+		if b.Type != typ {
+			result = append(result, b)
+		}
+	}
+	return result
+}
+
+// trackChunkOffsets returns the chunk offsets of every track in moov order.
+func trackChunkOffsets(rs io.ReadSeeker, moov *atomic.Box) ([][]uint64, error) {
+	var result [][]uint64
+	for _, trak := range moov.ChildrenOfType("trak") {
+		var offsets []uint64
+		for _, typ := range []string{"stco", "co64"} {
+			for _, box := range trak.FindAll(typ) {
+				if err := box.Load(rs); err != nil {
+					return nil, err
+				}
+				t, err := readChunkOffsets(box)
+				if err != nil {
+					return nil, err
+				}
+				offsets = append(offsets, t.offsets...)
+			}
+		}
+		result = append(result, offsets)
+	}
+	return result, nil
+}
+
+// locate returns the index of the box containing offset and the distance from its body start.
+func locate(boxes []*atomic.Box, offset uint64) (int, int64) {
+	off := int64(offset)
+	for i, b := range boxes {
+		if off >= b.BodyOffset() && off < b.End() {
+			return i, off - b.BodyOffset()
+		}
+	}
+	return -1, off
+}
+
+// hashBox computes a SHA-256 of the body of a box.
+func hashBox(rs io.ReadSeeker, box *atomic.Box) ([]byte, error) {
+	if _, err := rs.Seek(box.BodyOffset(), io.SeekStart); err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	if _, err := io.CopyN(h, rs, box.BodySize()); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// TrackDigest is the streaming hash of all sample bytes of one track.
+type TrackDigest struct {
+	TrackID uint32 `json:"trackId"`
+	Samples int    `json:"samples"`
+	SHA256  string `json:"sha256"`
+}
+
+// SampleMismatchError reports the first sample whose bytes differ between two files.
+type SampleMismatchError struct {
+	TrackID uint32
+	Sample  int // 1-based
+	Reason  string
+}
+
+func (e *SampleMismatchError) Error() string {
+	return fmt.Sprintf("%v: track %d sample %d: %s", ErrVerifyFailed, e.TrackID, e.Sample, e.Reason)
+}
+
+func (e *SampleMismatchError) Unwrap() error {
+	return ErrVerifyFailed
+}
+
+// VerifySamples resolves every sample of every track through the sample tables of
+// both files and proves that each one reads back identical bytes. It returns the
+// per-track digests, or a *SampleMismatchError for the first differing sample.
+func VerifySamples(ctx context.Context, originalPath, optimizedPath string) ([]TrackDigest, error) {
+	orig, err := os.Open(originalPath)
+	if err != nil {
+		return nil, err
+	}
+	defer orig.Close()
+
+	opt, err := os.Open(optimizedPath)
+	if err != nil {
+		return nil, err
+	}
+	defer opt.Close()
+
+	origTracks, err := readFileTracks(orig)
+	if err != nil {
+		return nil, fmt.Errorf("read original sample tables: %w", err)
+	}
+	optTracks, err := readFileTracks(opt)
+	if err != nil {
+		return nil, fmt.Errorf("%w: read sample tables: %v", ErrVerifyFailed, err)
+	}
+	if len(origTracks) != len(optTracks) {
+		return nil, fmt.Errorf("%w: expected %d tracks, found %d", ErrVerifyFailed, len(origTracks), len(optTracks))
+	}
+
+	// Sample sizes come from the files, so they are checked against the file
+	// sizes before any buffer is sized from them
+	origSize, err := orig.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	optSize, err := opt.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	var digests []TrackDigest
+	// buf holds the sample of both files side by side and only grows
+	var buf []byte
+	for i, a := range origTracks {
+		b := optTracks[i]
+		if len(a.Samples) != len(b.Samples) {
+			return nil, &SampleMismatchError{TrackID: a.ID, Sample: min(len(a.Samples), len(b.Samples)) + 1,
+				Reason: fmt.Sprintf("sample count %d, expected %d", len(b.Samples), len(a.Samples))}
+		}
+
+		h := sha256.New()
+		for j := range a.Samples {
+			if j%1024 == 0 {
+				if err := checkCanceled(ctx, originalPath); err != nil {
+					return nil, err
+				}
+			}
+			sa, sb := a.Samples[j], b.Samples[j]
+			if sa.Size != sb.Size {
+				return nil, &SampleMismatchError{TrackID: a.ID, Sample: j + 1,
+					Reason: fmt.Sprintf("size %d, expected %d", sb.Size, sa.Size)}
+			}
+			size := int64(sa.Size)
+			if sa.Offset < 0 || size > origSize-sa.Offset {
+				return nil, fmt.Errorf("original track %d sample %d lies outside the file", a.ID, j+1)
+			}
+			if sb.Offset < 0 || size > optSize-sb.Offset {
+				return nil, &SampleMismatchError{TrackID: a.ID, Sample: j + 1, Reason: "lies outside the file"}
+			}
+			if int64(cap(buf)) < 2*size {
+				buf = make([]byte, 2*size)
+			}
+			bufA, bufB := buf[:size], buf[size:2*size]
+			if _, err := orig.ReadAt(bufA, sa.Offset); err != nil {
+				return nil, fmt.Errorf("read original track %d sample %d: %w", a.ID, j+1, err)
+			}
+			if _, err := opt.ReadAt(bufB, sb.Offset); err != nil {
+				return nil, &SampleMismatchError{TrackID: a.ID, Sample: j + 1, Reason: err.Error()}
+			}
+			if !bytes.Equal(bufA, bufB) {
+				return nil, &SampleMismatchError{TrackID: a.ID, Sample: j + 1, Reason: "content differs"}
+			}
+			h.Write(bufB)
+		}
+		digests = append(digests, TrackDigest{
+			TrackID: a.ID,
+			Samples: len(a.Samples),
+			SHA256:  fmt.Sprintf("%x", h.Sum(nil)),
+		})
+	}
+	return digests, nil
+}
+
+// readFileTracks resolves every track of a file, including samples carried in fragments.
+func readFileTracks(rs io.ReadSeeker) ([]*atomic.Track, error) {
+	boxes, err := atomic.ParseTree(rs)
+	if err != nil {
+		return nil, err
+	}
+	moov := atomic.FindBox(boxes, "moov")
+	if moov == nil {
+		return nil, fmt.Errorf("no moov atom found")
+	}
+	return readMovieTracks(rs, boxes, moov)
+}
+
+// readMovieTracks reads the sample tables in moov and appends the samples of every moof.
+func readMovieTracks(rs io.ReadSeeker, boxes []*atomic.Box, moov *atomic.Box) ([]*atomic.Track, error) {
+	tracks, err := atomic.ReadTracks(rs, moov)
+	if err != nil {
+		return nil, err
+	}
+	if atomic.FindBox(boxes, "moof") == nil {
+		return tracks, nil
+	}
+	defaults, err := atomic.ReadTrackDefaults(rs, moov)
+	if err != nil {
+		return nil, err
+	}
+	fragments, err := atomic.ReadFragments(rs, boxes, defaults)
+	if err != nil {
+		return nil, err
+	}
+	if err := atomic.MergeFragments(tracks, fragments); err != nil {
+		return nil, err
+	}
+	return tracks, nil
+}
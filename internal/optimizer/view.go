@@ -0,0 +1,171 @@
+package optimizer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"mp4-optimizer/pkg/atomic"
+)
+
+// FastStartView presents an MP4 as if it had been optimized: ftyp, the patched
+// moov, then every other top-level box in its original order. Only moov is held
+// in memory; all other bytes are read from the source on demand, so nothing is
+// written to disk. Files that are already fast-start, fragmented or cannot be
+// parsed are presented unchanged.
+type FastStartView struct {
+	src       io.ReaderAt
+	parts     []viewPart
+	size      int64
+	pos       int64
+	rewritten bool
+}
+
+// viewPart is a contiguous range of the view, backed by memory or by the source.
+type viewPart struct {
+	start     int64 // offset in the view
+	size      int64
+	data      []byte // in-memory bytes, or nil to read from the source
+	srcOffset int64
+}
+
+// NewFastStartView builds a view over the size bytes of src. The layout matches
+// the file OptimizeWithOptions would write.
+func NewFastStartView(src io.ReaderAt, size int64) (*FastStartView, error) {
+	v := &FastStartView{src: src}
+	unchanged := func() (*FastStartView, error) {
+		v.add(viewPart{size: size})
+		return v, nil
+	}
+
+	// A damaged tail would leave part of the media unaddressed, so such files
+	// are served as they are
+	rs := io.NewSectionReader(src, 0, size)
+	boxes, err := atomic.ParseTree(rs)
+	if err != nil {
+		return unchanged()
+	}
+	moov := atomic.FindBox(boxes, "moov")
+	mdat := atomic.FindBox(boxes, "mdat")
+	if moov == nil || mdat == nil || moov.Offset < mdat.Offset {
+		return unchanged()
+	}
+	if atomic.FindBox(boxes, "moof") != nil || moov.Child("mvex") != nil {
+		return unchanged()
+	}
+	if err := moov.Load(rs); err != nil {
+		return nil, err
+	}
+	if _, err := RelocateMoov(moov, atomic.FindBox(boxes, "ftyp")); err != nil {
+		return nil, fmt.Errorf("failed to patch moov: %w", err)
+	}
+	moovBuf, err := moov.Bytes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize moov: %w", err)
+	}
+
+	if ftyp := atomic.FindBox(boxes, "ftyp"); ftyp != nil {
+		v.add(viewPart{size: ftyp.Size, srcOffset: ftyp.Offset})
+	}
+	v.add(viewPart{size: int64(len(moovBuf)), data: moovBuf})
+	for _, b := range boxes {
+		if b.Type == "ftyp" || b.Type == "moov" {
+			continue
+		}
+		v.add(viewPart{size: b.Size, srcOffset: b.Offset})
+	}
+	v.rewritten = true
+	return v, nil
+}
+
+// add appends p to the view, merging it into the previous part when both are
+// contiguous ranges of the source.
+func (v *FastStartView) add(p viewPart) {
+	p.start = v.size
+	v.size += p.size
+	if n := len(v.parts); n > 0 && p.data == nil {
+		last := &v.parts[n-1]
+		if last.data == nil && last.srcOffset+last.size == p.srcOffset {
+			last.size += p.size
+			return
+		}
+	}
+	v.parts = append(v.parts, p)
+}
+
+// WithSource returns a view with the same layout that reads from src, which
+// must hold the bytes the view was built from. A view keeps a read position, so
+// concurrent readers each need their own; this avoids parsing the file again.
+func (v *FastStartView) WithSource(src io.ReaderAt) *FastStartView {
+	return &FastStartView{src: src, parts: v.parts, size: v.size, rewritten: v.rewritten}
+}
+
+// Rewritten reports whether the view differs from the source, i.e. moov was moved.
+func (v *FastStartView) Rewritten() bool {
+	return v.rewritten
+}
+
+// Size returns the length of the view in bytes.
+func (v *FastStartView) Size() int64 {
+	return v.size
+}
+
+// ReadAt implements io.ReaderAt.
+func (v *FastStartView) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	n := 0
+	i := sort.Search(len(v.parts), func(i int) bool { return v.parts[i].start+v.parts[i].size > off })
+	for ; i < len(v.parts) && n < len(p); i++ {
+		part := v.parts[i]
+		rel := off + int64(n) - part.start
+		want := min(int64(len(p)-n), part.size-rel)
+		if part.data != nil {
+			copy(p[n:], part.data[rel:rel+want])
+			n += int(want)
+			continue
+		}
+		m, err := v.src.ReadAt(p[n:n+int(want)], part.srcOffset+rel)
+		n += m
+		if err != nil && !(err == io.EOF && int64(m) == want) {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return n, err
+		}
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Read implements io.Reader.
+func (v *FastStartView) Read(p []byte) (int, error) {
+	if v.pos >= v.size {
+		return 0, io.EOF
+	}
+	n, err := v.ReadAt(p[:min(int64(len(p)), v.size-v.pos)], v.pos)
+	v.pos += int64(n)
+	return n, err
+}
+
+// Seek implements io.Seeker.
+func (v *FastStartView) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += v.pos
+	case io.SeekEnd:
+		offset += v.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	v.pos = offset
+	return offset, nil
+}
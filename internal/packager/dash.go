@@ -0,0 +1,325 @@
+package packager
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"time"
+
+	"mp4-optimizer/internal/analyzer"
+	"mp4-optimizer/internal/optimizer"
+	"mp4-optimizer/pkg/atomic"
+)
+
+// dashManifest is the file name of the MPD inside a DASH package.
+const dashManifest = "manifest.mpd"
+
+// DASHOptions configures PackageDASH.
+type DASHOptions struct {
+	InputPath string
+	// OutputDir receives the manifest and one media file per track. Empty means
+	// a folder named after the input with a "_dash" suffix, next to it.
+	OutputDir string
+	// SegmentDuration is the target subsegment length. Subsegments start at
+	// key frames, so they may run longer.
+	SegmentDuration time.Duration
+	// Overwrite replaces an existing package in OutputDir
+	Overwrite bool
+	Progress  optimizer.ProgressCallback
+}
+
+// DASHResult describes a written DASH package.
+type DASHResult struct {
+	OutputDir string   `json:"outputDir"`
+	Manifest  string   `json:"manifest"`
+	Files     []string `json:"files"`
+}
+
+// dashContentTypes maps the handlers that are packaged to their DASH content
+// type. Other tracks (hint, timecode, metadata) are left out.
+var dashContentTypes = map[string]string{
+	"vide": "video",
+	"soun": "audio",
+	"text": "text",
+	"sbtl": "text",
+	"subt": "text",
+}
+
+// PackageDASH writes an on-demand profile DASH package: every video, audio
+// and text track becomes its own fragmented MP4 with a top-level sidx, and an
+// MPD describes them with SegmentBase byte ranges. Samples are copied as they are.
+func PackageDASH(ctx context.Context, opts DASHOptions) (*DASHResult, error) {
+	progressFn := opts.Progress
+	reportProgress := func(p float64, msg string) {
+		if progressFn != nil {
+			progressFn(p, msg)
+		}
+	}
+	if opts.SegmentDuration <= 0 {
+		opts.SegmentDuration = DefaultSegmentDuration
+	}
+	if opts.OutputDir == "" {
+		opts.OutputDir = defaultOutputDir(opts.InputPath, "_dash")
+	}
+
+	reportProgress(0, "开始处理...")
+
+	// 1. Read the metadata and list the tracks to package
+	meta, err := analyzer.GetMetadata(opts.InputPath)
+	if err != nil {
+		return nil, err
+	}
+	all, err := openSource(opts.InputPath, opts.SegmentDuration)
+	if err != nil {
+		return nil, err
+	}
+	all.file.Close()
+	var tracks []*atomic.Track
+	for _, t := range all.plan.Tracks {
+		if dashContentTypes[t.Handler] != "" && len(t.Samples) > 0 {
+			tracks = append(tracks, t)
+		}
+	}
+	if len(tracks) == 0 {
+		return nil, fmt.Errorf("no audio, video or text tracks to package")
+	}
+
+	out, err := prepareOutput(opts.OutputDir, dashManifest, opts.Overwrite)
+	if err != nil {
+		return nil, err
+	}
+	success := false
+	defer func() {
+		if !success {
+			out.discard()
+		}
+	}()
+
+	// 2. Write one self-indexed file per track
+	mpd := newMPD()
+	mpd.MinBufferTime = fmt.Sprintf("PT%.3fS", opts.SegmentDuration.Seconds())
+	result := &DASHResult{OutputDir: opts.OutputDir, Manifest: dashManifest}
+	for i, t := range tracks {
+		if err := checkCanceled(ctx); err != nil {
+			return nil, err
+		}
+		reportProgress(10+float64(i)/float64(len(tracks))*80, fmt.Sprintf("写入轨道 %d/%d...", i+1, len(tracks)))
+
+		contentType := dashContentTypes[t.Handler]
+		name := fmt.Sprintf("%s_%d.mp4", contentType, t.ID)
+		rep, err := writeDASHTrack(ctx, out, name, opts.InputPath, opts.SegmentDuration, t.ID)
+		if err != nil {
+			return nil, fmt.Errorf("track %d: %w", t.ID, err)
+		}
+		result.Files = append(result.Files, name)
+
+		rep.ID = fmt.Sprint(t.ID)
+		rep.Codecs = trackCodecs(meta, t)
+		if info := trackInfo(meta, t.ID); info != nil {
+			switch contentType {
+			case "video":
+				rep.Width, rep.Height = info.DisplayWidth, info.DisplayHeight
+			case "audio":
+				// Unknown rates are omitted; the timescale is not always the sample rate
+				rep.AudioSamplingRate = uint32(math.Round(info.SampleRate))
+			}
+		}
+		lang := ""
+		if info := trackInfo(meta, t.ID); info != nil && info.Language != "und" {
+			lang = info.Language
+		}
+		mpd.addRepresentation(contentType, lang, rep)
+		if t.Timescale > 0 {
+			var d uint64
+			for _, s := range t.Samples {
+				d += uint64(s.Duration)
+			}
+			mpd.duration = max(mpd.duration, float64(d)/float64(t.Timescale))
+		}
+	}
+
+	reportProgress(90, "写入清单...")
+
+	// 3. Write the manifest last so it only appears once the media is complete
+	buf, err := mpd.marshal()
+	if err != nil {
+		return nil, err
+	}
+	if err := out.writeFile(dashManifest, buf); err != nil {
+		return nil, err
+	}
+
+	success = true
+	reportProgress(100, "完成！")
+	return result, nil
+}
+
+// writeDASHTrack writes the on-demand file of one track: init segment, sidx
+// and fragments. It returns the representation with byte ranges and bandwidth.
+func writeDASHTrack(ctx context.Context, out *output, name, input string, target time.Duration, trackID uint32) (*dashRepresentation, error) {
+	src, err := openSource(input, target, trackID)
+	if err != nil {
+		return nil, err
+	}
+	defer src.file.Close()
+	plan := src.plan
+
+	sidx, err := plan.Sidx(0)
+	if err != nil {
+		return nil, err
+	}
+	sidxBuf, err := sidx.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := out.create(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	for _, buf := range [][]byte{plan.Init, sidxBuf} {
+		if _, err := f.Write(buf); err != nil {
+			return nil, err
+		}
+	}
+
+	// Bandwidth is the peak subsegment bit rate, which a client buffering
+	// minBufferTime can always sustain
+	var peak float64
+	for i, frag := range plan.Fragments {
+		if err := checkCanceled(ctx); err != nil {
+			return nil, err
+		}
+		n, err := frag.Write(f, src.file)
+		if err != nil {
+			return nil, err
+		}
+		if d := plan.Segments[i].Duration; d > 0 {
+			peak = max(peak, float64(n)*8/d)
+		}
+	}
+	if err := f.Sync(); err != nil {
+		return nil, err
+	}
+
+	initSize, sidxSize := int64(len(plan.Init)), int64(len(sidxBuf))
+	return &dashRepresentation{
+		Bandwidth: int64(math.Ceil(peak)),
+		BaseURL:   name,
+		SegmentBase: dashSegmentBase{
+			IndexRange:     fmt.Sprintf("%d-%d", initSize, initSize+sidxSize-1),
+			Initialization: dashInitialization{Range: fmt.Sprintf("0-%d", initSize-1)},
+		},
+	}, nil
+}
+
+// trackInfo returns the analyzer's description of the track with the given
+// ID, or nil if it has none.
+func trackInfo(meta *analyzer.Metadata, id uint32) *analyzer.TrackInfo {
+	for i := range meta.Tracks {
+		if meta.Tracks[i].ID == id {
+			return &meta.Tracks[i]
+		}
+	}
+	return nil
+}
+
+// trackCodecs returns the RFC 6381 codec string the analyzer derived for the
+// track, or "" when it has none. A bare sample entry type is not a valid
+// codecs value, so @codecs is left out instead.
+func trackCodecs(meta *analyzer.Metadata, t *atomic.Track) string {
+	if info := trackInfo(meta, t.ID); info != nil {
+		return info.CodecString
+	}
+	return ""
+}
+
+// MPD document, limited to what the on-demand profile needs
+
+type dashMPD struct {
+	XMLName                   xml.Name `xml:"MPD"`
+	Xmlns                     string   `xml:"xmlns,attr"`
+	Profiles                  string   `xml:"profiles,attr"`
+	Type                      string   `xml:"type,attr"`
+	MediaPresentationDuration string   `xml:"mediaPresentationDuration,attr"`
+	MinBufferTime             string   `xml:"minBufferTime,attr"`
+	Period                    dashPeriod
+
+	duration float64
+}
+
+type dashPeriod struct {
+	AdaptationSets []*dashAdaptationSet `xml:"AdaptationSet"`
+}
+
+type dashAdaptationSet struct {
+	ContentType             string                `xml:"contentType,attr"`
+	Lang                    string                `xml:"lang,attr,omitempty"`
+	MimeType                string                `xml:"mimeType,attr"`
+	SubsegmentAlignment     bool                  `xml:"subsegmentAlignment,attr"`
+	SubsegmentStartsWithSAP int                   `xml:"subsegmentStartsWithSAP,attr"`
+	Representations         []*dashRepresentation `xml:"Representation"`
+}
+
+type dashRepresentation struct {
+	ID                string          `xml:"id,attr"`
+	Bandwidth         int64           `xml:"bandwidth,attr"`
+	Codecs            string          `xml:"codecs,attr,omitempty"`
+	Width             int             `xml:"width,attr,omitempty"`
+	Height            int             `xml:"height,attr,omitempty"`
+	AudioSamplingRate uint32          `xml:"audioSamplingRate,attr,omitempty"`
+	BaseURL           string          `xml:"BaseURL"`
+	SegmentBase       dashSegmentBase `xml:"SegmentBase"`
+}
+
+type dashSegmentBase struct {
+	IndexRange     string             `xml:"indexRange,attr"`
+	Initialization dashInitialization `xml:"Initialization"`
+}
+
+type dashInitialization struct {
+	Range string `xml:"range,attr"`
+}
+
+func newMPD() *dashMPD {
+	return &dashMPD{
+		Xmlns:    "urn:mpeg:dash:schema:mpd:2011",
+		Profiles: "urn:mpeg:dash:profile:isoff-on-demand:2011",
+		Type:     "static",
+	}
+}
+
+// addRepresentation puts rep into the adaptation set of its content type and
+// language, so that players can switch between languages rather than treat
+// them as bit rates of one stream. An empty lang means unknown.
+func (m *dashMPD) addRepresentation(contentType, lang string, rep *dashRepresentation) {
+	for _, as := range m.Period.AdaptationSets {
+		if as.ContentType == contentType && as.Lang == lang {
+			as.Representations = append(as.Representations, rep)
+			return
+		}
+	}
+	mime := contentType + "/mp4"
+	if contentType == "text" {
+		mime = "application/mp4"
+	}
+	m.Period.AdaptationSets = append(m.Period.AdaptationSets, &dashAdaptationSet{
+		ContentType:             contentType,
+		Lang:                    lang,
+		MimeType:                mime,
+		SubsegmentAlignment:     true,
+		SubsegmentStartsWithSAP: 1,
+		Representations:         []*dashRepresentation{rep},
+	})
+}
+
+func (m *dashMPD) marshal() ([]byte, error) {
+	m.MediaPresentationDuration = fmt.Sprintf("PT%.3fS", m.duration)
+	buf, err := xml.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(buf, '\n')...), nil
+}
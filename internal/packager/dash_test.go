@@ -0,0 +1,129 @@
+package packager
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"mp4-optimizer/pkg/atomic"
+)
+
+func TestPackageDASH(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "movie.mp4")
+	if err := os.WriteFile(in, progressiveFile(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := PackageDASH(context.Background(), DASHOptions{InputPath: in, SegmentDuration: 2 * time.Second})
+	if err != nil {
+		t.Fatalf("PackageDASH failed: %v", err)
+	}
+	if res.OutputDir != filepath.Join(dir, "movie_dash") || len(res.Files) != 2 {
+		t.Fatalf("Unexpected result %+v", res)
+	}
+
+	data, err := os.ReadFile(filepath.Join(res.OutputDir, res.Manifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mpd dashMPD
+	if err := xml.Unmarshal(data, &mpd); err != nil {
+		t.Fatalf("Invalid MPD: %v\n%s", err, data)
+	}
+	if mpd.Profiles != "urn:mpeg:dash:profile:isoff-on-demand:2011" || mpd.MediaPresentationDuration != "PT6.000S" {
+		t.Errorf("Unexpected MPD attributes: %+v", mpd)
+	}
+	if len(mpd.Period.AdaptationSets) != 2 {
+		t.Fatalf("Expected video and audio adaptation sets, got %d", len(mpd.Period.AdaptationSets))
+	}
+
+	// The sample entries carry no rate, and the 1000 Hz timescale is not one
+	if bytes.Contains(data, []byte("audioSamplingRate")) {
+		t.Errorf("Expected no audioSamplingRate for an unknown sample rate:\n%s", data)
+	}
+	// Nor a codec configuration to derive an RFC 6381 string from
+	if bytes.Contains(data, []byte("codecs=")) {
+		t.Errorf("Expected no codecs without codec strings:\n%s", data)
+	}
+
+	wantSamples := map[string]int{"video": 6, "audio": 4}
+	for _, as := range mpd.Period.AdaptationSets {
+		// A zero mdhd language is the QuickTime code for English
+		if as.Lang != "eng" {
+			t.Errorf("%s: expected lang eng, got %q", as.ContentType, as.Lang)
+		}
+		rep := as.Representations[0]
+		if rep.Bandwidth <= 0 {
+			t.Errorf("%s: expected a bandwidth, got %d", as.ContentType, rep.Bandwidth)
+		}
+		media, err := os.ReadFile(filepath.Join(res.OutputDir, rep.BaseURL))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The ranges point at the init segment and the sidx that follows it
+		var initEnd, indexStart, indexEnd int
+		fmt.Sscanf(rep.SegmentBase.Initialization.Range, "0-%d", &initEnd)
+		fmt.Sscanf(rep.SegmentBase.IndexRange, "%d-%d", &indexStart, &indexEnd)
+		if indexStart != initEnd+1 || string(media[indexStart+4:indexStart+8]) != "sidx" {
+			t.Errorf("%s: index range %q does not address the sidx", as.ContentType, rep.SegmentBase.IndexRange)
+		}
+
+		// Each file carries a single track with all of its samples
+		counts := countFragmentSamples(t, media)
+		if len(counts) != 1 {
+			t.Errorf("%s: expected one track, got %v", as.ContentType, counts)
+		}
+		for _, n := range counts {
+			if n != wantSamples[as.ContentType] {
+				t.Errorf("%s: expected %d samples, got %d", as.ContentType, wantSamples[as.ContentType], n)
+			}
+		}
+		boxes, err := atomic.ParseTree(bytes.NewReader(media[:initEnd+1]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if moov := atomic.FindBox(boxes, "moov"); moov == nil || len(moov.ChildrenOfType("trak")) != 1 {
+			t.Errorf("%s: expected an init segment with one trak", as.ContentType)
+		}
+	}
+}
+
+func TestDASHAdaptationSetsByLanguage(t *testing.T) {
+	mpd := newMPD()
+	for _, r := range []struct{ contentType, lang, id string }{
+		{"video", "", "1"},
+		{"audio", "eng", "2"},
+		{"audio", "fra", "3"},
+		{"audio", "eng", "4"},
+	} {
+		mpd.addRepresentation(r.contentType, r.lang, &dashRepresentation{ID: r.id})
+	}
+
+	var got []string
+	for _, as := range mpd.Period.AdaptationSets {
+		var ids []string
+		for _, rep := range as.Representations {
+			ids = append(ids, rep.ID)
+		}
+		got = append(got, fmt.Sprintf("%s/%s:%v", as.ContentType, as.Lang, ids))
+	}
+	want := []string{"video/:[1]", "audio/eng:[2 4]", "audio/fra:[3]"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Expected adaptation sets %v, got %v", want, got)
+	}
+
+	buf, err := mpd.marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(buf, []byte(`lang="fra"`)) || bytes.Count(buf, []byte("lang=")) != 2 {
+		t.Errorf("Expected lang on the audio sets only:\n%s", buf)
+	}
+}
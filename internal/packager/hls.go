@@ -0,0 +1,298 @@
+package packager
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"mp4-optimizer/internal/analyzer"
+	"mp4-optimizer/internal/optimizer"
+	"mp4-optimizer/pkg/atomic"
+)
+
+// File names inside an HLS package
+const (
+	hlsMaster     = "master.m3u8"
+	hlsMedia      = "media.m3u8"
+	hlsInit       = "init.mp4"
+	hlsSingleFile = "media.mp4"
+	// hlsSegments matches the per-segment files, named seg_00001.m4s and up
+	hlsSegments = "seg_*.m4s"
+)
+
+// hlsHandlers are the handlers of the tracks muxed into the segments. Other
+// tracks (timecode, hint, metadata, subtitles) are left out; subtitles would
+// need a rendition of their own.
+var hlsHandlers = map[string]bool{
+	"vide": true,
+	"soun": true,
+}
+
+// HLSOptions configures PackageHLS.
+type HLSOptions struct {
+	InputPath string
+	// OutputDir receives the playlists and media. Empty means a folder named
+	// after the input with an "_hls" suffix, next to it.
+	OutputDir string
+	// SegmentDuration is the target segment length. Segments start at key
+	// frames, so they may run longer.
+	SegmentDuration time.Duration
+	// SingleFile writes the init segment and all segments into one file
+	// addressed with EXT-X-BYTERANGE instead of one file per segment
+	SingleFile bool
+	// Overwrite replaces an existing package in OutputDir
+	Overwrite bool
+	Progress  optimizer.ProgressCallback
+}
+
+// HLSResult describes a written HLS package.
+type HLSResult struct {
+	OutputDir      string `json:"outputDir"`
+	MasterPlaylist string `json:"masterPlaylist"`
+	MediaPlaylist  string `json:"mediaPlaylist"`
+	Segments       int    `json:"segments"`
+}
+
+// hlsSegment is one entry of the media playlist.
+type hlsSegment struct {
+	uri      string
+	offset   int64 // byte range start in single-file mode
+	size     int64
+	duration float64
+}
+
+// PackageHLS splits a progressive MP4 into fMP4 HLS segments cut at key frames
+// and writes a media playlist plus a master playlist carrying the resolution
+// and codec strings reported by the analyzer. Samples are copied as they are.
+func PackageHLS(ctx context.Context, opts HLSOptions) (*HLSResult, error) {
+	progressFn := opts.Progress
+	reportProgress := func(p float64, msg string) {
+		if progressFn != nil {
+			progressFn(p, msg)
+		}
+	}
+	if opts.SegmentDuration <= 0 {
+		opts.SegmentDuration = DefaultSegmentDuration
+	}
+	if opts.OutputDir == "" {
+		opts.OutputDir = defaultOutputDir(opts.InputPath, "_hls")
+	}
+
+	reportProgress(0, "开始处理...")
+
+	// 1. Read the metadata for the master playlist
+	meta, err := analyzer.GetMetadata(opts.InputPath)
+	if err != nil {
+		return nil, err
+	}
+
+	reportProgress(10, "规划分片...")
+
+	// 2. Plan the segments of the audio and video tracks from the sample tables
+	all, err := openSource(opts.InputPath, opts.SegmentDuration)
+	if err != nil {
+		return nil, err
+	}
+	all.file.Close()
+	var trackIDs []uint32
+	for _, t := range all.plan.Tracks {
+		if hlsHandlers[t.Handler] && len(t.Samples) > 0 {
+			trackIDs = append(trackIDs, t.ID)
+		}
+	}
+	if len(trackIDs) == 0 {
+		return nil, fmt.Errorf("no audio or video tracks to package")
+	}
+	src, err := openSource(opts.InputPath, opts.SegmentDuration, trackIDs...)
+	if err != nil {
+		return nil, err
+	}
+	defer src.file.Close()
+	plan := src.plan
+
+	out, err := prepareOutput(opts.OutputDir, hlsMaster, opts.Overwrite, hlsMedia, hlsInit, hlsSingleFile, hlsSegments)
+	if err != nil {
+		return nil, err
+	}
+	success := false
+	defer func() {
+		if !success {
+			out.discard()
+		}
+	}()
+
+	reportProgress(20, "写入分片...")
+
+	// 3. Write the init segment and the media segments
+	var (
+		segments []hlsSegment
+		mapURI   = hlsInit
+		mapRange string
+	)
+	var single *os.File
+	if opts.SingleFile {
+		f, err := out.create(hlsSingleFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		single = f
+		mapURI = hlsSingleFile
+		mapRange = fmt.Sprintf("%d@0", len(plan.Init))
+		if _, err := f.Write(plan.Init); err != nil {
+			return nil, err
+		}
+	} else if err := out.writeFile(hlsInit, plan.Init); err != nil {
+		return nil, err
+	}
+
+	offset := int64(len(plan.Init))
+	for i, frag := range plan.Fragments {
+		if err := checkCanceled(ctx); err != nil {
+			return nil, err
+		}
+		seg := hlsSegment{uri: hlsSingleFile, offset: offset, duration: plan.Segments[i].Duration}
+		if single != nil {
+			seg.size, err = frag.Write(single, src.file)
+		} else {
+			seg.uri = fmt.Sprintf("seg_%05d.m4s", i+1)
+			seg.size, err = writeSegment(out, seg.uri, frag, src.file)
+		}
+		if err != nil {
+			return nil, err
+		}
+		offset += seg.size
+		segments = append(segments, seg)
+		reportProgress(20+float64(i+1)/float64(len(plan.Fragments))*70, fmt.Sprintf("写入分片... %d/%d", i+1, len(plan.Fragments)))
+	}
+	if single != nil {
+		if err := single.Sync(); err != nil {
+			return nil, err
+		}
+	}
+
+	reportProgress(90, "写入播放列表...")
+
+	// 4. Write the playlists, master last so it only appears once the rest is complete
+	if err := out.writeFile(hlsMedia, []byte(mediaPlaylist(segments, mapURI, mapRange))); err != nil {
+		return nil, err
+	}
+	if err := out.writeFile(hlsMaster, []byte(masterPlaylist(meta, hlsCodecs(meta, plan.Tracks), segments, hlsMedia))); err != nil {
+		return nil, err
+	}
+
+	success = true
+	reportProgress(100, "完成！")
+	return &HLSResult{
+		OutputDir:      opts.OutputDir,
+		MasterPlaylist: hlsMaster,
+		MediaPlaylist:  hlsMedia,
+		Segments:       len(segments),
+	}, nil
+}
+
+// writeSegment writes one fragment into its own file.
+func writeSegment(out *output, name string, frag *atomic.MediaFragment, src io.ReadSeeker) (int64, error) {
+	f, err := out.create(name)
+	if err != nil {
+		return 0, err
+	}
+	n, err := frag.Write(f, src)
+	if err != nil {
+		f.Close()
+		return n, err
+	}
+	return n, f.Close()
+}
+
+// mediaPlaylist renders a VOD media playlist. mapRange is empty unless the
+// init segment is a byte range of a single file.
+func mediaPlaylist(segments []hlsSegment, mapURI, mapRange string) string {
+	// EXTINF rounded to the nearest integer must not exceed the target duration
+	target := 1
+	for _, s := range segments {
+		target = max(target, int(math.Round(s.duration)))
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:7\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", target)
+	b.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n")
+	b.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
+	b.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+	if mapRange != "" {
+		fmt.Fprintf(&b, "#EXT-X-MAP:URI=%q,BYTERANGE=%q\n", mapURI, mapRange)
+	} else {
+		fmt.Fprintf(&b, "#EXT-X-MAP:URI=%q\n", mapURI)
+	}
+	for _, s := range segments {
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n", s.duration)
+		if mapRange != "" {
+			fmt.Fprintf(&b, "#EXT-X-BYTERANGE:%d@%d\n", s.size, s.offset)
+		}
+		b.WriteString(s.uri + "\n")
+	}
+	b.WriteString("#EXT-X-ENDLIST\n")
+	return b.String()
+}
+
+// hlsCodecs returns the RFC 6381 codec strings of the packaged tracks, or ""
+// when any of them has none, since an incomplete CODECS attribute makes
+// players skip the variant.
+func hlsCodecs(meta *analyzer.Metadata, tracks []*atomic.Track) string {
+	var codecs []string
+	for _, t := range tracks {
+		info := trackInfo(meta, t.ID)
+		if info == nil || info.CodecString == "" {
+			return ""
+		}
+		if !slices.Contains(codecs, info.CodecString) {
+			codecs = append(codecs, info.CodecString)
+		}
+	}
+	return strings.Join(codecs, ",")
+}
+
+// masterPlaylist renders a master playlist with a single variant. BANDWIDTH is
+// the peak segment bit rate and AVERAGE-BANDWIDTH the overall one. CODECS is
+// left out when codecs is empty.
+func masterPlaylist(meta *analyzer.Metadata, codecs string, segments []hlsSegment, media string) string {
+	var peak, total, duration float64
+	for _, s := range segments {
+		bits := float64(s.size) * 8
+		if s.duration > 0 {
+			peak = max(peak, bits/s.duration)
+		}
+		total += bits
+		duration += s.duration
+	}
+	average := peak
+	if duration > 0 {
+		average = total / duration
+	}
+
+	attrs := []string{
+		fmt.Sprintf("BANDWIDTH=%d", int64(math.Ceil(peak))),
+		fmt.Sprintf("AVERAGE-BANDWIDTH=%d", int64(math.Ceil(average))),
+	}
+	if meta.Width > 0 && meta.Height > 0 {
+		attrs = append(attrs, fmt.Sprintf("RESOLUTION=%dx%d", meta.Width, meta.Height))
+	}
+	if codecs != "" {
+		attrs = append(attrs, fmt.Sprintf("CODECS=%q", codecs))
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:7\n")
+	b.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+	fmt.Fprintf(&b, "#EXT-X-STREAM-INF:%s\n", strings.Join(attrs, ","))
+	b.WriteString(media + "\n")
+	return b.String()
+}
@@ -0,0 +1,246 @@
+package packager
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"mp4-optimizer/pkg/atomic"
+)
+
+func box(typ string, body ...[]byte) []byte {
+	payload := bytes.Join(body, nil)
+	buf := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(8+len(payload)))
+	copy(buf[4:8], typ)
+	return append(buf, payload...)
+}
+
+func u32s(vals ...uint32) []byte {
+	buf := make([]byte, 4*len(vals))
+	for i, v := range vals {
+		binary.BigEndian.PutUint32(buf[i*4:], v)
+	}
+	return buf
+}
+
+// progressiveFile builds a fast-start movie with a 6 second video track of
+// one-second samples, sync at 1 and 4, and an audio track of 1.5 second samples.
+func progressiveFile() []byte {
+	return progressiveFileWith("soun")
+}
+
+// progressiveFileWith builds progressiveFile with handler as the handler of
+// the second track.
+func progressiveFileWith(handler string) []byte {
+	trak := func(id uint32, handler string, stbl []byte) []byte {
+		tkhd := make([]byte, 84)
+		binary.BigEndian.PutUint32(tkhd[12:16], id)
+		hdlr := make([]byte, 24)
+		copy(hdlr[8:12], handler)
+		return box("trak", box("tkhd", tkhd), box("mdia",
+			box("mdhd", u32s(0, 0, 0, 1000, 6000, 0)), box("hdlr", hdlr), box("minf", stbl)))
+	}
+	ftyp := box("ftyp", []byte("isom"), u32s(0x200), []byte("isom"))
+	build := func(mdatStart uint32) []byte {
+		video := box("stbl",
+			box("stsd", u32s(0, 0)),
+			box("stts", u32s(0, 1, 6, 1000)),
+			box("stss", u32s(0, 2, 1, 4)),
+			box("stsc", u32s(0, 1, 1, 3, 1)),
+			box("stsz", u32s(0, 10, 6)),
+			box("stco", u32s(0, 2, mdatStart, mdatStart+40)),
+		)
+		audio := box("stbl",
+			box("stsd", u32s(0, 0)),
+			box("stts", u32s(0, 1, 4, 1500)),
+			box("stsc", u32s(0, 1, 1, 2, 1)),
+			box("stsz", u32s(0, 5, 4)),
+			box("stco", u32s(0, 2, mdatStart+30, mdatStart+70)),
+		)
+		mvhd := append(u32s(0, 0, 0, 1000, 6000), make([]byte, 80)...)
+		return box("moov", box("mvhd", mvhd), trak(1, "vide", video), trak(2, handler, audio))
+	}
+	moovSize := uint32(len(build(0)))
+	var payload []byte
+	for chunk, n := range []int{30, 10, 30, 10} {
+		payload = append(payload, bytes.Repeat([]byte{byte(chunk + 1)}, n)...)
+	}
+	return bytes.Join([][]byte{ftyp, build(uint32(len(ftyp)) + moovSize + 8), box("mdat", payload)}, nil)
+}
+
+// countFragmentSamples parses data as an init segment followed by fragments
+// and returns the number of samples per track ID.
+func countFragmentSamples(t *testing.T, data []byte) map[uint32]int {
+	t.Helper()
+	r := bytes.NewReader(data)
+	boxes, err := atomic.ParseTree(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fragments, err := atomic.ReadFragments(r, boxes, nil)
+	if err != nil {
+		t.Fatalf("ReadFragments failed: %v", err)
+	}
+	counts := make(map[uint32]int)
+	for _, f := range fragments {
+		for _, tf := range f.Tracks {
+			counts[tf.TrackID] += len(tf.Samples)
+		}
+	}
+	return counts
+}
+
+func TestPackageHLS(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "movie.mp4")
+	if err := os.WriteFile(in, progressiveFile(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := PackageHLS(context.Background(), HLSOptions{InputPath: in, SegmentDuration: 2 * time.Second})
+	if err != nil {
+		t.Fatalf("PackageHLS failed: %v", err)
+	}
+	if res.OutputDir != filepath.Join(dir, "movie_hls") || res.Segments != 2 {
+		t.Fatalf("Unexpected result %+v", res)
+	}
+
+	media, err := os.ReadFile(filepath.Join(res.OutputDir, res.MediaPlaylist))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"#EXT-X-TARGETDURATION:3\n",
+		"#EXT-X-MAP:URI=\"init.mp4\"\n",
+		"#EXTINF:3.000,\nseg_00001.m4s\n",
+		"#EXTINF:3.000,\nseg_00002.m4s\n",
+		"#EXT-X-ENDLIST\n",
+	} {
+		if !strings.Contains(string(media), want) {
+			t.Errorf("Media playlist lacks %q:\n%s", want, media)
+		}
+	}
+	master, err := os.ReadFile(filepath.Join(res.OutputDir, res.MasterPlaylist))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(master), "#EXT-X-STREAM-INF:BANDWIDTH=") || !strings.HasSuffix(string(master), "\nmedia.m3u8\n") {
+		t.Errorf("Unexpected master playlist:\n%s", master)
+	}
+	// The sample entries carry no codec configuration, so there is no RFC 6381 string
+	if strings.Contains(string(master), "CODECS=") {
+		t.Errorf("Expected no CODECS without codec strings:\n%s", master)
+	}
+
+	// Init plus segments form a valid fragmented movie with every sample
+	var joined []byte
+	for _, name := range []string{"init.mp4", "seg_00001.m4s", "seg_00002.m4s"} {
+		data, err := os.ReadFile(filepath.Join(res.OutputDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		joined = append(joined, data...)
+	}
+	if counts := countFragmentSamples(t, joined); counts[1] != 6 || counts[2] != 4 {
+		t.Errorf("Expected 6 video and 4 audio samples, got %v", counts)
+	}
+
+	// A second run refuses to replace the package unless asked to
+	if _, err := PackageHLS(context.Background(), HLSOptions{InputPath: in}); !errors.Is(err, ErrOutputExists) {
+		t.Errorf("Expected ErrOutputExists, got %v", err)
+	}
+
+	// Overwriting with fewer segments removes the old ones
+	res, err = PackageHLS(context.Background(), HLSOptions{InputPath: in, SegmentDuration: 6 * time.Second, Overwrite: true})
+	if err != nil {
+		t.Fatalf("PackageHLS with Overwrite failed: %v", err)
+	}
+	if res.Segments != 1 {
+		t.Fatalf("Expected 1 segment, got %d", res.Segments)
+	}
+	if _, err := os.Stat(filepath.Join(res.OutputDir, "seg_00002.m4s")); !os.IsNotExist(err) {
+		t.Errorf("Expected the stale segment to be removed, got %v", err)
+	}
+}
+
+func TestPackageHLSSkipsOtherTracks(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "movie.mp4")
+	if err := os.WriteFile(in, progressiveFileWith("tmcd"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := PackageHLS(context.Background(), HLSOptions{InputPath: in, SegmentDuration: 2 * time.Second, SingleFile: true})
+	if err != nil {
+		t.Fatalf("PackageHLS failed: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(res.OutputDir, "media.mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts := countFragmentSamples(t, data); counts[1] != 6 || counts[2] != 0 {
+		t.Errorf("Expected only the 6 video samples, got %v", counts)
+	}
+}
+
+func TestPackageHLSSingleFile(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "movie.mp4")
+	if err := os.WriteFile(in, progressiveFile(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outDir := filepath.Join(dir, "out")
+
+	res, err := PackageHLS(context.Background(), HLSOptions{
+		InputPath:       in,
+		OutputDir:       outDir,
+		SegmentDuration: 2 * time.Second,
+		SingleFile:      true,
+	})
+	if err != nil {
+		t.Fatalf("PackageHLS failed: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(outDir, "media.mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	media, err := os.ReadFile(filepath.Join(outDir, res.MediaPlaylist))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The byte ranges tile the file after the init segment
+	var initSize, next int64
+	for _, line := range strings.Split(string(media), "\n") {
+		var size, offset int64
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-MAP:"):
+			if _, err := fmt.Sscanf(line[strings.Index(line, "BYTERANGE="):], "BYTERANGE=\"%d@%d\"", &size, &offset); err != nil {
+				t.Fatalf("Bad map line %q: %v", line, err)
+			}
+			initSize, next = size, size
+		case strings.HasPrefix(line, "#EXT-X-BYTERANGE:"):
+			if _, err := fmt.Sscanf(line, "#EXT-X-BYTERANGE:%d@%d", &size, &offset); err != nil {
+				t.Fatalf("Bad byte range %q: %v", line, err)
+			}
+			if offset != next {
+				t.Errorf("Expected range at %d, got %d", next, offset)
+			}
+			next = offset + size
+		}
+	}
+	if initSize == 0 || next != int64(len(data)) {
+		t.Errorf("Byte ranges end at %d, file has %d bytes", next, len(data))
+	}
+	if counts := countFragmentSamples(t, data); counts[1] != 6 || counts[2] != 4 {
+		t.Errorf("Expected 6 video and 4 audio samples, got %v", counts)
+	}
+}
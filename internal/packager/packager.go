@@ -0,0 +1,142 @@
+// Package packager turns progressive MP4 files into streaming packages (HLS,
+// DASH) by remuxing their samples into fragments. Nothing is re-encoded.
+package packager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"mp4-optimizer/internal/optimizer"
+	"mp4-optimizer/pkg/atomic"
+)
+
+// DefaultSegmentDuration is the target segment length when none is given.
+const DefaultSegmentDuration = 6 * time.Second
+
+// ErrOutputExists is returned when the output folder already holds a package
+// and overwriting was not requested.
+var ErrOutputExists = errors.New("output already exists")
+
+// source is an opened input with its fragment plan.
+type source struct {
+	file *os.File
+	plan *atomic.FragmentPlan
+}
+
+// openSource opens path and plans fragments of roughly target length for the
+// given tracks, or all of them. Fragmented input is rejected with
+// optimizer.ErrFragmented.
+func openSource(path string, target time.Duration, trackIDs ...uint32) (*source, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	boxes, err := atomic.ParseTree(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to parse atoms: %w", err)
+	}
+	moov := atomic.FindBox(boxes, "moov")
+	if moov == nil {
+		f.Close()
+		return nil, fmt.Errorf("no moov atom found")
+	}
+	if atomic.FindBox(boxes, "moof") != nil || moov.Child("mvex") != nil {
+		f.Close()
+		return nil, optimizer.ErrFragmented
+	}
+	plan, err := atomic.PlanFragments(f, boxes, target, trackIDs...)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to plan fragments: %w", err)
+	}
+	if len(plan.Fragments) == 0 {
+		f.Close()
+		return nil, fmt.Errorf("no samples to package")
+	}
+	return &source{file: f, plan: plan}, nil
+}
+
+// defaultOutputDir returns the folder next to input named after it plus suffix.
+func defaultOutputDir(input, suffix string) string {
+	base := filepath.Base(input)
+	return filepath.Join(filepath.Dir(input), strings.TrimSuffix(base, filepath.Ext(base))+suffix)
+}
+
+// output tracks the files written into a package folder so a failed run
+// leaves nothing half-written behind.
+type output struct {
+	dir     string
+	created []string
+}
+
+// prepareOutput creates dir. An existing marker file (the playlist or
+// manifest) means a previous package, which is only replaced with overwrite.
+// Replacing it first removes the files matching the stale patterns, so that
+// no leftovers of the old package end up mixed with the new one.
+func prepareOutput(dir, marker string, overwrite bool, stale ...string) (*output, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(filepath.Join(dir, marker)); err == nil {
+		if !overwrite {
+			return nil, fmt.Errorf("%w: %s", ErrOutputExists, filepath.Join(dir, marker))
+		}
+		for _, pattern := range append([]string{marker}, stale...) {
+			matches, err := filepath.Glob(filepath.Join(dir, pattern))
+			if err != nil {
+				return nil, err
+			}
+			for _, path := range matches {
+				if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+					return nil, fmt.Errorf("failed to remove the old package: %w", err)
+				}
+			}
+		}
+	}
+	return &output{dir: dir}, nil
+}
+
+// create opens name in the package folder for writing.
+func (o *output) create(name string) (*os.File, error) {
+	path := filepath.Join(o.dir, name)
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	o.created = append(o.created, path)
+	return f, nil
+}
+
+// writeFile writes a small file such as a playlist into the package folder.
+func (o *output) writeFile(name string, data []byte) error {
+	f, err := o.create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// discard removes every file written so far.
+func (o *output) discard() {
+	for _, path := range o.created {
+		os.Remove(path)
+	}
+}
+
+// checkCanceled returns the context error once the run was canceled.
+func checkCanceled(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("packaging canceled: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,529 @@
+// Package queue runs batches of optimizations with a bounded number of
+// workers. The queue can be paused and is persisted to disk, so a batch that
+// was interrupted by a restart picks up where it left off.
+package queue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultWorkers runs one job at a time: each in-place optimization needs room
+// for a full temporary copy, so serial runs keep the disk usage down. SSDs with
+// plenty of space can use more.
+const DefaultWorkers = 1
+
+// DefaultKeepFinished is how long finished jobs stay in the queue, so the
+// persisted state does not grow without bound.
+const DefaultKeepFinished = 7 * 24 * time.Hour
+
+// State is the lifecycle state of a job.
+type State string
+
+// Job states
+const (
+	Queued   State = "queued"
+	Running  State = "running"
+	Done     State = "done"
+	Failed   State = "failed"
+	Canceled State = "canceled"
+)
+
+// Finished reports whether s is a final state.
+func (s State) Finished() bool {
+	return s == Done || s == Failed || s == Canceled
+}
+
+// Options are the per-job settings chosen at enqueue time.
+type Options struct {
+	// OutputDir receives a copy with the same name; empty means optimize in place
+	OutputDir string `json:"outputDir,omitempty"`
+	// Overwrite decides what to do when the copy already exists: "fail", "replace" or "rename"
+	Overwrite string `json:"overwrite,omitempty"`
+	// Defragment merges fragmented (fMP4) input into a progressive fast-start file
+	Defragment bool `json:"defragment,omitempty"`
+}
+
+// Job is one queued optimization.
+type Job struct {
+	ID       string     `json:"id"`
+	Path     string     `json:"path"`
+	Options  Options    `json:"options"`
+	State    State      `json:"state"`
+	Progress float64    `json:"progress"`
+	Message  string     `json:"message,omitempty"`
+	Output   string     `json:"output,omitempty"`
+	Error    string     `json:"error,omitempty"`
+	Added    time.Time  `json:"added"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+// RunFunc performs one job. It reports progress through progress and returns
+// the written path. It must stop promptly once ctx is canceled.
+type RunFunc func(ctx context.Context, job Job, progress func(float64, string)) (string, error)
+
+// Config configures a Queue.
+type Config struct {
+	// Workers is the number of jobs run at once. Zero means DefaultWorkers.
+	Workers int
+	// StatePath is the file the queue is persisted to. Empty disables persistence.
+	StatePath string
+	// KeepFinished drops finished jobs once they are older than this. Zero
+	// means DefaultKeepFinished.
+	KeepFinished time.Duration
+	// Run performs a job
+	Run RunFunc
+	// OnChange receives a copy of a job whenever its state or progress changes. It may be nil.
+	OnChange func(Job)
+	// Log receives diagnostics. It may be nil.
+	Log func(string)
+}
+
+// Queue schedules jobs onto workers.
+type Queue struct {
+	cfg     Config
+	mu      sync.Mutex
+	jobs    []*Job
+	cancels map[string]context.CancelFunc
+	workers int
+	paused  bool
+	running int
+	closed  bool
+	wake    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// persisted is the on-disk form of the queue.
+type persisted struct {
+	Workers int    `json:"workers"`
+	Paused  bool   `json:"paused"`
+	Jobs    []*Job `json:"jobs"`
+}
+
+// New creates a queue and restores the persisted one, if any. Jobs that were
+// running when the previous process stopped are queued again.
+func New(cfg Config) (*Queue, error) {
+	if cfg.Run == nil {
+		return nil, errors.New("queue needs a run function")
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = DefaultWorkers
+	}
+	if cfg.KeepFinished <= 0 {
+		cfg.KeepFinished = DefaultKeepFinished
+	}
+	q := &Queue{
+		cfg:     cfg,
+		cancels: make(map[string]context.CancelFunc),
+		workers: cfg.Workers,
+		wake:    make(chan struct{}, 1),
+	}
+	if cfg.StatePath != "" {
+		if err := q.load(); err != nil {
+			return nil, err
+		}
+	}
+	return q, nil
+}
+
+// Start runs the scheduler until ctx is canceled or Close is called.
+func (q *Queue) Start(ctx context.Context) {
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+		for {
+			q.schedule()
+			select {
+			case <-ctx.Done():
+				q.interrupt()
+				return
+			case <-q.wake:
+				q.mu.Lock()
+				closed := q.closed
+				q.mu.Unlock()
+				if closed {
+					return
+				}
+			}
+		}
+	}()
+}
+
+// Close stops the queue. Running jobs are interrupted and stay queued in the
+// persisted state, so they run again after a restart. It waits for them to
+// clean up.
+func (q *Queue) Close() {
+	q.interrupt()
+	q.signal()
+	q.wg.Wait()
+}
+
+// interrupt marks the queue closed and cancels running jobs without
+// finishing them.
+func (q *Queue) interrupt() {
+	q.mu.Lock()
+	q.closed = true
+	for _, cancel := range q.cancels {
+		cancel()
+	}
+	q.mu.Unlock()
+}
+
+// Enqueue adds a job per path. Paths that are already queued or running are
+// skipped. It returns the new jobs.
+func (q *Queue) Enqueue(paths []string, opts Options) ([]Job, error) {
+	q.mu.Lock()
+	pending := make(map[string]bool)
+	for _, j := range q.jobs {
+		if !j.State.Finished() {
+			pending[j.Path] = true
+		}
+	}
+	var added []Job
+	for _, path := range paths {
+		if pending[path] {
+			continue
+		}
+		id := make([]byte, 8)
+		if _, err := rand.Read(id); err != nil {
+			q.mu.Unlock()
+			return nil, err
+		}
+		j := &Job{ID: hex.EncodeToString(id), Path: path, Options: opts, State: Queued, Added: time.Now()}
+		q.jobs = append(q.jobs, j)
+		pending[path] = true
+		added = append(added, *j)
+	}
+	q.saveLocked()
+	q.mu.Unlock()
+
+	for _, j := range added {
+		q.notify(j)
+	}
+	q.signal()
+	return added, nil
+}
+
+// Jobs returns a copy of every job in queue order.
+func (q *Queue) Jobs() []Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	jobs := make([]Job, len(q.jobs))
+	for i, j := range q.jobs {
+		jobs[i] = *j
+	}
+	return jobs
+}
+
+// Active returns the number of running jobs.
+func (q *Queue) Active() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.running
+}
+
+// Pending returns the number of queued and running jobs.
+func (q *Queue) Pending() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := 0
+	for _, j := range q.jobs {
+		if !j.State.Finished() {
+			n++
+		}
+	}
+	return n
+}
+
+// Pause stops starting new jobs. Running jobs finish normally.
+func (q *Queue) Pause() {
+	q.mu.Lock()
+	q.paused = true
+	q.saveLocked()
+	q.mu.Unlock()
+}
+
+// Resume starts queued jobs again.
+func (q *Queue) Resume() {
+	q.mu.Lock()
+	q.paused = false
+	q.saveLocked()
+	q.mu.Unlock()
+	q.signal()
+}
+
+// Paused reports whether the queue is paused.
+func (q *Queue) Paused() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.paused
+}
+
+// SetWorkers changes the number of jobs run at once. Lowering it lets running
+// jobs finish; it does not interrupt them.
+func (q *Queue) SetWorkers(n int) {
+	if n <= 0 {
+		n = DefaultWorkers
+	}
+	q.mu.Lock()
+	q.workers = n
+	q.saveLocked()
+	q.mu.Unlock()
+	q.signal()
+}
+
+// Workers returns the number of jobs run at once.
+func (q *Queue) Workers() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.workers
+}
+
+// Cancel cancels a queued job, or stops a running one. It reports false when
+// the job is unknown or already finished.
+func (q *Queue) Cancel(id string) bool {
+	q.mu.Lock()
+	j := q.find(id)
+	if j == nil || j.State.Finished() {
+		q.mu.Unlock()
+		return false
+	}
+	if cancel, ok := q.cancels[id]; ok {
+		// The worker records the outcome
+		cancel()
+		q.mu.Unlock()
+		return true
+	}
+	now := time.Now()
+	j.State, j.Finished = Canceled, &now
+	snapshot := *j
+	q.saveLocked()
+	q.mu.Unlock()
+	q.notify(snapshot)
+	return true
+}
+
+// CancelPath cancels the queued or running job of path. It reports false when
+// there is none.
+func (q *Queue) CancelPath(path string) bool {
+	for _, j := range q.Jobs() {
+		if j.Path == path && !j.State.Finished() {
+			return q.Cancel(j.ID)
+		}
+	}
+	return false
+}
+
+// CancelAll cancels every queued and running job.
+func (q *Queue) CancelAll() {
+	for _, j := range q.Jobs() {
+		if !j.State.Finished() {
+			q.Cancel(j.ID)
+		}
+	}
+}
+
+// Retry queues a failed or canceled job again.
+func (q *Queue) Retry(id string) bool {
+	q.mu.Lock()
+	j := q.find(id)
+	if j == nil || (j.State != Failed && j.State != Canceled) {
+		q.mu.Unlock()
+		return false
+	}
+	j.State, j.Progress, j.Message, j.Error = Queued, 0, "", ""
+	j.Started, j.Finished = nil, nil
+	snapshot := *j
+	q.saveLocked()
+	q.mu.Unlock()
+	q.notify(snapshot)
+	q.signal()
+	return true
+}
+
+// RemoveFinished forgets every finished job.
+func (q *Queue) RemoveFinished() {
+	q.mu.Lock()
+	q.removeFinishedLocked(time.Now())
+	q.saveLocked()
+	q.mu.Unlock()
+}
+
+// removeFinishedLocked forgets the jobs that finished before cutoff. The
+// caller holds q.mu.
+func (q *Queue) removeFinishedLocked(cutoff time.Time) {
+	kept := q.jobs[:0]
+	for _, j := range q.jobs {
+		if !j.State.Finished() || (j.Finished != nil && j.Finished.After(cutoff)) {
+			kept = append(kept, j)
+		}
+	}
+	clear(q.jobs[len(kept):])
+	q.jobs = kept
+}
+
+// schedule starts queued jobs while workers are free.
+func (q *Queue) schedule() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for !q.closed && !q.paused && q.running < q.workers {
+		var next *Job
+		for _, j := range q.jobs {
+			if j.State == Queued {
+				next = j
+				break
+			}
+		}
+		if next == nil {
+			return
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		now := time.Now()
+		next.State, next.Started, next.Progress, next.Message = Running, &now, 0, ""
+		q.cancels[next.ID] = cancel
+		q.running++
+		q.saveLocked()
+		snapshot := *next
+
+		q.wg.Add(1)
+		go func() {
+			defer q.wg.Done()
+			q.notify(snapshot)
+			q.run(ctx, cancel, next)
+		}()
+	}
+}
+
+// run performs a job on a worker and records the outcome.
+func (q *Queue) run(ctx context.Context, cancel context.CancelFunc, j *Job) {
+	q.mu.Lock()
+	job := *j
+	q.mu.Unlock()
+
+	out, err := q.cfg.Run(ctx, job, func(progress float64, message string) {
+		q.mu.Lock()
+		j.Progress, j.Message = progress, message
+		snapshot := *j
+		q.mu.Unlock()
+		q.notify(snapshot)
+	})
+	stopped := ctx.Err() != nil
+	cancel()
+
+	q.mu.Lock()
+	delete(q.cancels, j.ID)
+	q.running--
+	now := time.Now()
+	switch {
+	case err == nil:
+		// A job that finished while shutting down is done, not interrupted
+		j.State, j.Output, j.Finished = Done, out, &now
+	case q.closed && stopped:
+		// Interrupted by shutdown: run it again next time
+		j.State, j.Started, j.Progress, j.Message = Queued, nil, 0, ""
+	case stopped:
+		j.State, j.Error, j.Finished = Canceled, err.Error(), &now
+	default:
+		j.State, j.Error, j.Finished = Failed, err.Error(), &now
+	}
+	snapshot := *j
+	q.removeFinishedLocked(now.Add(-q.cfg.KeepFinished))
+	q.saveLocked()
+	q.mu.Unlock()
+
+	q.notify(snapshot)
+	q.signal()
+}
+
+// find returns the job with the given ID. The caller holds q.mu.
+func (q *Queue) find(id string) *Job {
+	for _, j := range q.jobs {
+		if j.ID == id {
+			return j
+		}
+	}
+	return nil
+}
+
+func (q *Queue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *Queue) notify(j Job) {
+	if q.cfg.OnChange != nil {
+		q.cfg.OnChange(j)
+	}
+}
+
+func (q *Queue) logf(format string, args ...any) {
+	if q.cfg.Log != nil {
+		q.cfg.Log(fmt.Sprintf(format, args...))
+	}
+}
+
+// load restores the persisted queue. A missing file is an empty queue.
+func (q *Queue) load() error {
+	data, err := os.ReadFile(q.cfg.StatePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var p persisted
+	if err := json.Unmarshal(data, &p); err != nil {
+		// A damaged state file must not keep the app from starting
+		q.logf("[Queue] Ignoring unreadable state %s: %v", q.cfg.StatePath, err)
+		return nil
+	}
+	for _, j := range p.Jobs {
+		if j.State == Running {
+			j.State, j.Started, j.Progress, j.Message = Queued, nil, 0, ""
+		}
+	}
+	q.jobs = p.Jobs
+	q.removeFinishedLocked(time.Now().Add(-q.cfg.KeepFinished))
+	q.paused = p.Paused
+	if p.Workers > 0 {
+		q.workers = p.Workers
+	}
+	return nil
+}
+
+// saveLocked writes the queue to disk via a temp file and rename, so a crash
+// never leaves a half-written state. The caller holds q.mu.
+func (q *Queue) saveLocked() {
+	if q.cfg.StatePath == "" {
+		return
+	}
+	data, err := json.MarshalIndent(persisted{Workers: q.workers, Paused: q.paused, Jobs: q.jobs}, "", "  ")
+	if err != nil {
+		q.logf("[Queue] Failed to encode state: %v", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(q.cfg.StatePath), 0755); err != nil {
+		q.logf("[Queue] Failed to save state: %v", err)
+		return
+	}
+	tmp := q.cfg.StatePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		q.logf("[Queue] Failed to save state: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, q.cfg.StatePath); err != nil {
+		q.logf("[Queue] Failed to save state: %v", err)
+	}
+}
@@ -0,0 +1,237 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitFor polls cond until it holds or the test times out.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatalf("Timed out waiting for %s", what)
+}
+
+func states(q *Queue) map[string]State {
+	m := make(map[string]State)
+	for _, j := range q.Jobs() {
+		m[j.Path] = j.State
+	}
+	return m
+}
+
+func TestQueueWorkersAndPause(t *testing.T) {
+	var mu sync.Mutex
+	running, peak := 0, 0
+	release := make(chan struct{})
+	q, err := New(Config{
+		Workers: 2,
+		Run: func(ctx context.Context, job Job, progress func(float64, string)) (string, error) {
+			mu.Lock()
+			running++
+			peak = max(peak, running)
+			mu.Unlock()
+			defer func() {
+				mu.Lock()
+				running--
+				mu.Unlock()
+			}()
+			select {
+			case <-release:
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+			if job.Path == "bad.mp4" {
+				return "", errors.New("broken")
+			}
+			return job.Path, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Start(context.Background())
+	defer q.Close()
+
+	q.Pause()
+	if _, err := q.Enqueue([]string{"a.mp4", "b.mp4", "c.mp4", "bad.mp4", "a.mp4"}, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(q.Jobs()); n != 4 {
+		t.Fatalf("Expected duplicates to be skipped, got %d jobs", n)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if q.Active() != 0 {
+		t.Fatalf("Expected a paused queue not to start jobs")
+	}
+
+	// Cancelling a queued job never runs it
+	for _, j := range q.Jobs() {
+		if j.Path == "c.mp4" && !q.Cancel(j.ID) {
+			t.Fatalf("Cancel of a queued job failed")
+		}
+	}
+
+	q.Resume()
+	waitFor(t, "two running jobs", func() bool { return q.Active() == 2 })
+	close(release)
+	waitFor(t, "all jobs to finish", func() bool { return q.Pending() == 0 })
+
+	want := map[string]State{"a.mp4": Done, "b.mp4": Done, "c.mp4": Canceled, "bad.mp4": Failed}
+	for path, state := range states(q) {
+		if state != want[path] {
+			t.Errorf("%s: expected %s, got %s", path, want[path], state)
+		}
+	}
+	if peak != 2 {
+		t.Errorf("Expected at most 2 concurrent jobs, saw %d", peak)
+	}
+}
+
+func TestQueueResumesAfterRestart(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "queue.json")
+	started := make(chan string, 4)
+	block := func(ctx context.Context, job Job, progress func(float64, string)) (string, error) {
+		started <- job.Path
+		<-ctx.Done()
+		return "", ctx.Err()
+	}
+
+	q, err := New(Config{StatePath: statePath, Run: block})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Start(context.Background())
+	q.Enqueue([]string{"a.mp4", "b.mp4"}, Options{OutputDir: "out"})
+	<-started
+	// The process goes away with a job running and one waiting
+	q.Close()
+
+	var ran []string
+	var mu sync.Mutex
+	q, err = New(Config{StatePath: statePath, Run: func(ctx context.Context, job Job, progress func(float64, string)) (string, error) {
+		mu.Lock()
+		ran = append(ran, job.Path)
+		mu.Unlock()
+		if job.Options.OutputDir != "out" {
+			t.Errorf("Expected the options to survive the restart, got %+v", job.Options)
+		}
+		return job.Path, nil
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := states(q); s["a.mp4"] != Queued || s["b.mp4"] != Queued {
+		t.Fatalf("Expected both jobs queued after restart, got %v", s)
+	}
+	q.Start(context.Background())
+	defer q.Close()
+	waitFor(t, "the resumed batch", func() bool { return q.Pending() == 0 })
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(ran) != 2 || ran[0] != "a.mp4" || ran[1] != "b.mp4" {
+		t.Errorf("Expected a.mp4 then b.mp4 to run, got %v", ran)
+	}
+}
+
+func TestQueueKeepsJobsFinishedDuringShutdown(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "queue.json")
+	started := make(chan struct{})
+	q, err := New(Config{StatePath: statePath, Run: func(ctx context.Context, job Job, progress func(float64, string)) (string, error) {
+		close(started)
+		// The file is already in place when the cancel arrives
+		<-ctx.Done()
+		return job.Path, nil
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Start(context.Background())
+	q.Enqueue([]string{"a.mp4"}, Options{})
+	<-started
+	q.Close()
+
+	if s := states(q); s["a.mp4"] != Done {
+		t.Fatalf("Expected a job that succeeded during shutdown to be done, got %v", s)
+	}
+	q, err = New(Config{StatePath: statePath, Run: func(ctx context.Context, job Job, progress func(float64, string)) (string, error) {
+		t.Errorf("Expected %s not to run again", job.Path)
+		return job.Path, nil
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := states(q); s["a.mp4"] != Done {
+		t.Errorf("Expected the job to stay done after restart, got %v", s)
+	}
+}
+
+func TestQueuePrunesOldFinishedJobs(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "queue.json")
+	q, err := New(Config{StatePath: statePath, Run: func(ctx context.Context, job Job, progress func(float64, string)) (string, error) {
+		return job.Path, nil
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Start(context.Background())
+	q.Enqueue([]string{"a.mp4"}, Options{})
+	waitFor(t, "the job", func() bool { return q.Pending() == 0 })
+	// A queued job is never pruned, however old
+	q.Pause()
+	q.Enqueue([]string{"b.mp4"}, Options{})
+	q.Close()
+
+	// Reloading keeps the recent job, and drops it once it is older than KeepFinished
+	q, err = New(Config{StatePath: statePath, Run: q.cfg.Run})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := states(q); s["a.mp4"] != Done || s["b.mp4"] != Queued {
+		t.Fatalf("Expected a recent finished job to be kept, got %v", s)
+	}
+	time.Sleep(10 * time.Millisecond)
+	q, err = New(Config{StatePath: statePath, KeepFinished: time.Millisecond, Run: q.cfg.Run})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := states(q); len(s) != 1 || s["b.mp4"] != Queued {
+		t.Errorf("Expected only the queued job to remain, got %v", s)
+	}
+}
+
+func TestQueueCancelPath(t *testing.T) {
+	started := make(chan struct{})
+	q, err := New(Config{Run: func(ctx context.Context, job Job, progress func(float64, string)) (string, error) {
+		close(started)
+		<-ctx.Done()
+		return "", ctx.Err()
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Start(context.Background())
+	defer q.Close()
+	q.Enqueue([]string{"a.mp4", "b.mp4"}, Options{})
+	<-started
+
+	if !q.CancelPath("a.mp4") || !q.CancelPath("b.mp4") {
+		t.Fatal("Expected the running and the queued job to be canceled")
+	}
+	waitFor(t, "the canceled jobs", func() bool { return q.Pending() == 0 && q.Active() == 0 })
+	if s := states(q); s["a.mp4"] != Canceled || s["b.mp4"] != Canceled {
+		t.Errorf("Expected both jobs canceled, got %v", s)
+	}
+	if q.CancelPath("a.mp4") || q.CancelPath("unknown.mp4") {
+		t.Error("Expected CancelPath to report false without an unfinished job")
+	}
+}
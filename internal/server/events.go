@@ -0,0 +1,98 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// progressEventName is the SSE event name, matching the event the GUI receives.
+const progressEventName = "optimize-progress"
+
+// heartbeatInterval keeps idle SSE connections from being closed by proxies.
+const heartbeatInterval = 15 * time.Second
+
+// ProgressEvent mirrors bridge.ProgressEvent, the payload of the GUI's
+// optimize-progress events.
+type ProgressEvent struct {
+	Job      string  `json:"job"`
+	Path     string  `json:"path"`
+	Progress float64 `json:"progress"`
+	Message  string  `json:"message"`
+	// Verification is "passed" or "failed" on the final event when content verification ran
+	Verification string `json:"verification,omitempty"`
+}
+
+// broker fans progress events out to SSE subscribers. Slow subscribers miss
+// events rather than stalling the optimizer.
+type broker struct {
+	mu   sync.Mutex
+	subs map[chan ProgressEvent]struct{}
+}
+
+func newBroker() *broker {
+	return &broker{subs: make(map[chan ProgressEvent]struct{})}
+}
+
+func (b *broker) subscribe() chan ProgressEvent {
+	ch := make(chan ProgressEvent, 64)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch
+}
+
+func (b *broker) unsubscribe(ch chan ProgressEvent) {
+	b.mu.Lock()
+	delete(b.subs, ch)
+	b.mu.Unlock()
+}
+
+func (b *broker) publish(e ProgressEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// handleEvents streams progress events as Server-Sent Events until the client
+// disconnects.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming not supported"))
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ch := s.events.subscribe()
+	defer s.events.unsubscribe(ch)
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case e := <-ch:
+			data, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", progressEventName, data)
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,275 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"mp4-optimizer/internal/analyzer"
+	"mp4-optimizer/internal/optimizer"
+)
+
+// Job statuses
+const (
+	JobRunning  = "running"
+	JobDone     = "done"
+	JobFailed   = "failed"
+	JobCanceled = "canceled"
+	JobRejected = "rejected" // fragmented input without defragment
+	JobSkipped  = "skipped"  // already fast-start, left untouched
+)
+
+// Finished jobs are kept for status queries within these limits
+const (
+	maxKeptJobs  = 1000
+	jobRetention = 24 * time.Hour
+)
+
+// JobRequest is the body of POST /api/jobs.
+type JobRequest struct {
+	Path string `json:"path"`
+	// OutputDir receives a copy with the same name; empty means optimize in place
+	OutputDir string `json:"outputDir,omitempty"`
+	// Overwrite is "fail", "replace" or "rename" for copies that already exist
+	Overwrite string `json:"overwrite,omitempty"`
+	// Safety is "temp" or "backup" for in-place runs
+	Safety         string `json:"safety,omitempty"`
+	KeepBackupDays int    `json:"keepBackupDays,omitempty"`
+	// Verify compares the media sample by sample; it defaults to true
+	Verify *bool `json:"verify,omitempty"`
+	// Defragment merges fragmented (fMP4) input into a progressive fast-start file
+	Defragment bool `json:"defragment,omitempty"`
+}
+
+// Job is the state of one optimization.
+type Job struct {
+	ID           string     `json:"id"`
+	Path         string     `json:"path"`
+	Status       string     `json:"status"`
+	Progress     float64    `json:"progress"`
+	Message      string     `json:"message,omitempty"`
+	Output       string     `json:"output,omitempty"`
+	Error        string     `json:"error,omitempty"`
+	Verification string     `json:"verification,omitempty"`
+	Created      time.Time  `json:"created"`
+	Finished     *time.Time `json:"finished,omitempty"`
+
+	cancel context.CancelFunc
+}
+
+// ErrBusy is returned when a job for the same file is still running.
+var ErrBusy = errors.New("file is already being optimized")
+
+// jobManager runs optimizations and keeps their state for status queries.
+type jobManager struct {
+	mu     sync.Mutex
+	jobs   map[string]*Job
+	events *broker
+	wg     sync.WaitGroup
+}
+
+func newJobManager(events *broker) *jobManager {
+	return &jobManager{jobs: make(map[string]*Job), events: events}
+}
+
+// submit validates req and starts the optimization in the background.
+func (m *jobManager) submit(req JobRequest) (*Job, error) {
+	if req.Path == "" {
+		return nil, fmt.Errorf("path is required")
+	}
+	switch optimizer.OverwritePolicy(req.Overwrite) {
+	case "", optimizer.OverwriteFail, optimizer.OverwriteReplace, optimizer.OverwriteRename:
+	default:
+		return nil, fmt.Errorf("invalid overwrite %q", req.Overwrite)
+	}
+	switch optimizer.SafetyMode(req.Safety) {
+	case "", optimizer.SafetyTemp, optimizer.SafetyBackup:
+	default:
+		return nil, fmt.Errorf("invalid safety %q", req.Safety)
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{
+		ID:      hex.EncodeToString(id),
+		Path:    req.Path,
+		Status:  JobRunning,
+		Created: time.Now(),
+		cancel:  cancel,
+	}
+
+	m.mu.Lock()
+	for _, j := range m.jobs {
+		if j.Path == req.Path && j.Status == JobRunning {
+			m.mu.Unlock()
+			cancel()
+			return nil, ErrBusy
+		}
+	}
+	m.prune()
+	m.jobs[job.ID] = job
+	m.mu.Unlock()
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer cancel()
+		m.run(ctx, job, req)
+	}()
+	return m.snapshot(job), nil
+}
+
+// run performs the optimization and records the outcome.
+func (m *jobManager) run(ctx context.Context, job *Job, req JobRequest) {
+	verify := req.Verify == nil || *req.Verify
+	report := func(progress float64, message, verification string) {
+		m.mu.Lock()
+		job.Progress, job.Message = progress, message
+		if verification != "" {
+			job.Verification = verification
+		}
+		m.mu.Unlock()
+		m.events.publish(ProgressEvent{
+			Job:          job.ID,
+			Path:         job.Path,
+			Progress:     progress,
+			Message:      message,
+			Verification: verification,
+		})
+	}
+
+	// Like the CLI, never rewrite a file that already streams
+	st, err := analyzer.CheckStructure(req.Path)
+	if err == nil && st.Layout == analyzer.LayoutFastStart {
+		report(100, "已是快速启动格式，已跳过", "")
+		now := time.Now()
+		m.mu.Lock()
+		job.Status, job.Output, job.Finished = JobSkipped, req.Path, &now
+		m.mu.Unlock()
+		return
+	}
+
+	run := optimizer.OptimizeWithOptions
+	if req.Defragment && err == nil && st.Layout == analyzer.LayoutFragmented {
+		run = optimizer.Defragment
+	}
+	out, err := run(ctx, optimizer.Options{
+		InputPath:      req.Path,
+		OutputDir:      req.OutputDir,
+		Overwrite:      optimizer.OverwritePolicy(req.Overwrite),
+		Safety:         optimizer.SafetyMode(req.Safety),
+		KeepBackupDays: req.KeepBackupDays,
+		Verify:         verify,
+		Progress: func(progress float64, message string) {
+			report(progress, message, "")
+		},
+	})
+
+	// Same final events as the GUI: the verification outcome, or the cancellation
+	var canceled *optimizer.CanceledError
+	switch {
+	case verify && err == nil:
+		report(100, "校验通过", "passed")
+	case errors.Is(err, optimizer.ErrVerifyFailed):
+		report(100, err.Error(), "failed")
+	case errors.As(err, &canceled):
+		report(0, "已取消", "")
+	}
+
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job.Finished = &now
+	switch {
+	case err == nil:
+		job.Status, job.Output = JobDone, out
+	case errors.As(err, &canceled):
+		job.Status, job.Error = JobCanceled, err.Error()
+	case errors.Is(err, optimizer.ErrFragmented):
+		job.Status, job.Error = JobRejected, err.Error()
+	default:
+		job.Status, job.Error = JobFailed, err.Error()
+	}
+}
+
+// get returns a copy of a job's state.
+func (m *jobManager) get(id string) (*Job, bool) {
+	m.mu.Lock()
+	job, ok := m.jobs[id]
+	m.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	return m.snapshot(job), true
+}
+
+// list returns every job, newest first.
+func (m *jobManager) list() []*Job {
+	m.mu.Lock()
+	jobs := make([]*Job, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		c := *j
+		jobs = append(jobs, &c)
+	}
+	m.mu.Unlock()
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].Created.After(jobs[k].Created) })
+	return jobs
+}
+
+// cancel stops a running job. It reports false for unknown jobs.
+func (m *jobManager) cancel(id string) bool {
+	m.mu.Lock()
+	job, ok := m.jobs[id]
+	m.mu.Unlock()
+	if ok {
+		job.cancel()
+	}
+	return ok
+}
+
+// cancelAll stops every running job and waits for them to clean up.
+func (m *jobManager) cancelAll() {
+	m.mu.Lock()
+	for _, j := range m.jobs {
+		j.cancel()
+	}
+	m.mu.Unlock()
+	m.wg.Wait()
+}
+
+func (m *jobManager) snapshot(job *Job) *Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c := *job
+	return &c
+}
+
+// prune forgets finished jobs past their retention, and the oldest finished
+// ones beyond maxKeptJobs. The caller holds m.mu.
+func (m *jobManager) prune() {
+	var finished []*Job
+	for id, j := range m.jobs {
+		if j.Finished == nil {
+			continue
+		}
+		if time.Since(*j.Finished) > jobRetention {
+			delete(m.jobs, id)
+			continue
+		}
+		finished = append(finished, j)
+	}
+	if excess := len(m.jobs) - maxKeptJobs + 1; excess > 0 {
+		sort.Slice(finished, func(i, k int) bool { return finished[i].Finished.Before(*finished[k].Finished) })
+		for _, j := range finished[:min(excess, len(finished))] {
+			delete(m.jobs, j.ID)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+// Package server exposes the analyzer and optimizer over a local JSON HTTP API,
+// with Server-Sent Events mirroring the GUI's optimize-progress events.
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"mp4-optimizer/internal/analyzer"
+)
+
+// DefaultAddr binds to the loopback interface only.
+const DefaultAddr = "127.0.0.1:8765"
+
+// Config configures a Server.
+type Config struct {
+	// Addr is the listen address. Empty means DefaultAddr.
+	Addr string
+	// Token must accompany every request, as "Authorization: Bearer <token>" or
+	// a "token" query parameter (for EventSource). Empty generates a random one.
+	Token string
+	// Log receives diagnostics. It may be nil.
+	Log *log.Logger
+}
+
+// Server is an http.Handler serving the API. It can be mounted into another
+// server or run on its own with ListenAndServe.
+type Server struct {
+	addr   string
+	token  string
+	log    *log.Logger
+	mux    *http.ServeMux
+	events *broker
+	jobs   *jobManager
+}
+
+// New creates a Server.
+func New(cfg Config) (*Server, error) {
+	if cfg.Addr == "" {
+		cfg.Addr = DefaultAddr
+	}
+	if cfg.Token == "" {
+		buf := make([]byte, 16)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		cfg.Token = hex.EncodeToString(buf)
+	}
+	s := &Server{
+		addr:   cfg.Addr,
+		token:  cfg.Token,
+		log:    cfg.Log,
+		mux:    http.NewServeMux(),
+		events: newBroker(),
+	}
+	s.jobs = newJobManager(s.events)
+
+	s.mux.HandleFunc("GET /api/check", s.handleCheck)
+	s.mux.HandleFunc("GET /api/metadata", s.handleMetadata)
+	s.mux.HandleFunc("GET /api/validate", s.handleValidate)
+	s.mux.HandleFunc("GET /api/jobs", s.handleListJobs)
+	s.mux.HandleFunc("POST /api/jobs", s.handleSubmitJob)
+	s.mux.HandleFunc("GET /api/jobs/{id}", s.handleGetJob)
+	s.mux.HandleFunc("DELETE /api/jobs/{id}", s.handleCancelJob)
+	s.mux.HandleFunc("GET /api/events", s.handleEvents)
+	return s, nil
+}
+
+// Token returns the token clients must send.
+func (s *Server) Token() string {
+	return s.token
+}
+
+// Addr returns the configured listen address.
+func (s *Server) Addr() string {
+	return s.addr
+}
+
+// ServeHTTP authenticates the request and dispatches it.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		writeError(w, http.StatusUnauthorized, errors.New("missing or invalid token"))
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	token := r.URL.Query().Get("token")
+	if auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		token = auth
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1
+}
+
+// ListenAndServe serves until ctx is canceled, then cancels running jobs so
+// their temp files are removed before it returns.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	ln, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: s, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	s.logf("API listening on http://%s", ln.Addr())
+	err = srv.Serve(ln)
+	s.jobs.cancelAll()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Close cancels running jobs and waits for them, for servers mounted elsewhere.
+func (s *Server) Close() {
+	s.jobs.cancelAll()
+}
+
+func (s *Server) logf(format string, args ...any) {
+	if s.log != nil {
+		s.log.Printf(format, args...)
+	}
+}
+
+// pathParam returns the required "path" query parameter.
+func pathParam(w http.ResponseWriter, r *http.Request) (string, bool) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		writeError(w, http.StatusBadRequest, errors.New("path is required"))
+		return "", false
+	}
+	return path, true
+}
+
+// CheckResult is the response of GET /api/check.
+type CheckResult struct {
+	Path      string              `json:"path"`
+	FastStart bool                `json:"fastStart"`
+	Structure *analyzer.Structure `json:"structure"`
+}
+
+func (s *Server) handleCheck(w http.ResponseWriter, r *http.Request) {
+	path, ok := pathParam(w, r)
+	if !ok {
+		return
+	}
+	st, err := analyzer.CheckStructure(path)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, CheckResult{Path: path, FastStart: st.Layout != analyzer.LayoutNeedsOptimize, Structure: st})
+}
+
+func (s *Server) handleMetadata(w http.ResponseWriter, r *http.Request) {
+	path, ok := pathParam(w, r)
+	if !ok {
+		return
+	}
+	meta, err := analyzer.GetMetadata(path)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, meta)
+}
+
+// ValidateResult is the response of GET /api/validate.
+type ValidateResult struct {
+	Path     string `json:"path"`
+	Complete bool   `json:"complete"`
+}
+
+func (s *Server) handleValidate(w http.ResponseWriter, r *http.Request) {
+	path, ok := pathParam(w, r)
+	if !ok {
+		return
+	}
+	complete, err := analyzer.ValidateFile(path)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ValidateResult{Path: path, Complete: complete})
+}
+
+func (s *Server) handleListJobs(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.jobs.list())
+}
+
+func (s *Server) handleSubmitJob(w http.ResponseWriter, r *http.Request) {
+	var req JobRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	job, err := s.jobs.submit(req)
+	if errors.Is(err, ErrBusy) {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	s.logf("Job %s: optimizing %s", job.ID, job.Path)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func (s *Server) handleGetJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.jobs.get(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("unknown job"))
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+func (s *Server) handleCancelJob(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !s.jobs.cancel(id) {
+		writeError(w, http.StatusNotFound, errors.New("unknown job"))
+		return
+	}
+	job, _ := s.jobs.get(id)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
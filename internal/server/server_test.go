@@ -0,0 +1,177 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func box(typ string, body ...[]byte) []byte {
+	payload := bytes.Join(body, nil)
+	buf := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(8+len(payload)))
+	copy(buf[4:8], typ)
+	return append(buf, payload...)
+}
+
+func u32s(vals ...uint32) []byte {
+	buf := make([]byte, 4*len(vals))
+	for i, v := range vals {
+		binary.BigEndian.PutUint32(buf[i*4:], v)
+	}
+	return buf
+}
+
+// slowStartFile builds [ftyp][mdat][moov] with one video track of two samples.
+func slowStartFile() []byte {
+	ftyp := box("ftyp", []byte("isom"), u32s(0x200), []byte("isom"))
+	mdat := box("mdat", bytes.Repeat([]byte{0xAA}, 16), bytes.Repeat([]byte{0xBB}, 16))
+	start := uint32(len(ftyp) + 8)
+	tkhd := make([]byte, 84)
+	binary.BigEndian.PutUint32(tkhd[12:16], 1)
+	hdlr := make([]byte, 24)
+	copy(hdlr[8:12], "vide")
+	stbl := box("stbl",
+		box("stsd", u32s(0, 0)),
+		box("stts", u32s(0, 1, 2, 40)),
+		box("stsc", u32s(0, 1, 1, 1, 1)),
+		box("stsz", u32s(0, 16, 2)),
+		box("stco", u32s(0, 2, start, start+16)),
+	)
+	trak := box("trak", box("tkhd", tkhd), box("mdia", box("mdhd", u32s(0, 0, 0, 1000, 80, 0)), box("hdlr", hdlr), box("minf", stbl)))
+	moov := box("moov", box("mvhd", make([]byte, 100)), trak)
+	return bytes.Join([][]byte{ftyp, mdat, moov}, nil)
+}
+
+func TestServer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "slow.mp4")
+	if err := os.WriteFile(path, slowStartFile(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(Config{Token: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	call := func(method, url string, body any, out any) int {
+		t.Helper()
+		var buf bytes.Buffer
+		if body != nil {
+			json.NewEncoder(&buf).Encode(body)
+		}
+		req, _ := http.NewRequest(method, ts.URL+url, &buf)
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if out != nil {
+			json.NewDecoder(resp.Body).Decode(out)
+		}
+		return resp.StatusCode
+	}
+
+	// Requests without the token are refused
+	resp, err := http.Get(ts.URL + "/api/check?path=" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 without token, got %d", resp.StatusCode)
+	}
+
+	var check CheckResult
+	if code := call("GET", "/api/check?path="+path, nil, &check); code != http.StatusOK || check.FastStart {
+		t.Fatalf("Expected a file needing optimization, got %d %+v", code, check)
+	}
+
+	// Subscribe before submitting so no progress event is missed
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", ts.URL+"/api/events?token=secret", nil)
+	stream, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Body.Close()
+	events := make(chan ProgressEvent)
+	go func() {
+		scanner := bufio.NewScanner(stream.Body)
+		for scanner.Scan() {
+			if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+				var e ProgressEvent
+				json.Unmarshal([]byte(data), &e)
+				events <- e
+			}
+		}
+		close(events)
+	}()
+
+	var job Job
+	if code := call("POST", "/api/jobs", JobRequest{Path: path}, &job); code != http.StatusAccepted || job.ID == "" {
+		t.Fatalf("Expected an accepted job, got %d %+v", code, job)
+	}
+
+	timeout := time.After(5 * time.Second)
+	for passed := false; !passed; {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				t.Fatal("Event stream closed early")
+			}
+			if e.Job != job.ID {
+				t.Fatalf("Unexpected event %+v", e)
+			}
+			passed = e.Verification == "passed"
+		case <-timeout:
+			t.Fatal("Timed out waiting for the verification event")
+		}
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); job.Status == JobRunning && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		call("GET", "/api/jobs/"+job.ID, nil, &job)
+	}
+	if job.Status != JobDone || job.Output != path {
+		t.Fatalf("Expected a finished job, got %+v", job)
+	}
+	if code := call("GET", "/api/check?path="+path, nil, &check); code != http.StatusOK || !check.FastStart {
+		t.Errorf("Expected the file to be fast-start now, got %+v", check)
+	}
+
+	// A second run finds the file fast-start and leaves it alone
+	optimized, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := call("POST", "/api/jobs", JobRequest{Path: path}, &job); code != http.StatusAccepted {
+		t.Fatalf("Expected an accepted job, got %d %+v", code, job)
+	}
+	for deadline := time.Now().Add(5 * time.Second); job.Status == JobRunning && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		call("GET", "/api/jobs/"+job.ID, nil, &job)
+	}
+	if job.Status != JobSkipped || job.Output != path {
+		t.Errorf("Expected the fast-start file to be skipped, got %+v", job)
+	}
+	if after, err := os.ReadFile(path); err != nil || !bytes.Equal(after, optimized) {
+		t.Errorf("Expected the skipped file to be unchanged")
+	}
+	if code := call("DELETE", "/api/jobs/unknown", nil, nil); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown job, got %d", code)
+	}
+}
@@ -0,0 +1,9 @@
+package watcher
+
+// notifier signals that something changed in a watched directory.
+// Scans are still driven by polling; notifications only make them happen sooner.
+type notifier interface {
+	Add(dir string) error
+	Events() <-chan struct{}
+	Close() error
+}
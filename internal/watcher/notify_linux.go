@@ -0,0 +1,80 @@
+//go:build linux
+
+package watcher
+
+import (
+	"os"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// inotifyNotifier wakes the watcher on inotify events
+type inotifyNotifier struct {
+	// fd is the raw descriptor for InotifyAddWatch. Calling file.Fd() instead
+	// would switch it to blocking mode, and Close could no longer unblock Read.
+	fd     int
+	file   *os.File
+	events chan struct{}
+	done   chan struct{}
+	mu     sync.Mutex
+	dirs   map[string]bool
+}
+
+// newNotifier 在 Linux 上使用 inotify 监听目录变化
+func newNotifier() (notifier, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	n := &inotifyNotifier{
+		fd: fd,
+		// A non-blocking fd is served by the runtime poller, so Close unblocks Read
+		file:   os.NewFile(uintptr(fd), "inotify"),
+		events: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+		dirs:   make(map[string]bool),
+	}
+	go n.loop()
+	return n, nil
+}
+
+func (n *inotifyNotifier) Add(dir string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.dirs[dir] {
+		return nil
+	}
+	mask := uint32(unix.IN_CREATE | unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO | unix.IN_MODIFY | unix.IN_DELETE)
+	if _, err := unix.InotifyAddWatch(n.fd, dir, mask); err != nil {
+		return err
+	}
+	n.dirs[dir] = true
+	return nil
+}
+
+func (n *inotifyNotifier) Events() <-chan struct{} {
+	return n.events
+}
+
+// Close stops the notifier and waits for its reader to exit.
+func (n *inotifyNotifier) Close() error {
+	err := n.file.Close()
+	<-n.done
+	return err
+}
+
+func (n *inotifyNotifier) loop() {
+	defer close(n.done)
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		if _, err := n.file.Read(buf); err != nil {
+			return
+		}
+		// The content does not matter, any event triggers a rescan
+		select {
+		case n.events <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package watcher
+
+import "errors"
+
+// newNotifier 非 Linux 平台暂不支持文件系统通知，使用轮询
+func newNotifier() (notifier, error) {
+	return nil, errors.New("not supported on this platform")
+}
@@ -0,0 +1,285 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"mp4-optimizer/internal/analyzer"
+	"mp4-optimizer/internal/discovery"
+	"mp4-optimizer/internal/optimizer"
+)
+
+// Default timings
+const (
+	DefaultInterval  = 5 * time.Second
+	DefaultStableFor = 10 * time.Second
+)
+
+// Config configures a Watcher.
+type Config struct {
+	// Dirs are watched recursively
+	Dirs []string
+	// Interval between scans. File system notifications trigger earlier scans where supported.
+	Interval time.Duration
+	// StableFor is how long size and mtime must stay unchanged before a file is touched
+	StableFor time.Duration
+	// Existing also processes files that are already present when watching starts
+	Existing bool
+	// Extensions are the file extensions processed; nil means discovery.DefaultExtensions
+	Extensions []string
+	// Optimize is the template for each run; InputPath and Progress are filled in per file
+	Optimize optimizer.Options
+	// OnEvent receives the outcome of every processed file. It may be nil.
+	OnEvent func(Event)
+	// Log receives diagnostics. It may be nil.
+	Log *log.Logger
+}
+
+// Event statuses
+const (
+	StatusOptimized = "optimized"
+	StatusSkipped   = "skipped"   // already fast-start
+	StatusTruncated = "truncated" // stable but incomplete; retried when it changes
+	StatusFailed    = "failed"
+)
+
+// Event reports what happened to one file.
+type Event struct {
+	Time   time.Time `json:"time"`
+	Path   string    `json:"path"`
+	Status string    `json:"status"`
+	Output string    `json:"output,omitempty"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// fileState is the size and mtime of a file at one scan.
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// candidate is a file waiting to become stable.
+type candidate struct {
+	state fileState
+	since time.Time
+}
+
+// Watcher monitors folders and optimizes new MP4 files once they are stable.
+type Watcher struct {
+	cfg        Config
+	candidates map[string]candidate
+	handled    map[string]fileState
+	notify     notifier
+	now        func() time.Time
+}
+
+// New creates a Watcher. It does not start watching until Run is called.
+func New(cfg Config) (*Watcher, error) {
+	if len(cfg.Dirs) == 0 {
+		return nil, fmt.Errorf("no directories to watch")
+	}
+	for _, dir := range cfg.Dirs {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("not a directory: %s", dir)
+		}
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultInterval
+	}
+	if cfg.StableFor <= 0 {
+		cfg.StableFor = DefaultStableFor
+	}
+	if cfg.Log == nil {
+		cfg.Log = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	return &Watcher{
+		cfg:        cfg,
+		candidates: make(map[string]candidate),
+		handled:    make(map[string]fileState),
+		now:        time.Now,
+	}, nil
+}
+
+// Run watches until ctx is cancelled. A running optimization is cancelled with it.
+func (w *Watcher) Run(ctx context.Context) error {
+	n, err := newNotifier()
+	if err != nil {
+		w.cfg.Log.Printf("[Watch] File system notifications unavailable, polling every %s: %v", w.cfg.Interval, err)
+	} else {
+		w.notify = n
+		defer n.Close()
+	}
+
+	if !w.cfg.Existing {
+		// Everything already there counts as handled until it changes
+		for path, st := range w.scan() {
+			w.handled[path] = st
+		}
+	}
+	w.cfg.Log.Printf("[Watch] Watching %d folder(s)", len(w.cfg.Dirs))
+
+	ticker := time.NewTicker(w.cfg.Interval)
+	defer ticker.Stop()
+
+	var wake <-chan struct{}
+	if w.notify != nil {
+		wake = w.notify.Events()
+	}
+
+	for {
+		w.Poll(ctx)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		case <-wake:
+			// Let a burst of writes settle before scanning again
+			settle := time.NewTimer(200 * time.Millisecond)
+			select {
+			case <-ctx.Done():
+				settle.Stop()
+				return nil
+			case <-settle.C:
+			}
+		}
+	}
+}
+
+// Poll runs one scan: it updates stability tracking and processes every file
+// that has become stable.
+func (w *Watcher) Poll(ctx context.Context) {
+	now := w.now()
+	current := w.scan()
+
+	for path := range w.candidates {
+		if _, ok := current[path]; !ok {
+			delete(w.candidates, path)
+		}
+	}
+	for path := range w.handled {
+		if _, ok := current[path]; !ok {
+			delete(w.handled, path)
+		}
+	}
+
+	for path, st := range current {
+		if ctx.Err() != nil {
+			return
+		}
+		if h, ok := w.handled[path]; ok && h == st {
+			continue
+		}
+
+		c, ok := w.candidates[path]
+		if !ok || c.state != st {
+			// New or still being written
+			w.candidates[path] = candidate{state: st, since: now}
+			continue
+		}
+		if now.Sub(c.since) < w.cfg.StableFor {
+			continue
+		}
+
+		delete(w.candidates, path)
+		w.process(ctx, path, st)
+	}
+}
+
+// scan returns the state of every media file under the watched folders.
+func (w *Watcher) scan() map[string]fileState {
+	result := discovery.Expand(w.cfg.Dirs, w.cfg.Extensions, nil)
+	if w.notify != nil {
+		for _, dir := range result.Folders {
+			if err := w.notify.Add(dir); err != nil {
+				w.cfg.Log.Printf("[Watch] Failed to watch %s: %v", dir, err)
+			}
+		}
+	}
+
+	states := make(map[string]fileState, len(result.Files))
+	for _, path := range result.Files {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		states[path] = fileState{size: info.Size(), modTime: info.ModTime()}
+	}
+	return states
+}
+
+// process validates and optimizes a stable file.
+func (w *Watcher) process(ctx context.Context, path string, st fileState) {
+	event := Event{Path: path}
+	defer func() {
+		if event.Status == "" {
+			return
+		}
+		event.Time = w.now()
+		if event.Error != "" {
+			w.cfg.Log.Printf("[Watch] %s %s: %s", event.Status, path, event.Error)
+		} else {
+			w.cfg.Log.Printf("[Watch] %s %s", event.Status, path)
+		}
+		if w.cfg.OnEvent != nil {
+			w.cfg.OnEvent(event)
+		}
+	}()
+
+	// Never touch a file that is still incomplete, however quiet it looks
+	complete, err := analyzer.ValidateFile(path)
+	if err != nil || !complete {
+		event.Status = StatusTruncated
+		if err != nil {
+			event.Error = err.Error()
+		}
+		w.handled[path] = st
+		return
+	}
+
+	fast, err := analyzer.CheckFastStart(path)
+	if err != nil {
+		event.Status, event.Error = StatusFailed, err.Error()
+		w.handled[path] = st
+		return
+	}
+	if fast {
+		event.Status = StatusSkipped
+		w.handled[path] = st
+		return
+	}
+
+	opts := w.cfg.Optimize
+	opts.InputPath = path
+	opts.Progress = nil
+	out, err := optimizer.OptimizeWithOptions(ctx, opts)
+	if err != nil {
+		var canceled *optimizer.CanceledError
+		if errors.As(err, &canceled) {
+			// Shutting down; the file will be picked up on the next start
+			event.Status = ""
+			return
+		}
+		event.Status, event.Error = StatusFailed, err.Error()
+		w.handled[path] = st
+		return
+	}
+
+	event.Status, event.Output = StatusOptimized, out
+	// Our own rewrite changes size and mtime; remember the new state so it is not picked up again
+	if info, err := os.Stat(path); err == nil {
+		w.handled[path] = fileState{size: info.Size(), modTime: info.ModTime()}
+	}
+	if out != path {
+		if info, err := os.Stat(out); err == nil {
+			w.handled[out] = fileState{size: info.Size(), modTime: info.ModTime()}
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package watcher
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"mp4-optimizer/internal/analyzer"
+)
+
+func box(typ string, body ...[]byte) []byte {
+	payload := bytes.Join(body, nil)
+	buf := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(8+len(payload)))
+	copy(buf[4:8], typ)
+	return append(buf, payload...)
+}
+
+func TestWatcherProcessesStableFiles(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing.mp4")
+	slow := bytes.Join([][]byte{box("ftyp", []byte("isom")), box("mdat", make([]byte, 32)), box("moov", box("mvhd", make([]byte, 100)))}, nil)
+	os.WriteFile(existing, slow, 0644)
+
+	var events []Event
+	w, err := New(Config{
+		Dirs:      []string{dir},
+		StableFor: 10 * time.Second,
+		OnEvent:   func(ev Event) { events = append(events, ev) },
+		Log:       log.New(io.Discard, "", 0),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	w.now = func() time.Time { return now }
+
+	// Files present at startup are left alone
+	for path, st := range w.scan() {
+		w.handled[path] = st
+	}
+
+	fresh := filepath.Join(dir, "sub", "new.mp4")
+	os.MkdirAll(filepath.Dir(fresh), 0755)
+	os.WriteFile(fresh, slow[:40], 0644) // still being written
+
+	ctx := context.Background()
+	w.Poll(ctx)
+	now = now.Add(time.Minute)
+	os.WriteFile(fresh, slow, 0644) // writer finished, state changed
+	w.Poll(ctx)
+	if len(events) != 0 {
+		t.Fatalf("Expected no processing before the file is stable, got %+v", events)
+	}
+
+	now = now.Add(time.Minute)
+	w.Poll(ctx)
+	if len(events) != 1 || events[0].Path != fresh || events[0].Status != StatusOptimized {
+		t.Fatalf("Expected the new file to be optimized, got %+v", events)
+	}
+	if fast, _ := analyzer.CheckFastStart(fresh); !fast {
+		t.Errorf("Expected the new file to be fast-start")
+	}
+	if fast, _ := analyzer.CheckFastStart(existing); fast {
+		t.Errorf("Expected the existing file to be left alone")
+	}
+
+	// Our own rewrite must not be picked up again
+	now = now.Add(time.Minute)
+	w.Poll(ctx)
+	now = now.Add(time.Minute)
+	w.Poll(ctx)
+	if len(events) != 1 {
+		t.Errorf("Expected the optimized file not to be reprocessed, got %+v", events)
+	}
+}
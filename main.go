@@ -3,10 +3,11 @@ package main
 import (
 	"embed"
 	"net/http"
-	"net/url"
+	"os"
 	"strings"
 
 	"mp4-optimizer/internal/bridge"
+	"mp4-optimizer/internal/cli"
 
 	"github.com/wailsapp/wails/v2"
 	"github.com/wailsapp/wails/v2/pkg/options"
@@ -20,12 +21,18 @@ var assets embed.FS
 var Version = "0.0.0"
 
 func main() {
+	// Subcommands such as "check" or "optimize" run headless without opening a window
+	if len(os.Args) > 1 && cli.IsCommand(os.Args[1]) {
+		os.Exit(cli.Run(os.Args[1:], os.Stdout, os.Stderr))
+	}
+
 	// Create an instance of the app structure
 	// Pass the build-time version
 	if Version == "" {
 		Version = "0.0.0"
 	}
 	app := bridge.NewApp(Version)
+	preview := bridge.NewPreviewHandler(app)
 
 	// Create application with options
 	err := wails.Run(&options.App{
@@ -41,17 +48,9 @@ func main() {
 		AssetServer: &assetserver.Options{
 			Assets: assets,
 			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				path := r.URL.Path
-				if strings.HasPrefix(path, "/video/") {
-					// Serve local file
-					filePath := strings.TrimPrefix(path, "/video/")
-					if decodedPath, err := url.PathUnescape(filePath); err == nil {
-						filePath = decodedPath
-					}
-					// Improve security here?
-					// Ideally we check if it is a valid video file or path is absolute.
-					// For now, simple serving.
-					http.ServeFile(w, r, filePath)
+				if strings.HasPrefix(r.URL.Path, "/video/") {
+					// Only files added through the file list, addressed by token
+					preview.ServeHTTP(w, r)
 					return
 				}
 				// Default asset serving is handled if we don't write generic handler?
@@ -64,6 +63,7 @@ func main() {
 		},
 		BackgroundColour: &options.RGBA{R: 27, G: 38, B: 54, A: 1},
 		OnStartup:        app.Startup,
+		OnShutdown:       app.Shutdown,
 		Bind: []interface{}{
 			app,
 		},
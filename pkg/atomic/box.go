@@ -0,0 +1,403 @@
+package atomic
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// maxBoxDepth guards against pathological nesting in corrupt files.
+const maxBoxDepth = 32
+
+// Box is a node of the parsed MP4 box tree.
+// Offsets are absolute positions in the underlying reader.
+type Box struct {
+	Atom
+
+	// HeaderSize is 8 for a compact header or 16 when a 64-bit largesize is used.
+	HeaderSize int64
+
+	// ChildOffset is the number of body bytes that precede the first child,
+	// e.g. the FullBox version/flags of 'meta' or the fixed fields of a sample entry.
+	// It is only meaningful for containers.
+	ChildOffset int64
+
+	Parent   *Box
+	Children []*Box
+
+	// Data holds the body of a leaf, or the ChildOffset prefix bytes of a container,
+	// when it has been loaded or replaced. A nil Data is copied from the source on write.
+	Data []byte
+
+	container bool
+	src       source
+}
+
+// source records where the body of a parsed box lives in the original reader.
+type source struct {
+	offset int64
+	size   int64
+	ok     bool
+}
+
+// containerBoxes maps plain container types to the number of body bytes before their children.
+var containerBoxes = map[string]int64{
+	"moov": 0,
+	"trak": 0,
+	"mdia": 0,
+	"minf": 0,
+	"stbl": 0,
+	"edts": 0,
+	"udta": 0,
+	"dinf": 0,
+	"mvex": 0,
+	"moof": 0,
+	"traf": 0,
+	"mfra": 0,
+	"tref": 0,
+	"sinf": 0,
+	"schi": 0,
+	"wave": 0,
+	"ilst": 0,
+	"gmhd": 0,
+	"stsd": 8, // FullBox header + entry_count
+	"dref": 8, // FullBox header + entry_count
+}
+
+// lenientBoxes hold vendor specific content; malformed children make them opaque instead of failing the parse.
+var lenientBoxes = map[string]bool{
+	"udta": true,
+	"meta": true,
+	"ilst": true,
+	"wave": true,
+}
+
+// Visual and audio sample entry types used when the track handler is unknown.
+var (
+	visualSampleEntries = map[string]bool{
+		"avc1": true, "avc2": true, "avc3": true, "avc4": true,
+		"hvc1": true, "hev1": true, "dvh1": true, "dvhe": true,
+		"dvav": true, "dva1": true, "av01": true, "vp08": true,
+		"vp09": true, "mp4v": true, "encv": true, "s263": true,
+		"jpeg": true, "mjpa": true, "mjpb": true, "apch": true,
+		"apcn": true, "apcs": true, "apco": true, "ap4h": true,
+	}
+	audioSampleEntries = map[string]bool{
+		"mp4a": true, "ac-3": true, "ec-3": true, "ac-4": true,
+		"Opus": true, "fLaC": true, "alac": true, "enca": true,
+		"samr": true, "sawb": true, "lpcm": true, "sowt": true,
+		"twos": true, "ipcm": true, "fpcm": true, "mha1": true,
+	}
+)
+
+const (
+	visualSampleEntrySize = 78 // reserved(6) + data_reference_index(2) + VisualSampleEntry fields(70)
+	audioSampleEntrySize  = 28 // reserved(6) + data_reference_index(2) + AudioSampleEntry fields(20)
+)
+
+// BodyOffset returns the absolute offset of the first byte after the header.
+func (b *Box) BodyOffset() int64 {
+	return b.Offset + b.HeaderSize
+}
+
+// BodySize returns the number of bytes after the header.
+func (b *Box) BodySize() int64 {
+	return b.Size - b.HeaderSize
+}
+
+// End returns the absolute offset just past the box.
+func (b *Box) End() int64 {
+	return b.Offset + b.Size
+}
+
+// IsContainer reports whether the box was parsed as a container of child boxes.
+func (b *Box) IsContainer() bool {
+	return b.container
+}
+
+// Child returns the first direct child of the given type, or nil.
+func (b *Box) Child(typ string) *Box {
+	for _, c := range b.Children {
+		if c.Type == typ {
+			return c
+		}
+	}
+	return nil
+}
+
+// ChildrenOfType returns all direct children of the given type.
+func (b *Box) ChildrenOfType(typ string) []*Box {
+	var result []*Box
+	for _, c := range b.Children {
+		if c.Type == typ {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// Find follows a path of child types, e.g. Find("mdia", "minf", "stbl").
+// It returns nil if any step is missing.
+func (b *Box) Find(path ...string) *Box {
+	cur := b
+	for _, typ := range path {
+		cur = cur.Child(typ)
+		if cur == nil {
+			return nil
+		}
+	}
+	return cur
+}
+
+// FindAll returns every descendant of the given type in depth-first order.
+func (b *Box) FindAll(typ string) []*Box {
+	var result []*Box
+	for _, c := range b.Children {
+		if c.Type == typ {
+			result = append(result, c)
+		}
+		result = append(result, c.FindAll(typ)...)
+	}
+	return result
+}
+
+// Ancestor returns the closest enclosing box of the given type, or nil.
+func (b *Box) Ancestor(typ string) *Box {
+	for p := b.Parent; p != nil; p = p.Parent {
+		if p.Type == typ {
+			return p
+		}
+	}
+	return nil
+}
+
+// ReadBody reads the whole body (everything after the header) of the box.
+// A leaf whose Data has been loaded or replaced returns Data instead.
+func (b *Box) ReadBody(rs io.ReadSeeker) ([]byte, error) {
+	if b.Data != nil && !b.container {
+		return b.Data, nil
+	}
+	if _, err := rs.Seek(b.BodyOffset(), io.SeekStart); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, b.BodySize())
+	if _, err := io.ReadFull(rs, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// FindBox returns the first box of the given type in a list of boxes, or nil.
+func FindBox(boxes []*Box, typ string) *Box {
+	for _, b := range boxes {
+		if b.Type == typ {
+			return b
+		}
+	}
+	return nil
+}
+
+// ParseTree parses the whole file into a box tree and returns the top-level boxes.
+// Children are parsed for every known container. Media payloads are never read.
+// On error the boxes parsed so far are returned alongside it.
+func ParseTree(rs io.ReadSeeker) ([]*Box, error) {
+	fileSize, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	var boxes []*Box
+	offset := int64(0)
+	for offset+8 <= fileSize {
+		box, err := readBox(rs, offset, fileSize, nil)
+		if err != nil {
+			return boxes, err
+		}
+		boxes = append(boxes, box)
+
+		// The last box may be truncated; keep it but do not descend past EOF.
+		end := box.End()
+		if end > fileSize {
+			break
+		}
+		if box.container {
+			if err := parseChildren(rs, box, 1); err != nil {
+				return boxes, err
+			}
+		}
+		offset = end
+	}
+
+	return boxes, nil
+}
+
+// readBox reads the header of the box at offset. limit is the end of the enclosing box.
+// Top-level boxes (parent == nil) may extend beyond limit, which indicates truncation.
+func readBox(rs io.ReadSeeker, offset, limit int64, parent *Box) (*Box, error) {
+	if _, err := rs.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	atom, headerLen, err := ReadAtomHeader(rs)
+	if err != nil {
+		return nil, fmt.Errorf("read box header at offset %d: %w", offset, err)
+	}
+	atom.Offset = offset
+
+	if atom.Size == 0 {
+		// Size 0 means "extends to the end of the enclosing box / file"
+		atom.Size = limit - offset
+	}
+	if atom.Size < headerLen {
+		return nil, fmt.Errorf("invalid box size %d for '%s' at offset %d", atom.Size, atom.Type, offset)
+	}
+	if parent != nil && offset+atom.Size > limit {
+		return nil, fmt.Errorf("box '%s' at offset %d overflows its parent '%s'", atom.Type, offset, parent.Type)
+	}
+
+	box := &Box{
+		Atom:       atom,
+		HeaderSize: headerLen,
+		Parent:     parent,
+	}
+	box.src = source{offset: box.BodyOffset(), size: box.BodySize(), ok: true}
+
+	childOffset, ok, err := childOffsetFor(rs, box)
+	if err != nil {
+		return nil, err
+	}
+	if ok && childOffset <= box.BodySize() {
+		box.container = true
+		box.ChildOffset = childOffset
+	}
+	return box, nil
+}
+
+// parseChildren reads the children of a container box.
+func parseChildren(rs io.ReadSeeker, box *Box, depth int) error {
+	if depth > maxBoxDepth {
+		return fmt.Errorf("box nesting too deep at offset %d", box.Offset)
+	}
+
+	children, err := readChildren(rs, box, depth)
+	if err != nil {
+		if lenientBoxes[box.Type] {
+			// Treat the box as an opaque leaf rather than rejecting the whole file
+			box.container = false
+			box.ChildOffset = 0
+			box.Children = nil
+			return nil
+		}
+		return err
+	}
+	box.Children = children
+	return nil
+}
+
+func readChildren(rs io.ReadSeeker, box *Box, depth int) ([]*Box, error) {
+	var children []*Box
+	end := box.End()
+	offset := box.BodyOffset() + box.ChildOffset
+
+	// Anything shorter than a header at the tail is padding (e.g. QuickTime udta terminator)
+	for offset+8 <= end {
+		child, err := readBox(rs, offset, end, box)
+		if err != nil {
+			return nil, err
+		}
+		// Append before descending so siblings such as 'hdlr' are visible to later children
+		children = append(children, child)
+		box.Children = children
+		if child.container {
+			if err := parseChildren(rs, child, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		offset = child.End()
+	}
+	return children, nil
+}
+
+// childOffsetFor reports whether the box is a container and where its children start.
+func childOffsetFor(rs io.ReadSeeker, box *Box) (int64, bool, error) {
+	if n, ok := containerBoxes[box.Type]; ok {
+		return n, true, nil
+	}
+
+	if box.Type == "meta" {
+		// ISO 'meta' is a FullBox, QuickTime 'meta' is a plain container.
+		// In the QuickTime form the first child ('hdlr') starts right after the header.
+		if box.BodySize() < 8 {
+			return 0, false, nil
+		}
+		peek, err := peekBody(rs, box, 8)
+		if err != nil {
+			return 0, false, err
+		}
+		if string(peek[4:8]) == "hdlr" {
+			return 0, true, nil
+		}
+		return 4, true, nil
+	}
+
+	if box.Parent != nil && box.Parent.Type == "stsd" {
+		return sampleEntryChildOffset(rs, box)
+	}
+
+	return 0, false, nil
+}
+
+// sampleEntryChildOffset returns the size of the fixed sample entry fields for visual and audio entries.
+func sampleEntryChildOffset(rs io.ReadSeeker, box *Box) (int64, bool, error) {
+	handler := trackHandler(rs, box)
+
+	switch {
+	case handler == "vide" || (handler == "" && visualSampleEntries[box.Type]):
+		return visualSampleEntrySize, true, nil
+	case handler == "soun" || (handler == "" && audioSampleEntries[box.Type]):
+		if box.BodySize() < audioSampleEntrySize {
+			return 0, false, nil
+		}
+		peek, err := peekBody(rs, box, 10)
+		if err != nil {
+			return 0, false, err
+		}
+		// QuickTime sound sample description versions extend the fixed fields
+		switch binary.BigEndian.Uint16(peek[8:10]) {
+		case 1:
+			return audioSampleEntrySize + 16, true, nil
+		case 2:
+			return audioSampleEntrySize + 36, true, nil
+		}
+		return audioSampleEntrySize, true, nil
+	}
+	return 0, false, nil
+}
+
+// trackHandler returns the handler type from the 'hdlr' of the enclosing 'mdia', if already parsed.
+func trackHandler(rs io.ReadSeeker, box *Box) string {
+	mdia := box.Ancestor("mdia")
+	if mdia == nil {
+		return ""
+	}
+	hdlr := mdia.Child("hdlr")
+	if hdlr == nil || hdlr.BodySize() < 12 {
+		return ""
+	}
+	peek, err := peekBody(rs, hdlr, 12)
+	if err != nil {
+		return ""
+	}
+	// version/flags(4) + pre_defined(4) + handler_type(4)
+	return string(peek[8:12])
+}
+
+func peekBody(rs io.ReadSeeker, box *Box, n int) ([]byte, error) {
+	if _, err := rs.Seek(box.BodyOffset(), io.SeekStart); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(rs, buf); err != nil {
+		return nil, fmt.Errorf("read '%s' body at offset %d: %w", box.Type, box.Offset, err)
+	}
+	return buf, nil
+}
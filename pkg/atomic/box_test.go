@@ -0,0 +1,113 @@
+package atomic
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// box builds a compact box with the given body.
+func box(typ string, body ...[]byte) []byte {
+	payload := bytes.Join(body, nil)
+	buf := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(8+len(payload)))
+	copy(buf[4:8], typ)
+	return append(buf, payload...)
+}
+
+func hdlrBody(handler string) []byte {
+	body := make([]byte, 24)
+	copy(body[8:12], handler)
+	return body
+}
+
+func TestParseTree(t *testing.T) {
+	avc1 := box("avc1", make([]byte, visualSampleEntrySize), box("avcC", []byte{1, 0x64, 0, 0x1f}))
+	stsd := box("stsd", []byte{0, 0, 0, 0, 0, 0, 0, 1}, avc1)
+	stco := box("stco", []byte{0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 40})
+	trak := box("trak",
+		box("tkhd", make([]byte, 84)),
+		box("mdia",
+			box("hdlr", hdlrBody("vide")),
+			box("minf", box("stbl", stsd, stco)),
+		),
+	)
+	meta := box("meta", []byte{0, 0, 0, 0}, box("hdlr", hdlrBody("mdir")), box("ilst"))
+	moov := box("moov", box("mvhd", make([]byte, 100)), trak, box("udta", meta))
+
+	file := bytes.Join([][]byte{box("ftyp", []byte("isom")), moov, box("mdat", make([]byte, 16))}, nil)
+
+	boxes, err := ParseTree(bytes.NewReader(file))
+	if err != nil {
+		t.Fatalf("ParseTree failed: %v", err)
+	}
+	if len(boxes) != 3 {
+		t.Fatalf("Expected 3 top-level boxes, got %d", len(boxes))
+	}
+
+	root := FindBox(boxes, "moov")
+	if root == nil {
+		t.Fatalf("moov not found")
+	}
+
+	entry := root.Find("trak", "mdia", "minf", "stbl", "stsd", "avc1")
+	if entry == nil {
+		t.Fatalf("sample entry not found")
+	}
+	if entry.ChildOffset != visualSampleEntrySize {
+		t.Errorf("Expected sample entry child offset %d, got %d", visualSampleEntrySize, entry.ChildOffset)
+	}
+	avcC := entry.Child("avcC")
+	if avcC == nil {
+		t.Fatalf("avcC not found inside sample entry")
+	}
+	if avcC.Ancestor("trak") == nil {
+		t.Errorf("Expected parent links up to trak")
+	}
+
+	co := root.FindAll("stco")
+	if len(co) != 1 {
+		t.Fatalf("Expected 1 stco, got %d", len(co))
+	}
+	wantOffset := int64(len(box("ftyp", []byte("isom")))) + 8 + int64(len(box("mvhd", make([]byte, 100)))) +
+		8 + int64(len(box("tkhd", make([]byte, 84)))) + 8 + int64(len(box("hdlr", hdlrBody("vide")))) +
+		8 + 8 + int64(len(stsd))
+	if co[0].Offset != wantOffset {
+		t.Errorf("Expected stco at offset %d, got %d", wantOffset, co[0].Offset)
+	}
+
+	m := root.Find("udta", "meta")
+	if m == nil || m.ChildOffset != 4 || m.Child("ilst") == nil {
+		t.Errorf("Expected ISO meta FullBox with children, got %+v", m)
+	}
+}
+
+func TestParseTreeTruncated(t *testing.T) {
+	file := bytes.Join([][]byte{box("ftyp", []byte("isom")), box("moov", box("mvhd", make([]byte, 100)))}, nil)
+	mdat := box("mdat", make([]byte, 64))
+	file = append(file, mdat[:20]...)
+
+	boxes, err := ParseTree(bytes.NewReader(file))
+	if err != nil {
+		t.Fatalf("ParseTree failed: %v", err)
+	}
+	last := boxes[len(boxes)-1]
+	if last.Type != "mdat" || last.End() <= int64(len(file)) {
+		t.Errorf("Expected truncated mdat to be reported with its declared size, got %+v", last.Atom)
+	}
+}
+
+func TestParseTreeLenientUdta(t *testing.T) {
+	// A udta whose content is not a valid box list must not break the parse
+	udta := box("udta", []byte{0, 0, 0, 0x7f, 'j', 'u', 'n', 'k', 0, 0, 0, 0})
+	file := box("moov", box("mvhd", make([]byte, 100)), udta)
+
+	boxes, err := ParseTree(bytes.NewReader(file))
+	if err != nil {
+		t.Fatalf("ParseTree failed: %v", err)
+	}
+	u := boxes[0].Child("udta")
+	if u == nil || u.IsContainer() || len(u.Children) != 0 {
+		t.Errorf("Expected udta to fall back to an opaque leaf, got %+v", u)
+	}
+}
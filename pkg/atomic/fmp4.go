@@ -0,0 +1,495 @@
+package atomic
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"time"
+)
+
+// Sample flags written to trun: sync samples depend on nothing, others are
+// flagged non-sync and dependent
+const (
+	syncSampleFlags    = 0x02000000
+	nonSyncSampleFlags = 0x01010000
+)
+
+// Segment is one fragment of a plan: for every track, the half-open range
+// of sample indices it carries.
+type Segment struct {
+	Ranges   [][2]int
+	Start    float64 // seconds, decode time of the first reference sample
+	Duration float64 // seconds, on the reference track
+}
+
+// ReferenceTrack returns the index of the track fragments are aligned to:
+// the first video track, or the first track if there is no video.
+func ReferenceTrack(tracks []*Track) int {
+	for i, t := range tracks {
+		if t.Handler == "vide" {
+			return i
+		}
+	}
+	return 0
+}
+
+// PlanSegments cuts tracks into segments of roughly target length. Every
+// segment starts at a sync sample of the reference track, so it decodes on its
+// own and may run longer than target; other tracks are cut at the same times.
+func PlanSegments(tracks []*Track, target time.Duration) []Segment {
+	if len(tracks) == 0 {
+		return nil
+	}
+	ref := tracks[ReferenceTrack(tracks)]
+
+	// Cut points as reference sample indices
+	cuts := []int{0}
+	if ref.Timescale > 0 {
+		targetTicks := uint64(target.Seconds() * float64(ref.Timescale))
+		var segStart uint64
+		for i, s := range ref.Samples {
+			if i > 0 && s.Sync && s.DecodeTime-segStart >= targetTicks {
+				cuts = append(cuts, i)
+				segStart = s.DecodeTime
+			}
+		}
+	}
+
+	// firstAt returns the first sample of t that decodes at or after the
+	// reference time of sample refIndex
+	next := make([]int, len(tracks))
+	firstAt := func(t *Track, from, refIndex int) int {
+		if refIndex >= len(ref.Samples) {
+			return len(t.Samples)
+		}
+		refTime := ref.Samples[refIndex].DecodeTime
+		i := from
+		for i < len(t.Samples) && t.Samples[i].DecodeTime*uint64(ref.Timescale) < refTime*uint64(t.Timescale) {
+			i++
+		}
+		return i
+	}
+
+	segments := make([]Segment, 0, len(cuts))
+	for k := range cuts {
+		end := len(ref.Samples)
+		if k+1 < len(cuts) {
+			end = cuts[k+1]
+		}
+		seg := Segment{Ranges: make([][2]int, len(tracks))}
+		for i, t := range tracks {
+			stop := len(t.Samples)
+			if t == ref {
+				stop = end
+			} else if k+1 < len(cuts) {
+				stop = firstAt(t, next[i], end)
+			}
+			seg.Ranges[i] = [2]int{next[i], stop}
+			next[i] = stop
+		}
+		if ref.Timescale > 0 && cuts[k] < len(ref.Samples) {
+			var d uint64
+			for _, s := range ref.Samples[cuts[k]:end] {
+				d += uint64(s.Duration)
+			}
+			seg.Start = float64(ref.Samples[cuts[k]].DecodeTime) / float64(ref.Timescale)
+			seg.Duration = float64(d) / float64(ref.Timescale)
+		}
+		segments = append(segments, seg)
+	}
+	return segments
+}
+
+// Runs returns the samples each track contributes to the segment, skipping empty ones.
+func (s Segment) Runs(tracks []*Track) []TrackRun {
+	var runs []TrackRun
+	for i, t := range tracks {
+		r := s.Ranges[i]
+		if r[0] >= r[1] {
+			continue
+		}
+		runs = append(runs, TrackRun{
+			TrackID:            t.ID,
+			Samples:            t.Samples[r[0]:r[1]],
+			CompositionOffsets: t.HasCompositionOffsets,
+		})
+	}
+	return runs
+}
+
+// BuildInitMoov turns a loaded progressive moov into the moov of an init
+// segment: sample tables are emptied, durations move to mehd and mvex/trex
+// declare the tracks as fragmented. tracks must have been read from moov.
+func BuildInitMoov(moov *Box, tracks []*Track) error {
+	mvhd := moov.Child("mvhd")
+	if mvhd == nil {
+		return fmt.Errorf("no mvhd box")
+	}
+	movieDuration := mvhd.Duration()
+	mvhd.SetDuration(0)
+
+	mvex := NewContainer("mvex", nil)
+	mehd := make([]byte, 12)
+	mehd[0] = 1
+	binary.BigEndian.PutUint64(mehd[4:12], movieDuration)
+	mvex.AppendChild(NewBox("mehd", mehd))
+
+	for _, t := range tracks {
+		stbl := t.Trak.Find("mdia", "minf", "stbl")
+		if stbl == nil {
+			return fmt.Errorf("track %d has no stbl", t.ID)
+		}
+		for _, c := range append([]*Box(nil), stbl.Children...) {
+			if c.Type != "stsd" {
+				stbl.RemoveChild(c)
+			}
+		}
+		stbl.AppendChild(NewBox("stts", make([]byte, 8)))
+		stbl.AppendChild(NewBox("stsc", make([]byte, 8)))
+		stbl.AppendChild(NewBox("stsz", make([]byte, 12)))
+		stbl.AppendChild(NewBox("stco", make([]byte, 8)))
+
+		if tkhd := t.Trak.Child("tkhd"); tkhd != nil {
+			tkhd.SetDuration(0)
+		}
+		if mdhd := t.Trak.Find("mdia", "mdhd"); mdhd != nil {
+			mdhd.SetDuration(0)
+		}
+
+		// Only the sample description index needs a default; trun carries the rest
+		trex := make([]byte, 24)
+		binary.BigEndian.PutUint32(trex[4:8], t.ID)
+		binary.BigEndian.PutUint32(trex[8:12], 1)
+		mvex.AppendChild(NewBox("trex", trex))
+	}
+
+	if old := moov.Child("mvex"); old != nil {
+		moov.RemoveChild(old)
+	}
+	moov.AppendChild(mvex)
+	moov.ComputeSize()
+	return nil
+}
+
+// TrackRun is the samples of one track stored in one fragment.
+type TrackRun struct {
+	TrackID            uint32
+	Samples            []Sample
+	CompositionOffsets bool
+}
+
+// MediaFragment is a moof together with the mdat that follows it.
+type MediaFragment struct {
+	Moof     *Box
+	Runs     []TrackRun
+	DataSize int64
+}
+
+// NewMediaFragment builds the moof for runs whose samples are stored back to
+// back, in order, in the mdat that immediately follows it.
+func NewMediaFragment(seq uint32, runs []TrackRun) *MediaFragment {
+	f := &MediaFragment{Runs: runs}
+	moof := NewContainer("moof", nil, NewBox("mfhd", binary.BigEndian.AppendUint32(make([]byte, 4), seq)))
+
+	var truns [][]byte
+	for _, r := range runs {
+		tfhd := make([]byte, 8)
+		binary.BigEndian.PutUint32(tfhd[0:4], tfhdDefaultBaseIsMoof)
+		binary.BigEndian.PutUint32(tfhd[4:8], r.TrackID)
+
+		tfdt := make([]byte, 12)
+		tfdt[0] = 1
+		binary.BigEndian.PutUint64(tfdt[4:12], r.Samples[0].DecodeTime)
+
+		flags := uint32(trunDataOffset | trunSampleDuration | trunSampleSize | trunSampleFlags)
+		var version byte
+		if r.CompositionOffsets {
+			flags |= trunSampleCompositionOff
+			for _, s := range r.Samples {
+				if s.CompositionOffset < 0 {
+					version = 1
+					break
+				}
+			}
+		}
+		trun := make([]byte, 12)
+		binary.BigEndian.PutUint32(trun[0:4], flags)
+		trun[0] = version
+		binary.BigEndian.PutUint32(trun[4:8], uint32(len(r.Samples)))
+		for _, s := range r.Samples {
+			trun = binary.BigEndian.AppendUint32(trun, s.Duration)
+			trun = binary.BigEndian.AppendUint32(trun, s.Size)
+			sampleFlags := uint32(nonSyncSampleFlags)
+			if s.Sync {
+				sampleFlags = syncSampleFlags
+			}
+			trun = binary.BigEndian.AppendUint32(trun, sampleFlags)
+			if r.CompositionOffsets {
+				trun = binary.BigEndian.AppendUint32(trun, uint32(s.CompositionOffset))
+			}
+			f.DataSize += int64(s.Size)
+		}
+		truns = append(truns, trun)
+
+		moof.AppendChild(NewContainer("traf", nil,
+			NewBox("tfhd", tfhd),
+			NewBox("tfdt", tfdt),
+			NewBox("trun", trun),
+		))
+	}
+
+	// Data offsets are relative to the moof start and skip the mdat header
+	offset := moof.ComputeSize() + HeaderSizeFor(f.DataSize)
+	for i, r := range runs {
+		binary.BigEndian.PutUint32(truns[i][8:12], uint32(offset))
+		for _, s := range r.Samples {
+			offset += int64(s.Size)
+		}
+	}
+	f.Moof = moof
+	return f
+}
+
+// Size returns the total size of the moof and its mdat.
+func (f *MediaFragment) Size() int64 {
+	return f.Moof.Size + HeaderSizeFor(f.DataSize) + f.DataSize
+}
+
+// Write writes the moof and an mdat holding the samples read from src.
+// Samples that are contiguous in src are copied in one go.
+func (f *MediaFragment) Write(w io.Writer, src io.ReadSeeker) (int64, error) {
+	moof, err := f.Moof.Bytes()
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(moof)
+	written := int64(n)
+	if err != nil {
+		return written, err
+	}
+	hn, err := WriteHeader(w, "mdat", HeaderSizeFor(f.DataSize)+f.DataSize)
+	written += hn
+	if err != nil {
+		return written, err
+	}
+
+	for _, r := range f.Runs {
+		for i := 0; i < len(r.Samples); {
+			start := r.Samples[i].Offset
+			end := start + int64(r.Samples[i].Size)
+			j := i + 1
+			for j < len(r.Samples) && r.Samples[j].Offset == end {
+				end += int64(r.Samples[j].Size)
+				j++
+			}
+			if _, err := src.Seek(start, io.SeekStart); err != nil {
+				return written, err
+			}
+			cn, err := io.CopyN(w, src, end-start)
+			written += cn
+			if err != nil {
+				return written, err
+			}
+			i = j
+		}
+	}
+	return written, nil
+}
+
+// SidxReference is one subsegment entry of a segment index.
+type SidxReference struct {
+	Size          int64  // bytes of the referenced moof and mdat
+	Duration      uint64 // in the sidx timescale
+	StartsWithSAP bool
+}
+
+// BuildSidx builds a version 1 segment index for the track referenceID.
+// firstOffset is the distance from the end of the sidx to the first referenced byte.
+func BuildSidx(referenceID, timescale uint32, earliest uint64, firstOffset int64, refs []SidxReference) (*Box, error) {
+	if len(refs) > math.MaxUint16 {
+		return nil, fmt.Errorf("too many sidx references: %d", len(refs))
+	}
+	data := make([]byte, 32, 32+12*len(refs))
+	data[0] = 1
+	binary.BigEndian.PutUint32(data[4:8], referenceID)
+	binary.BigEndian.PutUint32(data[8:12], timescale)
+	binary.BigEndian.PutUint64(data[12:20], earliest)
+	binary.BigEndian.PutUint64(data[20:28], uint64(firstOffset))
+	binary.BigEndian.PutUint16(data[30:32], uint16(len(refs)))
+	for _, r := range refs {
+		if r.Size > 0x7FFFFFFF || r.Duration > math.MaxUint32 {
+			return nil, fmt.Errorf("subsegment too large for sidx")
+		}
+		data = binary.BigEndian.AppendUint32(data, uint32(r.Size))
+		data = binary.BigEndian.AppendUint32(data, uint32(r.Duration))
+		var sap uint32
+		if r.StartsWithSAP {
+			// starts_with_SAP=1, SAP_type=1
+			sap = 0x90000000
+		}
+		data = binary.BigEndian.AppendUint32(data, sap)
+	}
+	return NewBox("sidx", data), nil
+}
+
+// RandomAccessEntry locates one sync sample for the movie fragment random access box.
+type RandomAccessEntry struct {
+	Time       uint64 // decode time in the track timescale
+	MoofOffset int64  // absolute offset of the moof holding the sample
+	TrafNumber uint16 // 1-based position of the track's traf in that moof
+}
+
+// BuildMfra builds a movie fragment random access box with one tfra per track
+// and the trailing mfro. Each entry points at the first sample of the first
+// trun in the entry's traf.
+func BuildMfra(entries map[uint32][]RandomAccessEntry, trackIDs []uint32) *Box {
+	mfra := NewContainer("mfra", nil)
+	for _, id := range trackIDs {
+		list := entries[id]
+		// traf numbers beyond 255 need a 2-byte field
+		wide := slices.ContainsFunc(list, func(e RandomAccessEntry) bool { return e.TrafNumber > math.MaxUint8 })
+		// Version(1) Flags(3) TrackID(4) Reserved+LengthSizes(4) Count(4)
+		data := make([]byte, 16, 16+20*len(list))
+		data[0] = 1
+		binary.BigEndian.PutUint32(data[4:8], id)
+		if wide {
+			// length_size_of_traf_num = 1, i.e. 2 bytes
+			data[11] = 1 << 4
+		}
+		binary.BigEndian.PutUint32(data[12:16], uint32(len(list)))
+		for _, e := range list {
+			data = binary.BigEndian.AppendUint64(data, e.Time)
+			data = binary.BigEndian.AppendUint64(data, uint64(e.MoofOffset))
+			if wide {
+				data = binary.BigEndian.AppendUint16(data, e.TrafNumber)
+			} else {
+				data = append(data, byte(e.TrafNumber))
+			}
+			// trun and sample numbers with 1-byte length fields
+			data = append(data, 1, 1)
+		}
+		mfra.AppendChild(NewBox("tfra", data))
+	}
+	mfro := NewBox("mfro", make([]byte, 8))
+	mfra.AppendChild(mfro)
+	size := mfra.ComputeSize()
+	binary.BigEndian.PutUint32(mfro.Data[4:8], uint32(size))
+	return mfra
+}
+
+// FragmentPlan is a progressive movie prepared for fragmented output: the
+// init segment and one MediaFragment per segment.
+type FragmentPlan struct {
+	Init      []byte // ftyp and moov
+	Tracks    []*Track
+	Segments  []Segment
+	Fragments []*MediaFragment
+}
+
+// PlanFragments reads the progressive movie described by boxes and cuts it into
+// fragments of roughly target length. When trackIDs are given, only those
+// tracks are kept. The moov in boxes is rewritten into the init segment, so
+// boxes must not be reused for anything else.
+func PlanFragments(rs io.ReadSeeker, boxes []*Box, target time.Duration, trackIDs ...uint32) (*FragmentPlan, error) {
+	moov := FindBox(boxes, "moov")
+	if moov == nil {
+		return nil, fmt.Errorf("no moov atom found")
+	}
+	if FindBox(boxes, "moof") != nil || moov.Child("mvex") != nil {
+		return nil, fmt.Errorf("movie is already fragmented")
+	}
+	if err := moov.Load(rs); err != nil {
+		return nil, err
+	}
+	tracks, err := ReadTracks(rs, moov)
+	if err != nil {
+		return nil, fmt.Errorf("read sample tables: %w", err)
+	}
+	if len(trackIDs) > 0 {
+		if tracks, err = keepTracks(moov, tracks, trackIDs); err != nil {
+			return nil, err
+		}
+	}
+
+	p := &FragmentPlan{Tracks: tracks}
+	for _, seg := range PlanSegments(tracks, target) {
+		runs := seg.Runs(tracks)
+		if len(runs) == 0 {
+			continue
+		}
+		p.Segments = append(p.Segments, seg)
+		p.Fragments = append(p.Fragments, NewMediaFragment(uint32(len(p.Fragments)+1), runs))
+	}
+
+	if ftyp := FindBox(boxes, "ftyp"); ftyp != nil {
+		if err := ftyp.Load(rs); err != nil {
+			return nil, err
+		}
+		if p.Init, err = fragmentedFtyp(ftyp).Bytes(); err != nil {
+			return nil, err
+		}
+	}
+	if err := BuildInitMoov(moov, tracks); err != nil {
+		return nil, fmt.Errorf("build init segment: %w", err)
+	}
+	moovBuf, err := moov.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	p.Init = append(p.Init, moovBuf...)
+	return p, nil
+}
+
+// keepTracks removes every trak of moov whose ID is not listed.
+func keepTracks(moov *Box, tracks []*Track, ids []uint32) ([]*Track, error) {
+	var kept []*Track
+	for _, t := range tracks {
+		if slices.Contains(ids, t.ID) {
+			kept = append(kept, t)
+		} else {
+			moov.RemoveChild(t.Trak)
+		}
+	}
+	if len(kept) != len(ids) {
+		return nil, fmt.Errorf("movie lacks some of the tracks %v", ids)
+	}
+	return kept, nil
+}
+
+// fragmentedFtyp adds the iso6 brand, which covers tfdt and default-base-is-moof.
+func fragmentedFtyp(ftyp *Box) *Box {
+	data := ftyp.Data
+	for i := 8; i+4 <= len(data); i += 4 {
+		if string(data[i:i+4]) == "iso6" {
+			return ftyp
+		}
+	}
+	return NewBox("ftyp", append(append([]byte(nil), data...), "iso6"...))
+}
+
+// Sidx indexes every fragment on the reference track. firstOffset is the
+// distance from the end of the sidx to the first moof.
+func (p *FragmentPlan) Sidx(firstOffset int64) (*Box, error) {
+	ref := ReferenceTrack(p.Tracks)
+	t := p.Tracks[ref]
+
+	var refs []SidxReference
+	var earliest uint64
+	for i, seg := range p.Segments {
+		entry := SidxReference{Size: p.Fragments[i].Size()}
+		if r := seg.Ranges[ref]; r[0] < r[1] {
+			samples := t.Samples[r[0]:r[1]]
+			for _, s := range samples {
+				entry.Duration += uint64(s.Duration)
+			}
+			entry.StartsWithSAP = samples[0].Sync
+			if i == 0 {
+				earliest = samples[0].PresentationTime()
+			}
+		}
+		refs = append(refs, entry)
+	}
+	return BuildSidx(t.ID, t.Timescale, earliest, firstOffset, refs)
+}
@@ -0,0 +1,80 @@
+package atomic
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestPlanSegments(t *testing.T) {
+	// Audio only: every sample is sync, so cuts land right at the target
+	audio := &Track{ID: 1, Handler: "soun", Timescale: 100}
+	for i := 0; i < 10; i++ {
+		audio.Samples = append(audio.Samples, Sample{DecodeTime: uint64(i * 50), Duration: 50, Sync: true})
+	}
+	segments := PlanSegments([]*Track{audio}, time.Second)
+	if len(segments) != 5 {
+		t.Fatalf("Expected 5 segments, got %d", len(segments))
+	}
+	for i, s := range segments {
+		if s.Ranges[0] != [2]int{i * 2, i*2 + 2} || s.Start != float64(i) || s.Duration != 1 {
+			t.Errorf("Segment %d = %+v", i+1, s)
+		}
+	}
+
+	// Video with a single sync sample cannot be cut at all
+	video := &Track{ID: 2, Handler: "vide", Timescale: 100}
+	for i := 0; i < 10; i++ {
+		video.Samples = append(video.Samples, Sample{DecodeTime: uint64(i * 50), Duration: 50, Sync: i == 0})
+	}
+	segments = PlanSegments([]*Track{audio, video}, time.Second)
+	if len(segments) != 1 || segments[0].Ranges[0] != [2]int{0, 10} || segments[0].Ranges[1] != [2]int{0, 10} {
+		t.Errorf("Expected one segment with everything, got %+v", segments)
+	}
+}
+
+func TestBuildMfra(t *testing.T) {
+	mfra := BuildMfra(map[uint32][]RandomAccessEntry{
+		1: {{Time: 0, MoofOffset: 100, TrafNumber: 1}, {Time: 90, MoofOffset: 500, TrafNumber: 1}},
+		2: {{Time: 0, MoofOffset: 100, TrafNumber: 2}},
+	}, []uint32{1, 2})
+	data, err := mfra.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Readers locate mfra from the size stored in the trailing mfro
+	if got := binary.BigEndian.Uint32(data[len(data)-4:]); int(got) != len(data) {
+		t.Errorf("mfro size %d, want %d", got, len(data))
+	}
+	r := bytes.NewReader(data)
+	boxes, err := ParseTree(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tfra := boxes[0].Child("tfra")
+	if tfra == nil {
+		t.Fatal("Expected a tfra box")
+	}
+	body, err := tfra.ReadBody(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := binary.BigEndian.Uint32(body[12:16]); count != 2 || len(body) != 16+2*19 {
+		t.Errorf("Expected a tfra with 2 entries, got %d in %d bytes", count, len(body))
+	}
+
+	// The second track's entry points at the second traf of its moof
+	tfras := boxes[0].ChildrenOfType("tfra")
+	if len(tfras) != 2 {
+		t.Fatalf("Expected a tfra per track, got %d", len(tfras))
+	}
+	body, err = tfras[1].ReadBody(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Time(8) MoofOffset(8) then the traf number
+	if id, traf := binary.BigEndian.Uint32(body[4:8]), body[16+16]; id != 2 || traf != 2 {
+		t.Errorf("Expected track 2 at traf 2, got track %d traf %d", id, traf)
+	}
+}
@@ -0,0 +1,340 @@
+package atomic
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Track fragment header (tfhd) flags
+const (
+	tfhdBaseDataOffset       = 0x000001
+	tfhdSampleDescription    = 0x000002
+	tfhdDefaultDuration      = 0x000008
+	tfhdDefaultSize          = 0x000010
+	tfhdDefaultFlags         = 0x000020
+	tfhdDefaultBaseIsMoof    = 0x020000
+	trunDataOffset           = 0x000001
+	trunFirstSampleFlags     = 0x000004
+	trunSampleDuration       = 0x000100
+	trunSampleSize           = 0x000200
+	trunSampleFlags          = 0x000400
+	trunSampleCompositionOff = 0x000800
+
+	// sampleIsNonSync is the sample_is_non_sync_sample bit of the sample flags
+	sampleIsNonSync = 0x00010000
+)
+
+// TrackDefaults are the per-track sample defaults declared by 'trex' in 'mvex'.
+type TrackDefaults struct {
+	TrackID          uint32
+	DescriptionIndex uint32
+	Duration         uint32
+	Size             uint32
+	Flags            uint32
+}
+
+// Fragment is one 'moof' with the samples of each of its track fragments resolved.
+type Fragment struct {
+	Moof     *Box
+	Sequence uint32 // from mfhd
+	Tracks   []*TrackFragment
+}
+
+// TrackFragment holds the samples of one 'traf'.
+type TrackFragment struct {
+	TrackID        uint32
+	BaseDecodeTime uint64 // from tfdt, or continued from the previous fragment
+	HasDecodeTime  bool
+	Samples        []Sample // DecodeTime is absolute in the track timescale
+}
+
+// Duration returns the sum of the sample durations of the track fragment.
+func (tf *TrackFragment) Duration() uint64 {
+	var d uint64
+	for _, s := range tf.Samples {
+		d += uint64(s.Duration)
+	}
+	return d
+}
+
+// ReadTrackDefaults reads every 'trex' in the 'mvex' of moov, keyed by track ID.
+func ReadTrackDefaults(rs io.ReadSeeker, moov *Box) (map[uint32]TrackDefaults, error) {
+	defaults := make(map[uint32]TrackDefaults)
+	mvex := moov.Child("mvex")
+	if mvex == nil {
+		return defaults, nil
+	}
+	for _, trex := range mvex.ChildrenOfType("trex") {
+		data, err := trex.ReadBody(rs)
+		if err != nil {
+			return nil, err
+		}
+		// Version(1) Flags(3) TrackID(4) DescIndex(4) Duration(4) Size(4) Flags(4)
+		if len(data) < 24 {
+			return nil, fmt.Errorf("trex box too small")
+		}
+		d := TrackDefaults{
+			TrackID:          binary.BigEndian.Uint32(data[4:8]),
+			DescriptionIndex: binary.BigEndian.Uint32(data[8:12]),
+			Duration:         binary.BigEndian.Uint32(data[12:16]),
+			Size:             binary.BigEndian.Uint32(data[16:20]),
+			Flags:            binary.BigEndian.Uint32(data[20:24]),
+		}
+		defaults[d.TrackID] = d
+	}
+	return defaults, nil
+}
+
+// ReadFragments resolves the samples of every top-level 'moof' in file order.
+// Decode times continue across fragments when a 'tfdt' is missing.
+func ReadFragments(rs io.ReadSeeker, boxes []*Box, defaults map[uint32]TrackDefaults) ([]*Fragment, error) {
+	var fragments []*Fragment
+	nextDecodeTime := make(map[uint32]uint64)
+
+	for _, moof := range boxes {
+		if moof.Type != "moof" {
+			continue
+		}
+		f, err := readFragment(rs, moof, defaults, nextDecodeTime)
+		if err != nil {
+			return fragments, fmt.Errorf("moof at offset %d: %w", moof.Offset, err)
+		}
+		fragments = append(fragments, f)
+	}
+	return fragments, nil
+}
+
+func readFragment(rs io.ReadSeeker, moof *Box, defaults map[uint32]TrackDefaults, nextDecodeTime map[uint32]uint64) (*Fragment, error) {
+	f := &Fragment{Moof: moof}
+	if mfhd := moof.Child("mfhd"); mfhd != nil {
+		data, err := mfhd.ReadBody(rs)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) >= 8 {
+			f.Sequence = binary.BigEndian.Uint32(data[4:8])
+		}
+	}
+
+	// Without an explicit base, the first traf starts at the moof and later ones
+	// continue where the previous traf's data ended
+	prevDataEnd := moof.Offset
+
+	for _, traf := range moof.ChildrenOfType("traf") {
+		tfhd := traf.Child("tfhd")
+		if tfhd == nil {
+			return nil, fmt.Errorf("traf without tfhd")
+		}
+		data, err := tfhd.ReadBody(rs)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) < 8 {
+			return nil, fmt.Errorf("tfhd box too small")
+		}
+		flags := binary.BigEndian.Uint32(data[0:4]) & 0xFFFFFF
+		tf := &TrackFragment{TrackID: binary.BigEndian.Uint32(data[4:8])}
+
+		def := defaults[tf.TrackID]
+		base := prevDataEnd
+		pos := 8
+		read32 := func() (uint32, error) {
+			if pos+4 > len(data) {
+				return 0, fmt.Errorf("tfhd box truncated")
+			}
+			v := binary.BigEndian.Uint32(data[pos : pos+4])
+			pos += 4
+			return v, nil
+		}
+		if flags&tfhdBaseDataOffset != 0 {
+			if pos+8 > len(data) {
+				return nil, fmt.Errorf("tfhd box truncated")
+			}
+			base = int64(binary.BigEndian.Uint64(data[pos : pos+8]))
+			pos += 8
+		} else if flags&tfhdDefaultBaseIsMoof != 0 {
+			base = moof.Offset
+		}
+		for _, field := range []struct {
+			flag uint32
+			dst  *uint32
+		}{
+			{tfhdSampleDescription, &def.DescriptionIndex},
+			{tfhdDefaultDuration, &def.Duration},
+			{tfhdDefaultSize, &def.Size},
+			{tfhdDefaultFlags, &def.Flags},
+		} {
+			if flags&field.flag != 0 {
+				v, err := read32()
+				if err != nil {
+					return nil, err
+				}
+				*field.dst = v
+			}
+		}
+
+		if def.DescriptionIndex == 0 {
+			def.DescriptionIndex = 1
+		}
+
+		dts, ok := nextDecodeTime[tf.TrackID]
+		if tfdt := traf.Child("tfdt"); tfdt != nil {
+			tdata, err := tfdt.ReadBody(rs)
+			if err != nil {
+				return nil, err
+			}
+			if len(tdata) >= 12 && tdata[0] == 1 {
+				dts = binary.BigEndian.Uint64(tdata[4:12])
+			} else if len(tdata) >= 8 {
+				dts = uint64(binary.BigEndian.Uint32(tdata[4:8]))
+			}
+			ok = true
+		}
+		tf.BaseDecodeTime = dts
+		tf.HasDecodeTime = ok
+
+		dataPos := base
+		for _, trun := range traf.ChildrenOfType("trun") {
+			end, err := readTrun(rs, trun, base, dataPos, def, tf, &dts)
+			if err != nil {
+				return nil, err
+			}
+			dataPos = end
+		}
+		prevDataEnd = dataPos
+		nextDecodeTime[tf.TrackID] = dts
+		f.Tracks = append(f.Tracks, tf)
+	}
+	return f, nil
+}
+
+// readTrun appends the samples of a 'trun' and returns the offset just past its data.
+func readTrun(rs io.ReadSeeker, trun *Box, base, dataPos int64, def TrackDefaults, tf *TrackFragment, dts *uint64) (int64, error) {
+	data, err := trun.ReadBody(rs)
+	if err != nil {
+		return 0, err
+	}
+	if len(data) < 8 {
+		return 0, fmt.Errorf("trun box too small")
+	}
+	version := data[0]
+	flags := binary.BigEndian.Uint32(data[0:4]) & 0xFFFFFF
+	count := binary.BigEndian.Uint32(data[4:8])
+	pos := 8
+
+	offset := dataPos
+	if flags&trunDataOffset != 0 {
+		if pos+4 > len(data) {
+			return 0, fmt.Errorf("trun box truncated")
+		}
+		offset = base + int64(int32(binary.BigEndian.Uint32(data[pos:pos+4])))
+		pos += 4
+	}
+	firstFlags, hasFirstFlags := uint32(0), false
+	if flags&trunFirstSampleFlags != 0 {
+		if pos+4 > len(data) {
+			return 0, fmt.Errorf("trun box truncated")
+		}
+		firstFlags, hasFirstFlags = binary.BigEndian.Uint32(data[pos:pos+4]), true
+		pos += 4
+	}
+
+	perSample := 0
+	for _, f := range []uint32{trunSampleDuration, trunSampleSize, trunSampleFlags, trunSampleCompositionOff} {
+		if flags&f != 0 {
+			perSample += 4
+		}
+	}
+	if uint64(len(data)-pos) < uint64(count)*uint64(perSample) {
+		return 0, fmt.Errorf("trun box truncated")
+	}
+	if perSample == 0 && count > 0 {
+		// Nothing in the box bounds the count, so the samples of the default
+		// size have to fit in the file
+		fileSize, err := rs.Seek(0, io.SeekEnd)
+		if err != nil {
+			return 0, err
+		}
+		if def.Size == 0 || offset < 0 || uint64(count)*uint64(def.Size) > uint64(max(fileSize-offset, 0)) {
+			return 0, fmt.Errorf("trun describes %d samples of %d bytes, more than the file holds", count, def.Size)
+		}
+	}
+
+	for i := uint32(0); i < count; i++ {
+		s := Sample{
+			Offset:           offset,
+			Size:             def.Size,
+			Duration:         def.Duration,
+			DescriptionIndex: def.DescriptionIndex,
+			DecodeTime:       *dts,
+		}
+		sampleFlags := def.Flags
+		if i == 0 && hasFirstFlags {
+			sampleFlags = firstFlags
+		}
+		if flags&trunSampleDuration != 0 {
+			s.Duration = binary.BigEndian.Uint32(data[pos:])
+			pos += 4
+		}
+		if flags&trunSampleSize != 0 {
+			s.Size = binary.BigEndian.Uint32(data[pos:])
+			pos += 4
+		}
+		if flags&trunSampleFlags != 0 {
+			sampleFlags = binary.BigEndian.Uint32(data[pos:])
+			pos += 4
+		}
+		if flags&trunSampleCompositionOff != 0 {
+			v := binary.BigEndian.Uint32(data[pos:])
+			if version == 0 && v > 1<<31 {
+				// Unsigned in version 0; keep the value rather than wrapping negative
+				v = 1<<31 - 1
+			}
+			s.CompositionOffset = int32(v)
+			pos += 4
+		}
+		s.Sync = sampleFlags&sampleIsNonSync == 0
+
+		tf.Samples = append(tf.Samples, s)
+		offset += int64(s.Size)
+		*dts += uint64(s.Duration)
+	}
+	return offset, nil
+}
+
+// MergeFragments appends the samples of every fragment to the track with the
+// same ID, so each track lists its complete media in decode order. A gap
+// before a fragment's tfdt is folded into the duration of the preceding sample.
+func MergeFragments(tracks []*Track, fragments []*Fragment) error {
+	byID := make(map[uint32]*Track, len(tracks))
+	for _, t := range tracks {
+		byID[t.ID] = t
+	}
+	for _, f := range fragments {
+		for _, tf := range f.Tracks {
+			t := byID[tf.TrackID]
+			if t == nil {
+				return fmt.Errorf("fragment %d references unknown track %d", f.Sequence, tf.TrackID)
+			}
+			if n := len(t.Samples); n > 0 && tf.HasDecodeTime {
+				last := &t.Samples[n-1]
+				end := last.DecodeTime + uint64(last.Duration)
+				if start := tf.BaseDecodeTime; start > end && start-end <= math.MaxUint32-uint64(last.Duration) {
+					last.Duration += uint32(start - end)
+				}
+			}
+			t.Samples = append(t.Samples, tf.Samples...)
+			for _, s := range tf.Samples {
+				if !s.Sync {
+					t.HasSyncTable = true
+				}
+				if s.CompositionOffset != 0 {
+					t.HasCompositionOffsets = true
+				}
+			}
+		}
+	}
+	return nil
+}
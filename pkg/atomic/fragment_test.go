@@ -0,0 +1,134 @@
+package atomic
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestReadFragments(t *testing.T) {
+	// trex for track 1: duration 100, size 10, non-sync by default
+	moov := box("moov", box("mvex", box("trex", u32s(0, 1, 1, 100, 10, sampleIsNonSync))))
+
+	// Fragment 1: default-base-is-moof, tfdt 1000, trun with data offset,
+	// a sync first sample and explicit sizes
+	traf1 := box("traf",
+		box("tfhd", u32s(tfhdDefaultBaseIsMoof, 1)),
+		box("tfdt", u32s(0, 1000)),
+		box("trun", u32s(trunDataOffset|trunFirstSampleFlags|trunSampleSize, 3, 200, 0, 5, 6, 7)),
+	)
+	moof1 := box("moof", box("mfhd", u32s(0, 1)), traf1)
+
+	// Fragment 2: no tfdt, so decode time continues; tfhd overrides duration,
+	// two truns without data offsets follow each other from the moof start
+	traf2 := box("traf",
+		box("tfhd", u32s(tfhdDefaultDuration, 1, 50)),
+		box("trun", u32s(0, 1)),
+		box("trun", u32s(trunSampleFlags, 1, 0)),
+	)
+	moof2 := box("moof", box("mfhd", u32s(0, 2)), traf2)
+
+	file := append(append(append([]byte{}, moov...), moof1...), moof2...)
+	r := bytes.NewReader(file)
+	boxes, err := ParseTree(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaults, err := ReadTrackDefaults(r, boxes[0])
+	if err != nil {
+		t.Fatalf("ReadTrackDefaults failed: %v", err)
+	}
+	fragments, err := ReadFragments(r, boxes, defaults)
+	if err != nil {
+		t.Fatalf("ReadFragments failed: %v", err)
+	}
+	if len(fragments) != 2 {
+		t.Fatalf("Expected 2 fragments, got %d", len(fragments))
+	}
+
+	moof1Offset := int64(len(moov))
+	moof2Offset := moof1Offset + int64(len(moof1))
+	want := [][]Sample{
+		{
+			{Offset: moof1Offset + 200, Size: 5, DecodeTime: 1000, Duration: 100, Sync: true, DescriptionIndex: 1},
+			{Offset: moof1Offset + 205, Size: 6, DecodeTime: 1100, Duration: 100, DescriptionIndex: 1},
+			{Offset: moof1Offset + 211, Size: 7, DecodeTime: 1200, Duration: 100, DescriptionIndex: 1},
+		},
+		{
+			{Offset: moof2Offset, Size: 10, DecodeTime: 1300, Duration: 50, DescriptionIndex: 1},
+			{Offset: moof2Offset + 10, Size: 10, DecodeTime: 1350, Duration: 50, Sync: true, DescriptionIndex: 1},
+		},
+	}
+	for i, f := range fragments {
+		if f.Sequence != uint32(i+1) {
+			t.Errorf("Fragment %d has sequence %d", i+1, f.Sequence)
+		}
+		if len(f.Tracks) != 1 || f.Tracks[0].TrackID != 1 {
+			t.Fatalf("Fragment %d: unexpected track fragments %+v", i+1, f.Tracks)
+		}
+		samples := f.Tracks[0].Samples
+		if len(samples) != len(want[i]) {
+			t.Fatalf("Fragment %d: expected %d samples, got %d", i+1, len(want[i]), len(samples))
+		}
+		for j := range samples {
+			if samples[j] != want[i][j] {
+				t.Errorf("Fragment %d sample %d = %+v, want %+v", i+1, j+1, samples[j], want[i][j])
+			}
+		}
+	}
+	if !fragments[0].Tracks[0].HasDecodeTime || fragments[1].Tracks[0].BaseDecodeTime != 1300 {
+		t.Errorf("Decode time did not continue across fragments")
+	}
+	if d := fragments[1].Tracks[0].Duration(); d != 100 {
+		t.Errorf("Expected fragment duration 100, got %d", d)
+	}
+}
+
+func TestReadFragmentsTruncatedTrun(t *testing.T) {
+	moof := box("moof", box("traf",
+		box("tfhd", u32s(0, 1)),
+		box("trun", u32s(trunSampleSize, 4, 1, 2)),
+	))
+	r := bytes.NewReader(moof)
+	boxes, err := ParseTree(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadFragments(r, boxes, nil); err == nil {
+		t.Errorf("Expected an error for a trun shorter than its sample count")
+	}
+}
+
+func TestReadFragmentsBoundsTrunCount(t *testing.T) {
+	// No per-sample fields, so only the file size limits 4 billion 1 byte samples
+	moof := box("moof", box("traf",
+		box("tfhd", u32s(0x10, 1, 1)),
+		box("trun", u32s(0, 0xFFFFFFFF)),
+	))
+	data := append(moof, box("mdat", make([]byte, 16))...)
+	r := bytes.NewReader(data)
+	boxes, err := ParseTree(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadFragments(r, boxes, nil); err == nil {
+		t.Errorf("Expected an error for a trun count the file cannot hold")
+	}
+
+	// The same samples within the file are fine
+	moof = box("moof", box("traf",
+		box("tfhd", u32s(0x10, 1, 1)),
+		box("trun", u32s(0, 4)),
+	))
+	data = append(moof, box("mdat", make([]byte, 16))...)
+	r = bytes.NewReader(data)
+	if boxes, err = ParseTree(r); err != nil {
+		t.Fatal(err)
+	}
+	fragments, err := ReadFragments(r, boxes, nil)
+	if err != nil {
+		t.Fatalf("ReadFragments failed: %v", err)
+	}
+	if n := len(fragments[0].Tracks[0].Samples); n != 4 {
+		t.Errorf("Expected 4 samples, got %d", n)
+	}
+}
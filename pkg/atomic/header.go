@@ -0,0 +1,64 @@
+package atomic
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// headerFields returns the offsets of the timescale (or -1 for tkhd) and
+// duration fields in the body of a mvhd, mdhd or tkhd box.
+func headerFields(b *Box) (timescale, duration int, ok bool) {
+	v1 := len(b.Data) > 0 && b.Data[0] == 1
+	switch b.Type {
+	case "mvhd", "mdhd":
+		// Version(1) Flags(3) Creation(4/8) Modification(4/8) Timescale(4) Duration(4/8)
+		if v1 {
+			return 20, 24, len(b.Data) >= 32
+		}
+		return 12, 16, len(b.Data) >= 20
+	case "tkhd":
+		// Version(1) Flags(3) Creation(4/8) Modification(4/8) TrackID(4) Reserved(4) Duration(4/8)
+		if v1 {
+			return -1, 28, len(b.Data) >= 36
+		}
+		return -1, 20, len(b.Data) >= 24
+	}
+	return 0, 0, false
+}
+
+// Timescale returns the timescale of a loaded mvhd or mdhd box, or 0.
+func (b *Box) Timescale() uint32 {
+	ts, _, ok := headerFields(b)
+	if !ok || ts < 0 {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b.Data[ts:])
+}
+
+// Duration returns the duration field of a loaded mvhd, mdhd or tkhd box, or 0.
+func (b *Box) Duration() uint64 {
+	_, off, ok := headerFields(b)
+	if !ok {
+		return 0
+	}
+	if b.Data[0] == 1 {
+		return binary.BigEndian.Uint64(b.Data[off:])
+	}
+	return uint64(binary.BigEndian.Uint32(b.Data[off:]))
+}
+
+// SetDuration stores d in the duration field of a loaded mvhd, mdhd or tkhd box.
+// Version 0 boxes saturate rather than wrap. Other boxes are left unchanged.
+func (b *Box) SetDuration(d uint64) {
+	_, off, ok := headerFields(b)
+	if !ok {
+		return
+	}
+	data := append([]byte(nil), b.Data...)
+	if data[0] == 1 {
+		binary.BigEndian.PutUint64(data[off:], d)
+	} else {
+		binary.BigEndian.PutUint32(data[off:], uint32(min(d, math.MaxUint32)))
+	}
+	b.SetData(data)
+}
@@ -0,0 +1,357 @@
+package atomic
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Sample is a single media sample resolved from the sample tables of a track.
+type Sample struct {
+	Offset            int64  // absolute file offset
+	Size              uint32 // bytes
+	DecodeTime        uint64 // in track timescale
+	Duration          uint32 // in track timescale
+	CompositionOffset int32  // ctts, in track timescale
+	Sync              bool   // key frame (every sample is sync when stss is absent)
+	DescriptionIndex  uint32 // 1-based stsd entry
+}
+
+// Track holds the resolved sample tables of a 'trak'.
+type Track struct {
+	ID        uint32
+	Handler   string // from hdlr, e.g. 'vide', 'soun'
+	Timescale uint32 // from mdhd
+	Duration  uint64 // from mdhd, in Timescale units
+	Samples   []Sample
+	Trak      *Box
+
+	// HasSyncTable is false when stss is absent, meaning every sample is a sync sample.
+	HasSyncTable bool
+	// HasCompositionOffsets is true when ctts is present.
+	HasCompositionOffsets bool
+}
+
+// ReadTracks resolves the sample tables of every track in moov.
+func ReadTracks(rs io.ReadSeeker, moov *Box) ([]*Track, error) {
+	var tracks []*Track
+	for _, trak := range moov.ChildrenOfType("trak") {
+		t, err := ReadTrack(rs, trak)
+		if err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, t)
+	}
+	return tracks, nil
+}
+
+// ReadTrack resolves the sample tables (stts, ctts, stss, stsz/stz2, stsc, stco/co64) of a trak.
+func ReadTrack(rs io.ReadSeeker, trak *Box) (*Track, error) {
+	t := &Track{Trak: trak}
+
+	if tkhd := trak.Child("tkhd"); tkhd != nil {
+		data, err := tkhd.ReadBody(rs)
+		if err != nil {
+			return nil, err
+		}
+		// Version(1)+Flags(3) + Create(4/8) + Mod(4/8) + TrackID(4)
+		if len(data) > 0 && data[0] == 1 && len(data) >= 24 {
+			t.ID = binary.BigEndian.Uint32(data[20:24])
+		} else if len(data) >= 16 {
+			t.ID = binary.BigEndian.Uint32(data[12:16])
+		}
+	}
+
+	mdia := trak.Child("mdia")
+	if mdia == nil {
+		return nil, fmt.Errorf("track %d has no mdia", t.ID)
+	}
+	if mdhd := mdia.Child("mdhd"); mdhd != nil {
+		data, err := mdhd.ReadBody(rs)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) > 0 && data[0] == 1 && len(data) >= 32 {
+			t.Timescale = binary.BigEndian.Uint32(data[20:24])
+			t.Duration = binary.BigEndian.Uint64(data[24:32])
+		} else if len(data) >= 20 {
+			t.Timescale = binary.BigEndian.Uint32(data[12:16])
+			t.Duration = uint64(binary.BigEndian.Uint32(data[16:20]))
+		}
+	}
+	if hdlr := mdia.Child("hdlr"); hdlr != nil {
+		data, err := hdlr.ReadBody(rs)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) >= 12 {
+			t.Handler = string(data[8:12])
+		}
+	}
+
+	stbl := mdia.Find("minf", "stbl")
+	if stbl == nil {
+		return nil, fmt.Errorf("track %d has no stbl", t.ID)
+	}
+
+	sizes, err := readSampleSizes(rs, stbl)
+	if err != nil {
+		return nil, fmt.Errorf("track %d: %w", t.ID, err)
+	}
+	t.Samples = make([]Sample, len(sizes))
+	for i, s := range sizes {
+		t.Samples[i].Size = s
+	}
+
+	if err := resolveChunks(rs, stbl, t.Samples); err != nil {
+		return nil, fmt.Errorf("track %d: %w", t.ID, err)
+	}
+	if err := resolveTimes(rs, stbl, t); err != nil {
+		return nil, fmt.Errorf("track %d: %w", t.ID, err)
+	}
+	if err := resolveSync(rs, stbl, t); err != nil {
+		return nil, fmt.Errorf("track %d: %w", t.ID, err)
+	}
+	return t, nil
+}
+
+// readFullBoxTable reads the body of a table FullBox and returns the entry count and entry bytes.
+func readFullBoxTable(rs io.ReadSeeker, box *Box, entrySize int) (uint32, []byte, error) {
+	data, err := box.ReadBody(rs)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(data) < 8 {
+		return 0, nil, fmt.Errorf("%s box too small", box.Type)
+	}
+	count := binary.BigEndian.Uint32(data[4:8])
+	entries := data[8:]
+	if uint64(len(entries)) < uint64(count)*uint64(entrySize) {
+		return 0, nil, fmt.Errorf("%s box truncated", box.Type)
+	}
+	return count, entries, nil
+}
+
+func readSampleSizes(rs io.ReadSeeker, stbl *Box) ([]uint32, error) {
+	if stsz := stbl.Child("stsz"); stsz != nil {
+		data, err := stsz.ReadBody(rs)
+		if err != nil {
+			return nil, err
+		}
+		// Version(1) Flags(3) SampleSize(4) Count(4)
+		if len(data) < 12 {
+			return nil, fmt.Errorf("stsz box too small")
+		}
+		fixed := binary.BigEndian.Uint32(data[4:8])
+		count := binary.BigEndian.Uint32(data[8:12])
+		// The count is checked before allocating, so a corrupt or hostile
+		// table cannot request gigabytes of memory
+		if fixed != 0 {
+			// Samples of a constant size all have to fit in the file
+			fileSize, err := rs.Seek(0, io.SeekEnd)
+			if err != nil {
+				return nil, err
+			}
+			if uint64(count)*uint64(fixed) > uint64(fileSize) {
+				return nil, fmt.Errorf("stsz describes %d samples of %d bytes, more than the file holds", count, fixed)
+			}
+			sizes := make([]uint32, count)
+			for i := range sizes {
+				sizes[i] = fixed
+			}
+			return sizes, nil
+		}
+		if uint64(len(data)-12) < uint64(count)*4 {
+			return nil, fmt.Errorf("stsz box truncated")
+		}
+		sizes := make([]uint32, count)
+		for i := range sizes {
+			sizes[i] = binary.BigEndian.Uint32(data[12+i*4:])
+		}
+		return sizes, nil
+	}
+
+	if stz2 := stbl.Child("stz2"); stz2 != nil {
+		data, err := stz2.ReadBody(rs)
+		if err != nil {
+			return nil, err
+		}
+		// Version(1) Flags(3) Reserved(3) FieldSize(1) Count(4)
+		if len(data) < 12 {
+			return nil, fmt.Errorf("stz2 box too small")
+		}
+		fieldSize := int(data[7])
+		if fieldSize != 4 && fieldSize != 8 && fieldSize != 16 {
+			return nil, fmt.Errorf("invalid stz2 field size %d", fieldSize)
+		}
+		count := binary.BigEndian.Uint32(data[8:12])
+		entries := data[12:]
+		if uint64(len(entries))*8 < uint64(count)*uint64(fieldSize) {
+			return nil, fmt.Errorf("stz2 box truncated")
+		}
+		sizes := make([]uint32, count)
+		for i := range sizes {
+			switch fieldSize {
+			case 4:
+				b := entries[i/2]
+				if i%2 == 0 {
+					sizes[i] = uint32(b >> 4)
+				} else {
+					sizes[i] = uint32(b & 0x0F)
+				}
+			case 8:
+				sizes[i] = uint32(entries[i])
+			case 16:
+				sizes[i] = uint32(binary.BigEndian.Uint16(entries[i*2:]))
+			}
+		}
+		return sizes, nil
+	}
+
+	return nil, fmt.Errorf("no stsz or stz2 box")
+}
+
+// resolveChunks assigns file offsets and description indices using stsc and stco/co64.
+func resolveChunks(rs io.ReadSeeker, stbl *Box, samples []Sample) error {
+	var chunkOffsets []uint64
+	if stco := stbl.Child("stco"); stco != nil {
+		count, entries, err := readFullBoxTable(rs, stco, 4)
+		if err != nil {
+			return err
+		}
+		chunkOffsets = make([]uint64, count)
+		for i := range chunkOffsets {
+			chunkOffsets[i] = uint64(binary.BigEndian.Uint32(entries[i*4:]))
+		}
+	} else if co64 := stbl.Child("co64"); co64 != nil {
+		count, entries, err := readFullBoxTable(rs, co64, 8)
+		if err != nil {
+			return err
+		}
+		chunkOffsets = make([]uint64, count)
+		for i := range chunkOffsets {
+			chunkOffsets[i] = binary.BigEndian.Uint64(entries[i*8:])
+		}
+	} else if len(samples) > 0 {
+		return fmt.Errorf("no stco or co64 box")
+	}
+
+	stsc := stbl.Child("stsc")
+	if stsc == nil {
+		if len(samples) > 0 {
+			return fmt.Errorf("no stsc box")
+		}
+		return nil
+	}
+	count, entries, err := readFullBoxTable(rs, stsc, 12)
+	if err != nil {
+		return err
+	}
+
+	sample := 0
+	for e := uint32(0); e < count && sample < len(samples); e++ {
+		firstChunk := binary.BigEndian.Uint32(entries[e*12:])
+		perChunk := binary.BigEndian.Uint32(entries[e*12+4:])
+		descIndex := binary.BigEndian.Uint32(entries[e*12+8:])
+
+		lastChunk := uint32(len(chunkOffsets))
+		if e+1 < count {
+			lastChunk = binary.BigEndian.Uint32(entries[(e+1)*12:]) - 1
+		}
+		if firstChunk == 0 {
+			return fmt.Errorf("invalid stsc first chunk 0")
+		}
+
+		for chunk := firstChunk; chunk <= lastChunk && sample < len(samples); chunk++ {
+			if int(chunk) > len(chunkOffsets) {
+				return fmt.Errorf("stsc references chunk %d of %d", chunk, len(chunkOffsets))
+			}
+			offset := int64(chunkOffsets[chunk-1])
+			for i := uint32(0); i < perChunk && sample < len(samples); i++ {
+				samples[sample].Offset = offset
+				samples[sample].DescriptionIndex = descIndex
+				offset += int64(samples[sample].Size)
+				sample++
+			}
+		}
+	}
+
+	if sample < len(samples) {
+		return fmt.Errorf("chunk tables cover %d of %d samples", sample, len(samples))
+	}
+	return nil
+}
+
+// resolveTimes assigns decode times, durations and composition offsets from stts and ctts.
+func resolveTimes(rs io.ReadSeeker, stbl *Box, t *Track) error {
+	samples := t.Samples
+	if stts := stbl.Child("stts"); stts != nil {
+		count, entries, err := readFullBoxTable(rs, stts, 8)
+		if err != nil {
+			return err
+		}
+		sample := 0
+		var dts uint64
+		for e := uint32(0); e < count; e++ {
+			n := binary.BigEndian.Uint32(entries[e*8:])
+			delta := binary.BigEndian.Uint32(entries[e*8+4:])
+			for i := uint32(0); i < n && sample < len(samples); i++ {
+				samples[sample].DecodeTime = dts
+				samples[sample].Duration = delta
+				dts += uint64(delta)
+				sample++
+			}
+		}
+	}
+
+	if ctts := stbl.Child("ctts"); ctts != nil {
+		t.HasCompositionOffsets = true
+		count, entries, err := readFullBoxTable(rs, ctts, 8)
+		if err != nil {
+			return err
+		}
+		sample := 0
+		for e := uint32(0); e < count; e++ {
+			n := binary.BigEndian.Uint32(entries[e*8:])
+			// Version 1 offsets are signed; version 0 writers also rely on two's complement
+			off := int32(binary.BigEndian.Uint32(entries[e*8+4:]))
+			for i := uint32(0); i < n && sample < len(samples); i++ {
+				samples[sample].CompositionOffset = off
+				sample++
+			}
+		}
+	}
+	return nil
+}
+
+// resolveSync marks sync samples from stss, or all samples when it is absent.
+func resolveSync(rs io.ReadSeeker, stbl *Box, t *Track) error {
+	stss := stbl.Child("stss")
+	if stss == nil {
+		for i := range t.Samples {
+			t.Samples[i].Sync = true
+		}
+		return nil
+	}
+
+	t.HasSyncTable = true
+	count, entries, err := readFullBoxTable(rs, stss, 4)
+	if err != nil {
+		return err
+	}
+	for e := uint32(0); e < count; e++ {
+		n := binary.BigEndian.Uint32(entries[e*4:])
+		if n >= 1 && int(n) <= len(t.Samples) {
+			t.Samples[n-1].Sync = true
+		}
+	}
+	return nil
+}
+
+// PresentationTime returns the composition time of s, clamped at zero.
+func (s Sample) PresentationTime() uint64 {
+	if s.CompositionOffset < 0 && uint64(-int64(s.CompositionOffset)) > s.DecodeTime {
+		return 0
+	}
+	return uint64(int64(s.DecodeTime) + int64(s.CompositionOffset))
+}
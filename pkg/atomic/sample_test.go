@@ -0,0 +1,96 @@
+package atomic
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func u32s(vals ...uint32) []byte {
+	buf := make([]byte, 4*len(vals))
+	for i, v := range vals {
+		binary.BigEndian.PutUint32(buf[i*4:], v)
+	}
+	return buf
+}
+
+func TestReadTrack(t *testing.T) {
+	// 5 samples in 3 chunks: 2 + 2 + 1
+	stbl := box("stbl",
+		box("stsd", u32s(0, 0)),
+		box("stts", u32s(0, 2, 3, 100, 2, 200)),
+		box("ctts", u32s(0, 1, 5, 100)),
+		box("stss", u32s(0, 2, 1, 4)),
+		box("stsc", u32s(0, 2, 1, 2, 1, 3, 1, 1)),
+		box("stsz", u32s(0, 0, 5, 10, 20, 30, 40, 50)),
+		box("stco", u32s(0, 3, 1000, 2000, 3000)),
+	)
+	tkhd := make([]byte, 84)
+	binary.BigEndian.PutUint32(tkhd[12:16], 7)
+	mdhd := u32s(0, 0, 0, 90000, 700, 0)
+	trak := box("trak", box("tkhd", tkhd), box("mdia", box("mdhd", mdhd), box("hdlr", hdlrBody("vide")), box("minf", stbl)))
+
+	boxes, err := ParseTree(bytes.NewReader(trak))
+	if err != nil {
+		t.Fatal(err)
+	}
+	track, err := ReadTrack(bytes.NewReader(trak), boxes[0])
+	if err != nil {
+		t.Fatalf("ReadTrack failed: %v", err)
+	}
+
+	if track.ID != 7 || track.Handler != "vide" || track.Timescale != 90000 || track.Duration != 700 {
+		t.Errorf("Unexpected track header %+v", track)
+	}
+	want := []Sample{
+		{Offset: 1000, Size: 10, DecodeTime: 0, Duration: 100, CompositionOffset: 100, Sync: true, DescriptionIndex: 1},
+		{Offset: 1010, Size: 20, DecodeTime: 100, Duration: 100, CompositionOffset: 100, DescriptionIndex: 1},
+		{Offset: 2000, Size: 30, DecodeTime: 200, Duration: 100, CompositionOffset: 100, DescriptionIndex: 1},
+		{Offset: 2030, Size: 40, DecodeTime: 300, Duration: 200, CompositionOffset: 100, Sync: true, DescriptionIndex: 1},
+		{Offset: 3000, Size: 50, DecodeTime: 500, Duration: 200, CompositionOffset: 100, DescriptionIndex: 1},
+	}
+	if len(track.Samples) != len(want) {
+		t.Fatalf("Expected %d samples, got %d", len(want), len(track.Samples))
+	}
+	for i := range want {
+		if track.Samples[i] != want[i] {
+			t.Errorf("Sample %d = %+v, want %+v", i+1, track.Samples[i], want[i])
+		}
+	}
+	if !track.HasSyncTable || !track.HasCompositionOffsets {
+		t.Errorf("Expected stss and ctts to be reported")
+	}
+}
+
+func TestReadSampleSizesBoundsCount(t *testing.T) {
+	tests := []struct {
+		name string
+		stsz []byte
+	}{
+		// A huge count must fail before the size table is allocated
+		{"variable sizes", box("stsz", u32s(0, 0, 0xFFFFFFFF, 10, 20))},
+		{"constant size", box("stsz", u32s(0, 1, 0xFFFFFFFF))},
+		{"stz2 without a field size", box("stz2", u32s(0, 0, 0xFFFFFFFF))},
+	}
+	for _, tt := range tests {
+		stbl := box("stbl", tt.stsz)
+		boxes, err := ParseTree(bytes.NewReader(stbl))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readSampleSizes(bytes.NewReader(stbl), boxes[0]); err == nil {
+			t.Errorf("%s: expected an error for a count the box cannot hold", tt.name)
+		}
+	}
+
+	// A constant size within the file is fine
+	stbl := box("stbl", box("stsz", u32s(0, 2, 4)))
+	boxes, err := ParseTree(bytes.NewReader(stbl))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sizes, err := readSampleSizes(bytes.NewReader(stbl), boxes[0])
+	if err != nil || len(sizes) != 4 || sizes[3] != 2 {
+		t.Errorf("Expected 4 samples of 2 bytes, got %v %v", sizes, err)
+	}
+}
@@ -0,0 +1,175 @@
+package atomic
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Chunk is a run of consecutive samples of one track stored back to back.
+type Chunk struct {
+	Offset  int64
+	Samples int
+}
+
+// BuildSampleTables returns the stts, ctts, stss, stsz, stsc and stco boxes
+// describing samples stored in chunks, in the order they belong in stbl.
+// ctts is omitted when every composition offset is zero and stss when every
+// sample is a sync sample. co64 replaces stco when largeOffsets is set.
+func BuildSampleTables(samples []Sample, chunks []Chunk, largeOffsets bool) []*Box {
+	boxes := []*Box{NewBox("stts", buildStts(samples))}
+	if ctts := buildCtts(samples); ctts != nil {
+		boxes = append(boxes, NewBox("ctts", ctts))
+	}
+	if stss := buildStss(samples); stss != nil {
+		boxes = append(boxes, NewBox("stss", stss))
+	}
+	boxes = append(boxes,
+		NewBox("stsz", buildStsz(samples)),
+		NewBox("stsc", buildStsc(samples, chunks)),
+	)
+	if largeOffsets {
+		boxes = append(boxes, NewBox("co64", buildChunkOffsets(chunks, 8)))
+	} else {
+		boxes = append(boxes, NewBox("stco", buildChunkOffsets(chunks, 4)))
+	}
+	return boxes
+}
+
+// ChunkOffsetsFit reports whether every chunk offset fits a 32-bit stco entry.
+func ChunkOffsetsFit(chunks []Chunk) bool {
+	for _, c := range chunks {
+		if c.Offset > math.MaxUint32 {
+			return false
+		}
+	}
+	return true
+}
+
+// fullBoxTable lays out a version/flags header, an entry count and the entries.
+func fullBoxTable(version byte, count int, entries []byte) []byte {
+	buf := make([]byte, 8, 8+len(entries))
+	buf[0] = version
+	binary.BigEndian.PutUint32(buf[4:8], uint32(count))
+	return append(buf, entries...)
+}
+
+func buildStts(samples []Sample) []byte {
+	var entries []byte
+	count := 0
+	for i := 0; i < len(samples); {
+		j := i + 1
+		for j < len(samples) && samples[j].Duration == samples[i].Duration {
+			j++
+		}
+		entries = binary.BigEndian.AppendUint32(entries, uint32(j-i))
+		entries = binary.BigEndian.AppendUint32(entries, samples[i].Duration)
+		count++
+		i = j
+	}
+	return fullBoxTable(0, count, entries)
+}
+
+func buildCtts(samples []Sample) []byte {
+	needed, negative := false, false
+	for _, s := range samples {
+		if s.CompositionOffset != 0 {
+			needed = true
+		}
+		if s.CompositionOffset < 0 {
+			negative = true
+		}
+	}
+	if !needed {
+		return nil
+	}
+
+	var entries []byte
+	count := 0
+	for i := 0; i < len(samples); {
+		j := i + 1
+		for j < len(samples) && samples[j].CompositionOffset == samples[i].CompositionOffset {
+			j++
+		}
+		entries = binary.BigEndian.AppendUint32(entries, uint32(j-i))
+		entries = binary.BigEndian.AppendUint32(entries, uint32(samples[i].CompositionOffset))
+		count++
+		i = j
+	}
+	var version byte
+	if negative {
+		// Signed offsets require version 1
+		version = 1
+	}
+	return fullBoxTable(version, count, entries)
+}
+
+func buildStss(samples []Sample) []byte {
+	var entries []byte
+	count := 0
+	for i, s := range samples {
+		if s.Sync {
+			entries = binary.BigEndian.AppendUint32(entries, uint32(i+1))
+			count++
+		}
+	}
+	if count == len(samples) {
+		return nil
+	}
+	return fullBoxTable(0, count, entries)
+}
+
+func buildStsz(samples []Sample) []byte {
+	buf := make([]byte, 12)
+	binary.BigEndian.PutUint32(buf[8:12], uint32(len(samples)))
+
+	constant := len(samples) > 0
+	for _, s := range samples {
+		if s.Size != samples[0].Size {
+			constant = false
+			break
+		}
+	}
+	if constant {
+		binary.BigEndian.PutUint32(buf[4:8], samples[0].Size)
+		return buf
+	}
+	for _, s := range samples {
+		buf = binary.BigEndian.AppendUint32(buf, s.Size)
+	}
+	return buf
+}
+
+func buildStsc(samples []Sample, chunks []Chunk) []byte {
+	var entries []byte
+	count := 0
+	var lastPerChunk, lastDesc uint32
+	sample := 0
+	for i, c := range chunks {
+		var desc uint32 = 1
+		if sample < len(samples) {
+			desc = samples[sample].DescriptionIndex
+		}
+		perChunk := uint32(c.Samples)
+		if count == 0 || perChunk != lastPerChunk || desc != lastDesc {
+			entries = binary.BigEndian.AppendUint32(entries, uint32(i+1))
+			entries = binary.BigEndian.AppendUint32(entries, perChunk)
+			entries = binary.BigEndian.AppendUint32(entries, desc)
+			count++
+			lastPerChunk, lastDesc = perChunk, desc
+		}
+		sample += c.Samples
+	}
+	return fullBoxTable(0, count, entries)
+}
+
+func buildChunkOffsets(chunks []Chunk, width int) []byte {
+	entries := make([]byte, 0, len(chunks)*width)
+	for _, c := range chunks {
+		if width == 8 {
+			entries = binary.BigEndian.AppendUint64(entries, uint64(c.Offset))
+		} else {
+			entries = binary.BigEndian.AppendUint32(entries, uint32(c.Offset))
+		}
+	}
+	return fullBoxTable(0, len(chunks), entries)
+}
@@ -0,0 +1,53 @@
+package atomic
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBuildSampleTablesRoundTrip(t *testing.T) {
+	samples := []Sample{
+		{Offset: 100, Size: 8, Duration: 10, CompositionOffset: 20, Sync: true, DescriptionIndex: 1},
+		{Offset: 108, Size: 8, Duration: 10, CompositionOffset: -10, DescriptionIndex: 1},
+		{Offset: 500, Size: 8, Duration: 10, DescriptionIndex: 1},
+		{Offset: 508, Size: 8, Duration: 30, Sync: true, DescriptionIndex: 2},
+	}
+	chunks := []Chunk{{Offset: 100, Samples: 2}, {Offset: 500, Samples: 1}, {Offset: 508, Samples: 1}}
+
+	for _, large := range []bool{false, true} {
+		var body [][]byte
+		body = append(body, box("stsd", u32s(0, 0)))
+		for _, b := range BuildSampleTables(samples, chunks, large) {
+			data, err := b.Bytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			body = append(body, data)
+		}
+		mdia := box("mdia", box("mdhd", u32s(0, 0, 0, 1000, 60, 0)), box("hdlr", hdlrBody("vide")), box("minf", box("stbl", body...)))
+		trak := box("trak", mdia)
+
+		boxes, err := ParseTree(bytes.NewReader(trak))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := boxes[0].Find("mdia", "minf", "stbl", "co64") != nil; got != large {
+			t.Errorf("large=%v: co64 present = %v", large, got)
+		}
+		track, err := ReadTrack(bytes.NewReader(trak), boxes[0])
+		if err != nil {
+			t.Fatalf("ReadTrack failed: %v", err)
+		}
+		if len(track.Samples) != len(samples) {
+			t.Fatalf("Expected %d samples, got %d", len(samples), len(track.Samples))
+		}
+		var dts uint64
+		for i, want := range samples {
+			want.DecodeTime = dts
+			dts += uint64(want.Duration)
+			if track.Samples[i] != want {
+				t.Errorf("Sample %d = %+v, want %+v", i+1, track.Samples[i], want)
+			}
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package atomic
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// NewBox creates a leaf box with the given body.
+func NewBox(typ string, data []byte) *Box {
+	b := &Box{Data: data}
+	b.Type = typ
+	b.computeSize()
+	return b
+}
+
+// NewContainer creates a container box. prefix holds the body bytes before the
+// children (e.g. FullBox version/flags) and may be nil.
+func NewContainer(typ string, prefix []byte, children ...*Box) *Box {
+	b := &Box{
+		Data:        prefix,
+		ChildOffset: int64(len(prefix)),
+		container:   true,
+	}
+	b.Type = typ
+	for _, c := range children {
+		b.AppendChild(c)
+	}
+	b.computeSize()
+	return b
+}
+
+// SetData replaces the body of a leaf box.
+func (b *Box) SetData(data []byte) {
+	b.Data = data
+	b.src = source{}
+}
+
+// AppendChild adds c as the last child of b.
+func (b *Box) AppendChild(c *Box) {
+	c.Parent = b
+	b.Children = append(b.Children, c)
+}
+
+// InsertChild inserts c as a child of b at index i.
+func (b *Box) InsertChild(i int, c *Box) {
+	c.Parent = b
+	b.Children = append(b.Children, nil)
+	copy(b.Children[i+1:], b.Children[i:])
+	b.Children[i] = c
+}
+
+// RemoveChild removes c from the children of b. It returns false if c is not a child.
+func (b *Box) RemoveChild(c *Box) bool {
+	for i, child := range b.Children {
+		if child == c {
+			b.Children = append(b.Children[:i], b.Children[i+1:]...)
+			c.Parent = nil
+			return true
+		}
+	}
+	return false
+}
+
+// ReplaceChild swaps old for c in place. It returns false if old is not a child.
+func (b *Box) ReplaceChild(old, c *Box) bool {
+	for i, child := range b.Children {
+		if child == old {
+			c.Parent = b
+			b.Children[i] = c
+			old.Parent = nil
+			return true
+		}
+	}
+	return false
+}
+
+// Load reads every body or prefix that is still backed by the source into Data,
+// so the subtree can be serialized without the original reader.
+func (b *Box) Load(rs io.ReadSeeker) error {
+	if b.Data == nil && b.src.ok {
+		n := b.src.size
+		if b.container {
+			n = b.ChildOffset
+		}
+		if _, err := rs.Seek(b.src.offset, io.SeekStart); err != nil {
+			return err
+		}
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(rs, buf); err != nil {
+			return fmt.Errorf("load '%s' at offset %d: %w", b.Type, b.Offset, err)
+		}
+		b.Data = buf
+	}
+	if b.container {
+		for _, c := range b.Children {
+			if err := c.Load(rs); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Layout recomputes sizes and assigns absolute offsets to the boxes as if they
+// were written back to back starting at offset. It returns the end offset.
+func Layout(boxes []*Box, offset int64) int64 {
+	for _, b := range boxes {
+		b.computeSize()
+		b.assignOffsets(offset)
+		offset += b.Size
+	}
+	return offset
+}
+
+// Bytes serializes the box. Every body must have been loaded (see Load).
+func (b *Box) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := WriteBox(&buf, nil, b); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteBoxes serializes the boxes in order. Bodies that were not loaded or
+// replaced are copied from src. It returns the number of bytes written.
+func WriteBoxes(w io.Writer, src io.ReadSeeker, boxes []*Box) (int64, error) {
+	var total int64
+	for _, b := range boxes {
+		n, err := WriteBox(w, src, b)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// WriteBox serializes a single box with freshly computed sizes.
+// A 64-bit largesize header is used only when the box does not fit in 32 bits.
+func WriteBox(w io.Writer, src io.ReadSeeker, b *Box) (int64, error) {
+	b.computeSize()
+	return b.write(w, src)
+}
+
+// WriteHeader writes a box header for a box of the given total size,
+// using the 16-byte largesize form when needed.
+func WriteHeader(w io.Writer, typ string, size int64) (int64, error) {
+	if len(typ) != 4 {
+		return 0, fmt.Errorf("invalid box type %q", typ)
+	}
+	var header [16]byte
+	n := 8
+	if size > math.MaxUint32 {
+		binary.BigEndian.PutUint32(header[0:4], 1)
+		copy(header[4:8], typ)
+		binary.BigEndian.PutUint64(header[8:16], uint64(size))
+		n = 16
+	} else {
+		binary.BigEndian.PutUint32(header[0:4], uint32(size))
+		copy(header[4:8], typ)
+	}
+	written, err := w.Write(header[:n])
+	return int64(written), err
+}
+
+// HeaderSizeFor returns the header size needed for a box with the given body size.
+func HeaderSizeFor(bodySize int64) int64 {
+	if bodySize+8 > math.MaxUint32 {
+		return 16
+	}
+	return 8
+}
+
+// ComputeSize recomputes Size and HeaderSize of b and its descendants
+// without touching offsets. It returns the new total size.
+func (b *Box) ComputeSize() int64 {
+	return b.computeSize()
+}
+
+// computeSize recomputes Size and HeaderSize bottom-up.
+func (b *Box) computeSize() int64 {
+	var body int64
+	if b.container {
+		body = b.prefixSize()
+		for _, c := range b.Children {
+			body += c.computeSize()
+		}
+	} else if b.Data != nil || !b.src.ok {
+		body = int64(len(b.Data))
+	} else {
+		body = b.src.size
+	}
+	b.HeaderSize = HeaderSizeFor(body)
+	b.Size = b.HeaderSize + body
+	return b.Size
+}
+
+func (b *Box) prefixSize() int64 {
+	if b.Data != nil {
+		return int64(len(b.Data))
+	}
+	return b.ChildOffset
+}
+
+func (b *Box) assignOffsets(offset int64) {
+	b.Offset = offset
+	if !b.container {
+		return
+	}
+	b.ChildOffset = b.prefixSize()
+	offset = b.BodyOffset() + b.ChildOffset
+	for _, c := range b.Children {
+		c.assignOffsets(offset)
+		offset += c.Size
+	}
+}
+
+func (b *Box) write(w io.Writer, src io.ReadSeeker) (int64, error) {
+	total, err := WriteHeader(w, b.Type, b.Size)
+	if err != nil {
+		return total, err
+	}
+
+	n := b.src.size
+	if b.container {
+		n = b.ChildOffset
+	}
+	written, err := b.writeData(w, src, n)
+	total += written
+	if err != nil {
+		return total, err
+	}
+
+	if b.container {
+		for _, c := range b.Children {
+			written, err := c.write(w, src)
+			total += written
+			if err != nil {
+				return total, err
+			}
+		}
+	}
+	return total, nil
+}
+
+// writeData writes Data, or n bytes of the original body copied from src.
+func (b *Box) writeData(w io.Writer, src io.ReadSeeker, n int64) (int64, error) {
+	if b.Data != nil || !b.src.ok {
+		written, err := w.Write(b.Data)
+		return int64(written), err
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	if src == nil {
+		return 0, fmt.Errorf("box '%s' is not loaded and no source was given", b.Type)
+	}
+	if _, err := src.Seek(b.src.offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.CopyN(w, src, n)
+}
@@ -0,0 +1,117 @@
+package atomic
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func sampleFile() []byte {
+	stbl := box("stbl",
+		box("stsd", []byte{0, 0, 0, 0, 0, 0, 0, 1}, box("avc1", make([]byte, visualSampleEntrySize), box("avcC", []byte{1, 2, 3}))),
+		box("stco", []byte{0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 40}),
+	)
+	trak := box("trak", box("tkhd", make([]byte, 84)), box("mdia", box("hdlr", hdlrBody("vide")), box("minf", stbl)))
+	moov := box("moov", box("mvhd", make([]byte, 100)), trak, box("udta", box("meta", []byte{0, 0, 0, 0}, box("hdlr", hdlrBody("mdir")))))
+	return bytes.Join([][]byte{box("ftyp", []byte("isom")), moov, box("mdat", make([]byte, 32))}, nil)
+}
+
+func TestWriteBoxesRoundTrip(t *testing.T) {
+	file := sampleFile()
+	src := bytes.NewReader(file)
+	boxes, err := ParseTree(src)
+	if err != nil {
+		t.Fatalf("ParseTree failed: %v", err)
+	}
+
+	var out bytes.Buffer
+	n, err := WriteBoxes(&out, src, boxes)
+	if err != nil {
+		t.Fatalf("WriteBoxes failed: %v", err)
+	}
+	if n != int64(len(file)) || !bytes.Equal(out.Bytes(), file) {
+		t.Errorf("Expected identical output for an unmodified tree")
+	}
+}
+
+func TestWriteBoxesModified(t *testing.T) {
+	file := sampleFile()
+	src := bytes.NewReader(file)
+	boxes, err := ParseTree(src)
+	if err != nil {
+		t.Fatalf("ParseTree failed: %v", err)
+	}
+
+	moov := FindBox(boxes, "moov")
+	moov.RemoveChild(moov.Child("udta"))
+	stbl := moov.Find("trak", "mdia", "minf", "stbl")
+	stbl.InsertChild(1, NewBox("stss", []byte{0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 1}))
+	stbl.Child("stco").SetData([]byte{0, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 1, 0, 0, 0, 2})
+
+	end := Layout(boxes, 0)
+
+	var out bytes.Buffer
+	if _, err := WriteBoxes(&out, src, boxes); err != nil {
+		t.Fatalf("WriteBoxes failed: %v", err)
+	}
+	if int64(out.Len()) != end {
+		t.Errorf("Expected %d bytes from Layout, wrote %d", end, out.Len())
+	}
+
+	reparsed, err := ParseTree(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatalf("reparse failed: %v", err)
+	}
+	newMoov := FindBox(reparsed, "moov")
+	if newMoov.Child("udta") != nil {
+		t.Errorf("Expected udta to be removed")
+	}
+	newStbl := newMoov.Find("trak", "mdia", "minf", "stbl")
+	if len(newStbl.Children) != 3 || newStbl.Children[1].Type != "stss" {
+		t.Fatalf("Expected stss inserted at index 1, got %d children", len(newStbl.Children))
+	}
+	stco := newStbl.Child("stco")
+	if stco.Size != 24 || stco.Offset != stbl.Child("stco").Offset {
+		t.Errorf("Expected resized stco at the laid out offset, got %+v", stco.Atom)
+	}
+	if newMoov.Find("trak", "mdia", "minf", "stbl", "stsd", "avc1", "avcC") == nil {
+		t.Errorf("Expected untouched sample entry children to be preserved")
+	}
+}
+
+func TestLargeSizeHeader(t *testing.T) {
+	if HeaderSizeFor(100) != 8 {
+		t.Errorf("Expected compact header for small boxes")
+	}
+	if HeaderSizeFor(math.MaxUint32-8) != 8 || HeaderSizeFor(math.MaxUint32-7) != 16 {
+		t.Errorf("Expected largesize exactly when the size exceeds 32 bits")
+	}
+
+	var buf bytes.Buffer
+	size := int64(math.MaxUint32) + 100
+	if _, err := WriteHeader(&buf, "mdat", size); err != nil {
+		t.Fatalf("WriteHeader failed: %v", err)
+	}
+	h := buf.Bytes()
+	if len(h) != 16 || binary.BigEndian.Uint32(h[0:4]) != 1 || int64(binary.BigEndian.Uint64(h[8:16])) != size {
+		t.Errorf("Unexpected largesize header % x", h)
+	}
+
+	atom, n, err := ReadAtomHeader(bytes.NewReader(h))
+	if err != nil || n != 16 || atom.Size != size || atom.Type != "mdat" {
+		t.Errorf("Header does not round trip: %+v %d %v", atom, n, err)
+	}
+}
+
+func TestNewContainerBytes(t *testing.T) {
+	meta := NewContainer("meta", []byte{0, 0, 0, 0}, NewBox("hdlr", hdlrBody("mdir")))
+	b, err := meta.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes failed: %v", err)
+	}
+	want := box("meta", []byte{0, 0, 0, 0}, box("hdlr", hdlrBody("mdir")))
+	if !bytes.Equal(b, want) {
+		t.Errorf("Unexpected serialization % x", b)
+	}
+}